@@ -0,0 +1,165 @@
+// File: batch.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file runs a command over several archives, named on the command line
+// or by a glob, and sums up their results.
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"btxz/core"
+	"btxz/internal/remote"
+
+	"github.com/pterm/pterm"
+)
+
+// batchHelp is the part of the help of test and list about several archives.
+const batchHelp = `
+
+SEVERAL ARCHIVES:
+  Given several archives, such as backups/*.btxz, the command runs for each in turn under
+  a section of its own, goes on past archives that fail, and ends with a summary. On
+  Windows, where the shell passes patterns on, they are expanded by btxz. The password
+  is read once and tried on every archive; on a terminal, an archive it does not open
+  offers to enter another one, which is then used for the rest. The exit status is 0 if
+  every archive passed, else that of the failures if they agree, else 1. --json needs a
+  single archive.`
+
+// urlHelp is the section of the help about archives given as a URL.
+const urlHelp = `
+
+ARCHIVE URLS:
+  An http:// or https:// URL in place of the archive reads it over the network, front to
+  back like standard input, without storing it on disk. --header 'Name: value' is sent
+  with every request, e.g. for a bearer token, and may be repeated. Proxies are taken from
+  HTTPS_PROXY, HTTP_PROXY and NO_PROXY. If the server supports range requests, list reads
+  only the header and the index of an archive created with one. A failed connection or an
+  error response exits with status 5 and is never reported as a wrong password. Not with
+  --use-keychain or --verify-key.`
+
+// archiveArgs returns the archives named by args. The shells of Windows pass
+// patterns such as backups\*.btxz on unexpanded, so there they are expanded
+// here; a pattern that matches nothing is kept, to be reported as missing.
+func archiveArgs(args []string) []string {
+	if runtime.GOOS != "windows" {
+		return args
+	}
+	var archives []string
+	for _, arg := range args {
+		matches, err := filepath.Glob(arg)
+		if err != nil || len(matches) == 0 {
+			archives = append(archives, arg)
+			continue
+		}
+		archives = append(archives, matches...)
+	}
+	return archives
+}
+
+// runBatch runs one for every archive, as described by batchHelp, and exits
+// with a non-zero status if any of them failed. one returns the exit status
+// of its run, and the error to report if it did not report the failure itself. password, read from source
+// once, is shared by the runs; keys is reset for each, so every archive can
+// be offered to the keychain.
+func runBatch(archives []string, password *string, source *passwordSource, keys *keychainOption, keyfile, identity string, keyShares []string, one func(archivePath string) (int, error)) {
+	if jsonOutput.enabled {
+		handleUsageError("--json writes one document and takes a single archive; run the command once per archive.")
+	}
+	for _, archivePath := range archives {
+		if archivePath == core.StdinPath {
+			handleUsageError("Standard input (-) can only be read as a single archive.")
+		}
+		if remote.IsURL(archivePath) {
+			handleUsageError("An archive URL can only be read as a single archive.")
+		}
+	}
+	// Read the password once; every archive then gets it as if given with -p.
+	*password = source.resolve(*password)
+	*source = passwordSource{fd: -1}
+	if *password == "" && identity == "" && len(keyShares) == 0 && !keys.enabled && batchNeedsPassword(archives, keyfile) {
+		*password = promptPassword("Enter decryption password")
+	}
+
+	codes := make([]int, len(archives))
+	failed := 0
+	for i, archivePath := range archives {
+		pterm.DefaultSection.Printf("[%d/%d] %s\n", i+1, len(archives), archivePath)
+		keys.used = false
+		codes[i] = runArchive(one, archivePath)
+		for codes[i] == exitAuth && *password != "" && confirm(fmt.Sprintf("The password does not open %s. Enter another one?", filepath.Base(archivePath))) {
+			*password = readSecret("Enter decryption password")
+			codes[i] = runArchive(one, archivePath)
+		}
+		if codes[i] != 0 {
+			failed++
+		}
+	}
+
+	pterm.DefaultSection.Println("Summary")
+	tableData := pterm.TableData{{"Archive", "Result"}}
+	code := 0
+	for i, archivePath := range archives {
+		tableData = append(tableData, []string{archivePath, batchResult(codes[i])})
+		if codes[i] != 0 {
+			if code == 0 {
+				code = codes[i]
+			} else if code != codes[i] {
+				code = exitError
+			}
+		}
+	}
+	pterm.DefaultTable.WithHasHeader().WithBoxed().WithData(tableData).Render()
+	if failed == 0 {
+		pterm.Success.Printf("All %d archives passed.\n", len(archives))
+		return
+	}
+	pterm.Error.Printf("%d of %d archives failed, %d passed.\n", failed, len(archives), len(archives)-failed)
+	os.Exit(code)
+}
+
+// runArchive runs one for archivePath, reports the error it returns, if any,
+// and returns its exit status.
+func runArchive(one func(archivePath string) (int, error), archivePath string) int {
+	code, err := one(archivePath)
+	if err != nil {
+		pterm.Error.Println(err)
+	}
+	return code
+}
+
+// batchNeedsPassword reports whether a key slot of one of the archives takes
+// a password, given whether a keyfile was passed.
+func batchNeedsPassword(archives []string, keyfile string) bool {
+	for _, archivePath := range archives {
+		slots, err := core.RequiredSecrets(archivePath)
+		if err != nil {
+			continue
+		}
+		for _, slot := range slots {
+			if slot.Password && !slot.Identity && !slot.Shares && slot.Keyfile == (keyfile != "") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// batchResult describes the exit status of the run for one archive.
+func batchResult(code int) string {
+	switch code {
+	case 0:
+		return "PASSED"
+	case exitAuth:
+		return "FAILED: not opened by the password or key"
+	case exitIntegrity:
+		return "FAILED: damaged or modified"
+	case exitIO:
+		return "FAILED: could not be read"
+	case exitUsage:
+		return "FAILED: not usable with these flags"
+	}
+	return fmt.Sprintf("FAILED (status %d)", code)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBatchExitCodes(t *testing.T) {
+	src := testTree(t)
+	good := createArchive(t, src, "secret")
+	other := createArchive(t, src, "other")
+	corrupt := corruptCopy(t, createArchive(t, randomTree(t), "secret"))
+	missing := filepath.Join(t.TempDir(), "missing.btxz")
+
+	for _, test := range []struct {
+		name     string
+		command  string
+		archives []string
+		code     int
+		passed   int
+		message  string // Reported for the failed archive, if not ""
+	}{
+		{"all pass", "test", []string{good, good}, 0, 2, ""},
+		{"one damaged", "test", []string{corrupt, good}, exitIntegrity, 1, "INTEGRITY CHECK FAILED"},
+		{"same failure", "test", []string{other, good, other}, exitAuth, 1, ""},
+		{"different failures", "test", []string{corrupt, missing, good}, exitError, 1, ""},
+		{"list, wrong password", "list", []string{good, other}, exitAuth, 1, "Access Denied: Incorrect Password."},
+		{"list, missing archive", "list", []string{missing, good}, exitIO, 1, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			args := append([]string{test.command, "-p", "secret"}, test.archives...)
+			r := runBTXZ(t, nil, args...)
+			if r.code != test.code {
+				t.Errorf("exit code %d, want %d: %s", r.code, test.code, r.stderr)
+			}
+			if !strings.Contains(r.stdout, test.message) {
+				t.Errorf("%q was not reported", test.message)
+			}
+			// Every archive runs, past the ones that fail.
+			_, summary, _ := strings.Cut(r.stdout, "Summary")
+			passed, failed := strings.Count(summary, "PASSED"), strings.Count(summary, "FAILED")
+			if passed != test.passed || failed != len(test.archives)-test.passed {
+				t.Errorf("%d archives passed and %d failed, want %d and %d", passed, failed, test.passed, len(test.archives)-test.passed)
+			}
+		})
+	}
+}
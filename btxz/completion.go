@@ -0,0 +1,94 @@
+// File: completion.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements the completion command and the completion of
+// archive names, channels and levels.
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// NewCompletionCmd configures the 'completion' command.
+func NewCompletionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion {bash|zsh|fish|powershell}",
+		Short: "Print the shell completion script",
+		Long: `Prints the script that adds tab completion to a shell: commands, flags, .btxz
+archives as the archive argument, and values such as those of --level. Load it
+from the startup file of the shell:
+
+  bash        source <(btxz completion bash)                 (in ~/.bashrc)
+  zsh         btxz completion zsh > "${fpath[1]}/_btxz"
+  fish        btxz completion fish > ~/.config/fish/completions/btxz.fish
+  powershell  btxz completion powershell | Out-String | Invoke-Expression   (in $PROFILE)`,
+		Example:   `  btxz completion bash > /etc/bash_completion.d/btxz`,
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+		Run: func(cmd *cobra.Command, args []string) {
+			if jsonOutput.enabled {
+				handleUsageError("--json cannot be used with completion: standard output carries the script.")
+			}
+			root := cmd.Root()
+			var err error
+			switch args[0] {
+			case "bash":
+				err = root.GenBashCompletionV2(os.Stdout, true)
+			case "zsh":
+				err = root.GenZshCompletion(os.Stdout)
+			case "fish":
+				err = root.GenFishCompletion(os.Stdout, true)
+			case "powershell":
+				err = root.GenPowerShellCompletionWithDesc(os.Stdout)
+			}
+			if err != nil {
+				handleFailure(err, "Failed to write the completion script: %v", err)
+			}
+		},
+	}
+}
+
+// completing reports whether cmd writes shell completions, the script of the
+// completion command or the candidates the shell asks for on every <TAB>.
+func completing(cmd *cobra.Command) bool {
+	switch cmd.Name() {
+	case "completion", cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return true
+	}
+	return false
+}
+
+// completeArchive returns the completion of commands whose first argument is
+// an archive: .btxz files, then files and folders if moreFiles is set, and
+// nothing otherwise, as the later arguments are entry names.
+func completeArchive(moreFiles bool) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		switch {
+		case len(args) == 0:
+			return []string{"btxz"}, cobra.ShellCompDirectiveFilterFileExt
+		case moreFiles:
+			return nil, cobra.ShellCompDirectiveDefault
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completeChannel completes the release channels of --channel.
+func completeChannel(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{
+		"stable\tReleases only",
+		"beta\tReleases and pre-release builds",
+	}, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeLevel completes the profiles of --level.
+func completeLevel(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{
+		"low\tFast: 64MB of RAM, 1 Argon2 pass",
+		"default\tBalanced: 128MB of RAM",
+		"max\tBest: 512MB of RAM, 4 Argon2 passes, maximum compression",
+		"auto\tChosen for the RAM and CPUs of this machine",
+	}, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,516 @@
+// File: core/archivefs.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements OpenFS, which presents the content of an archive as a
+// read-only io/fs file system. For a v4 archive with an index, the tree is
+// built from the index and a file is decrypted from its segment only when it
+// is opened. Archives without an index, including legacy ones, can only be
+// read front to back, so their content is decompressed once into memory.
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSymlinkHops is the number of symbolic links followed in one lookup
+// before it fails, as a loop would never resolve.
+const maxSymlinkHops = 40
+
+// OpenFS opens an archive as a read-only file system. The password is ignored
+// for unencrypted archives. Directories that are implied by the names of their
+// entries but not stored are presented as well. Symbolic links are followed
+// within the archive, and links pointing outside of it do not resolve. Files
+// with a recorded checksum fail the read that reaches their end if their
+// content does not match. The file system is safe for concurrent use, and
+// every open file of a v4 archive reads from its own handle on the archive.
+func OpenFS(archivePath, password string) (fs.FS, error) {
+	fsys, err := openArchiveFS(archivePath, password)
+	if err != nil {
+		return nil, err
+	}
+	return fsys, nil
+}
+
+// openArchiveFS returns the file system of an archive.
+func openArchiveFS(archivePath, password string) (*archiveFS, error) {
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	fsys := &archiveFS{
+		archivePath: archivePath,
+		root:        &fsNode{name: ".", mode: fs.ModeDir | 0o755, children: make(map[string]*fsNode)},
+	}
+	if version == coreVersionV4 {
+		err = fsys.loadV4(password)
+	} else {
+		err = visitLegacyEntries(archivePath, password, version, fsys.addContent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fsys.finish()
+	return fsys, nil
+}
+
+// archiveFS is the file system of an archive. Its tree is complete once OpenFS
+// returns and is only read afterwards.
+type archiveFS struct {
+	archivePath string
+	header      BtxzHeaderV4
+	aead        cipher.AEAD
+	index       *archiveIndex // Index the content is read through (nil = in memory)
+	digests     fileDigests
+	root        *fsNode
+	hardlinks   []hardlinkNode
+	added       int // Number of entries added, which orders them as stored
+}
+
+// hardlinkNode is a hard link waiting for its target to be known.
+type hardlinkNode struct {
+	node   *fsNode
+	target string
+}
+
+// fsNode is a file or directory of the tree. It is its own fs.FileInfo and
+// fs.DirEntry.
+type fsNode struct {
+	name     string // Base name
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	order    int         // Position of the entry in the archive (0 = not stored)
+	target   string      // Symlink target
+	entry    *indexEntry // Index entry holding the content (nil = data)
+	data     []byte
+	children map[string]*fsNode // Entries of a directory by name
+	sorted   []fs.DirEntry      // Entries of a directory by name, set by finish
+}
+
+func (n *fsNode) Name() string               { return n.name }
+func (n *fsNode) Size() int64                { return n.size }
+func (n *fsNode) Mode() fs.FileMode          { return n.mode }
+func (n *fsNode) ModTime() time.Time         { return n.modTime }
+func (n *fsNode) IsDir() bool                { return n.mode.IsDir() }
+func (n *fsNode) Sys() any                   { return nil }
+func (n *fsNode) Type() fs.FileMode          { return n.mode.Type() }
+func (n *fsNode) Info() (fs.FileInfo, error) { return n, nil }
+func (n *fsNode) String() string             { return fs.FormatFileInfo(n) }
+
+// loadV4 builds the tree of a v4 archive from its index, or reads the content
+// into memory if the archive has none.
+func (fsys *archiveFS) loadV4(password string) error {
+	archive, err := openArchiveV4(fsys.archivePath, password)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	index, err := archive.index()
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		decompressed, err := archive.tarStream()
+		if err != nil {
+			return err
+		}
+		return visitTarEntries(tar.NewReader(decompressed), fsys.addContent)
+	}
+
+	fsys.header, fsys.aead = archive.header, archive.aead
+	fsys.index, fsys.digests = index, index.digests()
+	for i := range index.Entries {
+		e := &index.Entries[i]
+		typeflag := e.Type
+		if typeflag == 0 {
+			typeflag = tar.TypeReg
+		}
+		node := fsys.add(e.Name, typeflag, e.Mode, time.Unix(0, e.ModTime), e.Link)
+		if node != nil && typeflag == tar.TypeReg {
+			node.entry, node.size = e, e.Size
+		}
+	}
+	return nil
+}
+
+// addContent adds an entry read from the tar stream, with its content.
+func (fsys *archiveFS) addContent(hdr *tar.Header, content io.Reader) error {
+	node := fsys.add(hdr.Name, hdr.Typeflag, hdr.Mode, hdr.ModTime, hdr.Linkname)
+	if node == nil || content == nil {
+		return nil
+	}
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", hdr.Name, err)
+	}
+	node.data, node.size = data, int64(len(data))
+	return nil
+}
+
+// add places an entry in the tree and returns its node, or nil if the entry is
+// the root or of a type the file system does not present, such as a device. A
+// later entry with the same name replaces an earlier one, as on extraction.
+func (fsys *archiveFS) add(name string, typeflag byte, mode int64, modTime time.Time, link string) *fsNode {
+	name = normalizeEntryName(name)
+	if name == "" {
+		if typeflag == tar.TypeDir {
+			fsys.root.mode, fsys.root.modTime = fs.ModeDir|fs.FileMode(mode).Perm(), modTime
+		}
+		return nil
+	}
+	perm := fs.FileMode(mode).Perm()
+	fsys.added++
+	node := &fsNode{name: path.Base(name), modTime: modTime, order: fsys.added}
+	switch typeflag {
+	case tar.TypeReg:
+		node.mode = perm
+	case tar.TypeLink:
+		node.mode = perm
+		fsys.hardlinks = append(fsys.hardlinks, hardlinkNode{node: node, target: normalizeEntryName(link)})
+	case tar.TypeSymlink:
+		node.mode = fs.ModeSymlink | perm
+		node.target, node.size = entryPath(link), int64(len(link))
+	case tar.TypeDir:
+		node.mode = fs.ModeDir | perm
+		node.children = make(map[string]*fsNode)
+	default:
+		return nil
+	}
+
+	parent := fsys.dir(path.Dir(name))
+	if old := parent.children[node.name]; old != nil && old.IsDir() && node.IsDir() {
+		// A directory stored after some of its entries keeps them.
+		node.children = old.children
+	}
+	parent.children[node.name] = node
+	return node
+}
+
+// dir returns the directory node of name, adding the directories that are
+// not stored. A file in the way is replaced, as on extraction.
+func (fsys *archiveFS) dir(name string) *fsNode {
+	if name == "." {
+		return fsys.root
+	}
+	parent := fsys.dir(path.Dir(name))
+	base := path.Base(name)
+	node := parent.children[base]
+	if node == nil || !node.IsDir() {
+		node = &fsNode{name: base, mode: fs.ModeDir | 0o755, children: make(map[string]*fsNode)}
+		parent.children[base] = node
+	}
+	return node
+}
+
+// finish resolves hard links to the content of their targets and sorts the
+// entries of every directory.
+func (fsys *archiveFS) finish() {
+	for _, link := range fsys.hardlinks {
+		target, err := fsys.resolve(link.target, 0)
+		if err == nil && target.mode.IsRegular() {
+			link.node.entry, link.node.data, link.node.size = target.entry, target.data, target.size
+		}
+	}
+	fsys.hardlinks = nil
+
+	var sortDir func(dir *fsNode)
+	sortDir = func(dir *fsNode) {
+		dir.sorted = make([]fs.DirEntry, 0, len(dir.children))
+		for _, child := range dir.children {
+			dir.sorted = append(dir.sorted, child)
+			if child.IsDir() {
+				sortDir(child)
+			}
+		}
+		sort.Slice(dir.sorted, func(i, j int) bool { return dir.sorted[i].Name() < dir.sorted[j].Name() })
+	}
+	sortDir(fsys.root)
+}
+
+// resolve looks up a valid path, following symbolic links.
+func (fsys *archiveFS) resolve(name string, hops int) (*fsNode, error) {
+	node := fsys.root
+	if name == "." {
+		return node, nil
+	}
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		if !node.IsDir() {
+			return nil, fs.ErrNotExist
+		}
+		child := node.children[elem]
+		if child == nil {
+			return nil, fs.ErrNotExist
+		}
+		if child.mode&fs.ModeSymlink != 0 {
+			if hops++; hops > maxSymlinkHops {
+				return nil, errors.New("too many levels of symbolic links")
+			}
+			if path.IsAbs(child.target) {
+				return nil, fs.ErrNotExist
+			}
+			parent := path.Dir(strings.Join(elems[:i+1], "/"))
+			target := path.Join(append([]string{parent, child.target}, elems[i+1:]...)...)
+			if !fs.ValidPath(target) {
+				return nil, fs.ErrNotExist
+			}
+			return fsys.resolve(target, hops)
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// lookup resolves name for the operation op.
+func (fsys *archiveFS) lookup(op, name string) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	node, err := fsys.resolve(name, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return node, nil
+}
+
+// Open opens the named file or directory.
+func (fsys *archiveFS) Open(name string) (fs.File, error) {
+	node, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if node.IsDir() {
+		return &fsDir{node: node, name: name}, nil
+	}
+	file := &fsFile{fsys: fsys, node: node, name: name}
+	if node.entry == nil {
+		file.mem = bytes.NewReader(node.data)
+	}
+	return file, nil
+}
+
+// Stat returns the file information of the named file, following symbolic links.
+func (fsys *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	node, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// ReadDir returns the entries of the named directory, sorted by name.
+func (fsys *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+	return append([]fs.DirEntry(nil), node.sorted...), nil
+}
+
+// Lstat returns the file information of the named file without following a
+// symbolic link it ends in.
+func (fsys *archiveFS) Lstat(name string) (fs.FileInfo, error) {
+	node, err := fsys.lookupLink("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// ReadLink returns the target of the named symbolic link.
+func (fsys *archiveFS) ReadLink(name string) (string, error) {
+	node, err := fsys.lookupLink("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if node.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return node.target, nil
+}
+
+// lookupLink resolves name for the operation op, following symbolic links
+// in its directory only.
+func (fsys *archiveFS) lookupLink(op, name string) (*fsNode, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return fsys.lookup(op, name)
+	}
+	dir, err := fsys.lookup(op, path.Dir(name))
+	if err != nil {
+		if pathErr, ok := err.(*fs.PathError); ok {
+			pathErr.Path = name
+		}
+		return nil, err
+	}
+	node := dir.children[path.Base(name)]
+	if node == nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return node, nil
+}
+
+// openContent returns the content of an index entry, read from a new handle on
+// the archive, which the caller closes.
+func (fsys *archiveFS) openContent(e *indexEntry) (io.Reader, io.Closer, error) {
+	if e.Segment < 0 || e.Segment >= len(fsys.index.Segments) {
+		return nil, nil, errors.New("invalid archive index: segment out of range")
+	}
+	file, err := openArchiveFile(fsys.archivePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open archive file: %w", err)
+	}
+	archive := &archiveV4{file: file, header: fsys.header, aead: fsys.aead}
+	decompressed, err := archive.segmentReader(fsys.index, e.Segment)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	// Skip the part of the segment that precedes the entry.
+	if _, err := io.CopyN(io.Discard, decompressed, e.Offset-fsys.index.Segments[e.Segment].TarOffset); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("error seeking in tar stream: %w", err)
+	}
+	tarReader := tar.NewReader(decompressed)
+	hdr, err := tarReader.Next()
+	if err != nil || hdr.Name != e.Name {
+		file.Close()
+		return nil, nil, fmt.Errorf("invalid archive index: %s not found at its offset", e.Name)
+	}
+	return fsys.digests.verifier(e.Name, tarReader), file, nil
+}
+
+// fsFile is an open file. Content held in memory is read in place; content in
+// the archive is decrypted from its segment on the first read. Seeking forward
+// skips through the content, and seeking back starts it over.
+type fsFile struct {
+	fsys    *archiveFS
+	node    *fsNode
+	name    string
+	mem     *bytes.Reader
+	content io.Reader // Content stream of the archive (nil = not opened)
+	closer  io.Closer
+	pos     int64 // Position of the content stream
+	offset  int64 // Position of the next read
+	closed  bool
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	return f.node, nil
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.mem != nil {
+		return f.mem.Read(p)
+	}
+	if f.content == nil && f.offset >= f.node.size {
+		return 0, io.EOF
+	}
+	if f.content == nil || f.offset < f.pos {
+		if f.closer != nil {
+			f.closer.Close()
+		}
+		content, closer, err := f.fsys.openContent(f.node.entry)
+		if err != nil {
+			f.content, f.closer = nil, nil
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		f.content, f.closer, f.pos = content, closer, 0
+	}
+	if f.offset > f.pos {
+		n, err := io.CopyN(io.Discard, f.content, f.offset-f.pos)
+		f.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.content.Read(p)
+	f.pos += int64(n)
+	f.offset = f.pos
+	return n, err
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.mem != nil {
+		return f.mem.Seek(offset, whence)
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.node.size
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: errors.New("negative position")}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *fsFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.closer != nil {
+		return f.closer.Close()
+	}
+	return nil
+}
+
+// fsDir is an open directory.
+type fsDir struct {
+	node   *fsNode
+	name   string
+	offset int // Entries returned by ReadDir so far
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.node, nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) Close() error { return nil }
+
+// ReadDir returns the next n entries, or all remaining ones if n <= 0.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.node.sorted[d.offset:]
+	if n <= 0 {
+		d.offset += len(remaining)
+		return append([]fs.DirEntry(nil), remaining...), nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+	d.offset += n
+	return append([]fs.DirEntry(nil), remaining[:n]...), nil
+}
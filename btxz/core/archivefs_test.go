@@ -0,0 +1,78 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// fsEntries make a tree with stored and implied directories, an empty file,
+// and links of both kinds.
+var fsEntries = []testEntry{
+	{name: "docs/", typeflag: tar.TypeDir},
+	{name: "docs/readme.md", content: "# readme"},
+	{name: "docs/empty", content: ""},
+	{name: "implied/deep/file.txt", content: "no directory entries above"},
+	{name: "docs/copy.md", typeflag: tar.TypeLink, linkname: "docs/readme.md"},
+	{name: "latest", typeflag: tar.TypeSymlink, linkname: "docs/readme.md"},
+}
+
+// fsFiles are the files of fsEntries as fstest.TestFS expects them.
+var fsFiles = []string{"docs/readme.md", "docs/empty", "docs/copy.md", "implied/deep/file.txt", "latest"}
+
+func TestOpenFSConformance(t *testing.T) {
+	fsys, err := OpenFS(writeTestArchive(t, fsEntries), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(fsys, fsFiles...); err != nil {
+		t.Error(err)
+	}
+	data, err := fs.ReadFile(fsys, "latest")
+	if err != nil || string(data) != "# readme" {
+		t.Errorf("latest: read %q, %v", data, err)
+	}
+}
+
+func TestOpenFSWithoutIndex(t *testing.T) {
+	// A streamed archive cut before its trailer has no index to find.
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "", CreateOptions{Level: "low", NoEncrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range fsEntries {
+		hdr := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Linkname: entry.linkname, Mode: 0o644, ModTime: time.Unix(1700000000, 0)}
+		switch entry.typeflag {
+		case 0:
+			hdr.Typeflag, hdr.Size = tar.TypeReg, int64(len(entry.content))
+		case tar.TypeDir:
+			hdr.Mode = 0o755
+		}
+		if err := w.AddFile(hdr, bytes.NewReader([]byte(entry.content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(t.TempDir(), "streamed.btxz")
+	if err := os.WriteFile(archive, buf.Bytes()[:buf.Len()-indexTrailerSize], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := openArchiveFS(archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fsys.index != nil {
+		t.Fatal("the archive was read through an index")
+	}
+	if err := fstest.TestFS(fsys, fsFiles...); err != nil {
+		t.Error(err)
+	}
+}
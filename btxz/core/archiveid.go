@@ -0,0 +1,68 @@
+// File: core/archiveid.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file derives a stable identifier for an archive, for tools that keep
+// per-archive state such as a stored password. It is read from the header
+// without a password and survives renaming, moving, modifying and rekeying.
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+)
+
+const (
+	// archiveIDLabel is hashed in front of the identifying header bytes.
+	archiveIDLabel = "BTXZ archive id"
+	// legacyIDSize is the number of leading bytes that identify a legacy
+	// archive; they cover its header with the random salt and nonce.
+	legacyIDSize = 64
+)
+
+// ArchiveID returns a stable identifier of an archive: 32 hex digits. For v4
+// archives it is derived from the key check value, which only changes when
+// the archive key does, that is when the archive is recreated. Legacy archives
+// cannot be modified and are identified by their header. Unencrypted archives
+// have no key and no identifier.
+func ArchiveID(archivePath string) (string, error) {
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return "", err
+	}
+	archiveFile, err := openArchiveFile(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer archiveFile.Close()
+
+	var identifying []byte
+	if version == coreVersionV4 {
+		header, err := readHeaderV4(archiveFile)
+		if err != nil {
+			return "", err
+		}
+		if !header.encrypted() {
+			return "", errors.New("the archive is not encrypted")
+		}
+		identifying = header.KeyCheck[:]
+	} else {
+		identifying = make([]byte, legacyIDSize)
+		n, err := io.ReadFull(archiveFile, identifying)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", err
+		}
+		identifying = identifying[:n]
+	}
+	hash := sha256.New()
+	hash.Write([]byte(archiveIDLabel))
+	hash.Write(identifying)
+	return hex.EncodeToString(hash.Sum(nil)[:16]), nil
+}
+
+// PlainPassword reports whether a secret is a password alone, without a
+// keyfile, identity, key shares or security key.
+func PlainPassword(secret string) bool {
+	return secret != "" && keyFlagsFor(secret) == keyFlagPassword
+}
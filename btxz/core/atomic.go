@@ -0,0 +1,115 @@
+// File: core/atomic.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements atomic archive creation. A new archive is written to
+// its path with ".partial" appended, in the same directory, synced to disk and
+// only then renamed into place. A crash, a full disk or a cancellation thus
+// never leaves a truncated file under the archive's name, and an existing
+// archive of that name is kept until its replacement is complete. Split
+// archives are written as name.btxz.partial.001, ... and renamed volume by
+// volume. With CreateOptions.NoOverwrite, an existing archive is not replaced
+// at all. Modifying, rekeying and converting an archive go through the same
+// partial file.
+package core
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// partialSuffix is appended to the path of an archive while it is written.
+const partialSuffix = ".partial"
+
+// archiveOutput is where a new archive is written: a partialFile, or the
+// volumeWriter of a split archive.
+type archiveOutput interface {
+	// commit makes the complete archive available at archivePath.
+	commit(archivePath string) error
+	// abort removes what was written.
+	abort()
+}
+
+// checkNoArchive returns an error wrapping fs.ErrExist if NoOverwrite is set
+// and the archive at archivePath, or its first volume, exists.
+func (opts *CreateOptions) checkNoArchive(archivePath string) error {
+	if !opts.NoOverwrite {
+		return nil
+	}
+	if opts.VolumeSize > 0 {
+		archivePath = volumePath(archivePath, 1)
+	}
+	if _, err := os.Lstat(archivePath); err == nil {
+		return fmt.Errorf("%s already exists: %w", archivePath, fs.ErrExist)
+	}
+	return nil
+}
+
+// partialFile is a single-file archive written under its partial name.
+type partialFile struct {
+	*os.File
+}
+
+// createPartial creates the partial file of the archive at archivePath,
+// replacing one left behind by an earlier run.
+func createPartial(archivePath string) (*partialFile, error) {
+	file, err := os.Create(archivePath + partialSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("could not create archive file: %w", err)
+	}
+	return &partialFile{file}, nil
+}
+
+// commit syncs and closes the file, and renames it to archivePath.
+func (p *partialFile) commit(archivePath string) error {
+	if err := p.Sync(); err != nil {
+		return fmt.Errorf("could not write archive file: %w", err)
+	}
+	if err := p.Close(); err != nil {
+		return fmt.Errorf("could not write archive file: %w", err)
+	}
+	if err := os.Rename(p.Name(), archivePath); err != nil {
+		return fmt.Errorf("could not move archive into place: %w", err)
+	}
+	return nil
+}
+
+func (p *partialFile) abort() {
+	p.Close()
+	os.Remove(p.Name())
+}
+
+// commit syncs and closes the volumes, renames them to the volumes of the
+// split archive at base, and removes higher-numbered volumes left behind by an
+// earlier, larger archive of that name.
+func (vw *volumeWriter) commit(base string) error {
+	if err := vw.current.Sync(); err != nil {
+		return fmt.Errorf("could not write archive volume: %w", err)
+	}
+	if vw.current != vw.first {
+		if err := vw.first.Sync(); err != nil {
+			return fmt.Errorf("could not write archive volume: %w", err)
+		}
+	}
+	if err := vw.Close(); err != nil {
+		return fmt.Errorf("could not write archive volume: %w", err)
+	}
+	for n := 1; n <= vw.count; n++ {
+		if err := os.Rename(volumePath(vw.base, n), volumePath(base, n)); err != nil {
+			return fmt.Errorf("could not move archive volume into place: %w", err)
+		}
+	}
+	for n := vw.count + 1; ; n++ {
+		if err := os.Remove(volumePath(base, n)); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (vw *volumeWriter) abort() {
+	vw.Close()
+	for n := 1; n <= vw.count; n++ {
+		os.Remove(volumePath(vw.base, n))
+	}
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// dirNames returns the names in dir, sorted.
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestRewritesGoThroughPartialFile(t *testing.T) {
+	src := legacyInput(t)
+	extra := filepath.Join(t.TempDir(), "extra.txt")
+	if err := os.WriteFile(extra, []byte("extra"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	createV4 := func(archive string) error {
+		_, err := CreateArchiveV4(archive, []string{src}, "secret", CreateOptions{Level: "low", KDF: fastKDF})
+		return err
+	}
+	for _, test := range []struct {
+		name    string
+		create  func(archive string) error
+		rewrite func(archive string) error
+		opens   string // The password that opens the result
+	}{
+		{
+			name:    "add",
+			create:  createV4,
+			rewrite: func(archive string) error { return AppendToArchiveV4(archive, []string{extra}, "secret", false) },
+			opens:   "secret",
+		},
+		{
+			name:   "remove",
+			create: createV4,
+			rewrite: func(archive string) error {
+				_, err := RemoveFromArchiveV4(archive, "secret", []string{"hello.txt"}, false)
+				return err
+			},
+			opens: "secret",
+		},
+		{
+			name:    "rekey",
+			create:  createV4,
+			rewrite: func(archive string) error { return RekeyArchive(archive, "secret", "new secret") },
+			opens:   "new secret",
+		},
+		{
+			name:   "convert in place",
+			create: func(archive string) error { return CreateArchiveV2(archive, []string{src}, "secret", "low") },
+			rewrite: func(archive string) error {
+				return ConvertArchive(archive, archive, "secret", CreateOptions{Level: "low", KDF: fastKDF})
+			},
+			opens: "secret",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "test.btxz")
+			if err := test.create(archive); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(archive, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			// A partial file left behind by an earlier run is replaced.
+			if err := os.WriteFile(archive+partialSuffix, []byte("stale"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := test.rewrite(archive); err != nil {
+				t.Fatal(err)
+			}
+			if names := dirNames(t, dir); !slices.Equal(names, []string{"test.btxz"}) {
+				t.Errorf("the directory holds %v, want only the archive", names)
+			}
+			if err := TestArchive(archive, test.opens); err != nil {
+				t.Errorf("the rewritten archive fails: %v", err)
+			}
+			if info, err := os.Stat(archive); err != nil || info.Mode().Perm() != 0o600 {
+				t.Errorf("the rewritten archive has mode %v, %v; want 0600", info.Mode().Perm(), err)
+			}
+		})
+	}
+}
+
+func TestFailedRewriteKeepsArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "test.btxz")
+	if _, err := CreateArchiveV4(archive, []string{legacyInput(t)}, "secret", CreateOptions{Level: "low", KDF: fastKDF}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+	if err := AppendToArchiveV4(archive, []string{missing}, "secret", false); err == nil {
+		t.Fatal("adding a missing file succeeded")
+	}
+	after, err := os.ReadFile(archive)
+	if err != nil || !slices.Equal(before, after) {
+		t.Errorf("the archive was changed by the failed rewrite (%v)", err)
+	}
+	if names := dirNames(t, dir); !slices.Equal(names, []string{"test.btxz"}) {
+		t.Errorf("the directory holds %v, want only the archive", names)
+	}
+}
+
+func TestNoOverwrite(t *testing.T) {
+	src := legacyInput(t)
+	create := func(archive string, opts CreateOptions) error {
+		opts.Level, opts.NoEncrypt = "low", true
+		_, err := CreateArchiveWithOptions(archive, []string{src}, "", opts)
+		return err
+	}
+	for _, test := range []struct {
+		name     string
+		opts     CreateOptions
+		existing string // Name of the file that is in the way
+	}{
+		{"single file", CreateOptions{NoOverwrite: true}, "test.btxz"},
+		{"split archive", CreateOptions{NoOverwrite: true, VolumeSize: 64 * 1024}, "test.btxz.001"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "test.btxz")
+			existing := filepath.Join(dir, test.existing)
+			if err := os.WriteFile(existing, []byte("mine"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := create(archive, test.opts); !errors.Is(err, fs.ErrExist) {
+				t.Fatalf("err = %v, want fs.ErrExist", err)
+			}
+			if data, _ := os.ReadFile(existing); string(data) != "mine" {
+				t.Errorf("%s was replaced", test.existing)
+			}
+			if names := dirNames(t, dir); !slices.Equal(names, []string{test.existing}) {
+				t.Errorf("the directory holds %v, want only %s", names, test.existing)
+			}
+			// Without NoOverwrite the archive is replaced.
+			opts := test.opts
+			opts.NoOverwrite = false
+			if err := create(archive, opts); err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := os.ReadFile(existing); string(data) == "mine" {
+				t.Errorf("%s was not replaced", test.existing)
+			}
+		})
+	}
+	t.Run("created meanwhile", func(t *testing.T) {
+		archive := filepath.Join(t.TempDir(), "test.btxz")
+		created := false
+		progress := func(done, total int64) {
+			if !created {
+				created = true
+				if err := os.WriteFile(archive, []byte("mine"), 0o644); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+		if err := create(archive, CreateOptions{NoOverwrite: true, Progress: progress}); !errors.Is(err, fs.ErrExist) {
+			t.Fatalf("err = %v, want fs.ErrExist", err)
+		}
+		if data, _ := os.ReadFile(archive); string(data) != "mine" {
+			t.Error("the archive created meanwhile was replaced")
+		}
+		if _, err := os.Lstat(archive + partialSuffix); err == nil {
+			t.Error("the partial file was left behind")
+		}
+	})
+}
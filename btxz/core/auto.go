@@ -0,0 +1,127 @@
+// File: core/auto.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements --level auto. ChooseLevel picks the strongest profile
+// whose Argon2 memory and compression memory fit in half of the available RAM,
+// that the CPUs can keep busy, and whose key derivation a short benchmark
+// expects to take no more than about a second. Between default and max, it
+// may choose the compression of default with a stronger KDF. The choice is
+// made from MachineStats alone, so it can be checked with any stats; the
+// archive records the concrete parameters as with a fixed level.
+package core
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// MachineStats are the resources of a machine ChooseLevel decides by.
+type MachineStats struct {
+	AvailableMemory uint64        // RAM available for new allocations in bytes, 0 if unknown
+	CPUs            int           // Logical CPUs
+	Benchmark       time.Duration // One Argon2 pass over benchmarkMemory with 4 threads, 0 if not measured
+}
+
+// AutoChoice is the profile ChooseLevel picked, and why.
+type AutoChoice struct {
+	Level  string    // A profile for CreateOptions.Level: low, default or max
+	KDF    KDFParams // Argon2 parameters for CreateOptions.KDF, zero for those of the profile
+	Reason string    // Why it was chosen, for the user
+}
+
+const (
+	// benchmarkMemory is the Argon2 memory, in KiB, of the benchmark pass.
+	benchmarkMemory = 64 * 1024
+	// benchmarkDuration is how long ReadMachineStats benchmarks at most.
+	benchmarkDuration = 200 * time.Millisecond
+	// autoUnlockBudget is the longest key derivation ChooseLevel accepts.
+	autoUnlockBudget = time.Second
+)
+
+// autoCandidate is a parameter set ChooseLevel may pick, and what it needs.
+type autoCandidate struct {
+	label   string // Its name in the reason
+	level   string
+	kdf     KDFParams
+	minCPUs int
+}
+
+// autoCandidates are tried strongest first. The last one is always chosen if
+// no other fits.
+var autoCandidates = []autoCandidate{
+	{label: "max", level: "max", minCPUs: 4},
+	{label: "default with a stronger KDF", level: "default", kdf: KDFParams{Memory: 256 * 1024, Time: 2}, minCPUs: 2},
+	{label: "default", level: "default", minCPUs: 2},
+	{label: "low", level: "low"},
+}
+
+// ReadMachineStats returns the resources of this machine. With benchmark, it
+// also times Argon2 passes for up to benchmarkDuration, keeping the fastest.
+func ReadMachineStats(benchmark bool) MachineStats {
+	stats := MachineStats{AvailableMemory: availableMemory(), CPUs: runtime.NumCPU()}
+	if benchmark {
+		params := KDFParams{Memory: benchmarkMemory, Time: 1, Threads: argon2Threads}
+		for start := time.Now(); time.Since(start) < benchmarkDuration; {
+			if elapsed := measureKDF(params); stats.Benchmark == 0 || elapsed < stats.Benchmark {
+				stats.Benchmark = elapsed
+			}
+		}
+	}
+	return stats
+}
+
+// ChooseLevel picks the parameters of a new archive for a machine with stats.
+func ChooseLevel(stats MachineStats) AutoChoice {
+	available := stats.AvailableMemory
+	facts := []string{fmt.Sprintf("%d MiB of RAM available", available>>20)}
+	if available == 0 {
+		available = fallbackAvailableMemory
+		facts = []string{fmt.Sprintf("available RAM unknown, assuming %d MiB", available>>20)}
+	}
+	facts = append(facts, fmt.Sprintf("%d CPUs", stats.CPUs))
+	if stats.Benchmark > 0 {
+		facts = append(facts, fmt.Sprintf("an Argon2 pass over %d MiB takes %s", benchmarkMemory/1024, stats.Benchmark.Round(time.Millisecond)))
+	}
+
+	var rejected string
+	choose := func(candidate autoCandidate, kdf KDFParams) AutoChoice {
+		why := strings.Join(facts, ", ")
+		if rejected != "" {
+			why += "; " + rejected
+		}
+		return AutoChoice{Level: candidate.level, KDF: candidate.kdf, Reason: fmt.Sprintf("%s (%s): %s", candidate.label, kdf, why)}
+	}
+	last := len(autoCandidates) - 1
+	for _, candidate := range autoCandidates[:last] {
+		profile := profileForLevel(candidate.level)
+		kdf, _ := profile.kdfParams(candidate.kdf)
+		compress, _ := DictMemory(int64(profile.dictCap))
+		need := uint64(kdf.Memory)*1024 + uint64(compress)
+		unlock := estimateKDF(kdf, stats.Benchmark)
+		var reason string
+		switch {
+		case need > available/2:
+			reason = fmt.Sprintf("%s needs %d MiB, more than half of it", candidate.label, need>>20)
+		case stats.CPUs < candidate.minCPUs:
+			reason = fmt.Sprintf("%s needs %d CPUs", candidate.label, candidate.minCPUs)
+		case unlock > autoUnlockBudget:
+			reason = fmt.Sprintf("%s would take about %s to unlock", candidate.label, unlock.Round(100*time.Millisecond))
+		}
+		if reason == "" {
+			return choose(candidate, kdf)
+		}
+		// The reason the next stronger candidate was passed over.
+		rejected = reason
+	}
+	kdf, _ := profileForLevel(autoCandidates[last].level).kdfParams(KDFParams{})
+	return choose(autoCandidates[last], kdf)
+}
+
+// estimateKDF returns how long a key derivation with params takes, scaled
+// from benchmark, or 0 if benchmark is 0. Argon2 takes time in proportion to
+// its memory and passes.
+func estimateKDF(params KDFParams, benchmark time.Duration) time.Duration {
+	return benchmark * time.Duration(params.Memory) / benchmarkMemory * time.Duration(params.Time)
+}
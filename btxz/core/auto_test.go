@@ -0,0 +1,64 @@
+package core
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChooseLevel(t *testing.T) {
+	const MiB = 1 << 20
+	stronger := KDFParams{Memory: 256 * 1024, Time: 2}
+	for _, test := range []struct {
+		name   string
+		stats  MachineStats
+		level  string
+		kdf    KDFParams
+		reason string // Part of the reason given
+	}{
+		{"large machine", MachineStats{AvailableMemory: 8192 * MiB, CPUs: 8, Benchmark: 20 * time.Millisecond}, "max", KDFParams{}, "8192 MiB of RAM available, 8 CPUs"},
+		{"not benchmarked", MachineStats{AvailableMemory: 8192 * MiB, CPUs: 8}, "max", KDFParams{}, "8 CPUs"},
+		{"memory unknown", MachineStats{CPUs: 8}, "max", KDFParams{}, "available RAM unknown, assuming 2048 MiB"},
+		{"memory for max", MachineStats{AvailableMemory: 1664 * MiB, CPUs: 4}, "max", KDFParams{}, ""},
+		{"memory just short of max", MachineStats{AvailableMemory: 1663 * MiB, CPUs: 4}, "default", stronger, "max needs 832 MiB, more than half of it"},
+		{"1 GiB", MachineStats{AvailableMemory: 1024 * MiB, CPUs: 8}, "default", stronger, "max needs 832 MiB"},
+		{"512 MiB", MachineStats{AvailableMemory: 512 * MiB, CPUs: 8}, "default", KDFParams{}, "default with a stronger KDF needs 296 MiB"},
+		{"256 MiB", MachineStats{AvailableMemory: 256 * MiB, CPUs: 8}, "low", KDFParams{}, "default needs 168 MiB"},
+		{"3 CPUs", MachineStats{AvailableMemory: 8192 * MiB, CPUs: 3}, "default", stronger, "max needs 4 CPUs"},
+		{"1 CPU", MachineStats{AvailableMemory: 8192 * MiB, CPUs: 1}, "low", KDFParams{}, "default needs 2 CPUs"},
+		{"slow KDF", MachineStats{AvailableMemory: 8192 * MiB, CPUs: 8, Benchmark: 50 * time.Millisecond}, "default", stronger, "max would take about 1.6s to unlock"},
+		{"slower KDF", MachineStats{AvailableMemory: 8192 * MiB, CPUs: 8, Benchmark: 200 * time.Millisecond}, "default", KDFParams{}, "default with a stronger KDF would take about 1.6s to unlock"},
+		{"very slow KDF", MachineStats{AvailableMemory: 8192 * MiB, CPUs: 8, Benchmark: time.Second}, "low", KDFParams{}, "default would take about 2s to unlock"},
+		{"unlock within the budget", MachineStats{AvailableMemory: 8192 * MiB, CPUs: 8, Benchmark: autoUnlockBudget / 32}, "max", KDFParams{}, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			choice := ChooseLevel(test.stats)
+			if choice.Level != test.level || choice.KDF != test.kdf {
+				t.Errorf("chose %s with %+v, want %s with %+v: %s", choice.Level, choice.KDF, test.level, test.kdf, choice.Reason)
+			}
+			if !strings.Contains(choice.Reason, test.reason) {
+				t.Errorf("reason %q does not say %q", choice.Reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestEstimateKDF(t *testing.T) {
+	benchmark := 100 * time.Millisecond
+	for _, test := range []struct {
+		params KDFParams
+		want   time.Duration
+	}{
+		{KDFParams{Memory: benchmarkMemory, Time: 1}, benchmark},
+		{KDFParams{Memory: 2 * benchmarkMemory, Time: 1}, 2 * benchmark},
+		{KDFParams{Memory: benchmarkMemory, Time: 3}, 3 * benchmark},
+		{KDFParams{Memory: benchmarkMemory / 2, Time: 4}, 2 * benchmark},
+	} {
+		if got := estimateKDF(test.params, benchmark); got != test.want {
+			t.Errorf("estimateKDF(%v) = %s, want %s", test.params, got, test.want)
+		}
+	}
+	if got := estimateKDF(KDFParams{Memory: benchmarkMemory, Time: 4}, 0); got != 0 {
+		t.Errorf("without a benchmark the estimate is %s, want 0", got)
+	}
+}
@@ -0,0 +1,146 @@
+// File: core/cascade.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the payload ciphers of v4 archives. The default is
+// XChaCha20-Poly1305 keyed directly with the archive key. The cascade mode,
+// for policies that require an AES-based layer, derives two independent
+// subkeys from the archive key with HKDF-SHA256 and seals every chunk with
+// AES-256-GCM first and XChaCha20-Poly1305 around it, so the 192-bit nonces of
+// XChaCha20 protect the outer layer and breaking either cipher alone reveals
+// nothing.
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+
+	"btxz/internal/secmem"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// cipherXChaCha20 seals the payload with XChaCha20-Poly1305 (the default).
+	cipherXChaCha20 = uint8(0)
+	// cipherCascade seals the payload with AES-256-GCM inside XChaCha20-Poly1305.
+	cipherCascade = uint8(1)
+
+	// cascadeOuterLabel and cascadeInnerLabel are the HKDF info strings of the
+	// two subkeys of the cascade.
+	cascadeOuterLabel = "BTXZ v4 cascade XChaCha20-Poly1305"
+	cascadeInnerLabel = "BTXZ v4 cascade AES-256-GCM"
+)
+
+// parseCipher maps a cipher name to its header value. An empty name selects
+// XChaCha20-Poly1305.
+func parseCipher(name string) (uint8, error) {
+	switch strings.ToLower(name) {
+	case "", "xchacha20", "xchacha20-poly1305":
+		return cipherXChaCha20, nil
+	case "cascade":
+		return cipherCascade, nil
+	default:
+		return 0, fmt.Errorf("unknown cipher %q (use xchacha20 or cascade)", name)
+	}
+}
+
+// cipherName returns the display name of a cipher header value.
+func cipherName(id uint8) string {
+	switch id {
+	case cipherXChaCha20:
+		return "XChaCha20-Poly1305"
+	case cipherCascade:
+		return "AES-256-GCM + XChaCha20-Poly1305 (cascade)"
+	default:
+		return fmt.Sprintf("unknown(%d)", id)
+	}
+}
+
+// newPayloadCipher returns the payload cipher with the given header value for
+// an archive key.
+func newPayloadCipher(id uint8, key []byte) (cipher.AEAD, error) {
+	switch id {
+	case cipherXChaCha20:
+		aead, err := chacha20poly1305.NewX(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create XChaCha20-Poly1305 AEAD: %w", err)
+		}
+		return aead, nil
+	case cipherCascade:
+		return newCascadeCipher(key)
+	default:
+		return nil, fmt.Errorf("unsupported v4 archive: unknown cipher %d; a newer version of btxz is required", id)
+	}
+}
+
+// cascadeCipher seals with AES-256-GCM, then with XChaCha20-Poly1305 over the
+// result. Both layers authenticate the same additional data.
+type cascadeCipher struct {
+	outer cipher.AEAD // XChaCha20-Poly1305
+	inner cipher.AEAD // AES-256-GCM
+}
+
+// newCascadeCipher derives the two subkeys of the cascade from key.
+func newCascadeCipher(key []byte) (cipher.AEAD, error) {
+	outerKey, err := cascadeSubkey(key, cascadeOuterLabel)
+	if err != nil {
+		return nil, err
+	}
+	defer secmem.Wipe(outerKey)
+	innerKey, err := cascadeSubkey(key, cascadeInnerLabel)
+	if err != nil {
+		return nil, err
+	}
+	defer secmem.Wipe(innerKey)
+
+	outer, err := chacha20poly1305.NewX(outerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create XChaCha20-Poly1305 AEAD: %w", err)
+	}
+	block, err := aes.NewCipher(innerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	inner, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES-GCM AEAD: %w", err)
+	}
+	return &cascadeCipher{outer: outer, inner: inner}, nil
+}
+
+// cascadeSubkey derives one 256-bit subkey of the cascade.
+func cascadeSubkey(key []byte, label string) ([]byte, error) {
+	subkey := make([]byte, xKeyLength)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(label)), subkey); err != nil {
+		return nil, err
+	}
+	return subkey, nil
+}
+
+func (c *cascadeCipher) NonceSize() int { return c.outer.NonceSize() }
+
+func (c *cascadeCipher) Overhead() int { return c.outer.Overhead() + c.inner.Overhead() }
+
+// innerNonce returns the AES-GCM nonce for an XChaCha20 nonce: its last 12
+// bytes, which hold the chunk counter of chunk nonces and are random for the
+// index, so it is unique wherever the outer nonce is.
+func (c *cascadeCipher) innerNonce(nonce []byte) []byte {
+	return nonce[len(nonce)-c.inner.NonceSize():]
+}
+
+func (c *cascadeCipher) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	sealed := c.inner.Seal(nil, c.innerNonce(nonce), plaintext, additionalData)
+	return c.outer.Seal(dst, nonce, sealed, additionalData)
+}
+
+func (c *cascadeCipher) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	sealed, err := c.outer.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return c.inner.Open(dst, c.innerNonce(nonce), sealed, additionalData)
+}
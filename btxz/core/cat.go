@@ -0,0 +1,88 @@
+// File: core/cat.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements CatEntries, which writes the content of archive members
+// to a writer without extracting them, for example to pipe a file into another
+// program. It reads the archive through its file system (see archivefs.go), so
+// a v4 archive with an index decodes only the segments of the members.
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// CatEntries writes the content of the named entries to w, one after another
+// in the order they are stored in the archive; a name given twice is written
+// once. Names are matched as for extraction. Hard links and deduplicated
+// copies give the content of their target, and symbolic links are followed
+// within the archive. Every name is looked up before anything is written, so
+// a missing entry or a directory fails the call without output.
+func CatEntries(archivePath, password string, names []string, w io.Writer) error {
+	if len(names) == 0 {
+		return errors.New("no entries specified")
+	}
+	fsys, err := openArchiveFS(archivePath, password)
+	if err != nil {
+		return err
+	}
+
+	type member struct {
+		name  string
+		order int
+	}
+	var members []member
+	var missing []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = normalizeEntryName(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		node, err := fsys.lookupLink("cat", name)
+		if err != nil {
+			missing = append(missing, name)
+			continue
+		}
+		target, err := fsys.lookup("cat", name)
+		if err != nil {
+			return err
+		}
+		if target.IsDir() {
+			return fmt.Errorf("%s is a directory", name)
+		}
+		members = append(members, member{name: name, order: node.order})
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("entries not found in archive: %s", strings.Join(missing, ", "))
+	}
+	if len(members) == 0 {
+		return errors.New("no entries specified")
+	}
+
+	sort.SliceStable(members, func(i, j int) bool { return members[i].order < members[j].order })
+	for _, m := range members {
+		if err := catEntry(fsys, m.name, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catEntry copies the content of a single entry to w.
+func catEntry(fsys *archiveFS, name string, w io.Writer) error {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.Copy(w, file); err != nil {
+		return fmt.Errorf("error reading %s: %w", name, err)
+	}
+	return nil
+}
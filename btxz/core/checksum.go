@@ -0,0 +1,125 @@
+// File: core/checksum.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements per-file checksums. The writer records the SHA-256 digest
+// of every regular file in the index, and test and extract re-hash the content
+// as it streams to name the files that do not match.
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+)
+
+// fileDigests maps entry names to the SHA-256 digest of their content. A nil
+// map verifies nothing, which is the case for archives without checksums.
+type fileDigests map[string][]byte
+
+// digests collects the checksums recorded in the index.
+func (index *archiveIndex) digests() fileDigests {
+	if index == nil {
+		return nil
+	}
+	var digests fileDigests
+	for _, e := range index.Entries {
+		sum, err := hex.DecodeString(e.SHA256)
+		if err != nil || len(sum) != sha256.Size {
+			continue
+		}
+		if digests == nil {
+			digests = make(fileDigests)
+		}
+		digests[e.Name] = sum
+	}
+	return digests
+}
+
+// copyVerified copies the content of an entry to dst. It reports false if the
+// entry has a recorded checksum that the content does not match.
+func (d fileDigests) copyVerified(dst io.Writer, src io.Reader, name string) (bool, error) {
+	want, ok := d[name]
+	if !ok {
+		_, err := io.Copy(dst, src)
+		return true, err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, hash), src); err != nil {
+		return false, err
+	}
+	return bytes.Equal(hash.Sum(nil), want), nil
+}
+
+// verifier returns content checked against the checksum recorded for name:
+// the read that reaches the end of the content fails if it does not match.
+func (d fileDigests) verifier(name string, content io.Reader) io.Reader {
+	want, ok := d[name]
+	if !ok {
+		return content
+	}
+	return &verifyingReader{r: content, name: name, hash: sha256.New(), want: want}
+}
+
+// verifyingReader hashes the content of an entry as it is read.
+type verifyingReader struct {
+	r    io.Reader
+	name string
+	hash hash.Hash // nil once the content was checked
+	want []byte
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	if v.hash != nil {
+		v.hash.Write(p[:n])
+		if err == io.EOF {
+			sum := v.hash.Sum(nil)
+			v.hash = nil
+			if !bytes.Equal(sum, v.want) {
+				return n, checksumError([]string{v.name})
+			}
+		}
+	}
+	return n, err
+}
+
+// verifyTarStream reads a whole tar stream and returns the names of the regular
+// files whose content does not match their recorded checksum.
+func verifyTarStream(tarReader *tar.Reader, digests fileDigests) ([]string, error) {
+	var mismatched []string
+	var entries entryCounter
+	for {
+		hdr, err := entries.next(tarReader)
+		if err == io.EOF {
+			return mismatched, nil
+		}
+		if err != nil {
+			return mismatched, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		ok, err := digests.copyVerified(io.Discard, tarReader, hdr.Name)
+		if err != nil {
+			return mismatched, err
+		}
+		if !ok {
+			mismatched = append(mismatched, hdr.Name)
+		}
+	}
+}
+
+// checksumError returns an error naming the files whose checksum did not match.
+func checksumError(mismatched []string) error {
+	if len(mismatched) == 0 {
+		return nil
+	}
+	sort.Strings(mismatched)
+	return fmt.Errorf("checksum mismatch: %s", strings.Join(mismatched, ", "))
+}
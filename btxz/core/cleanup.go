@@ -0,0 +1,92 @@
+// File: core/cleanup.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements ExtractOptions.CleanupOnError. The extraction records
+// every file, link and directory it creates, and if it fails, removes them
+// again, newest first, so that the output directory is left as it was rather
+// than holding a mix of restored and stale files. Directories are only removed
+// once empty; what cannot be removed is reported.
+package core
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// createdPaths records the paths an extraction created, in order. It is
+// shared by the writer pool, so every method takes the lock; a nil
+// *createdPaths records nothing.
+type createdPaths struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+// trackCreated sets opts.created if opts.CleanupOnError asks for the paths of
+// a failed extraction to be removed. A dry run writes nothing to remove.
+func (opts *ExtractOptions) trackCreated() {
+	if opts.CleanupOnError && !opts.DryRun {
+		opts.created = &createdPaths{}
+	}
+}
+
+// add records a file or link about to be written at path.
+func (c *createdPaths) add(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paths = append(c.paths, path)
+}
+
+// mkdirAll creates the directory at path and its missing parents like
+// os.MkdirAll, and records those it created.
+func (c *createdPaths) mkdirAll(path string) error {
+	if c == nil {
+		return os.MkdirAll(path, 0755)
+	}
+	var missing []string
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	err := os.MkdirAll(path, 0755)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(missing) - 1; i >= 0; i-- {
+		if info, statErr := os.Lstat(missing[i]); statErr == nil && info.IsDir() {
+			c.paths = append(c.paths, missing[i])
+		}
+	}
+	return err
+}
+
+// cleanup removes the recorded paths if err is a failure, and records in stats
+// that it ran and what it could not remove. An extraction stopped by ctx keeps
+// what it wrote, as it is reported.
+func (c *createdPaths) cleanup(ctx context.Context, err error, stats *ExtractStats) {
+	if c == nil || err == nil || canceledBy(ctx, err) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats.CleanedUp = true
+	removed := make(map[string]bool, len(c.paths))
+	for i := len(c.paths) - 1; i >= 0; i-- {
+		path := c.paths[i]
+		if removed[path] {
+			// Written twice, by two entries of the same name.
+			continue
+		}
+		removed[path] = true
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			stats.CleanupFailed = append(stats.CleanupFailed, path)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// File: core/codec.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file holds the compression backends of the v4 format. The codec is
+// recorded in the archive header, so readers always pick the right decoder.
+package core
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	// codecXZ compresses with LZMA2/XZ (the default).
+	codecXZ = uint8(0x00)
+	// codecZstd compresses with Zstandard.
+	codecZstd = uint8(0x01)
+	// codecS2 compresses with S2, an LZ77 codec in the class of LZ4 aimed at raw speed.
+	codecS2 = uint8(0x02)
+	// codecStore skips compression and encrypts the raw tar stream.
+	codecStore = uint8(0x03)
+	// codecAuto picks a codec per entry; the choice is recorded per segment in the index.
+	codecAuto = uint8(0x04)
+
+	// autoCompressedCodec is used by the auto codec for data worth compressing.
+	autoCompressedCodec = codecXZ
+	// autoSampleSize is the amount of file content test-compressed by the auto codec.
+	autoSampleSize = 64 * 1024
+	// autoStoreRatio is the sample ratio above which a file is considered incompressible.
+	autoStoreRatio = 0.95
+)
+
+// defaultStoreExtensions lists formats that are already compressed, which the
+// auto codec stores without sampling them.
+var defaultStoreExtensions = []string{
+	".7z", ".aac", ".apk", ".avi", ".avif", ".br", ".btxz", ".bz2", ".docx", ".epub",
+	".flac", ".gif", ".gz", ".heic", ".jar", ".jpeg", ".jpg", ".lz4", ".m4a", ".mkv",
+	".mov", ".mp3", ".mp4", ".ogg", ".opus", ".png", ".pptx", ".rar", ".tgz", ".webm",
+	".webp", ".whl", ".xlsx", ".xz", ".zip", ".zst",
+}
+
+// parseCodec maps a codec name to its header value. An empty name selects XZ.
+func parseCodec(name string) (uint8, error) {
+	switch strings.ToLower(name) {
+	case "", "xz", "lzma2":
+		return codecXZ, nil
+	case "zstd", "zstandard":
+		return codecZstd, nil
+	case "s2", "lz4":
+		// lz4 is accepted as an alias for the ultra-fast backend.
+		return codecS2, nil
+	case "store", "none":
+		return codecStore, nil
+	case "auto":
+		return codecAuto, nil
+	default:
+		return 0, fmt.Errorf("unknown codec %q (use xz, zstd, s2, store or auto)", name)
+	}
+}
+
+// codecName returns the display name of a codec header value.
+func codecName(codec uint8) string {
+	switch codec {
+	case codecXZ:
+		return "xz"
+	case codecZstd:
+		return "zstd"
+	case codecS2:
+		return "s2"
+	case codecStore:
+		return "store"
+	case codecAuto:
+		return "auto"
+	default:
+		return fmt.Sprintf("unknown(%d)", codec)
+	}
+}
+
+// zstdLevelFor maps a profile to a Zstandard encoder level.
+func zstdLevelFor(profile profileV4) zstd.EncoderLevel {
+	switch {
+	case profile.level == 0:
+		return zstd.SpeedFastest
+	case profile.level <= 3:
+		return zstd.SpeedDefault
+	case profile.level <= 6:
+		return zstd.SpeedBetterCompression
+	default:
+		return zstd.SpeedBestCompression
+	}
+}
+
+// zstdWindowFor returns the zstd window for a dictionary size: the largest
+// power of two that does not exceed it, within the limits of the format.
+func zstdWindowFor(dictCap int) int {
+	window := zstd.MinWindowSize
+	for window*2 <= dictCap && window*2 <= zstd.MaxWindowSize {
+		window *= 2
+	}
+	return window
+}
+
+// newCompressorV4 returns a factory for compressed streams of the given codec,
+// configured from the profile.
+func newCompressorV4(codec uint8, profile profileV4) (compressorFunc, error) {
+	switch codec {
+	case codecXZ:
+		// Using a larger dictionary improves compression but requires more memory for both compression and decompression.
+		xzConfig := xz.WriterConfig{
+			DictCap: profile.dictCap,
+		}
+		if err := xzConfig.Verify(); err != nil {
+			return nil, fmt.Errorf("failed to create xz writer: %w", err)
+		}
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return xzConfig.NewWriter(w)
+		}, nil
+	case codecZstd:
+		options := []zstd.EOption{
+			zstd.WithEncoderLevel(zstdLevelFor(profile)),
+			zstd.WithWindowSize(zstdWindowFor(profile.dictCap)),
+			zstd.WithEncoderConcurrency(1),
+		}
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w, options...)
+		}, nil
+	case codecS2:
+		options := []s2.WriterOption{s2.WriterConcurrency(1)}
+		if profile.level >= 7 {
+			options = append(options, s2.WriterBetterCompression())
+		}
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return s2.NewWriter(w, options...), nil
+		}, nil
+	case codecStore:
+		return func(w io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", codecName(codec))
+	}
+}
+
+// newDecompressorV4 wraps r with the decoder for the codec. Every decoder
+// reads a concatenation of independently compressed segments. The output is
+// bounded by Limits.OutputSize.
+func newDecompressorV4(codec uint8, r io.Reader) (io.Reader, error) {
+	switch codec {
+	case codecXZ:
+		xzReader, err := xz.NewReader(r)
+		if err != nil {
+			if isDecryptionError(err) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("failed to create xz reader: %w", err)
+		}
+		return limitOutput(xzReader), nil
+	case codecZstd:
+		zstdReader, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		return limitOutput(zstdReader.IOReadCloser()), nil
+	case codecS2:
+		return limitOutput(s2.NewReader(r)), nil
+	case codecStore:
+		return limitOutput(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", codecName(codec))
+	}
+}
+
+// nopWriteCloser passes writes through unchanged; it is the "compressor" of the store codec.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// codecChooser decides per entry whether the auto codec compresses or stores it.
+type codecChooser struct {
+	store  map[string]bool
+	sample []byte
+	probe  *flate.Writer
+	probed countingWriter
+}
+
+// newCodecChooser returns a chooser that stores the default incompressible
+// formats plus the given extra extensions (with or without the leading dot).
+func newCodecChooser(extraExtensions []string) *codecChooser {
+	c := &codecChooser{store: make(map[string]bool), sample: make([]byte, autoSampleSize)}
+	c.probe, _ = flate.NewWriter(&c.probed, flate.BestSpeed)
+	for _, ext := range append(defaultStoreExtensions, extraExtensions...) {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		c.store[ext] = true
+	}
+	return c
+}
+
+// choose picks the codec for an entry. Unknown extensions are decided by
+// test-compressing the first bytes of the content with a fast Deflate pass. It
+// returns a reader that yields the complete content, including the sampled part.
+func (c *codecChooser) choose(name string, content io.Reader) (uint8, io.Reader, error) {
+	if c.store[strings.ToLower(path.Ext(name))] {
+		return codecStore, content, nil
+	}
+	if content == nil {
+		return autoCompressedCodec, content, nil
+	}
+
+	n, err := io.ReadFull(content, c.sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, nil, err
+	}
+	sample := append([]byte(nil), c.sample[:n]...)
+	content = io.MultiReader(bytes.NewReader(sample), content)
+	if n == 0 {
+		return autoCompressedCodec, content, nil
+	}
+
+	c.probed = countingWriter{w: io.Discard}
+	c.probe.Reset(&c.probed)
+	if _, err := c.probe.Write(sample); err != nil {
+		return 0, nil, err
+	}
+	if err := c.probe.Close(); err != nil {
+		return 0, nil, err
+	}
+	if float64(c.probed.n)/float64(n) > autoStoreRatio {
+		return codecStore, content, nil
+	}
+	return autoCompressedCodec, content, nil
+}
@@ -0,0 +1,246 @@
+// File: core/collisions.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements ExtractOptions.CaseCollisions. Entry names that differ
+// only by case, such as README and Readme, or only by Unicode normal form name
+// the same file on the default file systems of macOS and Windows, so the later
+// entry would silently replace the earlier one. The extraction notices such
+// names, checks once with a probe file whether the file system of the output
+// directory folds them, and then renames, skips or refuses the later entry.
+package core
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The values of ExtractOptions.CaseCollisions.
+const (
+	CaseCollisionRename = "rename" // Write the later entry under a name with a numbered suffix
+	CaseCollisionSkip   = "skip"   // Leave the later entry out
+	CaseCollisionError  = "error"  // Stop the extraction
+)
+
+// RenamedEntry is an entry written under another name, as its own name
+// collides with that of an earlier entry.
+type RenamedEntry struct {
+	Name string // The entry name
+	As   string // The name it was written under
+}
+
+// CollisionError is returned with CaseCollisionError when entry names
+// collide on the file system of the output directory. Pairs holds the names,
+// the earlier one first.
+type CollisionError struct {
+	Pairs [][2]string
+}
+
+func (e *CollisionError) Error() string {
+	pairs := make([]string, len(e.Pairs))
+	for i, pair := range e.Pairs {
+		pairs[i] = fmt.Sprintf("%s and %s", pair[0], pair[1])
+	}
+	return "entry names collide on this file system, which ignores case or Unicode normal form: " + strings.Join(pairs, "; ")
+}
+
+// CaseCollisions returns the pairs of entry names that differ but name the
+// same file on a file system that ignores case and Unicode normal form, the
+// earlier name first. Directories should be left out of names: two spellings
+// of a directory merge harmlessly.
+func CaseCollisions(names []string) [][2]string {
+	first := make(map[string]string, len(names))
+	var pairs [][2]string
+	for _, name := range names {
+		key := foldName(name, true, true)
+		if earlier, ok := first[key]; !ok {
+			first[key] = name
+		} else if earlier != name {
+			pairs = append(pairs, [2]string{earlier, name})
+		}
+	}
+	return pairs
+}
+
+// foldName returns name as a file system that ignores case, Unicode normal
+// form or both compares it.
+func foldName(name string, foldCase, foldForm bool) string {
+	if foldForm {
+		name = norm.NFC.String(name)
+	}
+	if foldCase {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
+// collisionPath is a path an extraction wrote, and the entry it holds.
+type collisionPath struct {
+	path  string
+	entry string
+}
+
+// collisionCheck finds the entries of an extraction whose paths collide with
+// that of an earlier entry. Only names that differ by case or form at all are
+// checked against the file system, so most extractions never probe it.
+type collisionCheck struct {
+	mode     string
+	dir      string // The output directory
+	probed   bool
+	foldCase bool                       // The file system ignores case
+	foldForm bool                       // The file system ignores Unicode normal form
+	seen     map[string][]collisionPath // Written paths by foldName with both folds
+	renamed  map[string]string          // Paths of renamed entries, to their new path
+	skipped  map[string]bool            // Paths of skipped entries
+}
+
+// newCollisionCheck checks mode, a CaseCollisions value, for an extraction
+// into outputDir.
+func newCollisionCheck(outputDir, mode string) (*collisionCheck, error) {
+	switch mode {
+	case "":
+		mode = CaseCollisionRename
+	case CaseCollisionRename, CaseCollisionSkip, CaseCollisionError:
+	default:
+		return nil, fmt.Errorf("invalid case collision policy %q: use rename, skip or error", mode)
+	}
+	return &collisionCheck{mode: mode, dir: outputDir, seen: make(map[string][]collisionPath), renamed: make(map[string]string), skipped: make(map[string]bool)}, nil
+}
+
+// check records that entry is about to be written at path, relative to the
+// output directory. It returns the earlier entry whose path collides with it,
+// or "". On a collision with CaseCollisionRename, the entry is recorded under
+// the path returned instead.
+func (c *collisionCheck) check(path, entry string) (string, string) {
+	if renamed, ok := c.renamed[path]; ok {
+		// Another entry of a renamed name replaces it under its new name.
+		return renamed, ""
+	}
+	key := foldName(path, true, true)
+	for _, earlier := range c.seen[key] {
+		if earlier.path == path {
+			// The same name twice: the later entry replaces the earlier.
+			return path, ""
+		}
+	}
+	for _, earlier := range c.seen[key] {
+		if !c.collide(earlier.path, path) {
+			continue
+		}
+		switch c.mode {
+		case CaseCollisionRename:
+			renamed := c.rename(path)
+			c.renamed[path] = renamed
+			c.add(renamed, entry)
+			return renamed, earlier.entry
+		case CaseCollisionSkip:
+			c.skipped[path] = true
+		}
+		return path, earlier.entry
+	}
+	c.add(path, entry)
+	return path, ""
+}
+
+func (c *collisionCheck) add(path, entry string) {
+	key := foldName(path, true, true)
+	c.seen[key] = append(c.seen[key], collisionPath{path: path, entry: entry})
+}
+
+// collide reports whether two different paths name the same file here.
+func (c *collisionCheck) collide(a, b string) bool {
+	if !c.probed {
+		c.probed = true
+		c.foldCase, c.foldForm = probeFolding(c.dir)
+	}
+	return (c.foldCase || c.foldForm) && foldName(a, c.foldCase, c.foldForm) == foldName(b, c.foldCase, c.foldForm)
+}
+
+// rename returns path with a numbered suffix before its extension,
+// "Readme~2.md" for "Readme.md", that collides with nothing written yet.
+func (c *collisionCheck) rename(path string) string {
+	ext := filepath.Ext(path)
+	if ext == filepath.Base(path) {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(path, ext)
+	for n := 2; ; n++ {
+		candidate := stem + "~" + strconv.Itoa(n) + ext
+		if len(c.seen[foldName(candidate, true, true)]) == 0 {
+			return candidate
+		}
+	}
+}
+
+// linkTarget returns the path a hard link to the entry at path must point to,
+// and false if that entry was skipped.
+func (c *collisionCheck) linkTarget(path string) (string, bool) {
+	if c.skipped[path] {
+		return path, false
+	}
+	if renamed, ok := c.renamed[path]; ok {
+		return renamed, true
+	}
+	return path, true
+}
+
+// probeFolding reports whether the file system holding dir, or its nearest
+// existing parent, ignores case and Unicode normal form in names, by creating
+// a file and looking it up under other spellings. If no file can be created,
+// it assumes names are compared as they are. It is a variable so that tests
+// can stand in for a file system that folds names.
+var probeFolding = func(dir string) (foldCase, foldForm bool) {
+	dir, ok := existingDir(dir)
+	if !ok {
+		return false, false
+	}
+	// "é" in NFC, so the NFD spelling differs.
+	probe, err := os.CreateTemp(dir, ".btxz-case-é-*")
+	if err != nil {
+		return false, false
+	}
+	probe.Close()
+	defer os.Remove(probe.Name())
+	base := filepath.Base(probe.Name())
+	exists := func(name string) bool {
+		_, err := os.Lstat(filepath.Join(dir, name))
+		return err == nil
+	}
+	return exists(strings.ToUpper(base)), exists(norm.NFD.String(base))
+}
+
+// checkIndexCollisions returns a *CollisionError listing every pair of
+// colliding names in an archive with an index, before anything is written,
+// if opts.CaseCollisions is CaseCollisionError. Without an index, the
+// extraction stops at the first collision.
+func checkIndexCollisions(index *archiveIndex, outputDir string, opts ExtractOptions) error {
+	if index == nil || opts.CaseCollisions != CaseCollisionError {
+		return nil
+	}
+	diskPath, err := opts.diskPaths()
+	if err != nil {
+		return err
+	}
+	c, err := newCollisionCheck(outputDir, opts.CaseCollisions)
+	if err != nil {
+		return err
+	}
+	var pairs [][2]string
+	for _, entry := range index.Entries {
+		if entry.Type == tar.TypeDir {
+			continue
+		}
+		if _, earlier := c.check(diskPath(entry.Name), entry.Name); earlier != "" {
+			pairs = append(pairs, [2]string{earlier, entry.Name})
+		}
+	}
+	if len(pairs) > 0 {
+		return &CollisionError{Pairs: pairs}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+// File: core/context.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements cancellation. The Context variants of the entry points
+// check the context between entries and wrap the data being read, so a long
+// run stops at the next read once the context is done. A canceled archive is
+// removed rather than left half-written, and so is a partially extracted file.
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// CreateArchiveContext creates an archive like CreateArchiveWithOptions, and
+// stops once ctx is done. The partially written archive, or every volume of a
+// split archive, is then removed and the context's error returned; an archive
+// that existed at archivePath is kept.
+func CreateArchiveContext(ctx context.Context, archivePath string, inputPaths []string, password string, opts CreateOptions) (CreateStats, error) {
+	if err := ctx.Err(); err != nil {
+		return CreateStats{}, err
+	}
+	opts.ctx = ctx
+	stats, err := CreateArchiveWithOptions(archivePath, inputPaths, password, opts)
+	// The partial archive was removed, and archivePath never touched.
+	if canceledBy(ctx, err) {
+		return stats, ctx.Err()
+	}
+	return stats, err
+}
+
+// ExtractArchiveContext extracts an archive like ExtractArchiveWithOptions,
+// and stops once ctx is done. The file being written is then removed; the
+// entries extracted before are kept. This is the entry point that honors
+// opts.Verify and opts.CleanupOnError.
+func ExtractArchiveContext(ctx context.Context, archivePath, outputDir, password string, opts ExtractOptions) (ExtractStats, error) {
+	if err := ctx.Err(); err != nil {
+		return ExtractStats{}, err
+	}
+	opts.ctx = ctx
+	opts.trackCreated()
+	stats, err := extractVerified(opts, func(opts ExtractOptions) (ExtractStats, error) {
+		return ExtractArchiveWithOptions(archivePath, outputDir, password, opts)
+	})
+	opts.created.cleanup(ctx, err, &stats)
+	if canceledBy(ctx, err) {
+		return stats, ctx.Err()
+	}
+	return stats, err
+}
+
+// TestArchiveContext validates an archive like TestArchive, and stops once ctx
+// is done.
+func TestArchiveContext(ctx context.Context, archivePath, password string) error {
+	_, err := VerifyArchiveContext(ctx, archivePath, password)
+	return err
+}
+
+// VerifyArchiveContext validates an archive like VerifyArchive, and stops once
+// ctx is done. Legacy archives, which are decrypted in memory as a whole, are
+// checked for cancellation only before they are read.
+func VerifyArchiveContext(ctx context.Context, archivePath, password string) (ArchiveInfo, error) {
+	info, err := verifyArchive(ctx, archivePath, password)
+	if canceledBy(ctx, err) {
+		return info, ctx.Err()
+	}
+	return info, err
+}
+
+// ListArchiveContentsContext lists an archive like ListArchiveContents, and
+// stops once ctx is done.
+func ListArchiveContentsContext(ctx context.Context, archivePath, password string) ([]ArchiveEntry, error) {
+	contents, _, err := ListArchiveContext(ctx, archivePath, password)
+	return contents, err
+}
+
+// ListArchiveContext lists an archive like ListArchive, and stops once ctx is
+// done. As with VerifyArchiveContext, legacy archives are checked only before
+// they are read.
+func ListArchiveContext(ctx context.Context, archivePath, password string) ([]ArchiveEntry, ArchiveInfo, error) {
+	contents, info, err := listArchive(ctx, archivePath, password)
+	if canceledBy(ctx, err) {
+		return nil, info, ctx.Err()
+	}
+	return contents, info, err
+}
+
+// contextErr returns the error of ctx, which may be nil for "never canceled".
+func contextErr(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// canceledBy reports whether err was caused by ctx being done.
+func canceledBy(ctx context.Context, err error) bool {
+	ctxErr := contextErr(ctx)
+	return err != nil && ctxErr != nil && errors.Is(err, ctxErr)
+}
+
+// contextReader returns r failing every read with the error of ctx once ctx
+// is done. Contexts that are never canceled are not wrapped.
+func contextReader(ctx context.Context, r io.Reader) io.Reader {
+	if ctx == nil || ctx.Done() == nil {
+		return r
+	}
+	return &cancelableReader{ctx: ctx, r: r}
+}
+
+// cancelableReader checks its context before every read.
+type cancelableReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *cancelableReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
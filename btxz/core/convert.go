@@ -0,0 +1,183 @@
+// File: core/convert.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the conversion of legacy (v1 to v3) archives to the
+// current format. Entries are streamed from the decrypted legacy payload straight
+// into a new archive, so no plaintext file ever touches the disk.
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// entryVisitor receives the entries of an archive in order. content is nil for
+// entries without data.
+type entryVisitor func(hdr *tar.Header, content io.Reader) error
+
+// ConvertArchive rewrites a legacy archive as a v4 archive at outputPath, with a
+// fresh salt and nonce and the profile and codec given in opts. The new archive
+// is written to the partial file of outputPath (see atomic.go) and verified
+// before it is moved into place, so outputPath may be the source archive itself.
+func ConvertArchive(archivePath, outputPath, password string, opts CreateOptions) error {
+	if opts.VolumeSize > 0 {
+		return errors.New("converted archives cannot be split into volumes")
+	}
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return err
+	}
+	if version == coreVersionV4 {
+		return errors.New("archive already uses the current format (v4)")
+	}
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return err
+	}
+
+	opts.NoDedup = true
+	fill := func(writer *writerV4) error {
+		return visitLegacyEntries(archivePath, password, version, func(hdr *tar.Header, content io.Reader) error {
+			hdr.Name = writer.normalize(hdr.Name)
+			return writer.addEntry(hdr, content)
+		})
+	}
+	_, err = writeArchiveV4(outputPath, password, opts, fill, func(partialPath string) error {
+		if err := TestArchiveV4(partialPath, password); err != nil {
+			return fmt.Errorf("the converted archive failed verification: %w", err)
+		}
+		return os.Chmod(partialPath, info.Mode().Perm())
+	})
+	if err != nil {
+		return fmt.Errorf("conversion failed: %w", err)
+	}
+	return nil
+}
+
+// visitLegacyEntries decrypts a v1, v2 or v3 archive and passes its entries to visit.
+func visitLegacyEntries(archivePath, password string, version uint16, visit entryVisitor) error {
+	switch version {
+	case coreVersionV1:
+		payloadReader, err := getDecryptedReaderV1(archivePath, password)
+		if err != nil {
+			return err
+		}
+		defer payloadReader.Close()
+		xzReader, err := xz.NewReader(payloadReader)
+		if err != nil {
+			return fmt.Errorf("failed to create xz reader: %w", err)
+		}
+		return visitTarEntries(tar.NewReader(limitOutput(xzReader)), visit)
+	case coreVersionV2:
+		return visitZipEntriesV2(archivePath, password, visit)
+	case coreVersionV3:
+		payloadReader, err := getDecryptedReaderV3(archivePath, password)
+		if err != nil {
+			return err
+		}
+		xzReader, err := xz.NewReader(payloadReader)
+		if err != nil {
+			return fmt.Errorf("failed to create xz reader: %w", err)
+		}
+		return visitTarEntries(tar.NewReader(limitOutput(xzReader)), visit)
+	default:
+		return fmt.Errorf("unsupported archive core version: v%d", version)
+	}
+}
+
+// visitTarEntries passes every entry of a tar stream to visit.
+func visitTarEntries(tarReader *tar.Reader, visit entryVisitor) error {
+	var entries entryCounter
+	for {
+		hdr, err := entries.next(tarReader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar stream: %w", err)
+		}
+		var content io.Reader
+		if hdr.Typeflag == tar.TypeReg {
+			content = tarReader
+		}
+		if err := visit(hdr, content); err != nil {
+			return err
+		}
+	}
+}
+
+// discardEntry is an entryVisitor that reads the content of an entry and
+// throws it away, for the checks made while it is read.
+func discardEntry(hdr *tar.Header, content io.Reader) error {
+	if content == nil {
+		return nil
+	}
+	_, err := io.Copy(io.Discard, content)
+	return err
+}
+
+// visitZipEntriesV2 passes every member of a v2 archive to visit as a tar entry.
+func visitZipEntriesV2(archivePath, password string, visit entryVisitor) error {
+	payloadReader, err := getDecryptedReaderV2(archivePath, password)
+	if err != nil {
+		return err
+	}
+	zstdReader, err := zstd.NewReader(payloadReader)
+	if err != nil {
+		return fmt.Errorf("failed to create zstd reader: %w", err)
+	}
+	defer zstdReader.Close()
+
+	unzippedData, err := io.ReadAll(limitOutput(zstdReader))
+	if err != nil {
+		return fmt.Errorf("failed to decompress archive data: %w", err)
+	}
+	zipArchive, err := zip.NewReader(bytes.NewReader(unzippedData), int64(len(unzippedData)))
+	if err != nil {
+		return fmt.Errorf("failed to read zip stream from decompressed data: %w", err)
+	}
+	if err := checkZipLimits(zipArchive.File); err != nil {
+		return err
+	}
+
+	for _, file := range zipArchive.File {
+		hdr, err := tar.FileInfoHeader(file.FileInfo(), "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = file.Name
+		hdr.ModTime = file.Modified
+		if hdr.Typeflag == tar.TypeDir {
+			if !strings.HasSuffix(hdr.Name, "/") {
+				hdr.Name += "/"
+			}
+			if err := visit(hdr, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = visit(hdr, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,17 +3,20 @@
 package core
 
 import (
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"time"
 )
 
 // peekVersion opens an archive file, reads just the header to identify the
 // format version, and then closes the file. This allows the dispatcher to
 // call the correct version-specific logic.
 func peekVersion(archivePath string) (uint16, error) {
-	file, err := os.Open(archivePath)
+	file, err := openArchiveFile(archivePath)
 	if err != nil {
 		return 0, fmt.Errorf("could not open archive file: %w", err)
 	}
@@ -25,7 +28,12 @@ func peekVersion(archivePath string) (uint16, error) {
 	if _, err := file.Read(headerStart); err != nil {
 		return 0, fmt.Errorf("could not read archive header: %w", err)
 	}
+	return parseVersion(headerStart)
+}
 
+// parseVersion checks the signature at the start of an archive and returns
+// the format version that follows it.
+func parseVersion(headerStart []byte) (uint16, error) {
 	// Check signature
 	if string(headerStart[0:4]) != magicSignature {
 		return 0, errors.New("not a valid BTXZ archive")
@@ -36,64 +44,454 @@ func peekVersion(archivePath string) (uint16, error) {
 	return version, nil
 }
 
-// CreateArchive creates a new archive. By default, it creates the latest version (v3).
+// CreateOptions holds the optional settings for archive creation.
+type CreateOptions struct {
+	// Level selects the adaptive profile: "low", "default" or "max", or a
+	// compression level "0" to "9".
+	Level string
+	// DictSize overrides the dictionary (xz) or window (zstd) size of the
+	// level, in bytes, between MinDictSize and MaxDictSize. Zero keeps it.
+	DictSize int64
+	// Codec selects the compression backend: "xz" (default), "zstd", "s2" ("lz4")
+	// "store" (no compression) or "auto" (compress or store each file as it suits).
+	Codec string
+	// StoreExtensions adds file extensions that the auto codec always stores
+	// without compression, on top of its built-in list of compressed formats.
+	StoreExtensions []string
+	// NoDedup disables content-hash deduplication, so identical files are
+	// stored as independent copies (plain tar semantics).
+	NoDedup bool
+	// VolumeSize splits the archive into numbered volumes (name.001, name.002, ...)
+	// of at most this many bytes. Zero writes a single file.
+	VolumeSize int64
+	// NormalizeNames converts entry names to a Unicode normal form: "nfc", "nfd",
+	// or "none" (the default) to store names byte for byte.
+	NormalizeNames string
+	// Comment is a UTF-8 description stored encrypted in the archive index.
+	Comment string
+	// Secrets are further passwords (or keyfile and recipient secrets) that open
+	// the archive, each through its own key slot next to the main password's.
+	Secrets []string
+	// KDF overrides the Argon2 parameters of the profile. Zero fields keep the
+	// profile's values.
+	KDF KDFParams
+	// Cipher selects the payload cipher: "xchacha20" (default) or "cascade"
+	// (AES-256-GCM inside XChaCha20-Poly1305, see cascade.go).
+	Cipher string
+	// FIDO2 binds the archive to a security key (see fido2.go). The password
+	// must then be built with FIDO2Secret from its response for this binding.
+	FIDO2 *FIDO2Binding
+	// Threads compresses the payload in blocks, up to this many at once (see
+	// parallel.go). It is lowered if the blocks would not fit into the available
+	// RAM. 0 or 1 compresses each segment as a single stream.
+	Threads int
+	// Progress, if set, is called as the input files are read, with the bytes
+	// processed so far and their total size (see progress.go).
+	Progress ProgressFunc
+	// NoEncrypt writes the payload unencrypted (see plaintext.go). The password
+	// must then be empty; anyone can read the archive.
+	NoEncrypt bool
+	// Stdin is read as a single regular file where an input path is "-"
+	// (StdinPath), named StdinName or "stdin". Its content is not counted by
+	// Progress, as its size is unknown until it ends (see spool.go).
+	Stdin     io.Reader
+	StdinName string
+	// Exclude skips the files and directories whose entry name matches one of
+	// these patterns, such as "node_modules", "*.o" or "build/**/*.tmp" (see
+	// filter.go). Excluded directories are not walked.
+	Exclude []string
+	// Include, if not empty, stores only the files matching one of these
+	// patterns and no exclude pattern, such as "**/*.go". Directories are then
+	// not stored; extraction creates them for the files inside.
+	Include []string
+	// Dereference follows symbolic links below the input paths, storing the
+	// files and directories they point to instead of the links (see
+	// dereference.go). A link loop fails the creation.
+	Dereference bool
+	// KeepRoot stores the entries of an input folder below the folder's own
+	// name, as tar does: ./project is stored as project/ and project/a.txt.
+	// Without it, the folder itself is not stored and its entries are named
+	// relative to it (a.txt). Input files are stored under their base name
+	// either way.
+	KeepRoot bool
+	// Reproducible, if set, makes the archive a function of its inputs, its
+	// options, the password and Reproducible.Seed, so that creating it again
+	// from the same files gives the same bytes (see reproducible.go). Only
+	// archives created from paths or a file system can be reproducible, not
+	// those of a Writer or with standard input among the inputs.
+	Reproducible *Reproducible
+	// Snapshot, if set, makes the archive an incremental backup: files that
+	// match the snapshot are left out, and the entries it records that are gone
+	// are listed as deleted (see incremental.go). Save the snapshot once the
+	// archive is created. Only archives created from paths can use one.
+	Snapshot *Snapshot
+	// Base, if set, makes the archive differential: files whose content
+	// matches the file of the same name in the base archive are not stored but
+	// listed as taken from it (see diffbase.go). Only archives created from
+	// paths can have a base.
+	Base *DiffBase
+	// NoOverwrite refuses to replace an archive that exists at the archive
+	// path, or its first volume for a split archive, with an error wrapping
+	// fs.ErrExist. It is checked before anything is written and again just
+	// before the archive is moved into place. Archives written to an
+	// io.Writer ignore it.
+	NoOverwrite bool
+
+	ctx    context.Context     // Set by CreateArchiveContext (nil = never canceled)
+	digest []byte              // Digest of the inputs of a reproducible archive, set before the setup
+	inputs []reproducibleInput // What digest was taken of, in the order of the walk
+}
+
+// CreateStats reports what happened while creating an archive.
+type CreateStats struct {
+	DedupFiles int       // Files stored as references to an identical earlier file
+	DedupBytes int64     // Bytes not stored thanks to deduplication
+	KDF        KDFParams // Effective Argon2 parameters of the key slots (zero if unencrypted)
+	Threads    int       // Number of blocks compressed at once
+	StdinBytes int64     // Bytes read from CreateOptions.Stdin
+	Excluded   int       // Files and directories left out by CreateOptions.Exclude and Include
+	InputBytes int64     // Content size of the files added, not counting CreateOptions.Stdin
+	Backup     string    // BackupFull or BackupIncremental with CreateOptions.Snapshot
+	Unchanged  int       // Files left out as they match CreateOptions.Snapshot
+	Deleted    int       // Entries of CreateOptions.Snapshot listed as deleted
+	FromBase   int       // Files taken unchanged from CreateOptions.Base
+	// NotPortable lists the entries whose names do not extract unchanged on
+	// Windows, such as aux.c or "notes?", in archive order (see portable.go).
+	NotPortable []string
+}
+
+// CreateArchive creates a new archive. By default, it creates the latest version (v4).
 // It serves as the single entry point for archive creation.
 func CreateArchive(archivePath string, inputPaths []string, password string, level string) error {
-	// New archives are created using the secure v3 format (Pro).
-	return CreateArchiveV3(archivePath, inputPaths, password, level)
+	_, err := CreateArchiveWithOptions(archivePath, inputPaths, password, CreateOptions{Level: level})
+	return err
+}
+
+// CreateArchiveWithOptions creates a new archive using the given options.
+func CreateArchiveWithOptions(archivePath string, inputPaths []string, password string, opts CreateOptions) (CreateStats, error) {
+	// New archives are created using the streaming v4 format.
+	return CreateArchiveV4(archivePath, inputPaths, password, opts)
 }
 
-// ExtractArchive inspects the archive version and calls the appropriate
-// version-specific extraction function.
+// ExtractOptions controls what is extracted and how it is written to disk.
+type ExtractOptions struct {
+	// Names restricts extraction to these entries. Empty extracts everything.
+	Names []string
+	// NoTimes leaves extracted files with the current time instead of restoring
+	// their archived modification and access times.
+	NoTimes bool
+	// PreserveOwner restores the archived owner and group of every entry. This
+	// usually requires root privileges.
+	PreserveOwner bool
+	// NormalizeNames converts names to a Unicode normal form before they are
+	// written to disk: "nfc", "nfd", or "none" (the default).
+	NormalizeNames string
+	// CaseCollisions decides what happens to an entry whose name differs from
+	// an earlier one only by case or Unicode normal form, on a file system
+	// that ignores the difference: CaseCollisionRename (the default) writes it
+	// as name~2.ext and records it in ExtractStats.Renamed, CaseCollisionSkip
+	// leaves it out, listed in ExtractStats.Collided, and CaseCollisionError
+	// returns a *CollisionError (see collisions.go).
+	CaseCollisions string
+	// PortableNames escapes the path elements Windows cannot hold, such as
+	// device names like aux.c, names ending in a dot or a space, and names
+	// holding < > : " | ? *, as %XX; ExtractStats.Escaped records the names
+	// written (see portable.go). It is always on on Windows.
+	PortableNames bool
+	// NoSpaceCheck leaves out the check, made before anything is written when
+	// an archive file has an index, that the file system of outputDir has
+	// room for the files; without it, a shortfall returns a *SpaceError (see
+	// space.go).
+	NoSpaceCheck bool
+	// Threads writes small files with this many goroutines while the archive
+	// is read by one (see extractpool.go). 0 or 1 writes one file at a time.
+	Threads int
+	// Progress, if set, is called for every entry and as file content is
+	// extracted (see progress.go).
+	Progress ExtractProgressFunc
+	// Overwrite decides what happens to a file, link or symlink that already
+	// exists where an entry goes: OverwriteAlways (the default), OverwriteNever,
+	// OverwriteNewer or OverwritePrompt (see overwrite.go).
+	Overwrite string
+	// ConfirmOverwrite is asked, with OverwritePrompt, whether the entry name
+	// replaces the existing file. It is called from the goroutine reading the
+	// archive, which waits for the answer.
+	ConfirmOverwrite func(name string, existing os.FileInfo) bool
+	// DryRun reads the whole archive and decides on every entry as extraction
+	// would, but writes nothing; ExtractStats.Planned holds the decisions, and
+	// the other stats what would happen (see dryrun.go). ConfirmOverwrite is
+	// not called, and Threads does not apply.
+	DryRun bool
+	// Verify reads every file back once the extraction is complete and
+	// compares it with what was written to it; ExtractStats.VerifyFailed
+	// lists those that differ (see verifyextract.go). It has no effect with
+	// DryRun.
+	Verify bool
+	// CleanupOnError removes every file, link and directory the extraction
+	// created if it fails, so the output directory is not left half
+	// restored; ExtractStats.CleanedUp and CleanupFailed tell what happened
+	// (see cleanup.go). An extraction stopped by its context keeps what it
+	// wrote. Like Verify, it is honored by ExtractArchiveContext and
+	// ArchiveStream.Extract, for the entries of v1, v3 and v4 archives.
+	CleanupOnError bool
+	// ApplyDeletions removes from outputDir the entries an incremental backup
+	// lists as deleted, once its files are extracted, so applying a chain of
+	// backups in order restores the last state; ExtractStats.Deleted holds
+	// them. It applies when the whole archive is extracted.
+	ApplyDeletions bool
+	// Base is the base archive of a differential archive: once its own
+	// entries are extracted, the files it takes from the base are extracted
+	// from Base, which must be the archive it was created against. It applies
+	// when the whole archive is extracted from a file. Without it, those files
+	// are counted in ExtractStats.BaseMissing.
+	Base *DiffBase
+
+	ctx        context.Context // Set by ExtractArchiveContext (nil = never canceled)
+	written    *writtenFiles   // Set for Verify by ExtractArchiveContext
+	created    *createdPaths   // Set for CleanupOnError by ExtractArchiveContext
+	collisions *collisionCheck // Set by ExtractEntriesV4 for all its segments (nil = one per extractTarStream)
+}
+
+// ExtractStats reports what happened while extracting an archive.
+type ExtractStats struct {
+	Skipped      []string       // Entries not written because their path is unsafe
+	OwnerSkipped []string       // Entries whose owner could not be restored
+	Corrupted    []string       // Entries whose content does not match the recorded checksum
+	Extracted    []string       // Entries written to disk, in archive order
+	Kept         []string       // Entries not written, as ExtractOptions.Overwrite kept the file at their path
+	Overwritten  []string       // Entries that replaced an existing file
+	Planned      []PlannedEntry // With ExtractOptions.DryRun, the action for every selected entry, in archive order
+	Verified     int            // With ExtractOptions.Verify, the number of files read back
+	VerifyFailed []string       // With ExtractOptions.Verify, files that do not hold what was written to them, sorted
+	Deleted      []string       // With ExtractOptions.ApplyDeletions, the entries removed, sorted
+	FromBase     []string       // With ExtractOptions.Base, the files extracted from the base archive
+	BaseMissing  int            // Files of a differential archive not extracted, as ExtractOptions.Base is not set
+	Truncated    string         // The file being written when the context was done, removed as incomplete
+	Renamed      []RenamedEntry // Entries written under another name, as theirs collides with an earlier one (see collisions.go)
+	Collided     []string       // With CaseCollisionSkip, entries not written, as their name collides with an earlier one
+	Escaped      []RenamedEntry // With ExtractOptions.PortableNames, entries written under an escaped name
+	// With ExtractOptions.CleanupOnError, CleanedUp tells that the extraction
+	// failed and what it created was removed, and CleanupFailed lists the
+	// paths that could not be.
+	CleanedUp     bool
+	CleanupFailed []string
+}
+
+// ExtractArchive extracts every entry of an archive into outputDir and returns
+// the names of skipped entries.
 func ExtractArchive(archivePath, outputDir, password string) ([]string, error) {
+	stats, err := ExtractArchiveWithOptions(archivePath, outputDir, password, ExtractOptions{})
+	return stats.Skipped, err
+}
+
+// ExtractEntries extracts only the named entries of an archive into outputDir
+// and returns the names of skipped entries.
+func ExtractEntries(archivePath, outputDir, password string, names []string) ([]string, error) {
+	stats, err := ExtractArchiveWithOptions(archivePath, outputDir, password, ExtractOptions{Names: names})
+	return stats.Skipped, err
+}
+
+// ExtractArchiveWithOptions inspects the archive version and calls the appropriate
+// version-specific extraction function. With opts.Names, archives with an index
+// are accessed randomly, so only the data holding the requested entries is
+// decrypted and decompressed; older formats are scanned until every requested
+// entry has been written. Requested names that are not present in the archive
+// are reported as an error.
+func ExtractArchiveWithOptions(archivePath, outputDir, password string, opts ExtractOptions) (ExtractStats, error) {
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return ExtractStats{}, err
+	}
+
+	if len(opts.Names) > 0 {
+		switch version {
+		case coreVersionV1:
+			return ExtractEntriesV1(archivePath, outputDir, password, opts.Names, opts)
+		case coreVersionV2:
+			return ExtractEntriesV2(archivePath, outputDir, password, opts.Names, opts)
+		case coreVersionV3:
+			return ExtractEntriesV3(archivePath, outputDir, password, opts.Names, opts)
+		case coreVersionV4:
+			return ExtractEntriesV4(archivePath, outputDir, password, opts.Names, opts)
+		}
+	} else {
+		switch version {
+		case coreVersionV1:
+			return ExtractArchiveV1(archivePath, outputDir, password, opts)
+		case coreVersionV2:
+			return ExtractArchiveV2(archivePath, outputDir, password, opts)
+		case coreVersionV3:
+			return ExtractArchiveV3(archivePath, outputDir, password, opts)
+		case coreVersionV4:
+			return ExtractArchiveV4(archivePath, outputDir, password, opts)
+		}
+	}
+	return ExtractStats{}, fmt.Errorf("unsupported archive core version: v%d", version)
+}
+
+// AppendToArchive adds files and folders to an existing archive. Entries that
+// already exist are refused unless replace is set. Unchanged data is copied
+// without being recompressed, and the archive is replaced atomically.
+func AppendToArchive(archivePath string, inputPaths []string, password string, replace bool) error {
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return err
+	}
+
+	switch version {
+	case coreVersionV4:
+		return AppendToArchiveV4(archivePath, inputPaths, password, replace)
+	default:
+		return fmt.Errorf("adding files is not supported for legacy archive version v%d", version)
+	}
+}
+
+// SyncArchive updates an archive so it mirrors the input paths, recompressing only
+// new and changed files. Entries whose file no longer exists are dropped when
+// deleteMissing is set. If the archive does not exist yet, it is created with the
+// given level and every file is counted as added.
+func SyncArchive(archivePath string, inputPaths []string, password string, level string, deleteMissing bool) (SyncStats, error) {
+	if _, err := os.Stat(archivePath); os.IsNotExist(err) && splitArchiveBase(archivePath) == "" {
+		var stats SyncStats
+		err := walkInputs(inputPaths, func(filePath, basePath string) error {
+			stats.Added++
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+		return stats, CreateArchive(archivePath, inputPaths, password, level)
+	}
+
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return SyncStats{}, err
+	}
+
+	switch version {
+	case coreVersionV4:
+		return SyncArchiveV4(archivePath, inputPaths, password, deleteMissing)
+	default:
+		return SyncStats{}, fmt.Errorf("sync is not supported for legacy archive version v%d", version)
+	}
+}
+
+// RemoveFromArchive deletes the entries matching the given names or globs from an
+// archive and returns the names of the removed entries.
+func RemoveFromArchive(archivePath, password string, patterns []string, ignoreMissing bool) ([]string, error) {
 	version, err := peekVersion(archivePath)
 	if err != nil {
 		return nil, err
 	}
 
 	switch version {
-	case coreVersionV1:
-		return ExtractArchiveV1(archivePath, outputDir, password)
-	case coreVersionV2:
-		return ExtractArchiveV2(archivePath, outputDir, password)
-	case coreVersionV3:
-		return ExtractArchiveV3(archivePath, outputDir, password)
+	case coreVersionV4:
+		return RemoveFromArchiveV4(archivePath, password, patterns, ignoreMissing)
 	default:
-		return nil, fmt.Errorf("unsupported archive core version: v%d", version)
+		return nil, fmt.Errorf("removing entries is not supported for legacy archive version v%d", version)
 	}
 }
 
-// ListArchiveContents inspects the archive version and calls the appropriate
-// version-specific listing function.
+// ListArchiveContents returns the contents of an archive.
 func ListArchiveContents(archivePath, password string) ([]ArchiveEntry, error) {
+	contents, _, err := ListArchive(archivePath, password)
+	return contents, err
+}
+
+// Creator identifies the program writing archives. It is recorded in every new
+// archive; applications set it to their name and version.
+var Creator = "btxz"
+
+// ArchiveInfo holds archive-level metadata. Legacy formats carry none of it, and
+// archives from older releases lack the creation fields.
+type ArchiveInfo struct {
+	Version int       // Format version of the archive, 1 to 4
+	Comment string    // Description given at creation, if any
+	Creator string    // Program and version that created the archive, if recorded
+	Created time.Time // Creation time; zero if not recorded
+	Profile string    // Profile or compression level chosen at creation, if recorded
+	Level   string    // Compression level 0-9 (v4 archives)
+	// Dictionary (xz) or window (zstd) size in bytes, about the RAM that
+	// extraction needs; zero if unknown or the codec uses none.
+	DictSize int64
+	// Backup is BackupFull or BackupIncremental for an archive created with
+	// CreateOptions.Snapshot, and empty otherwise. Deleted lists, for an
+	// incremental one, the entries that no longer existed, with a trailing
+	// slash for directories.
+	Backup  string
+	Deleted []string
+	// Base is the fingerprint of the base archive of a differential archive
+	// (see DiffBase.Fingerprint), and BaseFiles the number of files it takes
+	// from it; empty for other archives.
+	Base      string
+	BaseFiles int
+}
+
+// ListArchive inspects the archive version and calls the appropriate
+// version-specific listing function. It returns the contents of the archive
+// together with its metadata.
+func ListArchive(archivePath, password string) ([]ArchiveEntry, ArchiveInfo, error) {
+	return listArchive(context.Background(), archivePath, password)
+}
+
+// listArchive lists an archive like ListArchive, stopping once ctx is done.
+func listArchive(ctx context.Context, archivePath, password string) ([]ArchiveEntry, ArchiveInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ArchiveInfo{}, err
+	}
 	version, err := peekVersion(archivePath)
 	if err != nil {
-		return nil, err
+		return nil, ArchiveInfo{}, err
 	}
 
+	var contents []ArchiveEntry
 	switch version {
 	case coreVersionV1:
-		return ListArchiveContentsV1(archivePath, password)
+		contents, err = ListArchiveContentsV1(archivePath, password)
 	case coreVersionV2:
-		return ListArchiveContentsV2(archivePath, password)
+		contents, err = ListArchiveContentsV2(archivePath, password)
 	case coreVersionV3:
-		return ListArchiveContentsV3(archivePath, password)
+		contents, err = ListArchiveContentsV3(archivePath, password)
+	case coreVersionV4:
+		return listArchiveV4(ctx, archivePath, password)
 	default:
-		return nil, fmt.Errorf("unsupported archive core version: v%d", version)
+		err = fmt.Errorf("unsupported archive core version: v%d", version)
 	}
+	return contents, ArchiveInfo{Version: int(version)}, err
 }
 
 // TestArchive validates the integrity of an archive without extracting it.
 func TestArchive(archivePath, password string) error {
+	_, err := VerifyArchive(archivePath, password)
+	return err
+}
+
+// VerifyArchive validates the integrity of an archive without extracting it and
+// returns the archive metadata.
+func VerifyArchive(archivePath, password string) (ArchiveInfo, error) {
+	return verifyArchive(context.Background(), archivePath, password)
+}
+
+// verifyArchive validates an archive like VerifyArchive, stopping once ctx is done.
+func verifyArchive(ctx context.Context, archivePath, password string) (ArchiveInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return ArchiveInfo{}, err
+	}
 	version, err := peekVersion(archivePath)
 	if err != nil {
-		return err
+		return ArchiveInfo{}, err
 	}
 
 	switch version {
+	case coreVersionV1:
+		return ArchiveInfo{Version: coreVersionV1}, TestArchiveV1(archivePath, password)
+	case coreVersionV2:
+		return ArchiveInfo{Version: coreVersionV2}, TestArchiveV2(archivePath, password)
 	case coreVersionV3:
-		return TestArchiveV3(archivePath, password)
+		return ArchiveInfo{Version: coreVersionV3}, TestArchiveV3(archivePath, password)
+	case coreVersionV4:
+		return verifyArchiveV4(ctx, archivePath, password)
 	default:
-		return fmt.Errorf("integrity check not supported for legacy archive version v%d", version)
+		return ArchiveInfo{}, fmt.Errorf("unsupported archive core version: v%d", version)
 	}
 }
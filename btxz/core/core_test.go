@@ -0,0 +1,87 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testEntry is an entry of an archive built by writeTestArchive.
+type testEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+// writeTestArchive writes an unencrypted archive holding entries, in their
+// order, and returns its path.
+func writeTestArchive(t testing.TB, entries []testEntry) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.btxz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	w, err := NewWriter(file, "", CreateOptions{Level: "low", NoEncrypt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Linkname: entry.linkname, Mode: 0644, ModTime: time.Unix(1700000000, 0)}
+		switch entry.typeflag {
+		case 0, tar.TypeReg:
+			hdr.Typeflag, hdr.Size = tar.TypeReg, int64(len(entry.content))
+		case tar.TypeDir:
+			hdr.Mode = 0755
+		}
+		if err := w.AddFile(hdr, bytes.NewReader([]byte(entry.content))); err != nil {
+			t.Fatalf("add %s: %v", entry.name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fastKDF are the cheapest Argon2 parameters accepted, for tests.
+var fastKDF = KDFParams{Memory: MinKDFMemory, Time: 1, Threads: 1}
+
+// encryptedTestArchive writes an archive holding the file secret.txt,
+// encrypted with secret, and returns its path.
+func encryptedTestArchive(t *testing.T, secret string, opts CreateOptions) string {
+	t.Helper()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "secret.txt"), []byte("top secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "test.btxz")
+	opts.Level, opts.KDF = "low", fastKDF
+	if _, err := CreateArchiveWithOptions(archive, []string{src}, secret, opts); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	return archive
+}
+
+// openWith extracts archive with secret and reports the error; on success it
+// checks the content of secret.txt.
+func openWith(t *testing.T, archive, secret string, opts ExtractOptions) error {
+	t.Helper()
+	out := t.TempDir()
+	if _, err := ExtractArchiveWithOptions(archive, out, secret, opts); err != nil {
+		return err
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "secret.txt")); err != nil || string(data) != "top secret" {
+		t.Errorf("secret.txt: read %q, %v", data, err)
+	}
+	return nil
+}
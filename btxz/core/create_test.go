@@ -0,0 +1,351 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// createTestArchive creates an unencrypted archive from inputs and returns
+// its path.
+func createTestArchive(t *testing.T, inputs []string, opts CreateOptions) string {
+	t.Helper()
+	archive := filepath.Join(t.TempDir(), "test.btxz")
+	opts.Level, opts.NoEncrypt = "low", true
+	if _, err := CreateArchiveWithOptions(archive, inputs, "", opts); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	return archive
+}
+
+// treeNames returns the slash-separated paths below dir, directories with a
+// trailing slash, sorted.
+func treeNames(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		name := filepath.ToSlash(rel)
+		if d.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// mkdirs creates the given slash-separated directories below root.
+func mkdirs(t *testing.T, root string, dirs ...string) {
+	t.Helper()
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(dir)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEmptyDirectoriesRoundTrip(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	mkdirs(t, src, "logs", "tmp/cache/deep", "a/b/c/d")
+	if err := os.Chmod(filepath.Join(src, "logs"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	archive := createTestArchive(t, []string{src}, CreateOptions{})
+
+	out := t.TempDir()
+	if _, err := ExtractArchiveWithOptions(archive, out, "", ExtractOptions{}); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if got, want := treeNames(t, out), treeNames(t, src); !slices.Equal(got, want) {
+		t.Errorf("extracted %v, want %v", got, want)
+	}
+	info, err := os.Stat(filepath.Join(out, "logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o700 {
+		t.Errorf("logs has mode %v, want 0700", info.Mode().Perm())
+	}
+}
+
+func TestHeaderOfLargeFile(t *testing.T) {
+	// Only the header is written: the content of a file this size is not.
+	var buf bytes.Buffer
+	w := &writerV4{tw: tar.NewWriter(&buf)}
+	mtime := time.Unix(1700000000, 123456789)
+	const size = 9 << 30
+	if err := w.writeHeader(&tar.Header{Name: "disk.img", Typeflag: tar.TypeReg, Size: size, Mode: 0o644, ModTime: mtime}); err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := tar.NewReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Size != size {
+		t.Errorf("size %d, want %d", hdr.Size, int64(size))
+	}
+	if hdr.Format&tar.FormatPAX == 0 {
+		t.Errorf("format %v, want PAX", hdr.Format)
+	}
+	if !hdr.ModTime.Equal(mtime) {
+		t.Errorf("modification time %v, want %v", hdr.ModTime, mtime)
+	}
+}
+
+func TestLongUTF8NameRoundTrip(t *testing.T) {
+	// Three folders of 100 characters, all but one of them two bytes long:
+	// over 300 characters and 600 bytes with the slashes.
+	element := strings.Repeat("ü", 99)
+	name := element + "1/" + element + "2/" + element + "3"
+	if n := utf8.RuneCountInString(name); n != 302 {
+		t.Fatalf("the name has %d characters", n)
+	}
+	src := filepath.Join(t.TempDir(), "src")
+	path := filepath.Join(src, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("long"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	archive := createTestArchive(t, []string{src}, CreateOptions{})
+
+	entries, _, err := ListArchive(archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(entries, func(e ArchiveEntry) bool { return e.Name == name }) {
+		t.Errorf("%s is not listed", name)
+	}
+	out := t.TempDir()
+	if _, err := ExtractArchiveWithOptions(archive, out, "", ExtractOptions{}); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name))); err != nil || string(data) != "long" {
+		t.Errorf("read %q, %v", data, err)
+	}
+}
+
+func TestWindowsSeparatorsExtractAsTree(t *testing.T) {
+	// As written by a Windows tool that keeps its separators.
+	archive := writeTestArchive(t, []testEntry{
+		{name: `docs\reports\q1.pdf`, content: "report"},
+		{name: `docs\copy.pdf`, typeflag: tar.TypeLink, linkname: `docs\reports\q1.pdf`},
+		{name: `docs\latest`, typeflag: tar.TypeSymlink, linkname: `reports\q1.pdf`},
+	})
+	out := t.TempDir()
+	stats, err := ExtractArchiveWithOptions(archive, out, "", ExtractOptions{})
+	if err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if len(stats.Skipped) != 0 {
+		t.Errorf("skipped %v", stats.Skipped)
+	}
+	want := []string{"docs/", "docs/copy.pdf", "docs/latest", "docs/reports/", "docs/reports/q1.pdf"}
+	if got := treeNames(t, out); !slices.Equal(got, want) {
+		t.Errorf("extracted %v, want %v", got, want)
+	}
+	for _, name := range []string{"docs/copy.pdf", "docs/latest"} {
+		if data, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name))); err != nil || string(data) != "report" {
+			t.Errorf("%s: read %q, %v", name, data, err)
+		}
+	}
+}
+
+func TestNamesAreStoredWithSlashes(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	mkdirs(t, src, "docs/reports")
+	if err := os.WriteFile(filepath.Join(src, "docs", "reports", "q1.pdf"), []byte("report"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("reports", "q1.pdf"), filepath.Join(src, "docs", "latest")); err != nil {
+		t.Skip("symlinks are not available:", err)
+	}
+	archive := createTestArchive(t, []string{src}, CreateOptions{})
+	entries, _, err := ListArchive(archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name, `\`) || strings.Contains(entry.Link, `\`) {
+			t.Errorf("%s -> %s is stored with a backslash", entry.Name, entry.Link)
+		}
+	}
+	// Either separator splits a name on extraction, on every platform.
+	for name, want := range map[string]string{
+		`docs\reports\q1.pdf`: "docs/reports/q1.pdf",
+		"docs/reports/q1.pdf": "docs/reports/q1.pdf",
+		`docs/mixed\q1.pdf`:   "docs/mixed/q1.pdf",
+	} {
+		if got := entryPath(name); got != want {
+			t.Errorf("entryPath(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestKeepRoot(t *testing.T) {
+	root := t.TempDir()
+	mkdirs(t, root, "alpha/sub", "beta")
+	for name, content := range map[string]string{"alpha/a.txt": "a", "alpha/sub/b.txt": "b", "beta/c.txt": "c", "note.txt": "n"} {
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alpha, beta, note := filepath.Join(root, "alpha"), filepath.Join(root, "beta"), filepath.Join(root, "note.txt")
+	for _, test := range []struct {
+		name     string
+		inputs   []string
+		keepRoot []string
+		contents []string
+	}{
+		{
+			name:     "single folder",
+			inputs:   []string{alpha},
+			keepRoot: []string{"alpha/", "alpha/a.txt", "alpha/sub/", "alpha/sub/b.txt"},
+			contents: []string{"a.txt", "sub/", "sub/b.txt"},
+		},
+		{
+			name:     "several folders",
+			inputs:   []string{alpha, beta},
+			keepRoot: []string{"alpha/", "alpha/a.txt", "alpha/sub/", "alpha/sub/b.txt", "beta/", "beta/c.txt"},
+			contents: []string{"a.txt", "c.txt", "sub/", "sub/b.txt"},
+		},
+		{
+			// Files keep their base name either way.
+			name:     "folder and file",
+			inputs:   []string{alpha, note},
+			keepRoot: []string{"alpha/", "alpha/a.txt", "alpha/sub/", "alpha/sub/b.txt", "note.txt"},
+			contents: []string{"a.txt", "note.txt", "sub/", "sub/b.txt"},
+		},
+	} {
+		for _, keepRoot := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s/keep-root=%v", test.name, keepRoot), func(t *testing.T) {
+				archive := createTestArchive(t, test.inputs, CreateOptions{KeepRoot: keepRoot})
+				out := t.TempDir()
+				if _, err := ExtractArchiveWithOptions(archive, out, "", ExtractOptions{}); err != nil {
+					t.Fatalf("extract: %v", err)
+				}
+				want := test.contents
+				if keepRoot {
+					want = test.keepRoot
+				}
+				if got := treeNames(t, out); !slices.Equal(got, want) {
+					t.Errorf("extracted %v, want %v", got, want)
+				}
+			})
+		}
+	}
+	t.Run("file system", func(t *testing.T) {
+		for _, keepRoot := range []bool{true, false} {
+			archive := filepath.Join(t.TempDir(), "test.btxz")
+			opts := CreateOptions{Level: "low", NoEncrypt: true, KeepRoot: keepRoot}
+			if _, err := CreateArchiveFromFSWithOptions(archive, os.DirFS(root), "alpha", "", opts); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			out := t.TempDir()
+			if _, err := ExtractArchiveWithOptions(archive, out, "", ExtractOptions{}); err != nil {
+				t.Fatalf("extract: %v", err)
+			}
+			want := []string{"a.txt", "sub/", "sub/b.txt"}
+			if keepRoot {
+				want = []string{"alpha/", "alpha/a.txt", "alpha/sub/", "alpha/sub/b.txt"}
+			}
+			if got := treeNames(t, out); !slices.Equal(got, want) {
+				t.Errorf("keep-root=%v: extracted %v, want %v", keepRoot, got, want)
+			}
+		}
+	})
+}
+
+func TestIncludeExclude(t *testing.T) {
+	src := t.TempDir()
+	mkdirs(t, src, "docs", "vendor/sub", "empty")
+	for _, name := range []string{"main.go", "main_test.go", "notes.md", "docs/guide.md", "vendor/lib.go", "vendor/sub/deep.go", "vendor/sub/data.txt"} {
+		if err := os.WriteFile(filepath.Join(src, filepath.FromSlash(name)), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		name             string
+		include, exclude []string
+		want             []string // Entry names stored
+		excluded         int      // Entries counted as left out
+	}{
+		{
+			name:     "include only",
+			include:  []string{"*.go"},
+			want:     []string{"main.go", "main_test.go", "vendor/lib.go", "vendor/sub/deep.go"},
+			excluded: 3,
+		},
+		{
+			name:     "exclude only",
+			exclude:  []string{"vendor", "*.md"},
+			want:     []string{"docs/", "empty/", "main.go", "main_test.go"},
+			excluded: 3,
+		},
+		{
+			// An entry matching both is left out.
+			name:     "exclude wins",
+			include:  []string{"*.go", "docs/**"},
+			exclude:  []string{"*_test.go", "vendor/sub"},
+			want:     []string{"docs/guide.md", "main.go", "vendor/lib.go"},
+			excluded: 3,
+		},
+		{
+			// With only an include, directories that do not match are still
+			// walked for files that do, but not stored themselves.
+			name:     "directories traversed",
+			include:  []string{"deep.go"},
+			want:     []string{"vendor/sub/deep.go"},
+			excluded: 6,
+		},
+		{
+			// An excluded directory is pruned: nothing below it is stored,
+			// even what the include matches, and it is counted once.
+			name:     "directories pruned",
+			include:  []string{"deep.go"},
+			exclude:  []string{"vendor"},
+			excluded: 5,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "test.btxz")
+			opts := CreateOptions{Level: "low", NoEncrypt: true, Include: test.include, Exclude: test.exclude}
+			stats, err := CreateArchiveWithOptions(archive, []string{src}, "", opts)
+			if err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			entries, err := ListArchiveContents(archive, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, test.want) {
+				t.Errorf("stored %v, want %v", names, test.want)
+			}
+			if stats.Excluded != test.excluded {
+				t.Errorf("%d entries excluded, want %d", stats.Excluded, test.excluded)
+			}
+		})
+	}
+}
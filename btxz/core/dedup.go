@@ -0,0 +1,98 @@
+// File: core/dedup.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements content-hash deduplication. When a file is byte-identical
+// to one already in the archive, only a small reference entry is stored: a tar
+// link entry marked with a PAX record, which extraction turns back into a real copy.
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// paxDedupKey marks a tar link entry as a deduplicated copy of its link target.
+// The value names the hash used to establish equality.
+const paxDedupKey = "BTXZ.dedup"
+
+// isDedupEntry reports whether a tar header is a deduplication reference.
+func isDedupEntry(hdr *tar.Header) bool {
+	return hdr.Typeflag == tar.TypeLink && hdr.PAXRecords[paxDedupKey] != ""
+}
+
+// dedupCandidate is a stored file that later files may duplicate.
+type dedupCandidate struct {
+	name string                        // Entry name in the archive
+	open func() (io.ReadCloser, error) // Opens the source of the content
+	hash []byte                        // SHA-256 of the content, computed lazily
+}
+
+// deduplicator finds files whose content matches a previously stored file. Files
+// are only hashed once another file of the same size shows up, so unique sizes
+// cost nothing.
+type deduplicator struct {
+	bySize map[int64][]*dedupCandidate
+	files  int   // Number of deduplicated files
+	saved  int64 // Bytes not stored thanks to deduplication
+}
+
+func newDeduplicator() *deduplicator {
+	return &deduplicator{bySize: make(map[int64][]*dedupCandidate)}
+}
+
+// find returns the entry name of a stored file identical to the one opened by
+// open, or "" if there is none. In that case the file is remembered as a
+// candidate itself.
+func (d *deduplicator) find(name string, size int64, open func() (io.ReadCloser, error)) (string, error) {
+	self := &dedupCandidate{name: name, open: open}
+	for _, candidate := range d.bySize[size] {
+		if candidate.hash == nil {
+			hash, err := candidate.sum()
+			if err != nil {
+				return "", err
+			}
+			candidate.hash = hash
+		}
+		if self.hash == nil {
+			hash, err := self.sum()
+			if err != nil {
+				return "", err
+			}
+			self.hash = hash
+		}
+		if bytes.Equal(candidate.hash, self.hash) {
+			d.files++
+			d.saved += size
+			return candidate.name, nil
+		}
+	}
+	d.bySize[size] = append(d.bySize[size], self)
+	return "", nil
+}
+
+// sum returns the SHA-256 digest of the candidate's content.
+func (c *dedupCandidate) sum() ([]byte, error) {
+	file, err := c.open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, fmt.Errorf("could not hash %s: %w", c.name, err)
+	}
+	return hash.Sum(nil), nil
+}
+
+// dedupHeader turns a file header into a reference to the identical entry target.
+func dedupHeader(header *tar.Header, target string) {
+	linkHeader(header, target)
+	if header.PAXRecords == nil {
+		header.PAXRecords = make(map[string]string)
+	}
+	header.PAXRecords[paxDedupKey] = "sha256"
+}
@@ -0,0 +1,125 @@
+// File: core/dereference.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the walk of the input paths, which can follow symbolic
+// links. Without CreateOptions.Dereference, a symlink is stored as the link
+// itself. With it, a link to a file is stored as a regular file holding the
+// target's content, and a link to a directory as a directory whose contents are
+// walked in turn. A link to a directory that contains it would be walked
+// forever, so it fails with an error naming the link.
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// walkOptions selects the entries walkIncluded visits.
+type walkOptions struct {
+	filter      *entryFilter // Picks the entries to visit (nil = all)
+	dereference bool         // Follow symlinks, visiting what they point to
+	keepRoot    bool         // Name the entries of an input folder below the folder's own name
+}
+
+// stat returns the FileInfo an entry is stored with: that of the link itself,
+// or that of its target when dereferencing.
+func (o walkOptions) stat(name string) (os.FileInfo, error) {
+	if o.dereference {
+		return os.Stat(name)
+	}
+	return os.Lstat(name)
+}
+
+// inputTree walks one input path for walkIncluded.
+type inputTree struct {
+	opts     walkOptions
+	basePath string
+	fn       func(filePath, basePath string) error
+	excluded int
+}
+
+// walk visits the tree at root, a path without symlinks when dereferencing,
+// under the name it has below the input path. linkedFrom holds the resolved
+// folders of the symlinks followed to get there, to detect loops.
+func (t *inputTree) walk(root, name string, linkedFrom []string) error {
+	return filepath.Walk(root, func(realPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if realPath == root && linkedFrom != nil {
+			// The entry of a followed link was visited in its parent.
+			return nil
+		}
+		filePath := name
+		if realPath != root {
+			filePath = realPath
+		}
+		if realPath != root && root != name {
+			rel, err := filepath.Rel(root, realPath)
+			if err != nil {
+				return err
+			}
+			filePath = filepath.Join(name, rel)
+		}
+		if info.IsDir() && filePath == t.basePath {
+			// The input folder itself is the root of its entries.
+			return nil
+		}
+		if t.opts.dereference && info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(realPath); err != nil {
+				return fmt.Errorf("broken symbolic link %s: %w", filePath, err)
+			}
+			if info.IsDir() {
+				return t.follow(realPath, filePath, linkedFrom)
+			}
+		}
+		switch t.opts.filter.verdict(entryName(filePath, t.basePath), info.IsDir()) {
+		case excludeEntry:
+			t.excluded++
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case omitEntry:
+			return nil
+		}
+		return t.fn(filePath, t.basePath)
+	})
+}
+
+// follow visits the symlink to a directory at linkPath, named filePath, and
+// walks the directory it points to.
+func (t *inputTree) follow(linkPath, filePath string, linkedFrom []string) error {
+	switch t.opts.filter.verdict(entryName(filePath, t.basePath), true) {
+	case excludeEntry:
+		t.excluded++
+		return nil
+	case keepEntry:
+		if err := t.fn(filePath, t.basePath); err != nil {
+			return err
+		}
+	}
+	target, err := filepath.EvalSymlinks(linkPath)
+	if err != nil {
+		return fmt.Errorf("broken symbolic link %s: %w", filePath, err)
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Dir(linkPath))
+	if err != nil {
+		return err
+	}
+	chain := append(linkedFrom[:len(linkedFrom):len(linkedFrom)], parent)
+	for _, dir := range chain {
+		if within(dir, target) {
+			return fmt.Errorf("symbolic link loop: %s points to %s, a folder that contains it", filePath, target)
+		}
+	}
+	return t.walk(target, filePath, chain)
+}
+
+// within reports whether path is dir or lies below it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
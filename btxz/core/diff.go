@@ -0,0 +1,270 @@
+// File: core/diff.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements comparing an archive with a directory, such as the one
+// it was extracted to or the one it was created from. By default a file counts
+// as changed if its size or modification time differs, which only needs the
+// listing of the archive. With DiffOptions.Content the content is compared
+// instead: the files on disk are hashed and checked against the checksums the
+// archive records, and an archive without them is read in full to hash its
+// files.
+package core
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The changes of a DiffEntry.
+const (
+	DiffMissing  = "missing"  // In the archive, but not in the directory
+	DiffAdded    = "added"    // In the directory, but not in the archive
+	DiffModified = "modified" // In both, with a different size, time, content or link target
+	DiffType     = "type"     // In both, but of a different type, such as a file in place of a directory
+)
+
+// DiffOptions are the options of DiffArchiveContext.
+type DiffOptions struct {
+	// Content compares the content of files by their SHA-256 digests rather
+	// than their size and modification time.
+	Content bool
+}
+
+// DiffEntry is a path that differs between an archive and a directory. Name
+// is relative to the directory, with forward slashes, as in the archive. A
+// folder that is only in the directory ends in a slash, and stands for
+// everything below it.
+type DiffEntry struct {
+	Name   string
+	Change string
+}
+
+// DiffStats is the outcome of DiffArchiveContext.
+type DiffStats struct {
+	Changes   []DiffEntry // Sorted by name
+	Unchanged int         // Entries of the archive that match the directory
+}
+
+// DiffArchiveContext compares the archive with dir, which is taken as the
+// output directory of an extraction: an entry named a/b is looked for at
+// dir/a/b. Directories implied by the names of entries count as part of the
+// archive. Entries whose path would leave dir are left out, as extraction
+// would not write them. It stops once ctx is done.
+func DiffArchiveContext(ctx context.Context, archivePath, dir, password string, opts DiffOptions) (DiffStats, error) {
+	var stats DiffStats
+	entries, err := diffEntries(ctx, archivePath, password, opts.Content)
+	if err != nil {
+		return stats, err
+	}
+
+	byName := make(map[string]ArchiveEntry, len(entries))
+	implied := make(map[string]bool)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name, "/")
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			continue
+		}
+		byName[name] = entry
+		for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+			implied[parent] = true
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		change, err := diffEntry(byName, byName[name], filepath.Join(dir, filepath.FromSlash(name)), opts)
+		if err != nil {
+			return stats, err
+		}
+		if change == "" {
+			stats.Unchanged++
+		} else {
+			stats.Changes = append(stats.Changes, DiffEntry{Name: name, Change: change})
+		}
+	}
+
+	err = filepath.WalkDir(dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil || rel == "." {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if _, ok := byName[name]; ok || implied[name] {
+			return nil
+		}
+		if d.IsDir() {
+			// Everything below is added too; the folder, named with a
+			// trailing slash, stands for it.
+			stats.Changes = append(stats.Changes, DiffEntry{Name: name + "/", Change: DiffAdded})
+			return filepath.SkipDir
+		}
+		stats.Changes = append(stats.Changes, DiffEntry{Name: name, Change: DiffAdded})
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	sort.Slice(stats.Changes, func(i, j int) bool { return stats.Changes[i].Name < stats.Changes[j].Name })
+	return stats, nil
+}
+
+// diffEntry compares entry with what is at filePath, and returns the change,
+// or "" if they match. Hard links and deduplicated copies are compared as the
+// file they link to, which is what extraction writes.
+func diffEntry(byName map[string]ArchiveEntry, entry ArchiveEntry, filePath string, opts DiffOptions) (string, error) {
+	info, err := os.Lstat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return DiffMissing, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	want := entry.Typeflag
+	if want == tar.TypeLink {
+		want = tar.TypeReg
+		if target, ok := byName[strings.TrimSuffix(entry.Link, "/")]; ok {
+			// The link keeps its own name; size and content are the target's.
+			target.Name = entry.Name
+			entry = target
+		}
+	}
+	if hdr, err := tar.FileInfoHeader(info, ""); err != nil || hdr.Typeflag != want {
+		return DiffType, nil
+	}
+
+	switch want {
+	case tar.TypeSymlink:
+		target, err := os.Readlink(filePath)
+		if err != nil {
+			return "", err
+		}
+		if target != entry.Link {
+			return DiffModified, nil
+		}
+	case tar.TypeReg:
+		if info.Size() != entry.Size {
+			return DiffModified, nil
+		}
+		if !opts.Content {
+			// Whole seconds, like create --sync, as entries without PAX
+			// headers keep no more.
+			if !info.ModTime().Round(time.Second).Equal(entry.ModTime.Round(time.Second)) {
+				return DiffModified, nil
+			}
+			return "", nil
+		}
+		sum, err := fileSHA256(filePath)
+		if err != nil {
+			return "", err
+		}
+		if entry.SHA256 != "" && sum != entry.SHA256 {
+			return DiffModified, nil
+		}
+	}
+	return "", nil
+}
+
+// diffEntries returns the entries of the archive. With content set, every
+// regular file carries its digest: those recorded in the index are used, and
+// an archive without them is read in full to hash its files.
+func diffEntries(ctx context.Context, archivePath, password string, content bool) ([]ArchiveEntry, error) {
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	if version == coreVersionV4 || !content {
+		entries, _, err := ListArchiveContext(ctx, archivePath, password)
+		if err != nil || !content || recordsDigests(entries) {
+			return entries, err
+		}
+	}
+
+	var entries []ArchiveEntry
+	visit := func(hdr *tar.Header, content io.Reader) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry := tarArchiveEntry(hdr)
+		if content != nil {
+			hash := sha256.New()
+			if _, err := io.Copy(hash, content); err != nil {
+				return err
+			}
+			entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		}
+		entries = append(entries, entry)
+		return nil
+	}
+	if version != coreVersionV4 {
+		err = visitLegacyEntries(archivePath, password, version, visit)
+		if canceledBy(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return entries, err
+	}
+	archive, err := openArchiveV4(archivePath, password)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	reader, err := newReaderV4(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	if err := visitTarEntries(reader.tr, visit); err != nil {
+		if canceledBy(ctx, err) {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return entries, nil
+}
+
+// recordsDigests reports whether every regular file among entries has a
+// recorded digest.
+func recordsDigests(entries []ArchiveEntry) bool {
+	for _, entry := range entries {
+		if entry.Typeflag == tar.TypeReg && entry.SHA256 == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// fileSHA256 returns the hex SHA-256 digest of the file at filePath.
+func fileSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
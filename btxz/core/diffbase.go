@@ -0,0 +1,215 @@
+// File: core/diffbase.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements differential archives. An archive created against a base
+// archive only stores the files that are new or whose content differs from the
+// base, and lists the others in its index, together with the fingerprint of
+// the base: a digest of the names and checksums of its files. Extracting it
+// with ExtractOptions.Base writes its own entries and then takes the listed
+// files from the base, so the base and any one differential archive restore
+// the tree. Each archive keeps its own key.
+package core
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// baseFingerprintLabel is hashed in front of the files of a base archive.
+const baseFingerprintLabel = "btxz diff base v1"
+
+// DiffBase is the archive a differential archive is created against, or
+// restored with. Load it with LoadDiffBase and pass it as CreateOptions.Base
+// or ExtractOptions.Base.
+type DiffBase struct {
+	path        string
+	password    string
+	fingerprint string
+	files       map[string]baseFile // The regular files of the base, by normalized entry name
+}
+
+// baseFile is what a differential archive compares a file with.
+type baseFile struct {
+	size   int64
+	sha256 string
+}
+
+// LoadDiffBase reads the listing of the archive at archivePath, opened with
+// password, and fingerprints its files. An archive that does not record the
+// checksums of its files is read in full to hash them. It stops once ctx is
+// done.
+func LoadDiffBase(ctx context.Context, archivePath, password string) (*DiffBase, error) {
+	entries, err := diffEntries(ctx, archivePath, password, true)
+	if err != nil {
+		return nil, err
+	}
+	base := &DiffBase{path: archivePath, password: password, files: make(map[string]baseFile)}
+	for _, entry := range entries {
+		name := normalizeEntryName(entry.Name)
+		switch {
+		case entry.Dedup:
+			// A deduplicated copy has the content of its original.
+			if file, ok := base.files[normalizeEntryName(entry.Link)]; ok {
+				base.files[name] = file
+			}
+		case entry.Typeflag == tar.TypeReg && entry.SHA256 != "":
+			base.files[name] = baseFile{size: entry.Size, sha256: entry.SHA256}
+		}
+	}
+	base.fingerprint = baseFingerprint(base.files)
+	return base, nil
+}
+
+// Fingerprint identifies the files of the base: 32 hex digits, recorded by
+// the differential archives created against it. Recreating the base from the
+// same files gives the same fingerprint; rekeying it keeps it.
+func (b *DiffBase) Fingerprint() string {
+	return b.fingerprint
+}
+
+// baseFingerprint hashes the names and checksums of files, sorted by name.
+func baseFingerprint(files map[string]baseFile) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	hash.Write([]byte(baseFingerprintLabel))
+	for _, name := range names {
+		fmt.Fprintf(hash, "%q %d %s\n", name, files[name].size, files[name].sha256)
+	}
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
+
+// takeFromBase reports whether the regular file at filePath, to be stored as
+// name, has the content of the base's file of that name, and if so lists it in
+// the index as taken from the base instead of storing it. A nil base stores
+// everything.
+func (w *writerV4) takeFromBase(name, filePath string, info os.FileInfo) (bool, error) {
+	if w.base == nil || !info.Mode().IsRegular() || strings.ContainsAny(name, "*?[") {
+		// The base's files are extracted by name, where wildcards would
+		// select others too.
+		return false, nil
+	}
+	file, ok := w.base.files[normalizeEntryName(name)]
+	if !ok || file.size != info.Size() {
+		return false, nil
+	}
+	sum, err := fileSHA256(filePath)
+	if err != nil || sum != file.sha256 {
+		return false, err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return false, err
+	}
+	w.progress.add(info.Size())
+	w.index.BaseEntries = append(w.index.BaseEntries, indexEntry{
+		Name:    name,
+		Size:    header.Size,
+		Mode:    header.Mode,
+		ModTime: header.ModTime.UnixNano(),
+		SHA256:  sum,
+	})
+	return true, nil
+}
+
+// check returns an error unless b is the base the differential archive with
+// index was created against. It accepts any base for other archives.
+func (b *DiffBase) check(index *archiveIndex) error {
+	if b == nil || index == nil || index.Base == "" || b.fingerprint == index.Base {
+		return nil
+	}
+	return fmt.Errorf("%s is not the base of this differential archive: its fingerprint is %s, but the archive was created against %s", b.path, b.fingerprint, index.Base)
+}
+
+// extractFromBase writes the files the differential archive with index takes
+// from opts.Base to outputDir, with the modes and times the archive records
+// for them, and adds them to stats. Without a base, their number is recorded
+// in stats.BaseMissing.
+func extractFromBase(outputDir string, index *archiveIndex, stats *ExtractStats, opts ExtractOptions) error {
+	if index == nil || len(index.BaseEntries) == 0 {
+		return nil
+	}
+	if opts.Base == nil {
+		stats.BaseMissing = len(index.BaseEntries)
+		return nil
+	}
+	// An entry the archive holds itself, as after adding a file to it, wins.
+	own := make(map[string]bool, len(index.Entries))
+	for _, e := range index.Entries {
+		own[normalizeEntryName(e.Name)] = true
+	}
+	wanted := make(map[string]indexEntry)
+	var names []string
+	for _, e := range index.BaseEntries {
+		if name := normalizeEntryName(e.Name); !own[name] {
+			wanted[name] = e
+			names = append(names, e.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	baseOpts := opts
+	baseOpts.Names = names
+	baseOpts.ApplyDeletions = false
+	baseOpts.Base = nil
+	baseStats, err := ExtractArchiveWithOptions(opts.Base.path, outputDir, opts.Base.password, baseOpts)
+	stats.Skipped = append(stats.Skipped, baseStats.Skipped...)
+	stats.OwnerSkipped = append(stats.OwnerSkipped, baseStats.OwnerSkipped...)
+	stats.Corrupted = append(stats.Corrupted, baseStats.Corrupted...)
+	stats.Extracted = append(stats.Extracted, baseStats.Extracted...)
+	stats.Kept = append(stats.Kept, baseStats.Kept...)
+	stats.Overwritten = append(stats.Overwritten, baseStats.Overwritten...)
+	stats.Planned = append(stats.Planned, baseStats.Planned...)
+	stats.Truncated = baseStats.Truncated
+	for _, name := range baseStats.Extracted {
+		// Originals extracted for a link to them and removed again are not.
+		if _, ok := wanted[normalizeEntryName(name)]; ok {
+			stats.FromBase = append(stats.FromBase, name)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("could not extract from base archive %s: %w", opts.Base.path, err)
+	}
+	if opts.DryRun {
+		return nil
+	}
+	normalize, err := nameNormalizer(opts.NormalizeNames)
+	if err != nil {
+		return err
+	}
+	for _, name := range stats.FromBase {
+		e := wanted[normalizeEntryName(name)]
+		if normalize != nil {
+			name = normalize(name)
+		}
+		targetPath := filepath.Join(outputDir, filepath.FromSlash(entryPath(name)))
+		if err := os.Chmod(targetPath, os.FileMode(e.Mode).Perm()); err != nil {
+			return err
+		}
+		if !opts.NoTimes {
+			modTime := time.Unix(0, e.ModTime)
+			if err := os.Chtimes(targetPath, modTime, modTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// errBaseStream is returned when a differential archive read from a stream
+// is to be restored with its base.
+var errBaseStream = errors.New("a differential archive can only be restored with its base from an archive file: a stream names its base at the end")
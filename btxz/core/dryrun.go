@@ -0,0 +1,101 @@
+// File: core/dryrun.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements dry-run extraction, which reads the archive and decides
+// on every entry exactly as extraction would, but writes nothing. The content
+// is still read in full, so every chunk is authenticated and every file with a
+// recorded checksum is re-hashed. As nothing reaches the disk, the symlinks and
+// files the archive would create are tracked in memory, so that later entries
+// are checked against them as if they existed.
+package core
+
+import "os"
+
+// The actions of a dry run, recorded in ExtractStats.Planned.
+const (
+	PlanCreate    = "create"    // The entry would be written where nothing exists yet
+	PlanOverwrite = "overwrite" // The entry would replace an existing file
+	PlanAsk       = "ask"       // OverwritePrompt would ask whether to replace an existing file
+	PlanSkip      = "skip"      // The overwrite policy would keep the existing file
+	PlanReject    = "reject"    // The path is unsafe, so the entry would not be written
+)
+
+// PlannedEntry is the action a dry run decided on for an entry.
+type PlannedEntry struct {
+	Name   string
+	Action string
+}
+
+// maxPlannedLinks bounds the symlinks followed while a path is resolved, on
+// disk or in a dry run, like the limit of the operating system.
+const maxPlannedLinks = 255
+
+// extractPlan is the outcome of the entries a dry run has decided on so far:
+// the paths that would exist once they were written.
+type extractPlan struct {
+	links map[string]string // Symlink paths and their targets
+	files map[string]bool   // Paths of anything else: files, hard links and directories
+}
+
+func newExtractPlan() *extractPlan {
+	return &extractPlan{links: make(map[string]string), files: make(map[string]bool)}
+}
+
+// symlink records a symlink that would be created at path.
+func (p *extractPlan) symlink(path, target string) {
+	delete(p.files, path)
+	p.links[path] = target
+}
+
+// file records a file or directory that would be created at path, replacing
+// any symlink there.
+func (p *extractPlan) file(path string) {
+	delete(p.links, path)
+	p.files[path] = true
+}
+
+// exists reports whether something would be at path, on disk or planned.
+func (p *extractPlan) exists(path string) bool {
+	if p.files[path] {
+		return true
+	}
+	if _, ok := p.links[path]; ok {
+		return true
+	}
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// resolve is resolvePath for the disk as it would be after the planned entries
+// were written: planned symlinks are followed, and a disk symlink that a
+// planned entry replaces is not.
+func (p *extractPlan) resolve(path string) string {
+	return resolveIn("", path, p.lookup)
+}
+
+// lookup is the linkLookup of the disk as it would be after the planned
+// entries were written.
+func (p *extractPlan) lookup(path string) (string, bool, bool) {
+	if target, ok := p.links[path]; ok {
+		return target, true, true
+	}
+	if p.files[path] {
+		return "", false, true
+	}
+	return diskLink(path)
+}
+
+// plannedAction returns the action a dry run records for an entry that was
+// not kept: whether it was rejected, and whether it replaces an existing file,
+// which the prompt only asks about.
+func plannedAction(rejected, replaces bool, opts ExtractOptions) string {
+	switch {
+	case rejected:
+		return PlanReject
+	case !replaces:
+		return PlanCreate
+	case opts.Overwrite == OverwritePrompt:
+		return PlanAsk
+	}
+	return PlanOverwrite
+}
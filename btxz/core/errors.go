@@ -0,0 +1,48 @@
+// File: core/errors.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the classes of errors a caller can act on without
+// matching error text: a secret that does not open the archive, and an archive
+// that is damaged. errors.Is reports the class of an error; the error keeps its
+// own, more specific message.
+package core
+
+import "errors"
+
+// ErrAuthentication is the class of errors caused by a secret that does not
+// open the archive: a wrong password, keyfile or identity, or key shares that
+// do not fit together. Archives of v1 to v3 seal their payload with a single
+// authentication tag and have no key check, so for them a damaged payload
+// cannot be told apart from a wrong password and falls in this class too.
+var ErrAuthentication = errors.New("the password or key does not open the archive")
+
+// ErrIntegrity is the class of errors caused by an archive that is damaged or
+// was modified: content that fails authentication once the key was accepted,
+// an archive file that ends early, a checksum or a signature that does not
+// match.
+var ErrIntegrity = errors.New("the archive is damaged or was modified")
+
+// classError is an error of a class, ErrAuthentication or ErrIntegrity.
+type classError struct {
+	class error
+	msg   string
+}
+
+func newClassError(class error, msg string) *classError {
+	return &classError{class: class, msg: msg}
+}
+
+func (e *classError) Error() string { return e.msg }
+
+func (e *classError) Is(target error) bool { return target == e.class }
+
+// integrityError is an integrity check that failed on err.
+type integrityError struct {
+	err error
+}
+
+func (e *integrityError) Error() string { return "integrity check failed: " + e.err.Error() }
+
+func (e *integrityError) Unwrap() error { return e.err }
+
+func (e *integrityError) Is(target error) bool { return target == ErrIntegrity }
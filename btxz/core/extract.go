@@ -0,0 +1,717 @@
+// File: core/extract.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file holds the extraction logic shared by the tar-based formats.
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// entrySelection tracks which requested entries still need to be extracted.
+// A nil selection means "everything". A requested name holding one of the
+// wildcards *, ? or [ is a pattern, matched like the filters of archive
+// creation (see filter.go), which selects every entry it matches as well as
+// the entry of that very name. A selection with patterns is only complete once
+// the whole archive was read.
+type entrySelection struct {
+	names    map[string]bool // Requested names not extracted yet
+	patterns []*selectionPattern
+}
+
+// selectionPattern is a requested pattern and whether it matched an entry.
+type selectionPattern struct {
+	text    string
+	name    string      // The pattern as an entry name, which it also selects
+	list    patternList // The pattern, ready for matching
+	matched bool
+}
+
+// newEntrySelection normalizes the requested entry names and patterns. A name
+// that is not a valid pattern selects the entry of that name only.
+func newEntrySelection(names []string) *entrySelection {
+	if len(names) == 0 {
+		return nil
+	}
+	selection := exactSelection()
+	for _, name := range names {
+		if strings.ContainsAny(name, "*?[") {
+			if list, err := newPatternList("file", []string{entryPath(name)}); err == nil {
+				selection.patterns = append(selection.patterns, &selectionPattern{text: name, name: normalizeEntryName(name), list: list})
+				continue
+			}
+		}
+		selection.names[normalizeEntryName(name)] = true
+	}
+	return selection
+}
+
+// exactSelection returns an empty selection for names to be added to, which
+// are taken as they are.
+func exactSelection() *entrySelection {
+	return &entrySelection{names: make(map[string]bool)}
+}
+
+// entryPath converts an archive member name to a slash-separated path. Archives
+// written by Windows tools may use backslashes, which are treated as separators
+// on every platform.
+func entryPath(name string) string {
+	return strings.ReplaceAll(name, `\`, "/")
+}
+
+// normalizeEntryName converts a user supplied member name to the form stored in archives.
+func normalizeEntryName(name string) string {
+	name = entryPath(name)
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	return name
+}
+
+// wants reports whether the entry should be extracted.
+func (s *entrySelection) wants(name string) bool {
+	if s == nil {
+		return true
+	}
+	name = normalizeEntryName(name)
+	if s.names[name] {
+		return true
+	}
+	for _, pattern := range s.patterns {
+		if pattern.name == name || pattern.list.matches(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// done marks an entry as extracted.
+func (s *entrySelection) done(name string) {
+	if s == nil {
+		return
+	}
+	name = normalizeEntryName(name)
+	delete(s.names, name)
+	for _, pattern := range s.patterns {
+		if pattern.name == name || pattern.list.matches(name) {
+			pattern.matched = true
+		}
+	}
+}
+
+// complete reports whether every requested entry has been extracted.
+func (s *entrySelection) complete() bool {
+	return s != nil && len(s.names) == 0 && len(s.patterns) == 0
+}
+
+// missingError returns an error naming requested entries that were not found
+// and patterns that matched none.
+func (s *entrySelection) missingError() error {
+	if s == nil {
+		return nil
+	}
+	var problems []string
+	if len(s.names) > 0 {
+		missing := make([]string, 0, len(s.names))
+		for name := range s.names {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		problems = append(problems, "entries not found in archive: "+strings.Join(missing, ", "))
+	}
+	var unmatched []string
+	for _, pattern := range s.patterns {
+		if !pattern.matched {
+			unmatched = append(unmatched, pattern.text)
+		}
+	}
+	if len(unmatched) > 0 {
+		problems = append(problems, "patterns matched no entry: "+strings.Join(unmatched, ", "))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// extractTarStream writes the selected entries of a tar stream below outputDir,
+// skipping entries whose paths would escape it, either directly or through a
+// symlink extracted earlier. Symlinks with an absolute target, or pointing
+// outside outputDir, are skipped too.
+// Modification and access times are restored unless opts.NoTimes is set, and
+// owners when opts.PreserveOwner is set. Names are converted to the Unicode
+// normal form given by opts.NormalizeNames, and escaped for Windows with
+// opts.PortableNames (see portable.go). Files with a checksum in digests are
+// re-hashed while they are written. With a selection it stops reading as soon as
+// every entry is written. With opts.Threads above 1, small files are written by a
+// pool of goroutines (see extractpool.go). The content read is counted by
+// progress, which may be nil. With opts.DryRun nothing is written, and the
+// decision on every entry is recorded instead (see dryrun.go). Entries whose
+// names collide on this file system are handled by opts.CaseCollisions (see
+// collisions.go).
+func extractTarStream(tarReader *tar.Reader, outputDir string, selection *entrySelection, digests fileDigests, progress *progressCounter, opts ExtractOptions) (ExtractStats, error) {
+	var stats ExtractStats
+
+	cleanOutputDir, err := filepath.Abs(filepath.Clean(outputDir))
+	if err != nil {
+		return stats, fmt.Errorf("could not resolve output directory path: %w", err)
+	}
+	// A dry run resolves paths as they would be once its entries were written.
+	resolve := resolvePath
+	lookup := diskLink
+	var plan *extractPlan
+	if opts.DryRun {
+		plan = newExtractPlan()
+		resolve, lookup = plan.resolve, plan.lookup
+	}
+	// resolveLink resolves the target of a symlink in dir through the links
+	// extracted so far, component by component.
+	resolveLink := func(dir, target string) string {
+		return resolveIn(dir, target, lookup)
+	}
+	realOutputDir := resolve(cleanOutputDir)
+	// linked holds the symlinks extracted so far. One is never replaced, as a
+	// link extracted through it, checked against its old target, would then
+	// follow the new one.
+	linked := make(map[string]bool)
+
+	names, err := nameNormalizer(opts.NormalizeNames)
+	if err != nil {
+		return stats, err
+	}
+	overwrite, err := newOverwritePolicy(opts)
+	if err != nil {
+		return stats, err
+	}
+	collisions := opts.collisions
+	if collisions == nil {
+		if collisions, err = newCollisionCheck(cleanOutputDir, opts.CaseCollisions); err != nil {
+			return stats, err
+		}
+	}
+	portable := opts.portableNames()
+	// cleanName turns an entry or link name into a relative path with forward
+	// slashes, and diskPath into one on this system.
+	cleanName := func(name string) string {
+		name = entryPath(name)
+		if names != nil {
+			name = names(name)
+		}
+		return name
+	}
+	diskPath := func(name string) string {
+		name = cleanName(name)
+		if portable {
+			name = PortableName(name)
+		}
+		return filepath.FromSlash(name)
+	}
+
+	var owners *ownerResolver
+	if opts.PreserveOwner {
+		owners = newOwnerResolver()
+	}
+	// Ownership is restored on a best-effort basis: failures are reported, not
+	// fatal. The lock guards the resolver's caches, and the stats, against the
+	// writer pool.
+	var ownerMu sync.Mutex
+	restoreOwner := func(targetPath string, hdr *tar.Header) {
+		if owners == nil {
+			return
+		}
+		ownerMu.Lock()
+		defer ownerMu.Unlock()
+		if owners.chown(targetPath, hdr) != nil {
+			stats.OwnerSkipped = append(stats.OwnerSkipped, hdr.Name)
+		}
+	}
+	// lateSkipped holds the files of the pool that were only found unsafe when
+	// they were written, so they were counted as extracted when queued.
+	lateSkipped := make(map[string]bool)
+	writeFile := func(targetPath string, hdr *tar.Header, content io.Reader, pooled bool) (bool, error) {
+		ok, err := writeExtractedFile(targetPath, realOutputDir, hdr, opts.written.content(targetPath, hdr.Name, content), digests, opts, restoreOwner)
+		if canceledBy(opts.ctx, err) {
+			ownerMu.Lock()
+			stats.Truncated = hdr.Name
+			ownerMu.Unlock()
+		}
+		if errors.Is(err, errOutsideOutput) {
+			ownerMu.Lock()
+			stats.Skipped = append(stats.Skipped, hdr.Name)
+			if pooled {
+				lateSkipped[hdr.Name] = true
+			}
+			ownerMu.Unlock()
+			return true, nil
+		}
+		return ok, err
+	}
+
+	var pool *filePool
+	if opts.Threads > 1 && plan == nil {
+		pool = newFilePool(opts.Threads, func(job fileJob) (bool, error) {
+			return writeFile(job.targetPath, job.hdr, bytes.NewReader(job.data), true)
+		})
+		defer pool.close()
+	}
+
+	// Directory modes and times are applied last, so read-only directories can
+	// still be filled and their times are not changed by adding children.
+	var dirs []extractedDir
+	var entries entryCounter
+	for !selection.complete() {
+		hdr, err := entries.next(tarReader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("error reading tar stream: %w", err)
+		}
+		if err := contextErr(opts.ctx); err != nil {
+			return stats, err
+		}
+		if !selection.wants(hdr.Name) {
+			continue
+		}
+		if pool != nil {
+			if err := pool.failed(); err != nil {
+				return stats, err
+			}
+		}
+
+		// An entry whose name collides with an earlier one on this file
+		// system is renamed, left out, or stops the extraction, as is a hard
+		// link to an entry that was left out.
+		relPath := diskPath(hdr.Name)
+		if portable {
+			if name := cleanName(hdr.Name); filepath.ToSlash(relPath) != name {
+				stats.Escaped = append(stats.Escaped, RenamedEntry{Name: hdr.Name, As: filepath.ToSlash(relPath)})
+			}
+		}
+		collided := false
+		if hdr.Typeflag != tar.TypeDir {
+			var earlier string
+			if relPath, earlier = collisions.check(relPath, hdr.Name); earlier != "" {
+				switch collisions.mode {
+				case CaseCollisionError:
+					return stats, &CollisionError{Pairs: [][2]string{{earlier, hdr.Name}}}
+				case CaseCollisionSkip:
+					collided = true
+				default:
+					stats.Renamed = append(stats.Renamed, RenamedEntry{Name: hdr.Name, As: filepath.ToSlash(relPath)})
+				}
+			}
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			if _, ok := collisions.linkTarget(diskPath(hdr.Linkname)); !ok {
+				collided = true
+			}
+		}
+		if collided {
+			stats.Collided = append(stats.Collided, hdr.Name)
+			if plan != nil {
+				stats.Planned = append(stats.Planned, PlannedEntry{Name: hdr.Name, Action: PlanSkip})
+				if hdr.Typeflag == tar.TypeReg {
+					// The content is read all the same, to be authenticated.
+					if _, err := io.Copy(io.Discard, contextReader(opts.ctx, progress.reader(tarReader))); err != nil {
+						return stats, err
+					}
+				}
+			} else if hdr.Typeflag == tar.TypeReg {
+				progress.add(hdr.Size)
+			}
+			selection.done(hdr.Name)
+			continue
+		}
+		targetPath := filepath.Join(cleanOutputDir, relPath)
+		cleanTargetPath := filepath.Clean(targetPath)
+
+		// Resolve symlinks in the parent directories, and for anything but a
+		// symlink entry also in the final component, which would be followed.
+		realTargetPath := filepath.Join(resolve(filepath.Dir(cleanTargetPath)), filepath.Base(cleanTargetPath))
+		if hdr.Typeflag != tar.TypeSymlink {
+			realTargetPath = resolve(cleanTargetPath)
+		}
+		if !isWithinDir(cleanOutputDir, cleanTargetPath) || !isWithinDir(realOutputDir, realTargetPath) {
+			stats.Skipped = append(stats.Skipped, hdr.Name)
+			if plan != nil {
+				stats.Planned = append(stats.Planned, PlannedEntry{Name: hdr.Name, Action: PlanReject})
+			}
+			selection.done(hdr.Name)
+			continue
+		}
+
+		if pool != nil {
+			// Nothing else may touch a path while its file is being written.
+			pool.wait(targetPath)
+		}
+		replaces := false
+		if hdr.Typeflag != tar.TypeDir {
+			var write bool
+			if write, replaces = overwrite.allows(targetPath, hdr.Name, hdr.ModTime); !write {
+				stats.Kept = append(stats.Kept, hdr.Name)
+				if plan != nil {
+					stats.Planned = append(stats.Planned, PlannedEntry{Name: hdr.Name, Action: PlanSkip})
+					if hdr.Typeflag == tar.TypeReg {
+						// The content is read all the same, to be authenticated.
+						if _, err := io.Copy(io.Discard, contextReader(opts.ctx, progress.reader(tarReader))); err != nil {
+							return stats, err
+						}
+					}
+				} else if hdr.Typeflag == tar.TypeReg {
+					progress.add(hdr.Size)
+				}
+				selection.done(hdr.Name)
+				continue
+			}
+		}
+		if pool != nil && (replaces || hdr.Typeflag == tar.TypeSymlink || hdr.Typeflag == tar.TypeLink) {
+			// A link can redirect, and a replaced file be, a path below which
+			// queued files were checked: they are written first.
+			pool.drain()
+		}
+		skipped := len(stats.Skipped)
+		progress.entry(hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if plan != nil {
+				plan.file(cleanTargetPath)
+				break
+			}
+			if err := opts.created.mkdirAll(targetPath); err != nil {
+				return stats, err
+			}
+			dirs = append(dirs, extractedDir{path: targetPath, mode: os.FileMode(hdr.Mode).Perm(), hdr: hdr})
+		case tar.TypeReg:
+			content := contextReader(opts.ctx, progress.reader(tarReader))
+			if plan != nil {
+				plan.file(cleanTargetPath)
+				ok, err := digests.copyVerified(io.Discard, content, hdr.Name)
+				if err != nil {
+					return stats, err
+				}
+				if !ok {
+					stats.Corrupted = append(stats.Corrupted, hdr.Name)
+				}
+				break
+			}
+			if err := opts.created.mkdirAll(filepath.Dir(targetPath)); err != nil {
+				return stats, err
+			}
+			if pool != nil && hdr.Size <= maxPooledFileSize {
+				data, err := io.ReadAll(content)
+				if err == nil {
+					pool.submit(targetPath, hdr, data)
+					break
+				}
+				// Write what could be read, as without the pool.
+				content = io.MultiReader(bytes.NewReader(data), errorReader{err})
+			}
+			ok, err := writeFile(targetPath, hdr, content, false)
+			if err != nil {
+				return stats, err
+			}
+			if !ok {
+				stats.Corrupted = append(stats.Corrupted, hdr.Name)
+			}
+		case tar.TypeLink:
+			// The original was extracted earlier: link to it, or duplicate it
+			// for a deduplicated copy.
+			sourceRel, _ := collisions.linkTarget(diskPath(hdr.Linkname))
+			sourcePath := filepath.Join(cleanOutputDir, sourceRel)
+			if !isWithinDir(cleanOutputDir, sourcePath) || !isWithinDir(realOutputDir, resolve(sourcePath)) {
+				stats.Skipped = append(stats.Skipped, hdr.Name)
+				break
+			}
+			if plan != nil {
+				if !plan.exists(filepath.Clean(sourcePath)) {
+					stats.Skipped = append(stats.Skipped, hdr.Name)
+					break
+				}
+				plan.file(cleanTargetPath)
+				break
+			}
+			if err := opts.created.mkdirAll(filepath.Dir(targetPath)); err != nil {
+				return stats, err
+			}
+			link := linkExtractedFile
+			if isDedupEntry(hdr) {
+				link = copyExtractedFile
+			}
+			opts.created.add(targetPath)
+			if err := link(sourcePath, targetPath, os.FileMode(hdr.Mode)); err != nil {
+				if os.IsNotExist(err) {
+					stats.Skipped = append(stats.Skipped, hdr.Name)
+					break
+				}
+				return stats, err
+			}
+			opts.written.link(targetPath, hdr.Name, sourcePath)
+			restoreOwner(targetPath, hdr)
+			if err := restoreTimes(targetPath, hdr, opts); err != nil {
+				return stats, err
+			}
+		case tar.TypeSymlink:
+			// An absolute target depends on where the tree is restored, and
+			// one that leads out of the output directory, directly, with ..
+			// or through a link extracted earlier, would let a later entry
+			// such as link/passwd write outside it.
+			target := diskPath(hdr.Linkname)
+			if linked[cleanTargetPath] || isAbsoluteTarget(target) || !isWithinDir(realOutputDir, resolveLink(filepath.Dir(realTargetPath), target)) {
+				stats.Skipped = append(stats.Skipped, hdr.Name)
+				break
+			}
+			linked[cleanTargetPath] = true
+			if plan != nil {
+				plan.symlink(cleanTargetPath, target)
+				break
+			}
+			if err := opts.created.mkdirAll(filepath.Dir(targetPath)); err != nil {
+				return stats, err
+			}
+			// Replace a file or link left at the path by an earlier extraction.
+			if err := removeExisting(targetPath); err != nil {
+				return stats, err
+			}
+			// os.Chtimes would follow the link, so its own times are not restored.
+			opts.created.add(targetPath)
+			if err := os.Symlink(target, targetPath); err != nil {
+				return stats, err
+			}
+			restoreOwner(targetPath, hdr)
+		}
+		if len(stats.Skipped) == skipped {
+			stats.Extracted = append(stats.Extracted, hdr.Name)
+			if replaces {
+				stats.Overwritten = append(stats.Overwritten, hdr.Name)
+			}
+		}
+		if plan != nil {
+			stats.Planned = append(stats.Planned, PlannedEntry{Name: hdr.Name, Action: plannedAction(len(stats.Skipped) > skipped, replaces, opts)})
+		}
+		selection.done(hdr.Name)
+	}
+
+	if pool != nil {
+		if err := pool.close(); err != nil {
+			return stats, err
+		}
+		stats.Corrupted = append(stats.Corrupted, pool.corrupted...)
+		if len(lateSkipped) > 0 {
+			extracted := stats.Extracted[:0]
+			for _, name := range stats.Extracted {
+				if !lateSkipped[name] {
+					extracted = append(extracted, name)
+				}
+			}
+			stats.Extracted = extracted
+		}
+	}
+	// Children first, in case a parent is read-only.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		restoreOwner(dirs[i].path, dirs[i].hdr)
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return stats, err
+		}
+		if err := restoreTimes(dirs[i].path, dirs[i].hdr, opts); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// errOutsideOutput is returned by writeExtractedFile for a file whose parent
+// directory no longer resolves into the output directory.
+var errOutsideOutput = errors.New("the path leads outside the output directory")
+
+// writeExtractedFile creates a regular file from the content of an entry and
+// restores its owner and times. It reports false if the content does not match
+// the recorded checksum. The parent directory is resolved again right before
+// the file is created, and must still lie in realOutputDir, so that no link
+// created since the entry was checked redirects the write.
+func writeExtractedFile(targetPath, realOutputDir string, hdr *tar.Header, content io.Reader, digests fileDigests, opts ExtractOptions, restoreOwner func(string, *tar.Header)) (bool, error) {
+	if !isWithinDir(realOutputDir, resolvePath(filepath.Dir(targetPath))) {
+		return false, errOutsideOutput
+	}
+	opts.created.add(targetPath)
+	if err := removeExisting(targetPath); err != nil {
+		return false, err
+	}
+	outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, os.FileMode(hdr.Mode))
+	if err != nil {
+		return false, err
+	}
+	ok, err := digests.copyVerified(outFile, content, hdr.Name)
+	if err != nil {
+		outFile.Close()
+		if canceledBy(opts.ctx, err) {
+			// A canceled file is incomplete, not damaged.
+			os.Remove(targetPath)
+			return false, err
+		}
+		return false, &entryError{name: hdr.Name, err: err}
+	}
+	if err := outFile.Close(); err != nil {
+		return false, err
+	}
+	restoreOwner(targetPath, hdr)
+	return ok, restoreTimes(targetPath, hdr, opts)
+}
+
+// errorReader returns err from every read.
+type errorReader struct{ err error }
+
+func (r errorReader) Read([]byte) (int, error) { return 0, r.err }
+
+// entryError reports a failure while the content of an entry was being
+// written. The file on disk holds the data read before the failure.
+type entryError struct {
+	name string
+	err  error
+}
+
+func (e *entryError) Error() string { return e.name + ": " + e.err.Error() }
+
+func (e *entryError) Unwrap() error { return e.err }
+
+// extractedDir is a directory created during extraction whose owner, mode and times are still to be set.
+type extractedDir struct {
+	path string
+	mode os.FileMode
+	hdr  *tar.Header
+}
+
+// restoreTimes sets the archived modification time of an extracted entry, and its
+// access time where the archive recorded one.
+func restoreTimes(targetPath string, hdr *tar.Header, opts ExtractOptions) error {
+	if opts.NoTimes || hdr.ModTime.IsZero() {
+		return nil
+	}
+	accessTime := hdr.AccessTime
+	if accessTime.IsZero() {
+		accessTime = hdr.ModTime
+	}
+	return os.Chtimes(targetPath, accessTime, hdr.ModTime)
+}
+
+// isWithinDir reports whether the clean absolute path p is dir or lies below it.
+func isWithinDir(dir, p string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// isAbsoluteTarget reports whether the symlink target, a path on this system,
+// is absolute or rooted, such as /etc, C:\Windows or \Windows.
+func isAbsoluteTarget(target string) bool {
+	return filepath.IsAbs(target) || filepath.VolumeName(target) != "" || strings.HasPrefix(target, string(filepath.Separator)) || strings.HasPrefix(target, "/")
+}
+
+// resolvePath returns the absolute path p with the symlinks in its existing
+// leading part resolved. Components that do not exist yet are kept as they are.
+// It returns "", which no directory contains, for a path that cannot be
+// resolved (see walkPath).
+func resolvePath(p string) string {
+	return resolveIn("", p, diskLink)
+}
+
+// linkLookup reports whether something exists at the clean absolute path, and
+// its target if it is a symlink.
+type linkLookup func(path string) (target string, isLink, exists bool)
+
+// diskLink is the linkLookup of the disk.
+func diskLink(path string) (string, bool, bool) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", false, false
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return "", false, true
+	}
+	target, err := os.Readlink(path)
+	return target, err == nil, true
+}
+
+// resolveIn resolves name, a path on this system, relative to dir, an absolute
+// path without symlinks, or on its own if it is absolute. It returns "" if
+// the path cannot be resolved (see walkPath).
+func resolveIn(dir, name string, lookup linkLookup) string {
+	followed := 0
+	resolved, _, ok := walkPath(dir, name, lookup, &followed)
+	if !ok {
+		return ""
+	}
+	return resolved
+}
+
+// walkPath resolves name against dir one component at a time, as the
+// operating system does: a symlink is replaced by its target before the next
+// component is looked up, and .. leads to the parent of what was resolved so
+// far. The path is never cleaned as text, which would drop the .. of "link/.."
+// before the link is followed. ok is false if .. follows a component that does
+// not exist yet, as where it leads depends on what is later created there, or
+// if more than maxPlannedLinks symlinks are followed. missing tells whether the
+// last component does not exist.
+func walkPath(dir, name string, lookup linkLookup, followed *int) (resolved string, missing, ok bool) {
+	resolved = dir
+	if volume := filepath.VolumeName(name); volume != "" || isAbsoluteTarget(name) {
+		resolved, name = volume+string(filepath.Separator), name[len(volume):]
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r < 0x80 && os.IsPathSeparator(uint8(r)) }) {
+		switch {
+		case part == ".":
+			continue
+		case part == "..":
+			if missing {
+				return "", true, false
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		target, isLink, exists := lookup(next)
+		switch {
+		case !exists:
+			// A dry run may still plan links below it.
+			missing, resolved = true, next
+		case !isLink:
+			missing, resolved = false, next
+		default:
+			if *followed++; *followed > maxPlannedLinks {
+				return "", false, false
+			}
+			if resolved, missing, ok = walkPath(resolved, target, lookup, followed); !ok {
+				return "", missing, false
+			}
+		}
+	}
+	return resolved, missing, true
+}
+
+// copyExtractedFile copies a file written earlier during extraction to targetPath.
+func copyExtractedFile(sourcePath, targetPath string, mode os.FileMode) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return err
+	}
+	outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(outFile, source); err != nil {
+		outFile.Close()
+		return err
+	}
+	return outFile.Close()
+}
@@ -0,0 +1,303 @@
+package core
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// escapeFixtures are archives that try to write outside the output directory
+// through the symlinks they create. outside in a link target is replaced by a
+// directory next to the output directory.
+var escapeFixtures = []struct {
+	name    string
+	entries []testEntry
+	skipped []string // Entries that must be left out
+}{
+	{
+		name: "absolute target then write",
+		entries: []testEntry{
+			{name: "link", typeflag: tar.TypeSymlink, linkname: "outside"},
+			{name: "link/pwn", content: "x"},
+		},
+		skipped: []string{"link"},
+	},
+	{
+		name: "dot-dot target then write",
+		entries: []testEntry{
+			{name: "up", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "up/pwn", content: "x"},
+		},
+		skipped: []string{"up"},
+	},
+	{
+		name: "nested dot-dot target",
+		entries: []testEntry{
+			{name: "d/", typeflag: tar.TypeDir},
+			{name: "d/up", typeflag: tar.TypeSymlink, linkname: "../.."},
+			{name: "d/up/pwn", content: "x"},
+		},
+		skipped: []string{"d/up"},
+	},
+	{
+		// filepath.Join would clean s/.. to . before s is followed.
+		name: "dot-dot through a link to the root",
+		entries: []testEntry{
+			{name: "s", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "a2", typeflag: tar.TypeSymlink, linkname: "s/.."},
+			{name: "a2/pwn", content: "x"},
+		},
+		skipped: []string{"a2"},
+	},
+	{
+		name: "dot-dot through a link to a subdirectory",
+		entries: []testEntry{
+			{name: "sub/deep/", typeflag: tar.TypeDir},
+			{name: "s", typeflag: tar.TypeSymlink, linkname: "sub/deep"},
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "s/../../.."},
+			{name: "a/pwn", content: "x"},
+		},
+		skipped: []string{"a"},
+	},
+	{
+		name: "chain of links",
+		entries: []testEntry{
+			{name: "b", typeflag: tar.TypeSymlink, linkname: ".."},
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "b"},
+			{name: "c", typeflag: tar.TypeSymlink, linkname: "a/.."},
+		},
+		skipped: []string{"b", "c"},
+	},
+	{
+		// Where x/.. leads depends on what x later becomes.
+		name: "dot-dot after a missing component",
+		entries: []testEntry{
+			{name: "m", typeflag: tar.TypeSymlink, linkname: "x/../.."},
+			{name: "x", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "m/pwn", content: "x"},
+		},
+		skipped: []string{"m"},
+	},
+	{
+		// back is checked while a leads to d/e; replacing a with . would make
+		// back lead to the parent of the output directory.
+		name: "replacing a link that another goes through",
+		entries: []testEntry{
+			{name: "d/e/", typeflag: tar.TypeDir},
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "d/e"},
+			{name: "back", typeflag: tar.TypeSymlink, linkname: "a/.."},
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "back/pwn", content: "x"},
+		},
+		skipped: []string{"a"},
+	},
+	{
+		name: "link loop",
+		entries: []testEntry{
+			{name: "l1", typeflag: tar.TypeSymlink, linkname: "l2"},
+			{name: "l2", typeflag: tar.TypeSymlink, linkname: "l1"},
+			{name: "l1/pwn", content: "x"},
+		},
+		skipped: []string{"l1/pwn"},
+	},
+	{
+		// Files queued for the writer pool below s must not be written
+		// through a link that replaces s.
+		name: "link replacing a directory of queued files",
+		entries: append(append([]testEntry{
+			{name: "s", typeflag: tar.TypeSymlink, linkname: "."},
+		}, queuedFiles("s/f", 64)...),
+			testEntry{name: "s", typeflag: tar.TypeSymlink, linkname: "s/.."},
+			testEntry{name: "s/pwn", content: "x"},
+		),
+		skipped: []string{"s"},
+	},
+}
+
+// fixtureEntries returns entries with the link target "outside" replaced by
+// the path outside.
+func fixtureEntries(entries []testEntry, outside string) []testEntry {
+	entries = slices.Clone(entries)
+	for i := range entries {
+		if entries[i].linkname == "outside" {
+			entries[i].linkname = outside
+		}
+	}
+	return entries
+}
+
+// queuedFiles returns n small files named prefix0, prefix1 and so on.
+func queuedFiles(prefix string, n int) []testEntry {
+	entries := make([]testEntry, n)
+	for i := range entries {
+		entries[i] = testEntry{name: fmt.Sprintf("%s%d", prefix, i), content: strings.Repeat("x", i)}
+	}
+	return entries
+}
+
+func TestExtractRejectsEscapingLinks(t *testing.T) {
+	for _, fixture := range escapeFixtures {
+		for _, threads := range []int{1, 8} {
+			t.Run(fmt.Sprintf("%s/threads=%d", fixture.name, threads), func(t *testing.T) {
+				root := t.TempDir()
+				outside := filepath.Join(root, "outside")
+				if err := os.Mkdir(outside, 0755); err != nil {
+					t.Fatal(err)
+				}
+				archive := writeTestArchive(t, fixtureEntries(fixture.entries, outside))
+				out := filepath.Join(root, "out")
+				stats, err := ExtractArchiveWithOptions(archive, out, "", ExtractOptions{Threads: threads})
+				if err != nil {
+					t.Fatalf("extract: %v", err)
+				}
+				for _, name := range fixture.skipped {
+					if !slices.Contains(stats.Skipped, name) {
+						t.Errorf("%s not skipped; skipped %v", name, stats.Skipped)
+					}
+				}
+				checkContained(t, root, out)
+			})
+		}
+	}
+}
+
+func TestDryRunRejectsEscapingLinks(t *testing.T) {
+	for _, fixture := range escapeFixtures {
+		t.Run(fixture.name, func(t *testing.T) {
+			root := t.TempDir()
+			archive := writeTestArchive(t, fixtureEntries(fixture.entries, filepath.Join(root, "outside")))
+			stats, err := ExtractArchiveWithOptions(archive, filepath.Join(root, "out"), "", ExtractOptions{DryRun: true})
+			if err != nil {
+				t.Fatalf("dry run: %v", err)
+			}
+			for _, name := range fixture.skipped {
+				if !slices.Contains(stats.Skipped, name) {
+					t.Errorf("%s not rejected; skipped %v", name, stats.Skipped)
+				}
+			}
+			if entries, _ := os.ReadDir(root); len(entries) != 0 {
+				// The archive lives in a directory of its own.
+				t.Errorf("the dry run wrote %d files", len(entries))
+			}
+		})
+	}
+}
+
+// checkContained fails unless root holds nothing but out and the outside
+// directory, which must be empty, and every link in out resolves into out.
+func checkContained(t *testing.T, root, out string) {
+	t.Helper()
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if name := entry.Name(); name != "out" && name != "outside" && !strings.HasSuffix(name, ".btxz") {
+			t.Errorf("%s was written outside the output directory", name)
+		}
+	}
+	if written, _ := os.ReadDir(filepath.Join(root, "outside")); len(written) != 0 {
+		t.Errorf("%d files were written to a directory outside the output", len(written))
+	}
+	realOut, err := filepath.EvalSymlinks(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filepath.WalkDir(out, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.Type()&fs.ModeSymlink == 0 {
+			return err
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil && !isWithinDir(realOut, resolved) {
+			t.Errorf("%s links to %s, outside the output directory", path, resolved)
+		}
+		return nil
+	})
+}
+
+func TestExtractFollowsInternalLinks(t *testing.T) {
+	archive := writeTestArchive(t, []testEntry{
+		{name: "sub/deep/", typeflag: tar.TypeDir},
+		{name: "docs", typeflag: tar.TypeSymlink, linkname: "sub"},
+		{name: "back", typeflag: tar.TypeSymlink, linkname: "docs/deep/.."},
+		{name: "docs/readme", content: "hello"},
+	})
+	out := t.TempDir()
+	stats, err := ExtractArchiveWithOptions(archive, out, "", ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Skipped) != 0 {
+		t.Errorf("skipped %v", stats.Skipped)
+	}
+	data, err := os.ReadFile(filepath.Join(out, "back", "readme"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("back/readme = %q, %v", data, err)
+	}
+}
+
+func TestWriteExtractedFileResolvesParentAgain(t *testing.T) {
+	root := t.TempDir()
+	out, outside := filepath.Join(root, "out"), filepath.Join(root, "outside")
+	for _, dir := range []string{out, outside} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A link that appeared after the entry was checked.
+	if err := os.Symlink(outside, filepath.Join(out, "dir")); err != nil {
+		t.Fatal(err)
+	}
+	realOut, _ := filepath.EvalSymlinks(out)
+	hdr := &tar.Header{Name: "dir/pwn", Mode: 0644}
+	_, err := writeExtractedFile(filepath.Join(out, "dir", "pwn"), realOut, hdr, strings.NewReader(""), nil, ExtractOptions{}, func(string, *tar.Header) {})
+	if !errors.Is(err, errOutsideOutput) {
+		t.Fatalf("err = %v, want errOutsideOutput", err)
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "pwn")); err == nil {
+		t.Fatal("the file was written outside the output directory")
+	}
+}
+
+func TestWalkPath(t *testing.T) {
+	links := map[string]string{
+		"/out/s":    ".",
+		"/out/deep": "a/b",
+		"/out/abs":  "/etc",
+		"/out/loop": "loop",
+	}
+	dirs := map[string]bool{"/out": true, "/out/a": true, "/out/a/b": true, "/etc": true}
+	lookup := func(path string) (string, bool, bool) {
+		if target, ok := links[path]; ok {
+			return target, true, true
+		}
+		return "", false, dirs[path]
+	}
+	for _, c := range []struct {
+		name    string
+		want    string
+		missing bool
+		ok      bool
+	}{
+		{"s/..", "/", false, true},
+		{"s/s/s/x", "/out/x", true, true},
+		{"deep/../..", "/out", false, true},
+		{"abs/passwd", "/etc/passwd", true, true},
+		{"new/../..", "", true, false},
+		{"loop/x", "", false, false},
+	} {
+		if filepath.Separator != '/' {
+			t.Skip("paths are for Unix")
+		}
+		followed := 0
+		got, missing, ok := walkPath("/out", c.name, lookup, &followed)
+		if got != c.want || missing != c.missing || ok != c.ok {
+			t.Errorf("walkPath(%q) = %q, %v, %v; want %q, %v, %v", c.name, got, missing, ok, c.want, c.missing, c.ok)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// File: core/extractpool.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements parallel extraction. Restoring many small files is
+// dominated by creating them one at a time, so the tar stream is still read by
+// a single goroutine, which buffers the content of small files and hands them
+// to a pool of writers. Large files, links and directories stay with the
+// reader, which waits for pending writes to a path before it touches the path
+// again, and for all of them before it creates a link or replaces an existing
+// file, which could redirect a path the queued files were checked against.
+package core
+
+import (
+	"archive/tar"
+	"sync"
+)
+
+const (
+	// maxPooledFileSize is the largest file passed to the writer pool; larger
+	// files are written by the reader as they are decompressed.
+	maxPooledFileSize = 1024 * 1024 // 1 MiB
+	// maxPendingPaths is the number of tracked paths after which the paths
+	// whose writes have finished are forgotten.
+	maxPendingPaths = 4096
+)
+
+// fileJob is a small file waiting to be written by the pool.
+type fileJob struct {
+	targetPath string
+	hdr        *tar.Header
+	data       []byte
+	done       chan struct{} // Closed once the file is written
+}
+
+// filePool writes small files with a fixed number of goroutines. It is fed by
+// a single reader; only the results are shared with the writers.
+type filePool struct {
+	jobs      chan fileJob
+	workers   sync.WaitGroup
+	mu        sync.Mutex
+	err       error    // First failure of a writer
+	corrupted []string // Files whose content does not match their checksum
+	pending   map[string]chan struct{}
+	closed    bool
+}
+
+// newFilePool starts threads writers calling write for every submitted file.
+// write reports false if the content does not match its recorded checksum.
+func newFilePool(threads int, write func(job fileJob) (bool, error)) *filePool {
+	pool := &filePool{
+		jobs:    make(chan fileJob, 4*threads),
+		pending: make(map[string]chan struct{}),
+	}
+	for i := 0; i < threads; i++ {
+		pool.workers.Add(1)
+		go func() {
+			defer pool.workers.Done()
+			for job := range pool.jobs {
+				ok, err := write(job)
+				pool.mu.Lock()
+				if err != nil && pool.err == nil {
+					pool.err = err
+				}
+				if err == nil && !ok {
+					pool.corrupted = append(pool.corrupted, job.hdr.Name)
+				}
+				pool.mu.Unlock()
+				close(job.done)
+			}
+		}()
+	}
+	return pool
+}
+
+// submit queues a file, blocking while the queue is full.
+func (p *filePool) submit(targetPath string, hdr *tar.Header, data []byte) {
+	if len(p.pending) >= maxPendingPaths {
+		for path, done := range p.pending {
+			select {
+			case <-done:
+				delete(p.pending, path)
+			default:
+			}
+		}
+	}
+	job := fileJob{targetPath: targetPath, hdr: hdr, data: data, done: make(chan struct{})}
+	p.pending[targetPath] = job.done
+	p.jobs <- job
+}
+
+// wait blocks until a queued write to path, if any, has finished.
+func (p *filePool) wait(path string) {
+	if done, ok := p.pending[path]; ok {
+		<-done
+		delete(p.pending, path)
+	}
+}
+
+// drain blocks until every queued write has finished.
+func (p *filePool) drain() {
+	for path, done := range p.pending {
+		<-done
+		delete(p.pending, path)
+	}
+}
+
+// failed returns the first failure of a writer so far.
+func (p *filePool) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// close waits for every queued file and returns the first failure. It may be
+// called more than once.
+func (p *filePool) close() error {
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+		p.workers.Wait()
+	}
+	return p.failed()
+}
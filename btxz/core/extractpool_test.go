@@ -0,0 +1,132 @@
+package core
+
+import (
+	"archive/tar"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFilePoolDrain(t *testing.T) {
+	release := make(chan struct{})
+	var written atomic.Int32
+	pool := newFilePool(4, func(job fileJob) (bool, error) {
+		<-release
+		written.Add(1)
+		return true, nil
+	})
+	defer pool.close()
+	for i := 0; i < 12; i++ {
+		pool.submit(fmt.Sprintf("f%d", i), &tar.Header{Name: fmt.Sprint(i)}, nil)
+	}
+	drained := make(chan struct{})
+	go func() {
+		pool.drain()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		t.Fatal("drain returned while writes were pending")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-drained
+	if n := written.Load(); n != 12 {
+		t.Errorf("drain returned after %d of 12 writes", n)
+	}
+	if len(pool.pending) != 0 {
+		t.Errorf("%d paths still pending", len(pool.pending))
+	}
+}
+
+// poolEntries returns a tree of n small files over a few directories, with
+// a hard link to every file whose number is a multiple of linkEvery, if above 0.
+func poolEntries(n, linkEvery int) []testEntry {
+	var entries []testEntry
+	for d := 0; d < 4; d++ {
+		entries = append(entries, testEntry{name: fmt.Sprintf("d%d/", d), typeflag: tar.TypeDir})
+	}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("d%d/f%d", i%4, i)
+		entries = append(entries, testEntry{name: name, content: strings.Repeat(fmt.Sprint(i), i%50)})
+		if linkEvery > 0 && i%linkEvery == 0 {
+			entries = append(entries, testEntry{name: name + ".link", typeflag: tar.TypeLink, linkname: name})
+		}
+	}
+	return entries
+}
+
+// readTree returns the content of every file below dir by its relative path.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestParallelExtractMatchesSerial(t *testing.T) {
+	archive := writeTestArchive(t, poolEntries(300, 10))
+	var trees []map[string]string
+	var extracted []int
+	for _, threads := range []int{1, 8} {
+		out := t.TempDir()
+		// The second run replaces every file of the first.
+		for run := 0; run < 2; run++ {
+			stats, err := ExtractArchiveWithOptions(archive, out, "", ExtractOptions{Threads: threads})
+			if err != nil {
+				t.Fatalf("threads=%d: %v", threads, err)
+			}
+			if len(stats.Skipped) != 0 || len(stats.Corrupted) != 0 {
+				t.Errorf("threads=%d: skipped %v, corrupted %v", threads, stats.Skipped, stats.Corrupted)
+			}
+			if run == 1 {
+				extracted = append(extracted, len(stats.Extracted))
+			}
+		}
+		trees = append(trees, readTree(t, out))
+	}
+	if extracted[0] != extracted[1] {
+		t.Errorf("extracted %d entries with one thread and %d with eight", extracted[0], extracted[1])
+	}
+	if len(trees[0]) != len(trees[1]) {
+		t.Fatalf("%d files with one thread, %d with eight", len(trees[0]), len(trees[1]))
+	}
+	for name, content := range trees[0] {
+		if trees[1][name] != content {
+			t.Errorf("%s differs: %q with one thread, %q with eight", name, content, trees[1][name])
+		}
+	}
+}
+
+func BenchmarkExtractSmallFiles(b *testing.B) {
+	archive := writeTestArchive(b, poolEntries(2000, 0))
+	for _, threads := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				out := filepath.Join(b.TempDir(), "out")
+				if _, err := ExtractArchiveWithOptions(archive, out, "", ExtractOptions{Threads: threads}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
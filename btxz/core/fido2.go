@@ -0,0 +1,118 @@
+// File: core/fido2.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file binds key slots to a FIDO2 security key. The header stores the id
+// of a credential on the key and a random salt; the hmac-secret response of
+// the key for that salt is put in front of the password and keyfile digest in
+// the Argon2 input, so the slot opens only while the key is present. Talking
+// to the device is left to the caller (see internal/fido2); this file only
+// handles the stored binding and the combined secret.
+package core
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// fido2Marker starts a secret built by FIDO2Secret.
+	fido2Marker = "\x00btxz-fido2\x00"
+	// fido2ResponseSize is the size of an hmac-secret response, and of the salt.
+	fido2ResponseSize = 32
+	// maxFIDO2Credential is the largest credential id the header can hold.
+	maxFIDO2Credential = 128
+)
+
+// fido2HeaderV4 is the security key binding in the v4 header. It is unused
+// (zero) unless a key slot carries keyFlagFIDO2.
+type fido2HeaderV4 struct {
+	CredentialLength uint8                    // Length of the credential id
+	Credential       [maxFIDO2Credential]byte // Credential id on the security key
+	Salt             [fido2ResponseSize]byte  // hmac-secret salt
+}
+
+// FIDO2Binding identifies the credential and salt whose hmac-secret response
+// opens an archive.
+type FIDO2Binding struct {
+	CredentialID []byte
+	Salt         []byte
+}
+
+// NewFIDO2Binding returns a binding to the credential with a fresh salt.
+func NewFIDO2Binding(credentialID []byte) (*FIDO2Binding, error) {
+	if len(credentialID) == 0 || len(credentialID) > maxFIDO2Credential {
+		return nil, fmt.Errorf("unsupported FIDO2 credential: its id has %d bytes, at most %d are supported", len(credentialID), maxFIDO2Credential)
+	}
+	salt := make([]byte, fido2ResponseSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return &FIDO2Binding{CredentialID: credentialID, Salt: salt}, nil
+}
+
+// FIDO2Secret combines the hmac-secret response of a security key with a
+// password or keyfile secret. Pass the result to the archive functions in
+// place of the password.
+func FIDO2Secret(secret string, response []byte) (string, error) {
+	if len(response) != fido2ResponseSize {
+		return "", fmt.Errorf("invalid hmac-secret response: %d bytes, %d expected", len(response), fido2ResponseSize)
+	}
+	return fido2Marker + string(response) + secret, nil
+}
+
+// ArchiveFIDO2 returns the security key binding of an archive, or nil if no
+// key slot needs a security key. It only reads the header.
+func ArchiveFIDO2(archivePath string) (*FIDO2Binding, error) {
+	version, err := peekVersion(archivePath)
+	if err != nil || version != coreVersionV4 {
+		return nil, err
+	}
+	archiveFile, err := openArchiveFile(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archiveFile.Close()
+	header, err := readHeaderV4(archiveFile)
+	if err != nil {
+		return nil, err
+	}
+	return header.fido2Binding(), nil
+}
+
+// fido2Binding returns the security key binding recorded in the header, or nil.
+func (header BtxzHeaderV4) fido2Binding() *FIDO2Binding {
+	if header.FIDO2.CredentialLength == 0 {
+		return nil
+	}
+	return &FIDO2Binding{
+		CredentialID: append([]byte(nil), header.FIDO2.Credential[:header.FIDO2.CredentialLength]...),
+		Salt:         append([]byte(nil), header.FIDO2.Salt[:]...),
+	}
+}
+
+// store records the binding in the header. The password must carry the
+// response for it.
+func (binding *FIDO2Binding) store(header *BtxzHeaderV4, password string) error {
+	if _, response := splitFIDO2(password); response == nil {
+		return errors.New("a security key binding requires the hmac-secret response in the password")
+	}
+	if len(binding.CredentialID) == 0 || len(binding.CredentialID) > maxFIDO2Credential || len(binding.Salt) != fido2ResponseSize {
+		return errors.New("invalid FIDO2 binding")
+	}
+	header.FIDO2.CredentialLength = uint8(len(binding.CredentialID))
+	copy(header.FIDO2.Credential[:], binding.CredentialID)
+	copy(header.FIDO2.Salt[:], binding.Salt)
+	return nil
+}
+
+// splitFIDO2 separates a secret into the hmac-secret response of a security
+// key and the rest. The response is nil for a secret without one.
+func splitFIDO2(secret string) (string, []byte) {
+	rest, ok := strings.CutPrefix(secret, fido2Marker)
+	if !ok || len(rest) < fido2ResponseSize {
+		return secret, nil
+	}
+	return rest[fido2ResponseSize:], []byte(rest[:fido2ResponseSize])
+}
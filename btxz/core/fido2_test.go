@@ -0,0 +1,111 @@
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"btxz/internal/fido2"
+)
+
+// fakeAuthenticator stands in for a security key: its hmac-secret response is
+// an HMAC of the credential and salt under a secret of the device.
+type fakeAuthenticator struct {
+	device     []byte
+	credential []byte
+}
+
+func (a *fakeAuthenticator) Credential(rpID string) ([]byte, error) {
+	return a.credential, nil
+}
+
+func (a *fakeAuthenticator) HMACSecret(rpID string, credentialID, salt []byte) ([]byte, error) {
+	if !bytes.Equal(credentialID, a.credential) {
+		return nil, errors.New("unknown credential")
+	}
+	mac := hmac.New(sha256.New, a.device)
+	mac.Write([]byte(rpID))
+	mac.Write(salt)
+	return mac.Sum(nil), nil
+}
+
+var _ fido2.Authenticator = (*fakeAuthenticator)(nil)
+
+// securityKeySecret combines the response of key for binding with password.
+func securityKeySecret(t *testing.T, key fido2.Authenticator, binding *FIDO2Binding, password string) string {
+	t.Helper()
+	response, err := key.HMACSecret(fido2.RelyingParty, binding.CredentialID, binding.Salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := FIDO2Secret(password, response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func TestFIDO2Binding(t *testing.T) {
+	key := &fakeAuthenticator{device: []byte("device one"), credential: []byte("credential")}
+	credential, err := key.Credential(fido2.RelyingParty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binding, err := NewFIDO2Binding(credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := encryptedTestArchive(t, securityKeySecret(t, key, binding, "password"), CreateOptions{FIDO2: binding})
+
+	// Extraction repeats the assertion with the binding stored in the header.
+	stored, err := ArchiveFIDO2(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || !bytes.Equal(stored.CredentialID, binding.CredentialID) || !bytes.Equal(stored.Salt, binding.Salt) {
+		t.Fatalf("stored binding %+v, want %+v", stored, binding)
+	}
+	if err := openWith(t, archive, securityKeySecret(t, key, stored, "password"), ExtractOptions{}); err != nil {
+		t.Errorf("the key and password do not open the archive: %v", err)
+	}
+
+	other := &fakeAuthenticator{device: []byte("device two"), credential: []byte("credential")}
+	for name, secret := range map[string]string{
+		"password alone": "password",
+		"another key":    securityKeySecret(t, other, stored, "password"),
+		"wrong password": securityKeySecret(t, key, stored, "wrong"),
+		"no password":    securityKeySecret(t, key, stored, ""),
+	} {
+		if err := openWith(t, archive, secret, ExtractOptions{}); !errors.Is(err, ErrAuthentication) {
+			t.Errorf("%s: err = %v, want ErrAuthentication", name, err)
+		}
+	}
+}
+
+func TestFIDO2BindingRequiresResponse(t *testing.T) {
+	binding, err := NewFIDO2Binding([]byte("credential"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A binding with a password that lacks the response could never be opened.
+	archive := filepath.Join(t.TempDir(), "test.btxz")
+	if _, err := CreateArchiveWithOptions(archive, []string{t.TempDir()}, "password", CreateOptions{Level: "low", KDF: fastKDF, FIDO2: binding}); err == nil {
+		t.Error("a binding without a response was accepted")
+	}
+	if _, err := FIDO2Secret("password", make([]byte, 16)); err == nil {
+		t.Error("a short response was accepted")
+	}
+	if _, err := NewFIDO2Binding(nil); err == nil {
+		t.Error("an empty credential was accepted")
+	}
+}
+
+func TestArchiveFIDO2WithoutBinding(t *testing.T) {
+	binding, err := ArchiveFIDO2(encryptedTestArchive(t, "password", CreateOptions{}))
+	if err != nil || binding != nil {
+		t.Errorf("ArchiveFIDO2 = %v, %v; want nil, nil", binding, err)
+	}
+}
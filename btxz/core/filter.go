@@ -0,0 +1,149 @@
+// File: core/filter.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the include and exclude patterns of archive creation. A
+// pattern is matched against the slash-separated name an entry would be stored
+// under, one path element at a time as by path.Match, where an element "**"
+// matches any number of elements, including none. A pattern without a slash
+// matches the last element only, so "node_modules" or "*.o" match at any depth.
+//
+// Exclude patterns take precedence: an excluded entry is left out even if it
+// matches an include pattern, and an excluded directory is not walked at all.
+// Include patterns select files, symlinks and hard links; once one is given,
+// directories are walked but not stored, as extraction creates the parents of
+// every file it writes.
+package core
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// patternList holds cleaned, checked filter patterns.
+type patternList []string
+
+// newPatternList checks the patterns and returns them ready for matching.
+func newPatternList(kind string, patterns []string) (patternList, error) {
+	var list patternList
+	for _, pattern := range patterns {
+		clean := strings.Trim(strings.TrimPrefix(pattern, "./"), "/")
+		if clean == "" {
+			return nil, fmt.Errorf("invalid %s pattern %q: it is empty", kind, pattern)
+		}
+		for _, element := range strings.Split(clean, "/") {
+			if _, err := path.Match(element, ""); err != nil {
+				return nil, fmt.Errorf("invalid %s pattern %q: %w", kind, pattern, err)
+			}
+		}
+		list = append(list, clean)
+	}
+	return list, nil
+}
+
+// MatchFilter reports whether an entry name, relative to its input folder,
+// matches one of the patterns, as CreateOptions.Include and Exclude match them.
+// The only error is an invalid pattern.
+func MatchFilter(patterns []string, name string) (bool, error) {
+	list, err := newPatternList("filter", patterns)
+	if err != nil {
+		return false, err
+	}
+	return list.matches(name), nil
+}
+
+// matches reports whether the entry name matches any of the patterns.
+func (list patternList) matches(name string) bool {
+	if len(list) == 0 {
+		return false
+	}
+	elements := strings.Split(strings.TrimSuffix(name, "/"), "/")
+	for _, pattern := range list {
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, elements[len(elements)-1]); ok {
+				return true
+			}
+			continue
+		}
+		if matchElements(strings.Split(pattern, "/"), elements) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchElements matches the elements of a name against those of a pattern.
+func matchElements(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := range name {
+				if matchElements(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// filterVerdict tells the walk what to do with an entry.
+type filterVerdict int
+
+const (
+	keepEntry    filterVerdict = iota // Written
+	omitEntry                         // Not written; a directory is still walked
+	excludeEntry                      // Not written and counted; a directory is not walked
+)
+
+// entryFilter holds the include and exclude patterns of a new archive. A nil
+// filter keeps every entry.
+type entryFilter struct {
+	include patternList
+	exclude patternList
+}
+
+// newEntryFilter checks the patterns. It returns nil if there are none.
+func newEntryFilter(include, exclude []string) (*entryFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	filter := &entryFilter{}
+	var err error
+	if filter.include, err = newPatternList("include", include); err != nil {
+		return nil, err
+	}
+	if filter.exclude, err = newPatternList("exclude", exclude); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// verdict decides on the entry with the given name.
+func (f *entryFilter) verdict(name string, dir bool) filterVerdict {
+	switch {
+	case f == nil:
+		return keepEntry
+	case f.exclude.matches(name):
+		return excludeEntry
+	case len(f.include) == 0:
+		return keepEntry
+	case dir:
+		return omitEntry
+	case f.include.matches(name):
+		return keepEntry
+	default:
+		return excludeEntry
+	}
+}
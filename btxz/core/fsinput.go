@@ -0,0 +1,215 @@
+// File: core/fsinput.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements archive creation from an io/fs file system, such as an
+// embed.FS, an opened zip file or content generated in memory. The file system
+// is walked like an input folder, and its entries go through the same pipeline
+// as files on disk, including hard link detection and deduplication.
+package core
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// readLinkFS is implemented by file systems that report the target of their
+// symbolic links, such as os.DirFS and the file systems of OpenFS.
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+// CreateArchiveFromFS creates a new v4 archive from the files below root in
+// fsys. A root directory is the root of the archive entries, as an input folder
+// is for CreateArchive; a root file is stored under its base name.
+func CreateArchiveFromFS(archivePath string, fsys fs.FS, root, password, level string) error {
+	_, err := CreateArchiveFromFSWithOptions(archivePath, fsys, root, password, CreateOptions{Level: level})
+	return err
+}
+
+// CreateArchiveFromFSWithOptions creates a new v4 archive from the files below
+// root in fsys with the given options.
+func CreateArchiveFromFSWithOptions(archivePath string, fsys fs.FS, root, password string, opts CreateOptions) (CreateStats, error) {
+	if !fs.ValidPath(root) {
+		return CreateStats{}, fmt.Errorf("invalid root %q: must be a slash-separated path within the file system", root)
+	}
+	if opts.Snapshot != nil {
+		return CreateStats{}, errors.New("only archives created from paths can be incremental")
+	}
+	if opts.Base != nil {
+		return CreateStats{}, errors.New("only archives created from paths can have a base archive")
+	}
+	if err := opts.digestFS(fsys, root); err != nil {
+		return CreateStats{}, err
+	}
+	return createArchiveV4(archivePath, password, opts, func(writer *writerV4) error {
+		progress, err := newFSProgressCounter(fsys, root, writer.walk, opts.Progress)
+		if err != nil {
+			return err
+		}
+		writer.progress = progress
+		return writer.addFS(fsys, root)
+	})
+}
+
+// AddFS adds the files below root in fsys, named as by CreateArchiveFromFS.
+func (w *Writer) AddFS(fsys fs.FS, root string) error {
+	if w.closed {
+		return errors.New("write to closed archive writer")
+	}
+	if !fs.ValidPath(root) {
+		return fmt.Errorf("invalid root %q: must be a slash-separated path within the file system", root)
+	}
+	return w.w.addFS(fsys, root)
+}
+
+// newFSProgressCounter is newProgressCounter for a file system.
+func newFSProgressCounter(fsys fs.FS, root string, walk walkOptions, report ProgressFunc) (*progressCounter, error) {
+	if report == nil {
+		return nil, nil
+	}
+	counter := &progressCounter{report: func(_ string, done, total int64) { report(done, total) }}
+	_, err := walkFS(fsys, root, walk, func(_, _ string, d fs.DirEntry) error {
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		counter.total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report(0, counter.total)
+	return counter, nil
+}
+
+// walkFS calls fn for every entry below root in fsys together with the base
+// its archive name is relative to, as walkIncluded does for paths, and returns
+// the number of entries excluded by the filter of walk.
+func walkFS(fsys fs.FS, root string, walk walkOptions, fn func(name, base string, d fs.DirEntry) error) (int, error) {
+	info, err := fs.Stat(fsys, root)
+	if err != nil {
+		return 0, fmt.Errorf("could not stat input %s: %w", root, err)
+	}
+	base := path.Dir(root)
+	if info.IsDir() && (!walk.keepRoot || root == ".") {
+		// The root "." of the file system has no name to keep.
+		base = root
+	}
+	excluded := 0
+	walkErr := fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && name == base {
+			// The root folder itself is the root of its entries.
+			return nil
+		}
+		switch walk.filter.verdict(fsEntryName(name, base), d.IsDir()) {
+		case excludeEntry:
+			excluded++
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		case omitEntry:
+			return nil
+		}
+		return fn(name, base, d)
+	})
+	if walkErr != nil {
+		return excluded, fmt.Errorf("failed while walking %s: %w", root, walkErr)
+	}
+	return excluded, nil
+}
+
+// fsEntryName returns the archive name of name relative to base.
+func fsEntryName(name, base string) string {
+	if base == "." {
+		return name
+	}
+	return strings.TrimPrefix(name, base+"/")
+}
+
+// addFS walks fsys from root and writes its files, directories and symlinks
+// into the tar stream.
+func (w *writerV4) addFS(fsys fs.FS, root string) error {
+	excluded, err := walkFS(fsys, root, w.walk, func(name, base string, d fs.DirEntry) error {
+		if err := contextErr(w.ctx); err != nil {
+			return err
+		}
+		return w.addFSFile(fsys, name, base, d)
+	})
+	w.excluded += excluded
+	return err
+}
+
+// addFSFile writes a single entry of a file system into the tar stream.
+func (w *writerV4) addFSFile(fsys fs.FS, name, base string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	entryName := w.normalize(fsEntryName(name, base))
+
+	if !info.Mode().IsRegular() {
+		// A symlink is stored as the link itself, which needs a file system
+		// that can report its target.
+		var target string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			links, ok := fsys.(readLinkFS)
+			if !ok {
+				return fmt.Errorf("cannot store symlink %s: the file system does not report link targets", name)
+			}
+			if target, err = links.ReadLink(name); err != nil {
+				return err
+			}
+		}
+		if _, err := w.checkInput(name, fsEntryName(name, base), info, target); err != nil {
+			return err
+		}
+		if target != "" {
+			target = w.normalize(target)
+		}
+		header, err := tar.FileInfoHeader(info, target)
+		if err != nil {
+			return err
+		}
+		header.Name = entryName
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		return w.addEntry(header, nil)
+	}
+
+	input, err := w.checkInput(name, fsEntryName(name, base), info, "")
+	if err != nil {
+		return err
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = entryName
+	content, open := io.Reader(file), func() (io.ReadCloser, error) { return fsys.Open(name) }
+	if input != nil {
+		spooled := input.content(file)
+		defer spooled.Close()
+		content, open = spooled, input.reopen(open)
+	}
+	return w.addRegular(header, info, content, open)
+}
@@ -0,0 +1,60 @@
+// File: core/hardlink.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements hard link preservation. A file reachable under several
+// names is stored once; every further name becomes a tar link entry, which
+// extraction recreates with os.Link.
+package core
+
+import (
+	"archive/tar"
+	"os"
+)
+
+// fileKey identifies a file on disk by its device and inode number.
+type fileKey struct {
+	dev uint64
+	ino uint64
+}
+
+// hardlinkTracker maps every multiply-linked file written so far to the entry
+// name it was first stored under.
+type hardlinkTracker map[fileKey]string
+
+// find returns the entry name of an earlier link to the same file as info, or ""
+// if there is none. In that case the file is remembered under name.
+func (t hardlinkTracker) find(info os.FileInfo, name string) string {
+	key, ok := hardlinkKey(info)
+	if !ok {
+		return ""
+	}
+	if target, seen := t[key]; seen {
+		return target
+	}
+	t[key] = name
+	return ""
+}
+
+// linkHeader turns a file header into a hard link to the entry target.
+func linkHeader(header *tar.Header, target string) {
+	header.Typeflag = tar.TypeLink
+	header.Linkname = target
+	header.Size = 0
+}
+
+// linkExtractedFile recreates a hard link to a file written earlier during
+// extraction. Where the filesystem cannot link, the file is copied instead.
+func linkExtractedFile(sourcePath, targetPath string, mode os.FileMode) error {
+	if _, err := os.Stat(sourcePath); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(targetPath); err == nil && !info.IsDir() {
+		if err := os.Remove(targetPath); err != nil {
+			return err
+		}
+	}
+	if err := os.Link(sourcePath, targetPath); err == nil {
+		return nil
+	}
+	return copyExtractedFile(sourcePath, targetPath, mode)
+}
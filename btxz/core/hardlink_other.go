@@ -0,0 +1,18 @@
+// File: core/hardlink_other.go
+
+//go:build !unix
+
+package core
+
+import "os"
+
+// hardlinkKey reports no identity on platforms without inode numbers, so every
+// name is stored as an independent file.
+func hardlinkKey(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
+
+// fileID reports no identity either; snapshots then compare size and time only.
+func fileID(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
@@ -0,0 +1,28 @@
+// File: core/hardlink_unix.go
+
+//go:build unix
+
+package core
+
+import (
+	"os"
+	"syscall"
+)
+
+// hardlinkKey returns the identity of a file that has more than one link.
+func hardlinkKey(info os.FileInfo) (fileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
+
+// fileID returns the identity of a file, however many links it has.
+func fileID(info os.FileInfo) (fileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
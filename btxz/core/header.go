@@ -0,0 +1,123 @@
+// File: core/header.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements describing an archive from its plaintext header alone:
+// the format, the cipher, the cost of deriving the key and how the payload was
+// compressed. No secret is needed, so this is what to look at before starting a
+// key derivation that may take minutes, or more memory than the machine has.
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// HeaderInfo is what the header of an archive, and the size of its file, tell
+// about it. Fields a format version does not record are left at their zero value.
+type HeaderInfo struct {
+	Version   int
+	Size      int64 // Bytes of the archive file, of all volumes together
+	Volumes   int   // Files the archive is split into; 1 if it is not split
+	Encrypted bool
+	Cipher    string // e.g. "XChaCha20-Poly1305"; empty if not encrypted
+	// The Argon2 parameters of the key derivation, zero if not encrypted.
+	// Argon2Memory is in KiB, as Argon2 takes it.
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+	Codec         string        // Compression backend: xz, zstd, s2, store or auto
+	Profile       string        // low, default, max or a level 0-9 (v3, v4); fast, default or best (v2)
+	DictSize      int64         // v4: dictionary (xz) or window (zstd) size in bytes; zero if the codec uses none
+	ChunkSize     int64         // v4: plaintext bytes per encrypted chunk
+	Index         bool          // v4: an index of the entries and their checksums follows the payload
+	Signed        bool          // A signature trailer is appended (see signature.go)
+	KeySlots      []SecretKinds // v4: the secrets that open each key slot
+}
+
+// ReadHeaderInfo reads the header of an archive and describes it. It reads no
+// more than the header and, for the signature, the end of the file.
+func ReadHeaderInfo(archivePath string) (HeaderInfo, error) {
+	var info HeaderInfo
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return info, err
+	}
+	file, err := openArchiveFile(archivePath)
+	if err != nil {
+		return info, fmt.Errorf("could not open archive file: %w", err)
+	}
+	defer file.Close()
+
+	info.Version = int(version)
+	info.Volumes = 1
+	if volumes, ok := file.(*volumeReader); ok {
+		info.Volumes = len(volumes.files)
+	}
+	switch version {
+	case coreVersionV1:
+		var header BtxzHeaderV1
+		if err := binary.Read(file, binary.LittleEndian, &header); err != nil {
+			return info, fmt.Errorf("failed to read v1 archive header: %w", err)
+		}
+		info.Codec = "xz"
+		if header.ProtectionMode != modeUnprotected {
+			info.Encrypted = true
+			info.Cipher = "AES-256-GCM"
+			info.Argon2Time, info.Argon2Memory, info.Argon2Threads = header.Argon2Time, header.Argon2Memory, header.Argon2Threads
+		}
+	case coreVersionV2:
+		var header BtxzHeaderV2
+		if err := binary.Read(file, binary.LittleEndian, &header); err != nil {
+			return info, fmt.Errorf("failed to read v2 archive header: %w", err)
+		}
+		info.Encrypted = true
+		info.Cipher = "AES-256-GCM"
+		info.Argon2Time, info.Argon2Memory, info.Argon2Threads = header.Argon2Time, header.Argon2Memory, header.Argon2Threads
+		info.Codec = "zstd"
+		switch header.CompressionLevel {
+		case levelFast:
+			info.Profile = "fast"
+		case levelBest:
+			info.Profile = "best"
+		default:
+			info.Profile = "default"
+		}
+	case coreVersionV3:
+		var header BtxzHeaderV3
+		if err := binary.Read(file, binary.LittleEndian, &header); err != nil {
+			return info, fmt.Errorf("failed to read v3 archive header: %w", err)
+		}
+		info.Encrypted = true
+		info.Cipher = "XChaCha20-Poly1305"
+		info.Argon2Time, info.Argon2Memory, info.Argon2Threads = header.Argon2Time, header.Argon2Memory, header.Argon2Threads
+		info.Codec = "xz"
+		info.Profile = profileForCompressionLevel(header.CompressionLevel).name
+	case coreVersionV4:
+		header, err := readHeaderV4(file)
+		if err != nil {
+			return info, err
+		}
+		if info.Encrypted = header.encrypted(); info.Encrypted {
+			info.Cipher = cipherName(header.Cipher)
+			info.Argon2Time, info.Argon2Memory, info.Argon2Threads = header.Argon2Time, header.Argon2Memory, header.Argon2Threads
+		}
+		info.Codec = codecName(header.Codec)
+		info.Profile = profileForHeader(header).name
+		info.DictSize = header.levelInfo(ArchiveInfo{}).DictSize
+		info.ChunkSize = int64(header.ChunkSize)
+		info.Index = header.IndexOffset != 0 || header.Flags&headerFlagIndexTrailer != 0
+		if info.KeySlots, err = header.secretKinds(); err != nil {
+			return info, err
+		}
+	default:
+		return info, fmt.Errorf("unsupported archive core version: v%d", version)
+	}
+
+	length, trailer, err := signedLength(file)
+	if err != nil {
+		return info, fmt.Errorf("could not read archive file: %w", err)
+	}
+	info.Signed = trailer != nil
+	info.Size = length + int64(len(trailer))
+	return info, nil
+}
@@ -0,0 +1,218 @@
+// File: core/incremental.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements incremental backups in the manner of GNU tar's
+// --listed-incremental. A snapshot file records the size, modification time and
+// inode number of every file an archive stored. The next archive created with
+// the snapshot only stores the files that are new or differ from it, together
+// with every directory, and lists in its index the entries that no longer
+// exist. Extracting a full archive and then each incremental one in order, with
+// ExtractOptions.ApplyDeletions, restores the tree as of the last one.
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// snapshotVersion is the format version of a snapshot file.
+const snapshotVersion = 1
+
+// The kinds of backup recorded in ArchiveInfo.Backup.
+const (
+	BackupFull        = "full"        // Created with an empty snapshot: every file is stored
+	BackupIncremental = "incremental" // Only what changed since the snapshot is stored
+)
+
+// Snapshot is the state of the files of an incremental backup, as recorded by
+// the last archive created with it. Load it with LoadSnapshot, pass it as
+// CreateOptions.Snapshot, and Save it once the archive is created.
+type Snapshot struct {
+	previous map[string]snapshotFile // The files as the snapshot file records them
+	next     map[string]snapshotFile // The files seen by the archive being created
+}
+
+// snapshotFile is what a snapshot records about an entry.
+type snapshotFile struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`         // Unix time in nanoseconds
+	Inode   uint64 `json:"ino,omitempty"` // Zero where the platform has no inode numbers
+	Mode    uint32 `json:"mode"`          // fs.FileMode, with the type bits
+}
+
+// snapshotDocument is the content of a snapshot file.
+type snapshotDocument struct {
+	Version int                     `json:"version"`
+	Files   map[string]snapshotFile `json:"files"` // By entry name, without the slash of directories
+}
+
+// LoadSnapshot reads the snapshot file at path. If the file does not exist, the
+// snapshot is empty and the next archive created with it is a full backup.
+func LoadSnapshot(path string) (*Snapshot, error) {
+	snapshot := &Snapshot{previous: make(map[string]snapshotFile)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot file: %w", err)
+	}
+	var doc snapshotDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid snapshot file %s: %w", path, err)
+	}
+	if doc.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot file version %d", doc.Version)
+	}
+	if doc.Files != nil {
+		snapshot.previous = doc.Files
+	}
+	return snapshot, nil
+}
+
+// Save writes the files seen by the last archive created with the snapshot to
+// path, replacing the file atomically, so that the next archive stores what
+// changed since. It should only be called once that archive was created.
+func (s *Snapshot) Save(path string) error {
+	if s.next == nil {
+		return errors.New("no archive was created with the snapshot")
+	}
+	data, err := json.Marshal(snapshotDocument{Version: snapshotVersion, Files: s.next})
+	if err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), ".btxz-*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not write snapshot file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("could not write snapshot file: %w", err)
+	}
+	return nil
+}
+
+// backup returns the kind of archive created with the snapshot.
+func (s *Snapshot) backup() string {
+	if len(s.previous) == 0 {
+		return BackupFull
+	}
+	return BackupIncremental
+}
+
+// start prepares the snapshot for a new archive.
+func (s *Snapshot) start() {
+	s.next = make(map[string]snapshotFile)
+}
+
+// unchanged records the entry name, found with info, and reports whether it
+// matches the snapshot, so it need not be stored again. Directories are always
+// stored, so their modes and times follow, and so are files the snapshot has
+// no inode number for when the platform does have one. A nil *Snapshot stores
+// everything.
+func (s *Snapshot) unchanged(name string, info os.FileInfo) bool {
+	if s == nil {
+		return false
+	}
+	file := snapshotFile{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Mode: uint32(info.Mode())}
+	if id, ok := fileID(info); ok {
+		file.Inode = id.ino
+	}
+	if info.IsDir() {
+		file.Size = 0
+	}
+	name = strings.TrimSuffix(name, "/")
+	s.next[name] = file
+	old, ok := s.previous[name]
+	return ok && !info.IsDir() && old == file
+}
+
+// deleted returns the entries of the snapshot that the new archive did not
+// see, sorted, with a trailing slash for directories.
+func (s *Snapshot) deleted() []string {
+	var names []string
+	for name, file := range s.previous {
+		if _, ok := s.next[name]; ok {
+			continue
+		}
+		if fs.FileMode(file.Mode).IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyDeletions removes the entries an incremental archive lists as deleted
+// from outputDir, and returns the names of those it removed. Names whose path
+// would leave outputDir are ignored, as are those that do not exist. A
+// directory is only removed once it is empty: it may hold files the backups do
+// not know about. With DryRun nothing is removed, and the names are those that
+// would be.
+func applyDeletions(outputDir string, deleted []string, opts ExtractOptions) ([]string, error) {
+	cleanOutputDir, err := filepath.Abs(filepath.Clean(outputDir))
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve output directory path: %w", err)
+	}
+	realOutputDir := resolvePath(cleanOutputDir)
+	names, err := nameNormalizer(opts.NormalizeNames)
+	if err != nil {
+		return nil, err
+	}
+	// Children sort after their folder, so going backwards empties a folder
+	// before it is removed.
+	var removed []string
+	for i := len(deleted) - 1; i >= 0; i-- {
+		if err := contextErr(opts.ctx); err != nil {
+			return removed, err
+		}
+		name := entryPath(deleted[i])
+		if names != nil {
+			name = names(name)
+		}
+		rel := filepath.FromSlash(name)
+		if !filepath.IsLocal(rel) {
+			continue
+		}
+		targetPath := filepath.Join(cleanOutputDir, rel)
+		if !isWithinDir(realOutputDir, resolvePath(filepath.Dir(targetPath))) {
+			// A symlink on the way leads out of the output directory.
+			continue
+		}
+		info, err := os.Lstat(targetPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if info.IsDir() && !opts.DryRun {
+			if entries, err := os.ReadDir(targetPath); err != nil || len(entries) > 0 {
+				continue
+			}
+		}
+		if !opts.DryRun {
+			if err := os.Remove(targetPath); err != nil {
+				return removed, fmt.Errorf("could not delete %s: %w", deleted[i], err)
+			}
+		}
+		removed = append(removed, deleted[i])
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
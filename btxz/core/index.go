@@ -0,0 +1,205 @@
+// File: core/index.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the encrypted index footer used by v4 archives.
+// The index lists every entry with its metadata and its offset in the tar stream,
+// which lets 'list' return without decrypting or decompressing the payload.
+package core
+
+import (
+	"archive/tar"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// maxIndexSize bounds the encrypted index size accepted from an archive.
+	maxIndexSize = 256 * 1024 * 1024 // 256 MiB
+	// maxCommentSize bounds the length of an archive comment.
+	maxCommentSize = 64 * 1024 // 64 KiB
+)
+
+// indexEntry is the serialized form of a single archive member in the index.
+type indexEntry struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`             // Content size; for links, that of the link target
+	Mode    int64  `json:"mode"`             // Tar header mode bits
+	ModTime int64  `json:"mtime"`            // Unix time in nanoseconds
+	Offset  int64  `json:"offset"`           // Offset of the tar header in the uncompressed tar stream
+	Segment int    `json:"segment"`          // Compressed segment that contains the entry
+	Type    byte   `json:"type,omitempty"`   // Tar type flag, omitted for regular files
+	Link    string `json:"link,omitempty"`   // Link target
+	Dedup   bool   `json:"dedup,omitempty"`  // The link is a deduplicated copy rather than a hard link
+	SHA256  string `json:"sha256,omitempty"` // Hex SHA-256 of the content, recorded for regular files
+}
+
+// archiveMeta describes an archive as a whole. It lives in the index, so it is
+// encrypted and authenticated together with the entry list.
+type archiveMeta struct {
+	Comment string `json:"comment,omitempty"` // UTF-8 description given at creation
+	Creator string `json:"creator,omitempty"` // Program and version that created the archive
+	Created int64  `json:"created,omitempty"` // Creation time, Unix time in nanoseconds
+	Profile string `json:"profile,omitempty"` // Profile chosen at creation: low, default, max or a level 0-9
+	// An archive created with a snapshot is a full or incremental backup; an
+	// incremental one lists the entries deleted since (see incremental.go).
+	Backup  string   `json:"backup,omitempty"`
+	Deleted []string `json:"deleted,omitempty"`
+	// A differential archive records the fingerprint of its base archive and
+	// the files it takes from it unchanged (see diffbase.go).
+	Base        string       `json:"base,omitempty"`
+	BaseEntries []indexEntry `json:"base_entries,omitempty"`
+	// Totals of the entry list, so progress can be shown without summing it.
+	TotalSize  int64 `json:"total_size,omitempty"`  // Size of the regular file content, what extraction reads
+	EntryCount int   `json:"entry_count,omitempty"` // Number of entries
+}
+
+// archiveIndex is the plaintext of the index footer.
+type archiveIndex struct {
+	Entries  []indexEntry   `json:"entries"`
+	Segments []indexSegment `json:"segments"`
+	archiveMeta
+}
+
+// info converts the archive metadata into the public type. Fields missing from
+// older archives stay empty.
+func (index *archiveIndex) info() ArchiveInfo {
+	info := ArchiveInfo{
+		Comment:   index.Comment,
+		Creator:   index.Creator,
+		Profile:   index.Profile,
+		Backup:    index.Backup,
+		Deleted:   index.Deleted,
+		Base:      index.Base,
+		BaseFiles: len(index.BaseEntries),
+	}
+	if index.Created != 0 {
+		info.Created = time.Unix(0, index.Created)
+	}
+	return info
+}
+
+// contentSize returns the total size of the regular files among entries. Links
+// store no content of their own.
+func contentSize(entries []indexEntry) int64 {
+	var total int64
+	for _, e := range entries {
+		if e.Type == 0 {
+			total += e.Size
+		}
+	}
+	return total
+}
+
+// typeflag returns the tar type of the entry, which the index omits for
+// regular files.
+func (e indexEntry) typeflag() byte {
+	if e.Type == 0 {
+		return tar.TypeReg
+	}
+	return e.Type
+}
+
+// toArchiveEntry converts an index entry into the public listing type.
+func (e indexEntry) toArchiveEntry() ArchiveEntry {
+	mode := os.FileMode(e.Mode)
+	switch e.Type {
+	case tar.TypeSymlink:
+		mode |= os.ModeSymlink
+	case tar.TypeDir:
+		mode |= os.ModeDir
+	}
+	return ArchiveEntry{
+		Mode:     mode.String(),
+		FileMode: mode,
+		Size:     e.Size,
+		Name:     e.Name,
+		ModTime:  time.Unix(0, e.ModTime),
+		Typeflag: e.typeflag(),
+		Offset:   e.Offset,
+		Link:     e.Link,
+		Hardlink: e.Type == tar.TypeLink && !e.Dedup,
+		Dedup:    e.Dedup,
+		SHA256:   e.SHA256,
+	}
+}
+
+// writeIndex seals the index with the archive key and writes it as
+// [24-byte nonce][4-byte LE ciphertext length][ciphertext], with the nonce read
+// from random. The header of the archive, as returned by additionalData, is
+// bound as additional data so an index cannot be moved between archives and
+// the header cannot be changed without the index failing authentication.
+func writeIndex(w io.Writer, aead cipher.AEAD, header []byte, index *archiveIndex, random io.Reader) error {
+	plain, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to encode archive index: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return fmt.Errorf("failed to generate index nonce: %w", err)
+	}
+	sealed := aead.Seal(nil, nonce, plain, header)
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := w.Write(nonce); err != nil {
+		return err
+	}
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(sealed)
+	return err
+}
+
+// readIndex reads and authenticates an index written by writeIndex.
+func readIndex(r io.Reader, aead cipher.AEAD, header []byte) (*archiveIndex, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, fmt.Errorf("could not read archive index: %w", err)
+	}
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, fmt.Errorf("could not read archive index: %w", err)
+	}
+	size := binary.LittleEndian.Uint32(length[:])
+	if size > maxIndexSize {
+		return nil, errors.New("invalid archive index: size out of range")
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r, sealed); err != nil {
+		return nil, fmt.Errorf("could not read archive index: %w", err)
+	}
+	plain, err := aead.Open(nil, nonce, sealed, header)
+	if err != nil {
+		return nil, errTampered
+	}
+
+	var index archiveIndex
+	if err := json.Unmarshal(plain, &index); err != nil {
+		return nil, fmt.Errorf("invalid archive index: %w", err)
+	}
+	if err := checkIndexLimits(&index); err != nil {
+		return nil, err
+	}
+	return &index, nil
+}
+
+// countingWriter tracks how many bytes have been written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
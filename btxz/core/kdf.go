@@ -0,0 +1,193 @@
+// File: core/kdf.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file holds the Argon2id parameters of v4 archives. The profiles choose
+// them by default; CreateOptions.KDF overrides single values within sane bounds,
+// and CalibrateKDF derives them from a target time on the current machine.
+// The parameters a header asks for are checked against KDFLimits before any
+// key is derived from them, for every format version.
+package core
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// MinKDFMemory is the smallest accepted Argon2 memory, in KiB.
+	MinKDFMemory = 8 * 1024 // 8 MiB
+	// WeakKDFMemory is the Argon2 memory, in KiB, below which brute-force
+	// resistance becomes noticeably weaker than with the low profile.
+	WeakKDFMemory = 64 * 1024 // 64 MiB
+	// maxKDFMemory bounds the Argon2 memory, in KiB, both when creating and
+	// when reading a header, so a crafted archive cannot exhaust memory.
+	maxKDFMemory = 4 * 1024 * 1024 // 4 GiB
+	// maxKDFTime bounds the number of Argon2 passes.
+	maxKDFTime = 1024
+)
+
+// KDFParams are the Argon2id parameters used to derive the key of every
+// password and keyfile slot. A zero field means "use the profile's value".
+type KDFParams struct {
+	Memory  uint32 // Memory in KiB
+	Time    uint32 // Number of passes
+	Threads uint8  // Degree of parallelism
+}
+
+// String describes the parameters, e.g. "Argon2id, 128 MiB, 1 pass, 4 threads".
+func (p KDFParams) String() string {
+	passes := "passes"
+	if p.Time == 1 {
+		passes = "pass"
+	}
+	return fmt.Sprintf("Argon2id, %d MiB, %d %s, %d threads", p.Memory/1024, p.Time, passes, p.Threads)
+}
+
+// kdfParams returns the parameters of a profile with the non-zero fields of
+// override applied, and checks that they are within bounds.
+func (profile profileV4) kdfParams(override KDFParams) (KDFParams, error) {
+	params := KDFParams{Memory: profile.argon2Memory, Time: profile.argon2Time, Threads: argon2Threads}
+	if override.Memory != 0 {
+		params.Memory = override.Memory
+	}
+	if override.Time != 0 {
+		params.Time = override.Time
+	}
+	if override.Threads != 0 {
+		params.Threads = override.Threads
+	}
+	return params, params.check()
+}
+
+// ErrKDFLimit is wrapped by the error for an archive whose header asks for
+// more Argon2 memory, passes or threads than KDFLimits allows.
+var ErrKDFLimit = errors.New("archive requests excessive KDF resources")
+
+// KDFLimits bounds the Argon2 parameters an archive header may ask for, so
+// that opening a crafted archive, even just to list it, cannot exhaust the
+// memory or the time of the process. A zero field takes its default: three
+// quarters of the available RAM, but at least the 128 MiB of the default
+// profile and at most 4 GiB; 64 passes; and two threads per CPU, but at
+// least 16. Programs that open archives known to ask for more raise it.
+var KDFLimits KDFParams
+
+const (
+	// kdfLimitMinMemory is the least default memory limit, in KiB, so that
+	// archives of the default profile open on any machine.
+	kdfLimitMinMemory = 128 * 1024
+	// kdfLimitTime is the default limit of Argon2 passes.
+	kdfLimitTime = 64
+	// kdfLimitMinThreads is the least default limit of Argon2 threads.
+	kdfLimitMinThreads = 16
+)
+
+// kdfLimits returns KDFLimits with the defaults of its zero fields filled in.
+func kdfLimits() KDFParams {
+	limits := KDFLimits
+	if limits.Memory == 0 {
+		limits.Memory = maxKDFMemory
+		if available := availableMemory(); available != 0 {
+			limits.Memory = uint32(min(max(available/4*3/1024, kdfLimitMinMemory), maxKDFMemory))
+		}
+	}
+	if limits.Time == 0 {
+		limits.Time = kdfLimitTime
+	}
+	if limits.Threads == 0 {
+		limits.Threads = uint8(min(max(2*runtime.NumCPU(), kdfLimitMinThreads), 255))
+	}
+	return limits
+}
+
+// checkHeaderKDF returns an error unless the Argon2 parameters read from an
+// archive header are valid and within KDFLimits. It is called before a key
+// is derived from them.
+func checkHeaderKDF(memory, time uint32, threads uint8) error {
+	if time < 1 || threads < 1 {
+		return errors.New("invalid archive header: Argon2 passes and threads must be at least 1")
+	}
+	limits := kdfLimits()
+	switch {
+	case memory > limits.Memory:
+		return fmt.Errorf("%w: %d MiB of Argon2 memory, above the limit of %d MiB", ErrKDFLimit, memory/1024, limits.Memory/1024)
+	case time > limits.Time:
+		return fmt.Errorf("%w: %d Argon2 passes, above the limit of %d", ErrKDFLimit, time, limits.Time)
+	case threads > limits.Threads:
+		return fmt.Errorf("%w: %d Argon2 threads, above the limit of %d", ErrKDFLimit, threads, limits.Threads)
+	}
+	return nil
+}
+
+// check reports parameters that are out of bounds.
+func (p KDFParams) check() error {
+	if p.Memory < MinKDFMemory || p.Memory > maxKDFMemory {
+		return fmt.Errorf("the Argon2 memory must be between %d MiB and %d MiB", MinKDFMemory/1024, maxKDFMemory/1024)
+	}
+	if p.Time < 1 || p.Time > maxKDFTime {
+		return fmt.Errorf("the number of Argon2 passes must be between 1 and %d", maxKDFTime)
+	}
+	if p.Threads < 1 {
+		return errors.New("the number of Argon2 threads must be between 1 and 255")
+	}
+	return nil
+}
+
+const (
+	// calibrationStartMemory is the Argon2 memory, in KiB, calibration starts with.
+	calibrationStartMemory = WeakKDFMemory
+	// calibrationMemoryShare is the fraction (1/n) of the available RAM that
+	// calibration may use at most.
+	calibrationMemoryShare = 4
+	// fallbackAvailableMemory is assumed, in bytes, where the available RAM is unknown.
+	fallbackAvailableMemory = 2 << 30 // 2 GiB
+)
+
+// CalibrateKDF measures Argon2id on this machine and returns parameters whose
+// key derivation takes about target. Memory is raised first, up to a quarter
+// of the available RAM, and passes are added after that, similar to what
+// cryptsetup does. threads 0 keeps the default. Calibration itself takes
+// about as long as target.
+func CalibrateKDF(target time.Duration, threads uint8) (KDFParams, error) {
+	if target <= 0 {
+		return KDFParams{}, errors.New("the KDF target time must be positive")
+	}
+	if threads == 0 {
+		threads = argon2Threads
+	}
+	available := availableMemory()
+	if available == 0 {
+		available = fallbackAvailableMemory
+	}
+	memoryCap := uint32(min(available/calibrationMemoryShare/1024, maxKDFMemory))
+	memoryCap = max(memoryCap, MinKDFMemory)
+
+	params := KDFParams{Memory: min(calibrationStartMemory, memoryCap), Time: 1, Threads: threads}
+	elapsed := measureKDF(params)
+	// Too slow even with the starting memory: give up memory, down to the minimum.
+	for elapsed > target && params.Memory/2 >= MinKDFMemory {
+		params.Memory /= 2
+		elapsed = measureKDF(params)
+	}
+	// Fast enough: use more memory while a single pass stays well below target.
+	for elapsed < target/2 && params.Memory*2 <= memoryCap {
+		params.Memory *= 2
+		elapsed = measureKDF(params)
+	}
+	if elapsed > 0 {
+		passes := int64((target + elapsed/2) / elapsed)
+		params.Time = uint32(min(max(passes, 1), maxKDFTime))
+	}
+	return params, params.check()
+}
+
+// measureKDF returns how long one key derivation with params takes.
+func measureKDF(params KDFParams) time.Duration {
+	salt := make([]byte, saltSize)
+	start := time.Now()
+	argon2.IDKey([]byte("btxz calibration"), salt, params.Time, params.Memory, params.Threads, xKeyLength)
+	return time.Since(start)
+}
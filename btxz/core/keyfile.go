@@ -0,0 +1,158 @@
+// File: core/keyfile.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements keyfiles. The SHA-256 digest of a keyfile is put in front
+// of the (optional) password to form the Argon2 input, so an archive created with
+// a keyfile, a password or both can only be opened with exactly the same secrets.
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// minKeyfileSize is the smallest accepted keyfile, enough for a 256-bit key.
+	minKeyfileSize = 32
+
+	// keyfileMarker starts a secret built by KeyfileSecret. A typed password
+	// cannot contain the NUL bytes it holds.
+	keyfileMarker = "\x00btxz-keyfile\x00"
+
+	// keyFlagPassword records in a key slot that its key involves a password.
+	keyFlagPassword = uint8(1) << 0
+	// keyFlagKeyfile records in a key slot that its key involves a keyfile.
+	keyFlagKeyfile = uint8(1) << 1
+	// keyFlagRecipient records in a key slot that its key is derived from an
+	// X25519 key exchange instead of a password or keyfile (see recipient.go).
+	keyFlagRecipient = uint8(1) << 2
+	// keyFlagShares records in a key slot that its key is split into key
+	// shares (see shares.go).
+	keyFlagShares = uint8(1) << 3
+	// keyFlagFIDO2 records in a key slot that its key also involves the
+	// hmac-secret response of a security key (see fido2.go).
+	keyFlagFIDO2 = uint8(1) << 4
+)
+
+// SecretKinds describes the secrets that open one key slot of an archive.
+type SecretKinds struct {
+	Password bool // A password is part of the key
+	Keyfile  bool // A keyfile is part of the key
+	Identity bool // The archive is encrypted to a recipient; its identity opens it
+	Shares   bool // The key is split into shares; enough of them open it
+	FIDO2    bool // The security key bound to the archive is part of the key
+}
+
+// KeyfileSecret combines the keyfile at keyfilePath with an optional password
+// into the secret of an archive. Pass the result to the archive functions in
+// place of the password. The keyfile must hold at least 32 bytes; its content
+// is read in full, so any file works, but random data is recommended.
+func KeyfileSecret(password, keyfilePath string) (string, error) {
+	file, err := os.Open(keyfilePath)
+	if err != nil {
+		return "", fmt.Errorf("could not open keyfile: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, file)
+	if err != nil {
+		return "", fmt.Errorf("could not read keyfile: %w", err)
+	}
+	if n < minKeyfileSize {
+		return "", fmt.Errorf("keyfile is too small: %d bytes, at least %d are required", n, minKeyfileSize)
+	}
+	return keyfileMarker + string(hash.Sum(nil)) + password, nil
+}
+
+// splitSecret separates a secret into its password and keyfile digest. The
+// digest is nil for a plain password.
+func splitSecret(secret string) (string, []byte) {
+	rest, ok := strings.CutPrefix(secret, keyfileMarker)
+	if !ok || len(rest) < sha256.Size {
+		return secret, nil
+	}
+	return rest[sha256.Size:], []byte(rest[:sha256.Size])
+}
+
+// kdfInput returns the Argon2 input for a secret: the password, preceded by the
+// keyfile digest if there is one, preceded by the hmac-secret response of a
+// security key if there is one. Both have a fixed length, so no password can
+// produce the input of a different combination.
+func kdfInput(secret string) []byte {
+	rest, response := splitFIDO2(secret)
+	password, digest := splitSecret(rest)
+	return append(append(response, digest...), password...)
+}
+
+// keyFlagsFor returns the header key flags describing a secret.
+func keyFlagsFor(secret string) uint8 {
+	if rest, response := splitFIDO2(secret); response != nil {
+		return keyFlagsFor(rest) | keyFlagFIDO2
+	}
+	if strings.HasPrefix(secret, recipientMarker) || strings.HasPrefix(secret, identityMarker) {
+		return keyFlagRecipient
+	}
+	if strings.HasPrefix(secret, shareMarker) {
+		return keyFlagShares
+	}
+	password, digest := splitSecret(secret)
+	var flags uint8
+	if password != "" {
+		flags |= keyFlagPassword
+	}
+	if digest != nil {
+		flags |= keyFlagKeyfile
+	}
+	return flags
+}
+
+// RequiredSecrets reports which secrets open an archive, one entry per key
+// slot; any one of them is enough. It only reads the header. Legacy archives
+// always take a password; unencrypted archives need none and return an empty
+// list.
+func RequiredSecrets(archivePath string) ([]SecretKinds, error) {
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	if version != coreVersionV4 {
+		return []SecretKinds{{Password: true}}, nil
+	}
+
+	archiveFile, err := openArchiveFile(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archiveFile.Close()
+	header, err := readHeaderV4(archiveFile)
+	if err != nil {
+		return nil, err
+	}
+	return header.secretKinds()
+}
+
+// secretKinds reports which secrets open the key slots of the header.
+func (header BtxzHeaderV4) secretKinds() ([]SecretKinds, error) {
+	if !header.encrypted() {
+		return []SecretKinds{}, nil
+	}
+	slots, err := header.usedKeySlots()
+	if err != nil {
+		return nil, err
+	}
+	kinds := make([]SecretKinds, len(slots))
+	for i, slot := range slots {
+		kinds[i] = SecretKinds{
+			Password: slot.Flags&keyFlagPassword != 0,
+			Keyfile:  slot.Flags&keyFlagKeyfile != 0,
+			Identity: slot.Flags&keyFlagRecipient != 0,
+			Shares:   slot.Flags&keyFlagShares != 0,
+			FIDO2:    slot.Flags&keyFlagFIDO2 != 0,
+		}
+	}
+	return kinds, nil
+}
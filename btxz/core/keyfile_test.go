@@ -0,0 +1,84 @@
+package core
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeKeyfile writes a keyfile of size random bytes and returns its path.
+func writeKeyfile(t *testing.T, size int) string {
+	t.Helper()
+	data := make([]byte, size)
+	rand.Read(data)
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// keyfileSecret returns the secret of password and the keyfile at path.
+func keyfileSecret(t *testing.T, password, path string) string {
+	t.Helper()
+	secret, err := KeyfileSecret(password, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func TestKeyfileSecrets(t *testing.T) {
+	key, other := writeKeyfile(t, 64), writeKeyfile(t, 64)
+	tests := []struct {
+		name    string
+		create  string
+		opens   []string
+		refuses []string
+	}{
+		{
+			name:    "keyfile only",
+			create:  keyfileSecret(t, "", key),
+			opens:   []string{keyfileSecret(t, "", key)},
+			refuses: []string{keyfileSecret(t, "", other), "", "password"},
+		},
+		{
+			name:    "keyfile and password",
+			create:  keyfileSecret(t, "password", key),
+			opens:   []string{keyfileSecret(t, "password", key)},
+			refuses: []string{keyfileSecret(t, "", key), "password", keyfileSecret(t, "password", other), keyfileSecret(t, "wrong", key)},
+		},
+		{
+			name:    "password only",
+			create:  "password",
+			opens:   []string{"password"},
+			refuses: []string{keyfileSecret(t, "password", key), keyfileSecret(t, "", key)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := encryptedTestArchive(t, test.create, CreateOptions{})
+			for _, secret := range test.opens {
+				if err := openWith(t, archive, secret, ExtractOptions{}); err != nil {
+					t.Errorf("the right secrets do not open the archive: %v", err)
+				}
+			}
+			for i, secret := range test.refuses {
+				if err := openWith(t, archive, secret, ExtractOptions{}); !errors.Is(err, ErrAuthentication) {
+					t.Errorf("wrong secret %d: err = %v, want ErrAuthentication", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyfileTooSmall(t *testing.T) {
+	if _, err := KeyfileSecret("", writeKeyfile(t, minKeyfileSize-1)); err == nil {
+		t.Error("a keyfile of 31 bytes was accepted")
+	}
+	if _, err := KeyfileSecret("", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("a missing keyfile was accepted")
+	}
+}
@@ -0,0 +1,158 @@
+// File: core/keyslot.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the key slot table of the v4 header. The payload is
+// encrypted with a random archive key, and every slot holds that key wrapped
+// under a key encryption key (KEK) derived from one secret: a password and/or
+// keyfile through Argon2, or a recipient through X25519. Any secret with a slot
+// opens the archive, and access can be granted or revoked by rewriting the
+// header alone.
+package core
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+
+	"btxz/internal/secmem"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// maxKeySlots is the number of key slots in the v4 header.
+	maxKeySlots = 8
+	// keySlotLabel is the additional data authenticated with every wrapped key.
+	keySlotLabel = "BTXZ v4 key slot"
+)
+
+// keySlotV4 is one entry of the key slot table in the v4 header.
+type keySlotV4 struct {
+	Flags      uint8                                        // keyFlag* bits of the secret that opens the slot (0 = unused)
+	Salt       [32]byte                                     // Argon2 salt, or the ephemeral X25519 public key of a recipient slot
+	Nonce      [xNonceSize]byte                             // Nonce of the wrapped key
+	WrappedKey [xKeyLength + chacha20poly1305.Overhead]byte // Archive key sealed with the KEK
+}
+
+// sealKeySlots generates an archive key from random and wraps it in one key
+// slot per secret, in order. The caller should wipe the key once the payload
+// cipher is set up.
+func sealKeySlots(header *BtxzHeaderV4, secrets []string, random io.Reader) ([]byte, error) {
+	if len(secrets) > maxKeySlots {
+		return nil, fmt.Errorf("too many passwords or keys: an archive has at most %d key slots", maxKeySlots)
+	}
+	key := make([]byte, xKeyLength)
+	if _, err := io.ReadFull(random, key); err != nil {
+		return nil, fmt.Errorf("failed to generate archive key: %w", err)
+	}
+	header.KeySlots = [maxKeySlots]keySlotV4{}
+	for i, secret := range secrets {
+		if err := header.KeySlots[i].seal(header, key, secret, random); err != nil {
+			secmem.Wipe(key)
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// seal fills an unused slot with key, wrapped for secret, drawing the salt and
+// the nonce from random.
+func (slot *keySlotV4) seal(header *BtxzHeaderV4, key []byte, secret string, random io.Reader) error {
+	slot.Flags = keyFlagsFor(secret)
+	if slot.Flags&keyFlagRecipient == 0 {
+		if _, err := io.ReadFull(random, slot.Salt[:]); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+	}
+	if _, err := io.ReadFull(random, slot.Nonce[:]); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	aead, err := slot.kek(header, secret)
+	if err != nil {
+		return err
+	}
+	aead.Seal(slot.WrappedKey[:0], slot.Nonce[:], key, []byte(keySlotLabel))
+	return nil
+}
+
+// kek derives the key encryption key of a slot from secret. For a new
+// recipient slot, it also stores the ephemeral public key in the slot.
+func (slot *keySlotV4) kek(header *BtxzHeaderV4, secret string) (cipher.AEAD, error) {
+	var kek []byte
+	var err error
+	switch {
+	case slot.Flags&keyFlagRecipient != 0:
+		if kek, err = recipientKey(secret, &slot.Salt); err != nil {
+			return nil, err
+		}
+	case slot.Flags&keyFlagShares != 0:
+		if kek, err = shareKey(secret, slot.Salt[:]); err != nil {
+			return nil, err
+		}
+	default:
+		input := kdfInput(secret)
+		kek = argon2.IDKey(input, slot.Salt[:], header.Argon2Time, header.Argon2Memory, header.Argon2Threads, xKeyLength)
+		secmem.Wipe(input)
+	}
+	// The AEAD keeps its own copy of the key.
+	aead, err := chacha20poly1305.NewX(kek)
+	secmem.Wipe(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create XChaCha20-Poly1305 AEAD: %w", err)
+	}
+	return aead, nil
+}
+
+// openKeySlots returns the archive key and the index of the first slot that
+// secret opens. Only slots of the same kind as the secret are tried, as each
+// attempt costs a full key derivation. A secret with the response of a
+// security key also tries the slots without one, so a recovery password works
+// while the key is plugged in. The caller should wipe the key once the payload
+// cipher is set up; newAEADV4 does so.
+func openKeySlots(header *BtxzHeaderV4, secret string) ([]byte, int, error) {
+	key, slot, err := openKeySlotsOfKind(header, secret)
+	if rest, response := splitFIDO2(secret); response != nil && errors.Is(err, errDecryptionFailed) {
+		return openKeySlotsOfKind(header, rest)
+	}
+	return key, slot, err
+}
+
+// openKeySlotsOfKind tries the slots of the same kind as secret.
+func openKeySlotsOfKind(header *BtxzHeaderV4, secret string) ([]byte, int, error) {
+	flags := keyFlagsFor(secret)
+	for i := range header.KeySlots {
+		slot := &header.KeySlots[i]
+		if slot.Flags == 0 || slot.Flags != flags {
+			continue
+		}
+		if flags&(keyFlagRecipient|keyFlagShares) == 0 {
+			// The header is untrusted until a slot opens.
+			if err := checkHeaderKDF(header.Argon2Memory, header.Argon2Time, header.Argon2Threads); err != nil {
+				return nil, -1, err
+			}
+		}
+		aead, err := slot.kek(header, secret)
+		if err != nil {
+			return nil, -1, err
+		}
+		if key, err := aead.Open(nil, slot.Nonce[:], slot.WrappedKey[:], []byte(keySlotLabel)); err == nil {
+			return key, i, nil
+		}
+	}
+	return nil, -1, errDecryptionFailed
+}
+
+// usedKeySlots returns the slots of a header that hold a key.
+func (header *BtxzHeaderV4) usedKeySlots() ([]keySlotV4, error) {
+	var used []keySlotV4
+	for _, slot := range header.KeySlots {
+		if slot.Flags != 0 {
+			used = append(used, slot)
+		}
+	}
+	if len(used) == 0 {
+		return nil, errors.New("invalid v4 archive header: no key slots")
+	}
+	return used, nil
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// legacyInput writes a folder with a small text file and a larger file of
+// incompressible data, so that most of an archive of it is payload.
+func legacyInput(t *testing.T) string {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "src")
+	mkdirs(t, src, "sub")
+	data := make([]byte, 64*1024)
+	rng := rand.New(rand.NewPCG(5, 6))
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "random.bin"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "hello.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+// damaged writes a copy of archive changed by damage and returns its path.
+func damaged(t *testing.T, archive string, damage func([]byte) []byte) string {
+	t.Helper()
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "damaged.btxz")
+	if err := os.WriteFile(path, damage(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLegacyIntegrity(t *testing.T) {
+	src := legacyInput(t)
+	for _, legacy := range []struct {
+		version int
+		create  func(archive string) error
+		test    func(archive, password string) error
+	}{
+		{1, func(archive string) error { return CreateArchiveV1(archive, []string{src}, "secret") }, TestArchiveV1},
+		{2, func(archive string) error { return CreateArchiveV2(archive, []string{src}, "secret", "low") }, TestArchiveV2},
+	} {
+		t.Run(fmt.Sprintf("v%d", legacy.version), func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "legacy.btxz")
+			if err := legacy.create(archive); err != nil {
+				t.Fatal(err)
+			}
+			if err := legacy.test(archive, "secret"); err != nil {
+				t.Fatalf("the intact archive fails: %v", err)
+			}
+			if err := TestArchive(archive, "secret"); err != nil {
+				t.Errorf("TestArchive: %v", err)
+			}
+			if err := legacy.test(archive, "wrong"); !errors.Is(err, ErrAuthentication) {
+				t.Errorf("wrong password: err = %v, want ErrAuthentication", err)
+			}
+			// Without a key check, damage to the payload fails its
+			// authentication like a wrong password.
+			flipped := damaged(t, archive, func(data []byte) []byte {
+				data[len(data)/2] ^= 0x01
+				return data
+			})
+			if err := legacy.test(flipped, "secret"); !errors.Is(err, ErrAuthentication) {
+				t.Errorf("flipped byte: err = %v, want ErrAuthentication", err)
+			}
+			truncated := damaged(t, archive, func(data []byte) []byte { return data[:len(data)*3/4] })
+			if err := legacy.test(truncated, "secret"); !errors.Is(err, ErrAuthentication) {
+				t.Errorf("truncated: err = %v, want ErrAuthentication", err)
+			}
+		})
+	}
+}
+
+func TestUnencryptedV1Integrity(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "plain.btxz")
+	if err := CreateArchiveV1(archive, []string{legacyInput(t)}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := TestArchiveV1(archive, ""); err != nil {
+		t.Fatalf("the intact archive fails: %v", err)
+	}
+	// Only the checks of the xz stream find the damage.
+	flipped := damaged(t, archive, func(data []byte) []byte {
+		data[len(data)/2] ^= 0x01
+		return data
+	})
+	if err := TestArchiveV1(flipped, ""); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("flipped byte: err = %v, want ErrIntegrity", err)
+	}
+}
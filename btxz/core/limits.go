@@ -0,0 +1,172 @@
+// File: core/limits.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements Limits, the safeguards against decompression bombs. A
+// small archive can decompress to terabytes or hold millions of entries, so
+// reading one, to extract, test or list it, stops with a *LimitError once its
+// decompressed stream, its number of entries or the length of an entry name
+// exceeds the limit. The limits are far above what real archives need; they
+// keep a crafted archive from filling the disk, the memory or the time of the
+// process.
+package core
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ReadLimits bounds what reading an archive may produce. A zero field takes
+// its default.
+type ReadLimits struct {
+	OutputSize int64 // Decompressed bytes of a payload stream (default 1 TiB)
+	Entries    int   // Entries of an archive (default 10 million)
+	NameLength int   // Bytes of an entry name (default 4096)
+}
+
+// Limits applies to every archive read. Programs that read archives known to
+// exceed the defaults raise it.
+var Limits ReadLimits
+
+const (
+	defaultMaxOutputSize = 1 << 40 // 1 TiB
+	defaultMaxEntries    = 10_000_000
+	defaultMaxNameLength = 4096
+)
+
+// The values of LimitError.Kind.
+const (
+	LimitOutputSize = "output size"
+	LimitEntries    = "entries"
+	LimitNameLength = "name length"
+)
+
+// LimitError is returned when an archive exceeds one of Limits. Kind tells
+// which, and Limit its value.
+type LimitError struct {
+	Kind  string
+	Limit int64
+}
+
+func (e *LimitError) Error() string {
+	switch e.Kind {
+	case LimitOutputSize:
+		return fmt.Sprintf("the archive decompresses to more than %d bytes, the limit of its output size", e.Limit)
+	case LimitEntries:
+		return fmt.Sprintf("the archive holds more than %d entries, the limit of its number of entries", e.Limit)
+	}
+	return fmt.Sprintf("the archive holds an entry name longer than %d bytes, the limit of the length of a name", e.Limit)
+}
+
+// isLimitError reports whether err stems from Limits, so that it is not
+// reported as damage.
+func isLimitError(err error) bool {
+	var limitErr *LimitError
+	return errors.As(err, &limitErr)
+}
+
+// readLimits returns Limits with the defaults of its zero fields filled in.
+func readLimits() ReadLimits {
+	limits := Limits
+	if limits.OutputSize == 0 {
+		limits.OutputSize = defaultMaxOutputSize
+	}
+	if limits.Entries == 0 {
+		limits.Entries = defaultMaxEntries
+	}
+	if limits.NameLength == 0 {
+		limits.NameLength = defaultMaxNameLength
+	}
+	return limits
+}
+
+// outputLimiter reads a decompressed stream and fails once it yields more
+// than limit bytes.
+type outputLimiter struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+// limitOutput returns r, a decompressed stream, bounded by Limits.OutputSize.
+func limitOutput(r io.Reader) io.Reader {
+	return &outputLimiter{r: r, limit: readLimits().OutputSize}
+}
+
+func (l *outputLimiter) Read(p []byte) (int, error) {
+	if l.n > l.limit {
+		return 0, &LimitError{Kind: LimitOutputSize, Limit: l.limit}
+	}
+	// Reading one byte past the limit tells a stream of exactly limit bytes
+	// from a longer one.
+	if rest := l.limit - l.n + 1; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.limit {
+		return n - int(l.n-l.limit), &LimitError{Kind: LimitOutputSize, Limit: l.limit}
+	}
+	return n, err
+}
+
+// entryCounter counts the entries of an archive against Limits. The zero
+// value is ready to use.
+type entryCounter struct {
+	n int
+}
+
+// add counts an entry of the given name.
+func (c *entryCounter) add(name string) error {
+	limits := readLimits()
+	c.n++
+	if c.n > limits.Entries {
+		return &LimitError{Kind: LimitEntries, Limit: int64(limits.Entries)}
+	}
+	if len(name) > limits.NameLength {
+		return &LimitError{Kind: LimitNameLength, Limit: int64(limits.NameLength)}
+	}
+	return nil
+}
+
+// next advances tarReader like its Next method, and counts the entry.
+func (c *entryCounter) next(tarReader *tar.Reader) (*tar.Header, error) {
+	hdr, err := tarReader.Next()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.add(hdr.Name); err != nil {
+		return nil, err
+	}
+	return hdr, nil
+}
+
+// checkIndexLimits checks the entries of an index against Limits.
+func checkIndexLimits(index *archiveIndex) error {
+	var entries entryCounter
+	for _, entry := range index.Entries {
+		if err := entries.add(entry.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkZipLimits checks the members of the zip stream of a v2 archive against
+// Limits, by the sizes they declare, which the zip reader holds them to.
+func checkZipLimits(files []*zip.File) error {
+	limits := readLimits()
+	var entries entryCounter
+	var size uint64
+	for _, file := range files {
+		if err := entries.add(file.Name); err != nil {
+			return err
+		}
+		if size += file.UncompressedSize64; size > uint64(limits.OutputSize) {
+			return &LimitError{Kind: LimitOutputSize, Limit: limits.OutputSize}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+// File: core/memory_other.go
+
+//go:build !linux
+
+package core
+
+// availableMemory reports that the available RAM is unknown on this platform,
+// so callers fall back to a conservative assumption.
+func availableMemory() uint64 {
+	return 0
+}
@@ -0,0 +1,460 @@
+// File: core/modify.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements in-place modification of v4 archives (adding, removing and
+// synchronizing entries).
+// Archives are never edited where they lie: the result is written with a fresh
+// base nonce to the partial file next to the original (see atomic.go), then
+// swapped in. Segments
+// that are unaffected by the change are copied without being recompressed.
+package core
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// AppendToArchiveV4 adds files and folders to an existing v4 archive. The key is
+// re-derived from the stored salt, and the original KDF parameters and profile are
+// kept. Entries that already exist are refused unless replace is set, in which case
+// the old copies are dropped.
+func AppendToArchiveV4(archivePath string, inputPaths []string, password string, replace bool) error {
+	if len(inputPaths) == 0 {
+		return errors.New("no input files or folders specified")
+	}
+
+	// Collect the names the new entries will be stored under.
+	added := make(map[string]bool)
+	err := walkInputs(inputPaths, func(filePath, basePath string) error {
+		added[normalizeEntryName(entryName(filePath, basePath))] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return modifyArchiveV4(archivePath, password, func(src *archiveV4, index *archiveIndex, dst *writerV4) error {
+		for _, e := range index.Entries {
+			// Directory entries are simply written again.
+			if added[normalizeEntryName(e.Name)] && !replace && e.Type != tar.TypeDir {
+				return fmt.Errorf("entry already exists in archive: %s (use --replace to overwrite)", e.Name)
+			}
+		}
+		if err := rewriteArchiveV4(src, index, dst, func(name string) bool {
+			return !added[normalizeEntryName(name)]
+		}); err != nil {
+			return err
+		}
+		return dst.addPaths(inputPaths)
+	})
+}
+
+// SyncStats summarizes the outcome of synchronizing an archive with the filesystem.
+type SyncStats struct {
+	Added     int // Files that were not in the archive
+	Updated   int // Files whose size or modification time changed
+	Unchanged int // Entries copied from the old archive as-is
+	Removed   int // Entries dropped because their file no longer exists
+}
+
+// SyncArchiveV4 brings a v4 archive up to date with the input paths. Files whose
+// size and modification time match their entry are kept as they are, changed and
+// new files are (re)compressed, and entries whose file is gone are dropped when
+// deleteMissing is set. If nothing changed, the archive is not rewritten.
+func SyncArchiveV4(archivePath string, inputPaths []string, password string, deleteMissing bool) (SyncStats, error) {
+	var stats SyncStats
+	if len(inputPaths) == 0 {
+		return stats, errors.New("no input files or folders specified")
+	}
+
+	onDisk := make(map[string]os.FileInfo)
+	err := walkInputs(inputPaths, func(filePath, basePath string) error {
+		info, err := os.Lstat(filePath)
+		if err != nil {
+			return err
+		}
+		onDisk[normalizeEntryName(entryName(filePath, basePath))] = info
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	err = modifyArchiveV4(archivePath, password, func(src *archiveV4, index *archiveIndex, dst *writerV4) error {
+		unchanged := make(map[string]bool)
+		drop := make(map[string]bool)
+		for _, e := range index.Entries {
+			name := normalizeEntryName(e.Name)
+			info, ok := onDisk[name]
+			switch {
+			case !ok && deleteMissing:
+				drop[e.Name] = true
+				stats.Removed++
+			case !ok:
+				stats.Unchanged++
+			case unchangedOnDisk(info, e):
+				unchanged[name] = true
+				stats.Unchanged++
+			default:
+				drop[e.Name] = true
+				stats.Updated++
+			}
+		}
+		stats.Added = len(onDisk) - len(unchanged) - stats.Updated
+		if stats.Added == 0 && stats.Updated == 0 && stats.Removed == 0 {
+			return errNothingChanged
+		}
+
+		if err := rewriteArchiveV4(src, index, dst, func(name string) bool {
+			return !drop[name]
+		}); err != nil {
+			return err
+		}
+		return walkInputs(inputPaths, func(filePath, basePath string) error {
+			if unchanged[normalizeEntryName(entryName(filePath, basePath))] {
+				return nil
+			}
+			return dst.addFile(filePath, basePath)
+		})
+	})
+	if err == errNothingChanged {
+		err = nil
+	}
+	return stats, err
+}
+
+// unchangedOnDisk reports whether a file still matches its archive entry.
+// Modification times are compared at whole seconds, the precision of entries
+// written without PAX headers, and symlinks are stored without a size.
+// Directories change whenever their contents do, so they only need to still be
+// directories.
+func unchangedOnDisk(info os.FileInfo, e indexEntry) bool {
+	if info.IsDir() || e.Type == tar.TypeDir {
+		return info.IsDir() && e.Type == tar.TypeDir
+	}
+	if !info.ModTime().Round(time.Second).Equal(time.Unix(0, e.ModTime).Round(time.Second)) {
+		return false
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return e.Type == tar.TypeSymlink
+	}
+	return e.Type != tar.TypeSymlink && info.Size() == e.Size
+}
+
+// RemoveFromArchiveV4 deletes the entries matching any of the patterns from a v4
+// archive and returns their names. Patterns are entry names or path.Match globs; a
+// pattern naming a directory also removes everything below it. A pattern that
+// matches nothing is an error unless ignoreMissing is set.
+func RemoveFromArchiveV4(archivePath, password string, patterns []string, ignoreMissing bool) ([]string, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("no entries specified")
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(normalizeEntryName(pattern), ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	var removed []string
+	err := modifyArchiveV4(archivePath, password, func(src *archiveV4, index *archiveIndex, dst *writerV4) error {
+		drop := make(map[string]bool)
+		var unmatched []string
+		for _, pattern := range patterns {
+			matched := false
+			for _, e := range index.Entries {
+				if matchEntry(pattern, e.Name) {
+					matched = true
+					if !drop[e.Name] {
+						drop[e.Name] = true
+						removed = append(removed, e.Name)
+					}
+				}
+			}
+			if !matched {
+				unmatched = append(unmatched, pattern)
+			}
+		}
+		if len(unmatched) > 0 && !ignoreMissing {
+			return fmt.Errorf("no entries match: %s (use --ignore-missing to skip)", strings.Join(unmatched, ", "))
+		}
+		if len(removed) == 0 {
+			return errNothingChanged
+		}
+		return rewriteArchiveV4(src, index, dst, func(name string) bool {
+			return !drop[name]
+		})
+	})
+	if err == errNothingChanged {
+		err = nil
+	}
+	return removed, err
+}
+
+// matchEntry reports whether an entry name matches a removal pattern, either as a
+// glob or because the pattern names one of its parent directories.
+func matchEntry(pattern, name string) bool {
+	pattern = normalizeEntryName(pattern)
+	name = normalizeEntryName(name)
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	return strings.HasPrefix(name, pattern+"/")
+}
+
+// errNothingChanged aborts a modification that would leave the archive as it is.
+var errNothingChanged = errors.New("archive unchanged")
+
+// modifyArchiveV4 opens a v4 archive, lets build write the new contents into a
+// partial archive that shares its key, salt and profile, and atomically replaces
+// the original with the result. The partial file is removed on any error.
+func modifyArchiveV4(archivePath, password string, build func(src *archiveV4, index *archiveIndex, dst *writerV4) error) error {
+	if splitArchiveBase(archivePath) != "" {
+		return errors.New("split (multi-volume) archives cannot be modified; recreate the archive instead")
+	}
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return err
+	}
+
+	src, err := openArchiveV4(archivePath, password)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	index, err := src.fullIndex()
+	if err != nil {
+		return err
+	}
+
+	file, err := createPartial(archivePath)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			file.abort()
+		}
+	}()
+
+	// The same key is reused, so a new base nonce is mandatory.
+	header := src.header
+	header.IndexOffset = 0
+	header.Flags &^= headerFlagIndexTrailer
+	if _, err := rand.Read(header.Nonce[:]); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	if src.checkKey != nil {
+		header.KeyCheck = keyCheckValue(src.checkKey, &header)
+	}
+
+	fileWriter := bufio.NewWriter(file)
+	dst, err := newWriterV4(fileWriter, header, src.aead, profileForHeader(header))
+	if err != nil {
+		return err
+	}
+	dst.index.archiveMeta = index.archiveMeta
+	if err := build(src, index, dst); err != nil {
+		return err
+	}
+	if err := finishArchiveV4(file, fileWriter, dst); err != nil {
+		return err
+	}
+	if err := file.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	// Windows cannot rename over an open file.
+	src.Close()
+	if err := file.commit(archivePath); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// rewriteArchiveV4 copies the entries of src for which keep returns true into dst.
+// Segments whose entries are all kept are copied verbatim. The last segment, which
+// holds the tar trailer, and segments with dropped entries are decoded and their
+// kept entries written again. When a dropped file has hard links or deduplicated
+// copies that are kept, the first of them (the heir) becomes a regular file with
+// its content, and the others are pointed at the heir. The content is spooled to
+// a temporary file from the dropped entry until the heir is reached.
+func rewriteArchiveV4(src *archiveV4, index *archiveIndex, dst *writerV4, keep func(name string) bool) error {
+	spools := make(map[string]*os.File) // Content of dropped link targets, until their heir is written
+	defer func() {
+		for _, spool := range spools {
+			removeSpool(spool)
+		}
+	}()
+	heirs := make(map[string]indexEntry)
+	for _, e := range index.Entries {
+		if e.Type == tar.TypeLink && keep(e.Name) && !keep(e.Link) {
+			if _, ok := heirs[e.Link]; !ok {
+				heirs[e.Link] = e
+			}
+		}
+	}
+
+	bySegment := make([][]indexEntry, len(index.Segments))
+	for _, e := range index.Entries {
+		if e.Segment < 0 || e.Segment >= len(index.Segments) {
+			return errors.New("invalid archive index: segment out of range")
+		}
+		bySegment[e.Segment] = append(bySegment[e.Segment], e)
+	}
+
+	for i, segment := range index.Segments {
+		clean := i < len(index.Segments)-1
+		for _, e := range bySegment[i] {
+			if _, orphan := heirs[e.Link]; !keep(e.Name) || (e.Type == tar.TypeLink && orphan) {
+				clean = false
+				break
+			}
+		}
+
+		if clean {
+			payloadReader, err := src.segmentPayload(index, i)
+			if err != nil {
+				return err
+			}
+			tarLen := index.Segments[i+1].TarOffset - segment.TarOffset
+			segment.Codec = src.segmentCodec(segment)
+			if err := dst.copySegment(payloadReader, segment, tarLen, bySegment[i]); err != nil {
+				return fmt.Errorf("failed to copy archive data: %w", err)
+			}
+			continue
+		}
+
+		decompressed, err := src.segmentReader(index, i)
+		if err != nil {
+			return err
+		}
+		tarReader := tar.NewReader(decompressed)
+		for {
+			hdr, err := tarReader.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("error reading tar stream: %w", err)
+			}
+			var content io.Reader = tarReader
+			spooled := "" // The dropped target whose content the entry takes
+			if !keep(hdr.Name) {
+				if _, ok := heirs[hdr.Name]; ok {
+					if spools[hdr.Name], err = spoolContent(tarReader); err != nil {
+						return fmt.Errorf("failed to copy entry %s: %w", hdr.Name, err)
+					}
+				}
+				continue
+			}
+			if hdr.Typeflag == tar.TypeLink {
+				if heir, ok := heirs[hdr.Linkname]; ok && hdr.Name != heir.Name {
+					hdr.Linkname = heir.Name
+				} else if ok {
+					spooled = hdr.Linkname
+					if content, err = materialize(hdr, spools[spooled]); err != nil {
+						return err
+					}
+				}
+			}
+			if err := dst.addEntry(hdr, content); err != nil {
+				return fmt.Errorf("failed to copy entry %s: %w", hdr.Name, err)
+			}
+			if spooled != "" {
+				removeSpool(spools[spooled])
+				delete(spools, spooled)
+			}
+		}
+	}
+	return nil
+}
+
+// spoolContent copies the content of an entry to a temporary file, rewound
+// for reading.
+func spoolContent(r io.Reader) (*os.File, error) {
+	spool, err := os.CreateTemp("", "btxz-spool-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(spool, r); err != nil {
+		removeSpool(spool)
+		return nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		removeSpool(spool)
+		return nil, err
+	}
+	return spool, nil
+}
+
+// removeSpool closes and removes a file written by spoolContent.
+func removeSpool(spool *os.File) {
+	spool.Close()
+	os.Remove(spool.Name())
+}
+
+// materialize turns the link header of an heir into that of a regular file
+// holding spool, the content of its dropped target, and returns the content.
+func materialize(hdr *tar.Header, spool *os.File) (io.Reader, error) {
+	if spool == nil {
+		return nil, fmt.Errorf("the content of %s, for %s, was not found in the archive", hdr.Linkname, hdr.Name)
+	}
+	info, err := spool.Stat()
+	if err != nil {
+		return nil, err
+	}
+	hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeReg, "", info.Size()
+	delete(hdr.PAXRecords, paxDedupKey)
+	return spool, nil
+}
+
+// fullIndex returns the archive index. For archives written without an index
+// footer, the tar stream is scanned and treated as a single segment.
+func (a *archiveV4) fullIndex() (*archiveIndex, error) {
+	index, err := a.index()
+	if err != nil || index != nil {
+		return index, err
+	}
+
+	index = &archiveIndex{
+		Segments: []indexSegment{{Offset: int64(binary.Size(a.header))}},
+	}
+	decompressed, err := a.tarStream()
+	if err != nil {
+		return nil, err
+	}
+	tarReader := tar.NewReader(decompressed)
+	for {
+		hdr, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry := indexEntry{
+			Name:    hdr.Name,
+			Size:    hdr.Size,
+			Mode:    hdr.Mode,
+			ModTime: hdr.ModTime.UnixNano(),
+			Link:    hdr.Linkname,
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			entry.Type = hdr.Typeflag
+		}
+		index.Entries = append(index.Entries, entry)
+	}
+	return index, nil
+}
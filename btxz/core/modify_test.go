@@ -0,0 +1,98 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoveLinkTarget(t *testing.T) {
+	const shared = "shared content"
+	for _, test := range []struct {
+		name  string
+		link  func(oldname, newname string) error // Makes the later files
+		dedup bool                                // They are stored as copies rather than hard links
+	}{
+		{"copies", func(oldname, newname string) error { return os.WriteFile(newname, []byte(shared), 0o644) }, true},
+		{"hard links", os.Link, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "src")
+			if err := os.Mkdir(src, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(src, "a.txt"), []byte(shared), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(src, "d.txt"), []byte("other"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"b.txt", "c.txt"} {
+				if err := test.link(filepath.Join(src, "a.txt"), filepath.Join(src, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// The heir keeps its own time, not that of the file it replaces.
+			mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+			if err := os.Chtimes(filepath.Join(src, "b.txt"), mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(t.TempDir(), "test.btxz")
+			if _, err := CreateArchiveWithOptions(archive, []string{src}, "secret", CreateOptions{Level: "low", KDF: fastKDF}); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			if b := entriesByName(t, archive)["b.txt"]; b.Dedup != test.dedup || b.Hardlink == test.dedup || b.Link != "a.txt" {
+				t.Fatalf("b.txt is not stored as a link to a.txt: %+v", b)
+			}
+
+			// The first link left takes the content; the others point at it.
+			if _, err := RemoveFromArchiveV4(archive, "secret", []string{"a.txt"}, false); err != nil {
+				t.Fatal(err)
+			}
+			entries := entriesByName(t, archive)
+			if _, ok := entries["a.txt"]; ok {
+				t.Error("a.txt is still listed")
+			}
+			if b := entries["b.txt"]; b.Dedup || b.Hardlink || b.Link != "" || b.Size != int64(len(shared)) {
+				t.Errorf("b.txt is not a regular file with the content: %+v", b)
+			}
+			if b := entries["b.txt"]; !b.ModTime.Equal(mtime) {
+				t.Errorf("b.txt was modified %v, want %v", b.ModTime, mtime)
+			}
+			if c := entries["c.txt"]; c.Dedup != test.dedup || c.Hardlink == test.dedup || c.Link != "b.txt" {
+				t.Errorf("c.txt does not point at b.txt: %+v", c)
+			}
+			if err := TestArchive(archive, "secret"); err != nil {
+				t.Fatalf("the archive fails the test: %v", err)
+			}
+
+			out := t.TempDir()
+			if _, err := ExtractArchive(archive, out, "secret"); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range map[string]string{"b.txt": shared, "c.txt": shared, "d.txt": "other"} {
+				if data, err := os.ReadFile(filepath.Join(out, name)); err != nil || string(data) != want {
+					t.Errorf("%s extracted as %q, %v; want %q", name, data, err, want)
+				}
+			}
+			if _, err := os.Lstat(filepath.Join(out, "a.txt")); err == nil {
+				t.Error("a.txt was extracted")
+			}
+		})
+	}
+}
+
+// entriesByName lists the archive, encrypted with "secret", by entry name.
+func entriesByName(t *testing.T, archive string) map[string]ArchiveEntry {
+	t.Helper()
+	list, err := ListArchiveContents(archive, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]ArchiveEntry)
+	for _, entry := range list {
+		entries[entry.Name] = entry
+	}
+	return entries
+}
@@ -0,0 +1,49 @@
+// File: core/names.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements optional Unicode normalization of entry names. macOS
+// stores file names decomposed (NFD) while most other systems use composed
+// names (NFC), so names that look identical may differ byte for byte.
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// parseNameForm maps a --normalize-names value to a Unicode normal form. It
+// returns ok == false for "none" (or an empty value), which keeps names as they are.
+func parseNameForm(name string) (form norm.Form, ok bool, err error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return 0, false, nil
+	case "nfc":
+		return norm.NFC, true, nil
+	case "nfd":
+		return norm.NFD, true, nil
+	default:
+		return 0, false, fmt.Errorf("unknown name normalization %q (use nfc, nfd or none)", name)
+	}
+}
+
+// nameNormalizer returns a function that converts names to the normal form
+// selected by a --normalize-names value, or nil if names are kept as they are.
+func nameNormalizer(name string) (func(string) string, error) {
+	form, ok, err := parseNameForm(name)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return form.String, nil
+}
+
+// IsNormalName reports whether an entry name is in the normal form selected by
+// a --normalize-names value ("nfc" or "nfd"). With "none", every name is.
+func IsNormalName(name, form string) (bool, error) {
+	f, ok, err := parseNameForm(form)
+	if err != nil || !ok {
+		return true, err
+	}
+	return f.IsNormalString(name), nil
+}
@@ -0,0 +1,84 @@
+// File: core/overwrite.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the overwrite policy of extraction, which decides what
+// happens when a file, link or symlink already exists where an entry is to be
+// written. A replaced file is removed before the entry is written rather than
+// written over, so no stale bytes remain past the end of a shorter entry and
+// other hard links to the old file keep their content. Directories are always
+// merged.
+package core
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// The overwrite policies of ExtractOptions.Overwrite.
+const (
+	OverwriteAlways = "always" // Replace existing files (the default)
+	OverwriteNever  = "never"  // Keep existing files and skip their entries
+	OverwriteNewer  = "newer"  // Replace files older than their entry
+	OverwritePrompt = "prompt" // Ask ExtractOptions.ConfirmOverwrite for each file
+)
+
+// overwritePolicy applies ExtractOptions.Overwrite.
+type overwritePolicy struct {
+	mode    string
+	confirm func(name string, existing os.FileInfo) bool
+}
+
+// newOverwritePolicy checks the overwrite options.
+func newOverwritePolicy(opts ExtractOptions) (*overwritePolicy, error) {
+	policy := &overwritePolicy{mode: opts.Overwrite, confirm: opts.ConfirmOverwrite}
+	if opts.DryRun {
+		// A dry run asks nothing; it reports the files the prompt would ask about.
+		policy.confirm = nil
+	}
+	switch opts.Overwrite {
+	case "":
+		policy.mode = OverwriteAlways
+	case OverwriteAlways, OverwriteNever, OverwriteNewer:
+	case OverwritePrompt:
+		if opts.ConfirmOverwrite == nil && !opts.DryRun {
+			return nil, fmt.Errorf("overwrite policy %q needs a ConfirmOverwrite function", OverwritePrompt)
+		}
+	default:
+		return nil, fmt.Errorf("invalid overwrite policy %q: use always, never, newer or prompt", opts.Overwrite)
+	}
+	return policy, nil
+}
+
+// allows decides on the entry name, last modified at modTime, to be written to
+// targetPath. It reports whether the entry is to be written, and whether it
+// replaces an existing file.
+func (p *overwritePolicy) allows(targetPath, name string, modTime time.Time) (write, replaces bool) {
+	info, err := os.Lstat(targetPath)
+	if err != nil || info.IsDir() {
+		// Nothing to replace; a directory in the way fails as it would without a policy.
+		return true, false
+	}
+	switch p.mode {
+	case OverwriteNever:
+		return false, false
+	case OverwriteNewer:
+		if !modTime.After(info.ModTime()) {
+			return false, false
+		}
+	case OverwritePrompt:
+		if p.confirm != nil && !p.confirm(name, info) {
+			return false, false
+		}
+	}
+	return true, true
+}
+
+// removeExisting removes the file, link or symlink at targetPath, if any, for
+// an entry to replace it.
+func removeExisting(targetPath string) error {
+	if info, err := os.Lstat(targetPath); err == nil && !info.IsDir() {
+		return os.Remove(targetPath)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// File: core/owner.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements restoring file ownership on extract. Tar headers record
+// the numeric uid and gid of every entry together with the user and group names,
+// which are written by tar.FileInfoHeader on platforms that have them.
+package core
+
+import (
+	"archive/tar"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// ownerResolver maps the owner recorded in a tar header to local ids. As in GNU
+// tar, a user or group name that exists on this system takes precedence over
+// the stored numeric id. Lookups are cached, since archives tend to repeat a
+// handful of owners.
+type ownerResolver struct {
+	users  map[string]int
+	groups map[string]int
+}
+
+func newOwnerResolver() *ownerResolver {
+	return &ownerResolver{users: make(map[string]int), groups: make(map[string]int)}
+}
+
+// uid returns the local user id for the owner of hdr.
+func (r *ownerResolver) uid(hdr *tar.Header) int {
+	if hdr.Uname == "" {
+		return hdr.Uid
+	}
+	id, ok := r.users[hdr.Uname]
+	if !ok {
+		id = hdr.Uid
+		if u, err := user.Lookup(hdr.Uname); err == nil {
+			if n, err := strconv.Atoi(u.Uid); err == nil {
+				id = n
+			}
+		}
+		r.users[hdr.Uname] = id
+	}
+	return id
+}
+
+// gid returns the local group id for the group of hdr.
+func (r *ownerResolver) gid(hdr *tar.Header) int {
+	if hdr.Gname == "" {
+		return hdr.Gid
+	}
+	id, ok := r.groups[hdr.Gname]
+	if !ok {
+		id = hdr.Gid
+		if g, err := user.LookupGroup(hdr.Gname); err == nil {
+			if n, err := strconv.Atoi(g.Gid); err == nil {
+				id = n
+			}
+		}
+		r.groups[hdr.Gname] = id
+	}
+	return id
+}
+
+// chown gives an extracted entry its archived owner. Symlinks are changed
+// themselves rather than the files they point to.
+func (r *ownerResolver) chown(targetPath string, hdr *tar.Header) error {
+	return os.Lchown(targetPath, r.uid(hdr), r.gid(hdr))
+}
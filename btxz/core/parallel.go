@@ -0,0 +1,181 @@
+// File: core/parallel.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements parallel compression in the manner of pixz and pigz.
+// The tar data of a segment is cut into blocks that are compressed on their
+// own, each by its own goroutine, and written in order. The blocks of a segment
+// form a concatenation of complete compressed streams, which every decoder
+// reads like a single segment, so readers need not know about them. The index
+// records where each block starts, so a reader may also decompress the blocks
+// of a segment in parallel.
+package core
+
+import (
+	"bytes"
+
+	"btxz/internal/secmem"
+)
+
+// indexBlock locates an independently compressed block within a segment.
+// Unlike segments, blocks may start inside an entry. Both offsets are relative
+// to the start of the segment, so segments copied verbatim keep their blocks.
+type indexBlock struct {
+	Offset    int64 `json:"offset"`     // Offset in the compressed data of the segment
+	TarOffset int64 `json:"tar_offset"` // Offset in the tar data of the segment
+}
+
+// blockJob is a block being compressed by its own goroutine.
+type blockJob struct {
+	first     bool  // The block starts a segment
+	codecID   uint8 // Codec of the segment
+	tarOffset int64 // Tar offset of the block start
+	done      chan blockResult
+}
+
+// blockResult is the outcome of compressing a block.
+type blockResult struct {
+	compressed []byte
+	err        error
+}
+
+// blockCompressor cuts the tar data of each segment into blocks and compresses
+// up to threads of them at once. Blocks are written to the chunk stream in the
+// order they were cut, so the output depends on the block size alone, not on
+// the number of threads or the scheduling.
+type blockCompressor struct {
+	threads    int
+	blockSize  int
+	block      []byte      // Uncompressed data of the block being filled
+	blockStart int64       // Tar offset of the block being filled
+	first      bool        // The block being filled starts a segment
+	pending    []*blockJob // Blocks being compressed, oldest first
+	compressed int64       // Compressed bytes of the open segment written so far
+}
+
+// blockSizeFor returns the size of the blocks of a segment. It is twice the
+// segment size, so only entries larger than a segment are split, and the ratio
+// loss from restarting the compressor stays as small as between segments.
+func blockSizeFor(segmentSize int64) int {
+	return int(2 * segmentSize)
+}
+
+// parallelThreads limits the requested number of threads so that the blocks
+// in flight fit into half of the available RAM. Each thread holds a block, its
+// compressed output and an encoder with its dictionary.
+func parallelThreads(threads int, blockSize int, dictCap int) int {
+	available := availableMemory()
+	if available == 0 {
+		return threads
+	}
+	compress, _ := DictMemory(int64(dictCap))
+	perThread := uint64(2*int64(blockSize) + compress)
+	if fit := int(available / 2 / perThread); fit < threads {
+		threads = fit
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	return threads
+}
+
+// newBlockCompressor returns a compressor running up to threads goroutines.
+func newBlockCompressor(threads int, blockSize int) *blockCompressor {
+	return &blockCompressor{threads: threads, blockSize: blockSize}
+}
+
+// write adds tar data to the open segment of sw, opening one if needed, and
+// submits every block that fills up.
+func (bc *blockCompressor) write(sw *segmentWriter, p []byte) (int, error) {
+	if !sw.open {
+		sw.open = true
+		sw.segmentStart = sw.tarOffset
+		sw.started++
+		bc.first = true
+		bc.blockStart = sw.tarOffset
+	}
+	written := 0
+	for len(p) > 0 {
+		if bc.block == nil {
+			bc.block = make([]byte, 0, bc.blockSize)
+		}
+		n := copy(bc.block[len(bc.block):bc.blockSize], p)
+		bc.block = bc.block[:len(bc.block)+n]
+		p = p[n:]
+		written += n
+		sw.tarOffset += int64(n)
+		if len(bc.block) == bc.blockSize {
+			if err := bc.submit(sw); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// submit starts compressing the block being filled, then writes out the
+// oldest blocks until no more than threads are in flight.
+func (bc *blockCompressor) submit(sw *segmentWriter) error {
+	job := &blockJob{first: bc.first, codecID: sw.codecID, tarOffset: bc.blockStart, done: make(chan blockResult, 1)}
+	block, newCodec := bc.block, sw.newCodec
+	go func() {
+		var buf bytes.Buffer
+		codec, err := newCodec(&buf)
+		if err == nil {
+			_, err = codec.Write(block)
+			if closeErr := codec.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		secmem.Wipe(block)
+		job.done <- blockResult{compressed: buf.Bytes(), err: err}
+	}()
+	bc.pending = append(bc.pending, job)
+	bc.block = nil
+	bc.first = false
+	bc.blockStart = sw.tarOffset
+
+	for len(bc.pending) > bc.threads {
+		if err := bc.writeOldest(sw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOldest waits for the oldest pending block and appends it to the chunk
+// stream, recording the segment or block it starts.
+func (bc *blockCompressor) writeOldest(sw *segmentWriter) error {
+	job := bc.pending[0]
+	bc.pending = bc.pending[1:]
+	result := <-job.done
+	if result.err != nil {
+		return result.err
+	}
+	if job.first {
+		sw.startSegment(job.codecID, job.tarOffset)
+		bc.compressed = 0
+	} else {
+		segment := &sw.segments[len(sw.segments)-1]
+		segment.Blocks = append(segment.Blocks, indexBlock{Offset: bc.compressed, TarOffset: job.tarOffset - segment.TarOffset})
+	}
+	_, err := sw.chunks.Write(result.compressed)
+	bc.compressed += int64(len(result.compressed))
+	secmem.Wipe(result.compressed)
+	return err
+}
+
+// finish submits the last block of the open segment and writes out every
+// pending block.
+func (bc *blockCompressor) finish(sw *segmentWriter) error {
+	if len(bc.block) > 0 || bc.first {
+		if err := bc.submit(sw); err != nil {
+			return err
+		}
+	}
+	for len(bc.pending) > 0 {
+		if err := bc.writeOldest(sw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
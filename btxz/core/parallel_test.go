@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// compressibleData returns size bytes of words drawn from a small vocabulary,
+// which compress about as well as text.
+func compressibleData(size int) []byte {
+	words := []string{"archive ", "block ", "segment ", "stream ", "thread ", "index ", "chunk ", "entry\n"}
+	rng := rand.New(rand.NewPCG(1, 2))
+	var buf bytes.Buffer
+	buf.Grow(size)
+	for buf.Len() < size {
+		buf.WriteString(words[rng.IntN(len(words))])
+	}
+	return buf.Bytes()[:size]
+}
+
+// largeInput writes a folder holding one file of size bytes and returns
+// its path.
+func largeInput(t testing.TB, size int) string {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.Mkdir(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "data"), compressibleData(size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+// archiveBlocks returns the number of blocks after the first of every
+// segment of an archive.
+func archiveBlocks(t *testing.T, archivePath string) int {
+	t.Helper()
+	archive, err := openArchiveV4(archivePath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archive.Close()
+	index, err := archive.index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := 0
+	for _, segment := range index.Segments {
+		blocks += len(segment.Blocks)
+	}
+	return blocks
+}
+
+func TestParallelCompression(t *testing.T) {
+	// Over two blocks of the smallest segment size.
+	src := largeInput(t, 5*int(minSegmentSize))
+	want, err := os.ReadFile(filepath.Join(src, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, threads := range []int{1, 4} {
+		t.Run(fmt.Sprintf("threads=%d", threads), func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "test.btxz")
+			stats, err := CreateArchiveWithOptions(archive, []string{src}, "", CreateOptions{Level: "low", Codec: "zstd", NoEncrypt: true, Threads: threads})
+			if err != nil {
+				t.Fatal(err)
+			}
+			blocks := archiveBlocks(t, archive)
+			if threads == 1 && blocks != 0 {
+				t.Errorf("a single thread wrote %d blocks, want a single stream", blocks)
+			}
+			if threads > 1 && stats.Threads > 1 && blocks == 0 {
+				t.Errorf("%d threads wrote a single stream", stats.Threads)
+			}
+			out := t.TempDir()
+			if _, err := ExtractArchiveWithOptions(archive, out, "", ExtractOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(filepath.Join(out, "data"))
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("the extracted data differs (%d of %d bytes, %v)", len(got), len(want), err)
+			}
+		})
+	}
+}
+
+func TestReproducibleIgnoresThreads(t *testing.T) {
+	src := largeInput(t, 3*int(minSegmentSize))
+	var sums [][sha256.Size]byte
+	for _, threads := range []int{1, 1, 4} {
+		archive := filepath.Join(t.TempDir(), "test.btxz")
+		opts := CreateOptions{Level: "low", Codec: "zstd", KDF: fastKDF, Threads: threads, Reproducible: &Reproducible{}}
+		if _, err := CreateArchiveWithOptions(archive, []string{src}, "password", opts); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sums = append(sums, sha256.Sum256(data))
+	}
+	for i, sum := range sums[1:] {
+		if sum != sums[0] {
+			t.Errorf("run %d differs from the first", i+2)
+		}
+	}
+}
+
+func BenchmarkCreateParallel(b *testing.B) {
+	const size = 4 * minSegmentSize
+	src := largeInput(b, size)
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%d", threads), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				archive := filepath.Join(b.TempDir(), "test.btxz")
+				if _, err := CreateArchiveWithOptions(archive, []string{src}, "", CreateOptions{Level: "low", NoEncrypt: true, Threads: threads}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
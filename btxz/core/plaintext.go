@@ -0,0 +1,69 @@
+// File: core/plaintext.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements unencrypted v4 archives, created with
+// CreateOptions.NoEncrypt. They keep the chunk framing, index and segments of
+// encrypted archives, so selective extraction, modification and repair work
+// unchanged, but no key is derived and the chunks are stored in the clear. Each
+// chunk is followed by a CRC-32C checksum in place of the authentication tag;
+// it detects accidental corruption only, as anyone can rewrite it.
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+const (
+	// headerFlagPlaintext marks a v4 archive whose payload is not encrypted.
+	headerFlagPlaintext = uint8(1) << 0
+	// headerFlagIndexTrailer marks a v4 archive written to a stream, whose index
+	// offset is stored in a trailer instead of the header (see writer.go).
+	headerFlagIndexTrailer = uint8(1) << 1
+	// knownHeaderFlags are the header flags this version understands.
+	knownHeaderFlags = headerFlagPlaintext | headerFlagIndexTrailer
+)
+
+// crc32c is the checksum table of plaintext chunks.
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// errChecksumMismatch is returned by plaintextCipher.Open for a damaged chunk.
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// plaintextCipher stands in for the AEAD of an unencrypted archive. Seal
+// appends the data unchanged, followed by a checksum over the nonce, the
+// additional data and the data, so moved or truncated chunks are still
+// detected like in an encrypted archive.
+type plaintextCipher struct{}
+
+func (plaintextCipher) NonceSize() int { return xNonceSize }
+
+func (plaintextCipher) Overhead() int { return crc32.Size }
+
+func (plaintextCipher) checksum(nonce, data, additionalData []byte) uint32 {
+	sum := crc32.Update(0, crc32c, nonce)
+	sum = crc32.Update(sum, crc32c, additionalData)
+	return crc32.Update(sum, crc32c, data)
+}
+
+func (c plaintextCipher) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	sum := c.checksum(nonce, plaintext, additionalData)
+	return binary.LittleEndian.AppendUint32(append(dst, plaintext...), sum)
+}
+
+func (c plaintextCipher) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < crc32.Size {
+		return nil, errChecksumMismatch
+	}
+	data := ciphertext[:len(ciphertext)-crc32.Size]
+	if binary.LittleEndian.Uint32(ciphertext[len(data):]) != c.checksum(nonce, data, additionalData) {
+		return nil, errChecksumMismatch
+	}
+	return append(dst, data...), nil
+}
+
+// encrypted reports whether the payload of an archive is encrypted.
+func (header *BtxzHeaderV4) encrypted() bool {
+	return header.Flags&headerFlagPlaintext == 0
+}
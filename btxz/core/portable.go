@@ -0,0 +1,92 @@
+// File: core/portable.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements ExtractOptions.PortableNames. Windows cannot create
+// files named after its devices (CON, PRN, AUX, NUL, COM1 to COM9 and LPT1 to
+// LPT9, with any extension), names that end in a dot or a space, or names
+// holding < > : " | ? * or a control character: the write fails, or creates a
+// file that cannot be opened again. Such path elements are escaped instead:
+// the offending characters, the first character of a device name, and every %
+// of the element are written as %XX, their hex code, so decoding %XX gives the
+// name back. Elements that need no escaping are kept as they are.
+package core
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// portableNames reports whether the names of an extraction are escaped: with
+// opts.PortableNames, and always on Windows.
+func (opts *ExtractOptions) portableNames() bool {
+	return opts.PortableNames || runtime.GOOS == "windows"
+}
+
+// PortableName returns an entry name, with forward slashes, with every path
+// element Windows cannot hold escaped. A name it returns unchanged extracts
+// cleanly on Windows.
+func PortableName(name string) string {
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		elems[i] = portableElement(elem)
+	}
+	return strings.Join(elems, "/")
+}
+
+// portableElement escapes a single path element if Windows cannot hold it.
+func portableElement(elem string) string {
+	if elem == "." || elem == ".." {
+		return elem
+	}
+	reserved := isDeviceName(elem)
+	trailing := len(elem) - len(strings.TrimRight(elem, ". "))
+	if !reserved && trailing == 0 && !strings.ContainsFunc(elem, isForbiddenRune) {
+		return elem
+	}
+	var b strings.Builder
+	for i := 0; i < len(elem); i++ {
+		c := elem[i]
+		if c == '%' || c < 0x20 || strings.IndexByte(`<>:"|?*`, c) >= 0 || reserved && i == 0 || i >= len(elem)-trailing {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// isForbiddenRune reports whether Windows refuses r anywhere in a name.
+func isForbiddenRune(r rune) bool {
+	return r < 0x20 || strings.ContainsRune(`<>:"|?*`, r)
+}
+
+// isDeviceName reports whether elem names a Windows device, whatever its case
+// and extension.
+func isDeviceName(elem string) bool {
+	stem, _, _ := strings.Cut(elem, ".")
+	stem = strings.ToUpper(stem)
+	switch stem {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	if strings.HasPrefix(stem, "COM") || strings.HasPrefix(stem, "LPT") {
+		switch stem[3:] {
+		case "0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "¹", "²", "³":
+			return true
+		}
+	}
+	return false
+}
+
+// notPortable returns the names of entries that do not extract unchanged on
+// Windows, in archive order.
+func notPortable(entries []indexEntry) []string {
+	var names []string
+	for _, entry := range entries {
+		if PortableName(entry.Name) != entry.Name {
+			names = append(names, entry.Name)
+		}
+	}
+	return names
+}
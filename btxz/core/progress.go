@@ -0,0 +1,104 @@
+// File: core/progress.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file reports the progress of archive creation and extraction. The
+// inputs are scanned for their total size up front, and v4 archives record the
+// size of their content in the index. The content of every file is counted as
+// it is read, so callers can show a progress bar with a throughput and an ETA.
+package core
+
+import (
+	"io"
+)
+
+// ProgressFunc receives the number of input bytes processed so far and the
+// total size of the inputs. It is called from the goroutine creating the
+// archive, often, so it should return quickly.
+type ProgressFunc func(done, total int64)
+
+// ExtractProgressFunc receives the name of the entry being extracted, the
+// content bytes extracted so far and the total size of the content. The total
+// is zero if the archive does not record it, as legacy archives do not.
+type ExtractProgressFunc func(name string, done, total int64)
+
+// progressCounter counts the content bytes read while an archive is written or
+// extracted. A nil counter counts nothing.
+type progressCounter struct {
+	report ExtractProgressFunc
+	name   string // Entry being extracted
+	done   int64
+	total  int64
+}
+
+// newProgressCounter scans the inputs for the total size of their regular
+// files that are not excluded. It returns nil if report is nil, so headless callers pay nothing.
+func newProgressCounter(inputPaths []string, walk walkOptions, report ProgressFunc) (*progressCounter, error) {
+	if report == nil {
+		return nil, nil
+	}
+	counter := &progressCounter{report: func(_ string, done, total int64) { report(done, total) }}
+	_, err := walkIncluded(inputPaths, walk, func(filePath, _ string) error {
+		info, err := walk.stat(filePath)
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			counter.total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report(0, counter.total)
+	return counter, nil
+}
+
+// newExtractCounter returns a counter reporting to report, or nil if report
+// is nil.
+func newExtractCounter(report ExtractProgressFunc, total int64) *progressCounter {
+	if report == nil {
+		return nil
+	}
+	report("", 0, total)
+	return &progressCounter{report: report, total: total}
+}
+
+// entry records the name of the entry being extracted.
+func (p *progressCounter) entry(name string) {
+	if p == nil {
+		return
+	}
+	p.name = name
+	p.report(p.name, p.done, p.total)
+}
+
+// add counts n bytes as processed, for files stored without reading them,
+// such as hard links and duplicates.
+func (p *progressCounter) add(n int64) {
+	if p == nil || n == 0 {
+		return
+	}
+	p.done += n
+	p.report(p.name, p.done, p.total)
+}
+
+// reader returns r counting the bytes read through it.
+func (p *progressCounter) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r: r, counter: p}
+}
+
+// progressReader counts the bytes read from r.
+type progressReader struct {
+	r       io.Reader
+	counter *progressCounter
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.counter.add(int64(n))
+	return n, err
+}
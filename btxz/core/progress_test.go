@@ -0,0 +1,64 @@
+package core
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// diskBytes returns the size of the content of the regular files below dir.
+func diskBytes(t *testing.T, dir string) int64 {
+	t.Helper()
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return total
+}
+
+func TestExtractProgressTotal(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	mkdirs(t, src, "docs/empty", "data")
+	for name, size := range map[string]int{"docs/readme": 1200, "data/big": 3 << 20, "data/small": 17, "empty": 0} {
+		if err := os.WriteFile(filepath.Join(src, filepath.FromSlash(name)), []byte(strings.Repeat(name, size)[:size]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive := createTestArchive(t, []string{src}, CreateOptions{})
+
+	for _, threads := range []int{1, 4} {
+		out := t.TempDir()
+		var calls int
+		var last, total int64
+		opts := ExtractOptions{Threads: threads, Progress: func(name string, done, all int64) {
+			if done < last {
+				t.Errorf("progress went back from %d to %d", last, done)
+			}
+			calls++
+			last, total = done, all
+		}}
+		if _, err := ExtractArchiveWithOptions(archive, out, "", opts); err != nil {
+			t.Fatal(err)
+		}
+		want := diskBytes(t, out)
+		if total != want || last != want {
+			t.Errorf("threads=%d: reported %d of %d bytes, extracted %d", threads, last, total, want)
+		}
+		if calls < 2 {
+			t.Errorf("threads=%d: progress reported %d times", threads, calls)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// File: core/recipient.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements public-key (recipient) encryption. For every recipient,
+// the writer generates an ephemeral X25519 key pair, stores its public half in a
+// key slot and derives the key encryption key of the slot with HKDF-SHA256 from
+// the shared secret with the recipient. Only the holder of the recipient's
+// identity (private key) can derive it again. The chunked XChaCha20-Poly1305
+// layer is the same as in password mode.
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"btxz/internal/secmem"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// recipientPrefix starts an encoded public key (recipient).
+	recipientPrefix = "btxz1"
+	// identityPrefix starts an encoded private key (identity).
+	identityPrefix = "BTXZ-SECRET-KEY-1"
+	// recipientKeyLabel is the HKDF info of the key of a recipient key slot.
+	recipientKeyLabel = "BTXZ v4 X25519"
+
+	// recipientMarker starts a secret built by RecipientSecret.
+	recipientMarker = "\x00btxz-recipient\x00"
+	// identityMarker starts a secret built by IdentitySecret.
+	identityMarker = "\x00btxz-identity\x00"
+)
+
+// keyEncoding encodes X25519 keys; recipients are shown in lower case.
+var keyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateIdentity creates a new X25519 key pair. It returns the encoded
+// identity, which must be kept secret, and the recipient to encrypt to.
+func GenerateIdentity() (identity, recipient string, err error) {
+	scalar := make([]byte, curve25519.ScalarSize)
+	defer secmem.Wipe(scalar)
+	if _, err := rand.Read(scalar); err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	public, err := curve25519.X25519(scalar, curve25519.Basepoint)
+	if err != nil {
+		return "", "", err
+	}
+	return identityPrefix + keyEncoding.EncodeToString(scalar), encodeRecipient(public), nil
+}
+
+// encodeRecipient returns the text form of an X25519 public key.
+func encodeRecipient(public []byte) string {
+	return recipientPrefix + strings.ToLower(keyEncoding.EncodeToString(public))
+}
+
+// decodeKey decodes a key with the given prefix into its 32 bytes.
+func decodeKey(text, prefix, kind string) ([]byte, error) {
+	rest, ok := strings.CutPrefix(strings.ToUpper(text), strings.ToUpper(prefix))
+	if !ok {
+		return nil, fmt.Errorf("invalid %s: it must start with %q", kind, prefix)
+	}
+	key, err := keyEncoding.DecodeString(rest)
+	if err != nil || len(key) != curve25519.PointSize {
+		return nil, fmt.Errorf("invalid %s: malformed key", kind)
+	}
+	return key, nil
+}
+
+// RecipientSecret returns the secret that encrypts a new archive to recipient.
+// Pass it to CreateArchiveWithOptions in place of the password.
+func RecipientSecret(recipient string) (string, error) {
+	public, err := decodeKey(strings.TrimSpace(recipient), recipientPrefix, "recipient")
+	if err != nil {
+		return "", err
+	}
+	return recipientMarker + string(public), nil
+}
+
+// IdentitySecret reads the identity file at identityPath and returns the secret
+// that opens archives encrypted to it. Blank lines and lines starting with '#'
+// are ignored; the first other line must hold the identity.
+func IdentitySecret(identityPath string) (string, error) {
+	scalar, err := readKeyFile(identityPath, identityPrefix, "identity")
+	if err != nil {
+		return "", err
+	}
+	defer secmem.Wipe(scalar)
+	return identityMarker + string(scalar), nil
+}
+
+// readKeyFile decodes the first key in a key file that is neither blank nor a
+// '#' comment.
+func readKeyFile(keyPath, prefix, kind string) ([]byte, error) {
+	line, err := keyFileLine(keyPath, kind)
+	if err != nil {
+		return nil, err
+	}
+	return decodeKey(line, prefix, kind)
+}
+
+// keyFileLine returns the first line of a key file that is neither blank nor
+// a '#' comment.
+func keyFileLine(keyPath, kind string) (string, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return "", fmt.Errorf("could not read %s file: %w", kind, err)
+	}
+	defer secmem.Wipe(data)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return line, nil
+	}
+	return "", fmt.Errorf("%s file holds no %s", kind, kind)
+}
+
+// recipientKey derives the key of a recipient key slot. With a recipient
+// secret it generates the ephemeral key pair and stores its public half in
+// ephemeral; with an identity secret it uses the stored one.
+func recipientKey(secret string, ephemeral *[curve25519.PointSize]byte) ([]byte, error) {
+	var shared, recipient []byte
+	if public, ok := strings.CutPrefix(secret, recipientMarker); ok {
+		if *ephemeral != [curve25519.PointSize]byte{} {
+			return nil, errors.New("a recipient can only encrypt; opening the archive requires its identity")
+		}
+		recipient = []byte(public)
+		scalar := make([]byte, curve25519.ScalarSize)
+		defer secmem.Wipe(scalar)
+		if _, err := rand.Read(scalar); err != nil {
+			return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+		}
+		ephemeralPublic, err := curve25519.X25519(scalar, curve25519.Basepoint)
+		if err != nil {
+			return nil, err
+		}
+		copy(ephemeral[:], ephemeralPublic)
+		if shared, err = curve25519.X25519(scalar, recipient); err != nil {
+			return nil, fmt.Errorf("invalid recipient: %w", err)
+		}
+	} else if scalar, ok := strings.CutPrefix(secret, identityMarker); ok {
+		var err error
+		if recipient, err = curve25519.X25519([]byte(scalar), curve25519.Basepoint); err != nil {
+			return nil, err
+		}
+		if shared, err = curve25519.X25519([]byte(scalar), ephemeral[:]); err != nil {
+			return nil, fmt.Errorf("invalid v4 archive header: %w", err)
+		}
+	} else {
+		return nil, errors.New("the archive is encrypted to a recipient; an identity is required to open it")
+	}
+
+	defer secmem.Wipe(shared)
+	salt := append(ephemeral[:], recipient...)
+	key := make([]byte, xKeyLength)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(recipientKeyLabel)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,124 @@
+// File: core/rekey.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements changing the password of an archive. Only the key slot
+// opened by the old password is replaced; the archive key, and with it the
+// encrypted payload, stays the same, so no data is decrypted or recompressed.
+package core
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"btxz/internal/secmem"
+)
+
+// RekeyArchive replaces the key slot that password opens with one for
+// newPassword. The archive is rewritten through its partial file (see
+// atomic.go), which is verified with the new password before it atomically
+// replaces the original.
+func RekeyArchive(archivePath, password, newPassword string) error {
+	if newPassword == "" {
+		return errors.New("a new password is required")
+	}
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return err
+	}
+	if version != coreVersionV4 {
+		return fmt.Errorf("v%d archives cannot be rekeyed; convert them to v4 first", version)
+	}
+	if splitArchiveBase(archivePath) != "" {
+		return errors.New("split (multi-volume) archives cannot be rekeyed; recreate the archive instead")
+	}
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	header, err := readHeaderV4(src)
+	if err != nil {
+		return err
+	}
+	if !header.encrypted() {
+		return errors.New("the archive is not encrypted, so it has no password to change; recreate it with a password instead")
+	}
+	key, slot, err := openKeySlots(&header, password)
+	if err != nil {
+		return err
+	}
+	defer secmem.Wipe(key)
+	// A slot bound to a security key stays bound to it.
+	if _, response := splitFIDO2(password); response != nil && header.KeySlots[slot].Flags&keyFlagFIDO2 != 0 {
+		if _, bound := splitFIDO2(newPassword); bound == nil {
+			newPassword = fido2Marker + string(response) + newPassword
+		}
+	}
+	header.KeySlots[slot] = keySlotV4{}
+	if err := header.KeySlots[slot].seal(&header, key, newPassword, rand.Reader); err != nil {
+		return err
+	}
+
+	file, err := createPartial(archivePath)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			file.abort()
+		}
+	}()
+
+	// The header keeps its size, so the payload and the index offset stay
+	// valid. A signature would no longer match the new header and is dropped.
+	length, _, err := signedLength(src)
+	if err != nil {
+		return err
+	}
+	headerSize := int64(binary.Size(header))
+	if _, err := src.Seek(headerSize, io.SeekStart); err != nil {
+		return err
+	}
+	fileWriter := bufio.NewWriter(file)
+	if err := binary.Write(fileWriter, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("failed to write v4 header: %w", err)
+	}
+	if _, err := io.CopyN(fileWriter, src, length-headerSize); err != nil {
+		return fmt.Errorf("failed to copy archive data: %w", err)
+	}
+	if err := fileWriter.Flush(); err != nil {
+		return err
+	}
+	if err := file.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	rekeyed, err := openArchiveV4(file.Name(), newPassword)
+	if err != nil {
+		return fmt.Errorf("rekeyed archive failed verification: %w", err)
+	}
+	_, err = rekeyed.index()
+	rekeyed.Close()
+	if err != nil {
+		return fmt.Errorf("rekeyed archive failed verification: %w", err)
+	}
+
+	// Windows cannot rename over an open file.
+	src.Close()
+	if err := file.commit(archivePath); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
@@ -0,0 +1,186 @@
+// File: core/repair.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the salvaging of damaged archives. Every chunk of a v4
+// archive is authenticated on its own and every segment can be decoded on its
+// own, so data in front of, and with an index also behind, a damaged area can
+// still be trusted. Legacy archives are sealed as a single unit and cannot be
+// salvaged at all.
+package core
+
+import (
+	"archive/tar"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// RepairReport describes the outcome of salvaging an archive.
+type RepairReport struct {
+	Recovered []string // Entries restored completely
+	Partial   []string // Entries cut short by the damage or failing their checksum; the readable part was written
+	Lost      []string // Entries known from the index that could not be read at all
+	Skipped   []string // Entries not written because their path is unsafe or their link target is lost
+	Problems  []string // Description of every damaged area that was found
+}
+
+// add records the outcome of one extraction pass. err is the error that ended
+// the pass, if any.
+func (r *RepairReport) add(stats ExtractStats, err error) {
+	corrupted := make(map[string]bool, len(stats.Corrupted))
+	for _, name := range stats.Corrupted {
+		corrupted[name] = true
+	}
+	for _, name := range stats.Extracted {
+		if !corrupted[name] {
+			r.Recovered = append(r.Recovered, name)
+		}
+	}
+	r.Partial = append(r.Partial, stats.Corrupted...)
+	var partial *entryError
+	if errors.As(err, &partial) {
+		r.Partial = append(r.Partial, partial.name)
+	}
+	r.Skipped = append(r.Skipped, stats.Skipped...)
+}
+
+// RepairArchive extracts everything that can still be read from a damaged
+// archive into outputDir. It returns an error only if nothing can be salvaged.
+func RepairArchive(archivePath, outputDir, password string, opts ExtractOptions) (RepairReport, error) {
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return RepairReport{}, err
+	}
+	if version == coreVersionV4 {
+		return repairArchiveV4(archivePath, outputDir, password, opts)
+	}
+	return repairLegacyArchive(archivePath, outputDir, password, version, opts)
+}
+
+// repairArchiveV4 salvages a v4 archive. With a readable index, segments are
+// decoded one by one, so damage only costs the entries of the affected segment.
+// Without one, the stream is read up to the first damaged chunk.
+func repairArchiveV4(archivePath, outputDir, password string, opts ExtractOptions) (RepairReport, error) {
+	var report RepairReport
+	archive, err := openArchiveV4(archivePath, password)
+	if err != nil {
+		return report, err
+	}
+	defer archive.Close()
+
+	index, err := archive.index()
+	if err != nil || index == nil || len(index.Segments) == 0 {
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("index footer: %s", damageDescription(err)))
+		}
+		return report, salvageStreamV4(archive, outputDir, opts, &report)
+	}
+
+	bySegment := make(map[int][]indexEntry)
+	for _, e := range index.Entries {
+		bySegment[e.Segment] = append(bySegment[e.Segment], e)
+	}
+	digests := index.digests()
+	for i := range index.Segments {
+		var stats ExtractStats
+		reader, err := archive.segmentReader(index, i)
+		if err == nil {
+			stats, err = extractTarStream(tar.NewReader(reader), outputDir, nil, digests, nil, opts)
+		}
+		report.add(stats, err)
+		if err == nil {
+			continue
+		}
+
+		report.Problems = append(report.Problems, fmt.Sprintf("segment %d of %d: %s", i+1, len(index.Segments), damageDescription(err)))
+		reached := make(map[string]bool)
+		for _, name := range append(stats.Extracted, stats.Skipped...) {
+			reached[name] = true
+		}
+		var partial *entryError
+		if errors.As(err, &partial) {
+			reached[partial.name] = true
+		}
+		for _, e := range bySegment[i] {
+			if !reached[e.Name] {
+				report.Lost = append(report.Lost, e.Name)
+			}
+		}
+	}
+	return report, nil
+}
+
+// salvageStreamV4 extracts the tar stream of an archive without an index up to
+// the first damaged chunk.
+func salvageStreamV4(archive *archiveV4, outputDir string, opts ExtractOptions, report *RepairReport) error {
+	if archive.header.Codec == codecAuto {
+		return errors.New("the archive uses per-file codecs (--codec auto), which cannot be decoded without its index")
+	}
+	payloadReader, err := archive.payload()
+	if err != nil {
+		return err
+	}
+	decompressed, err := newDecompressorV4(archive.header.Codec, payloadReader)
+	if err != nil {
+		return err
+	}
+
+	stats, err := extractTarStream(tar.NewReader(decompressed), outputDir, nil, nil, nil, opts)
+	report.add(stats, err)
+	if err != nil {
+		report.Problems = append(report.Problems,
+			fmt.Sprintf("payload: %s; without the index, the entries after this point are unknown", damageDescription(err)))
+	}
+	return nil
+}
+
+// damageDescription explains a read error in terms of the damage that caused it.
+func damageDescription(err error) string {
+	switch {
+	case errors.Is(err, errTruncated), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "the archive ends early (truncated)"
+	case errors.Is(err, errTampered), errors.Is(err, errDecryptionFailed):
+		return "data failed authentication (damaged or altered)"
+	default:
+		return err.Error()
+	}
+}
+
+// repairLegacyArchive handles v1 to v3 archives. Their payload is sealed with a
+// single authentication tag, so it is either intact and extracted in full, or
+// nothing of it can be trusted.
+func repairLegacyArchive(archivePath, outputDir, password string, version uint16, opts ExtractOptions) (RepairReport, error) {
+	var report RepairReport
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return report, err
+	}
+
+	var headerSize int
+	switch version {
+	case coreVersionV1:
+		headerSize = binary.Size(BtxzHeaderV1{})
+	case coreVersionV2:
+		headerSize = binary.Size(BtxzHeaderV2{})
+	case coreVersionV3:
+		headerSize = binary.Size(BtxzHeaderV3{})
+	default:
+		return report, fmt.Errorf("unsupported archive core version: v%d", version)
+	}
+	// Both AES-GCM (v1, v2) and XChaCha20-Poly1305 (v3) append a 16-byte tag.
+	if info.Size() < int64(headerSize+chacha20poly1305.Overhead) {
+		return report, fmt.Errorf("archive is truncated: %d bytes is less than the v%d header and authentication tag alone", info.Size(), version)
+	}
+
+	stats, err := ExtractArchiveWithOptions(archivePath, outputDir, password, opts)
+	if errors.Is(err, errDecryptionFailed) {
+		return report, fmt.Errorf("v%d archives cannot be repaired: one authentication tag covers the whole payload, "+
+			"so if any byte is missing or damaged nothing can be decrypted safely (a wrong password fails the same way)", version)
+	}
+	report.add(stats, err)
+	return report, err
+}
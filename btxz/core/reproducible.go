@@ -0,0 +1,383 @@
+// File: core/reproducible.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements CreateOptions.Reproducible: archives that depend on
+// nothing but their inputs, so that two runs over the same files give the same
+// bytes and a build can be attested by the digest of its archive. Entries are
+// stored without owners or access times and with their modification times
+// clamped to an epoch, and the payload is compressed in a single stream.
+//
+// What an archive normally draws at random, its key, the salts of its key
+// slots and its nonces, is read from a stream derived instead from a digest of
+// the inputs and the options, the password, stretched by Argon2 as for a key
+// slot, and an optional seed. As that digest covers everything that is
+// encrypted, two archives only share a key and a nonce when they encrypt the
+// same plaintext; the writer checks every entry against what was digested
+// before encrypting it, and fails if an input changed in between. The price
+// is that equal inputs give equal archives: anyone holding two reproducible
+// archives can tell whether they contain the same files under the same
+// password and seed.
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"btxz/internal/secmem"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// reproducibleLabel separates the stream of a reproducible archive from
+	// other uses of its inputs.
+	reproducibleLabel = "btxz reproducible v1"
+	// reproducibleSaltLabel prefixes the Argon2 salt the password is stretched
+	// with.
+	reproducibleSaltLabel = "btxz reproducible salt v1"
+)
+
+// Reproducible configures a reproducible archive.
+type Reproducible struct {
+	// Epoch is the point in time the inputs stand for, as SOURCE_DATE_EPOCH
+	// is for a build: later modification times are lowered to it, and it is
+	// recorded as the creation time. Zero keeps the times of the files and
+	// records no creation time.
+	Epoch time.Time
+	// Seed, if set, is mixed into everything derived from the inputs, so the
+	// same files under the same password only give the same archive with the
+	// same seed.
+	Seed []byte
+}
+
+// normalize removes from the header of a new entry what differs between two
+// copies of the same files: the owners, the access and change times, and a
+// modification time after the epoch. A nil *Reproducible keeps the header.
+func (r *Reproducible) normalize(header *tar.Header) {
+	if r == nil {
+		return
+	}
+	header.Uid, header.Gid = 0, 0
+	header.Uname, header.Gname = "", ""
+	header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	header.ModTime = r.clamp(header.ModTime)
+}
+
+// clamp returns t, or the epoch if t is later.
+func (r *Reproducible) clamp(t time.Time) time.Time {
+	if !r.Epoch.IsZero() && t.After(r.Epoch) {
+		return r.Epoch
+	}
+	return t
+}
+
+// digestPaths sets opts.digest to the digest of the inputs of a reproducible
+// archive of inputPaths, walking them as the archive will. It does nothing
+// unless opts.Reproducible is set.
+func (opts *CreateOptions) digestPaths(inputPaths []string) error {
+	if opts.Reproducible == nil {
+		return nil
+	}
+	for _, path := range inputPaths {
+		if path == StdinPath {
+			return errors.New("standard input cannot be archived reproducibly: its content is not known before the archive is written")
+		}
+	}
+	hash, walk, err := opts.newInputDigest()
+	if err != nil {
+		return err
+	}
+	_, err = walkIncluded(inputPaths, walk, func(filePath, basePath string) error {
+		if err := contextErr(opts.ctx); err != nil {
+			return err
+		}
+		info, err := walk.stat(filePath)
+		if err != nil {
+			return err
+		}
+		var target string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		}
+		return opts.digestEntry(hash, filePath, entryName(filePath, basePath), info, target, func() (io.ReadCloser, error) { return os.Open(filePath) })
+	})
+	if err != nil {
+		return err
+	}
+	opts.digest = hash.Sum(nil)
+	return nil
+}
+
+// digestFS is digestPaths for the files below root in fsys.
+func (opts *CreateOptions) digestFS(fsys fs.FS, root string) error {
+	if opts.Reproducible == nil {
+		return nil
+	}
+	hash, walk, err := opts.newInputDigest()
+	if err != nil {
+		return err
+	}
+	_, err = walkFS(fsys, root, walk, func(name, base string, d fs.DirEntry) error {
+		if err := contextErr(opts.ctx); err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var target string
+		if links, ok := fsys.(readLinkFS); ok && info.Mode()&fs.ModeSymlink != 0 {
+			if target, err = links.ReadLink(name); err != nil {
+				return err
+			}
+		}
+		return opts.digestEntry(hash, name, fsEntryName(name, base), info, target, func() (io.ReadCloser, error) { return fsys.Open(name) })
+	})
+	if err != nil {
+		return err
+	}
+	opts.digest = hash.Sum(nil)
+	return nil
+}
+
+// newInputDigest returns a hash holding the options that shape the plaintext
+// of the archive, and the walk options that pick its entries.
+func (opts *CreateOptions) newInputDigest() (hash.Hash, walkOptions, error) {
+	filter, err := newEntryFilter(opts.Include, opts.Exclude)
+	if err != nil {
+		return nil, walkOptions{}, err
+	}
+	settings, err := json.Marshal([]any{
+		Creator, opts.Level, opts.Codec, opts.StoreExtensions, opts.DictSize, opts.NoDedup,
+		opts.NormalizeNames, opts.Comment, opts.Cipher, opts.NoEncrypt, opts.KDF,
+		opts.Include, opts.Exclude, opts.Dereference, opts.KeepRoot, opts.Reproducible.Epoch.UnixNano(),
+	})
+	if err != nil {
+		return nil, walkOptions{}, err
+	}
+	opts.inputs = nil
+	hash := sha256.New()
+	hash.Write([]byte(reproducibleLabel))
+	hash.Write(settings)
+	return hash, walkOptions{filter: filter, dereference: opts.Dereference, keepRoot: opts.KeepRoot}, nil
+}
+
+// digestEntry adds an entry to the digest of the inputs: its name, type,
+// mode, size, clamped modification time and link target, and the content of
+// a regular file. What was digested is recorded in opts.inputs under path, the
+// path the walk met the entry as, for the writer to check.
+func (opts *CreateOptions) digestEntry(hash io.Writer, path, name string, info fs.FileInfo, target string, open func() (io.ReadCloser, error)) error {
+	input := reproducibleInput{path: path, line: opts.Reproducible.describe(name, info, target)}
+	io.WriteString(hash, input.line)
+	if info.Mode().IsRegular() {
+		file, err := open()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		sum := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(hash, sum), file); err != nil {
+			return err
+		}
+		sum.Sum(input.sum[:0])
+	}
+	opts.inputs = append(opts.inputs, input)
+	return nil
+}
+
+// describe returns the line the digest of the inputs holds for an entry.
+func (r *Reproducible) describe(name string, info fs.FileInfo, target string) string {
+	return fmt.Sprintf("%q %v %d %d %q\n", name, info.Mode(), info.Size(), r.clamp(info.ModTime()).UnixNano(), target)
+}
+
+// reproducibleInput is an entry of a reproducible archive as it was digested.
+// The keys and nonces of the archive are derived from the digest, so what
+// is encrypted must be exactly what was digested: were a file to change in
+// between, two different plaintexts would be sealed under the same nonces.
+// The writer therefore checks every entry against its reproducibleInput
+// before anything of it is encrypted.
+type reproducibleInput struct {
+	path string            // Path of the entry as the walk met it
+	line string            // What describe returned for it
+	sum  [sha256.Size]byte // SHA-256 of the content of a regular file
+}
+
+// inputChangedError reports that the input at path is not what was digested.
+func inputChangedError(path string) error {
+	return fmt.Errorf("%s changed while the archive was written: a reproducible archive needs inputs that do not change", path)
+}
+
+// checkInput checks that the entry met as path, under name, with info and
+// the link target, is the next one digested, and returns the input recorded
+// for it. It returns nil unless the archive is reproducible.
+func (w *writerV4) checkInput(path, name string, info fs.FileInfo, target string) (*reproducibleInput, error) {
+	if w.reproducible == nil {
+		return nil, nil
+	}
+	if w.nextInput < len(w.inputs) {
+		input := &w.inputs[w.nextInput]
+		if input.path == path && input.line == w.reproducible.describe(name, info, target) {
+			w.nextInput++
+			return input, nil
+		}
+	}
+	return nil, inputChangedError(path)
+}
+
+// checkInputsDone checks that every input digested has been written. An
+// input is missing if it was removed after it was digested.
+func (w *writerV4) checkInputsDone() error {
+	if w.reproducible == nil || w.nextInput == len(w.inputs) {
+		return nil
+	}
+	return inputChangedError(w.inputs[w.nextInput].path)
+}
+
+// content returns file, the content of input, as a reader that holds it back
+// until all of it has been read and checked against the digest. Small files
+// are held in memory and others spooled to a temporary file, which Close
+// removes.
+func (input *reproducibleInput) content(file io.Reader) *spooledInput {
+	return &spooledInput{file: input.verify(file)}
+}
+
+// verify returns r, which reads the content of input, failing at its end if
+// the content is not what was digested.
+func (input *reproducibleInput) verify(r io.Reader) io.Reader {
+	return &inputReader{r: r, hash: sha256.New(), input: input}
+}
+
+// reopen returns open, opening the content of input checked by verify, as
+// the deduplicator reads it again at any time.
+func (input *reproducibleInput) reopen(open func() (io.ReadCloser, error)) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		file, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{input.verify(file), file}, nil
+	}
+}
+
+// inputReader reads the content of input, checking it at the end.
+type inputReader struct {
+	r     io.Reader
+	hash  hash.Hash
+	input *reproducibleInput
+}
+
+func (v *inputReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF && !bytes.Equal(v.hash.Sum(nil), v.input.sum[:]) {
+		return n, inputChangedError(v.input.path)
+	}
+	return n, err
+}
+
+// spoolMemory is the size up to which the content of a reproducible entry is
+// held in memory rather than spooled to a temporary file.
+const spoolMemory = 4 << 20
+
+// spooledInput reads the content of a reproducible entry once it has all
+// been read and verified.
+type spooledInput struct {
+	file  io.Reader // Verifies the content as it is read
+	r     io.Reader // Reads the verified content (nil = not read yet)
+	spool *os.File  // Holds a large content (nil = held in memory)
+}
+
+func (s *spooledInput) Read(p []byte) (int, error) {
+	if s.r == nil {
+		if err := s.load(); err != nil {
+			return 0, err
+		}
+	}
+	return s.r.Read(p)
+}
+
+// load reads and verifies the whole content.
+func (s *spooledInput) load() error {
+	var held bytes.Buffer
+	_, err := io.CopyN(&held, s.file, spoolMemory+1)
+	if err == io.EOF {
+		s.r = &held
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.spool, err = os.CreateTemp("", "btxz-spool-*"); err != nil {
+		return err
+	}
+	if _, err := s.spool.Write(held.Bytes()); err != nil {
+		return err
+	}
+	if _, err := io.Copy(s.spool, s.file); err != nil {
+		return err
+	}
+	if _, err := s.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.r = s.spool
+	return nil
+}
+
+// Close removes the temporary file, if there is one.
+func (s *spooledInput) Close() error {
+	if s.spool == nil {
+		return nil
+	}
+	s.spool.Close()
+	return os.Remove(s.spool.Name())
+}
+
+// reproducibleRandom returns the stream a reproducible archive reads its key,
+// salts and nonces from, in place of crypto/rand. opts.digest must have been
+// set. Secrets that are not stretched by Argon2, those of recipients, split
+// keys and security keys, are refused: a stream derived from a public key
+// would let anyone who knows the files derive the archive key.
+func reproducibleRandom(password string, opts CreateOptions, kdf KDFParams) (io.Reader, error) {
+	if opts.digest == nil {
+		return nil, errors.New("only archives created from paths or a file system can be reproducible")
+	}
+	if opts.FIDO2 != nil {
+		return nil, errors.New("an archive bound to a security key cannot be reproducible")
+	}
+	if opts.Snapshot != nil || opts.Base != nil {
+		// What is stored depends on the snapshot or the base, which the digest
+		// does not cover.
+		return nil, errors.New("an incremental or differential archive cannot be reproducible")
+	}
+	for _, secret := range append([]string{password}, opts.Secrets...) {
+		if keyFlagsFor(secret)&(keyFlagRecipient|keyFlagShares) != 0 {
+			return nil, errors.New("an archive for a recipient or with a split key cannot be reproducible")
+		}
+	}
+	secret := opts.digest
+	if !opts.NoEncrypt {
+		// Stretched like the password of a key slot, so knowing the files
+		// does not make guessing the password faster than trying it on the
+		// archive.
+		input := kdfInput(password)
+		salt := sha256.Sum256(append([]byte(reproducibleSaltLabel), opts.digest...))
+		secret = argon2.IDKey(input, salt[:], kdf.Time, kdf.Memory, kdf.Threads, xKeyLength)
+		secmem.Wipe(input)
+	}
+	return hkdf.New(sha256.New, secret, opts.Reproducible.Seed, append([]byte(reproducibleLabel), opts.digest...)), nil
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reproducibleTree writes a folder of files and directories, all modified at
+// mtime, and returns its path.
+func reproducibleTree(t *testing.T, mtime time.Time) string {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "src")
+	mkdirs(t, src, "docs/old", "empty")
+	for name, content := range map[string]string{"readme.txt": "read me", "docs/a.txt": "alpha", "docs/old/b.txt": "bravo"} {
+		if err := os.WriteFile(filepath.Join(src, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"readme.txt", "docs/a.txt", "docs/old/b.txt", "docs/old", "docs", "empty"} {
+		if err := os.Chtimes(filepath.Join(src, filepath.FromSlash(name)), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return src
+}
+
+// reproducibleSum creates a reproducible archive of src and returns the
+// SHA-256 digest of its bytes.
+func reproducibleSum(t *testing.T, src, password string, repro Reproducible) [sha256.Size]byte {
+	t.Helper()
+	archive := filepath.Join(t.TempDir(), "test.btxz")
+	opts := CreateOptions{Level: "low", KDF: fastKDF, Reproducible: &repro}
+	if _, err := CreateArchiveWithOptions(archive, []string{src}, password, opts); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sha256.Sum256(data)
+}
+
+func TestReproducibleRunsMatch(t *testing.T) {
+	epoch := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	repro := Reproducible{Epoch: epoch, Seed: []byte("seed")}
+	src := reproducibleTree(t, epoch.Add(time.Hour))
+	want := reproducibleSum(t, src, "password", repro)
+
+	if got := reproducibleSum(t, src, "password", repro); got != want {
+		t.Errorf("a second run gave %x, want %x", got, want)
+	}
+	// Times after the epoch are clamped to it, so a copy touched later gives
+	// the same archive.
+	if got := reproducibleSum(t, reproducibleTree(t, epoch.Add(48*time.Hour)), "password", repro); got != want {
+		t.Errorf("a copy modified later gave %x, want %x", got, want)
+	}
+
+	for name, sum := range map[string][sha256.Size]byte{
+		"another seed":     reproducibleSum(t, src, "password", Reproducible{Epoch: epoch, Seed: []byte("other")}),
+		"no seed":          reproducibleSum(t, src, "password", Reproducible{Epoch: epoch}),
+		"another password": reproducibleSum(t, src, "other", repro),
+		"another epoch":    reproducibleSum(t, src, "password", Reproducible{Epoch: epoch.Add(-time.Minute), Seed: repro.Seed}),
+	} {
+		if sum == want {
+			t.Errorf("%s gave the same archive", name)
+		}
+	}
+}
+
+func TestReproducibleRecordsEpoch(t *testing.T) {
+	epoch := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := reproducibleTree(t, epoch.Add(time.Hour))
+	archive := filepath.Join(t.TempDir(), "test.btxz")
+	opts := CreateOptions{Level: "low", NoEncrypt: true, Reproducible: &Reproducible{Epoch: epoch}}
+	if _, err := CreateArchiveWithOptions(archive, []string{src}, "", opts); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	entries, info, err := ListArchive(archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Created.Equal(epoch) {
+		t.Errorf("created %v, want the epoch %v", info.Created, epoch)
+	}
+	for _, entry := range entries {
+		if !entry.ModTime.Equal(epoch) {
+			t.Errorf("%s modified %v, want the epoch %v", entry.Name, entry.ModTime, epoch)
+		}
+	}
+}
+
+func TestReproducibleInputChanged(t *testing.T) {
+	epoch := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, test := range []struct {
+		name    string
+		content string // What readme.txt holds once the writer starts
+	}{
+		{"same size", "READ ME"},
+		{"grown", "read me, and then some more"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			src := reproducibleTree(t, epoch.Add(time.Hour))
+			archive := filepath.Join(t.TempDir(), "test.btxz")
+			// The digest has been taken by the time the writer reports
+			// progress; readme.txt is the last entry the writer meets.
+			changed := false
+			progress := func(done, total int64) {
+				if !changed {
+					changed = true
+					if err := os.WriteFile(filepath.Join(src, "readme.txt"), []byte(test.content), 0o644); err != nil {
+						t.Error(err)
+					}
+				}
+			}
+			opts := CreateOptions{Level: "low", KDF: fastKDF, Reproducible: &Reproducible{Epoch: epoch}, Progress: progress}
+			_, err := CreateArchiveWithOptions(archive, []string{src}, "password", opts)
+			if err == nil || !strings.Contains(err.Error(), "readme.txt changed") {
+				t.Fatalf("err = %v, want readme.txt reported as changed", err)
+			}
+			if !changed {
+				t.Fatal("the file was not changed between the passes")
+			}
+			if _, err := os.Stat(archive); err == nil {
+				t.Error("an archive was left behind")
+			}
+		})
+	}
+}
+
+func TestReproducibleSpoolsLargeFiles(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	src := reproducibleTree(t, time.Now())
+	large := bytes.Repeat([]byte("a large file that is spooled to disk "), 2*spoolMemory/32)
+	if err := os.WriteFile(filepath.Join(src, "large.bin"), large, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(t.TempDir(), "test.btxz")
+	opts := CreateOptions{Level: "low", KDF: fastKDF, NoDedup: true, Reproducible: &Reproducible{}}
+	if _, err := CreateArchiveWithOptions(archive, []string{src}, "password", opts); err != nil {
+		t.Fatal(err)
+	}
+	out := t.TempDir()
+	if _, err := ExtractArchive(archive, out, "password"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(out, "large.bin")); err != nil || !bytes.Equal(data, large) {
+		t.Errorf("large.bin came back with %d bytes, %v; want %d", len(data), err, len(large))
+	}
+	if names := dirNames(t, tmp); len(names) != 0 {
+		t.Errorf("the spool left %v behind", names)
+	}
+}
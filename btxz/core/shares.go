@@ -0,0 +1,206 @@
+// File: core/shares.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements key shares. NewKeyShares generates a random secret for a
+// key slot and splits it with Shamir's Secret Sharing over GF(2^8) into shares
+// of which any threshold reconstruct it, while fewer reveal nothing about it.
+// Like every slot, the share slot wraps the archive key, so shares can be used
+// together with passwords, and revoked by rewriting the header alone.
+//
+// A share holds the index of the share, the threshold and a set id, the first
+// bytes of a hash of the shared secret. Shares of different sets are rejected
+// before they are combined, and the id confirms the combined secret.
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"btxz/internal/secmem"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// sharePrefix starts an encoded key share.
+	sharePrefix = "BTXZ-SHARE-1"
+	// shareMarker starts a secret built by NewKeyShares or ShareSecret.
+	shareMarker = "\x00btxz-shares\x00"
+	// shareSetLabel is hashed with the shared secret to form the set id.
+	shareSetLabel = "BTXZ key share set"
+	// shareKeyLabel is the HKDF info of the key of a share key slot.
+	shareKeyLabel = "BTXZ v4 key shares"
+
+	// shareSetIDSize is the size of the set id of a share.
+	shareSetIDSize = 8
+	// shareSize is the size of a decoded share: set id, threshold, index and
+	// one byte per byte of the secret.
+	shareSize = shareSetIDSize + 2 + xKeyLength
+	// maxShares is the largest number of shares; indices are non-zero bytes.
+	maxShares = 255
+)
+
+// NewKeyShares generates the secret of a new share key slot and splits it into
+// count shares, any threshold of which open the slot. Pass the secret to
+// CreateArchiveWithOptions like a password and hand out the encoded shares,
+// one per holder.
+func NewKeyShares(threshold, count int) (secret string, shares []string, err error) {
+	if threshold < 2 || threshold > count || count > maxShares {
+		return "", nil, fmt.Errorf("invalid key split %d/%d: the threshold must be at least 2 and at most the number of shares (up to %d)", threshold, count, maxShares)
+	}
+	key := make([]byte, xKeyLength)
+	defer secmem.Wipe(key)
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	setID := shareSetID(key)
+
+	// One random polynomial of degree threshold-1 per byte of the key, with
+	// the key byte as its constant term.
+	coefficients := make([]byte, xKeyLength*(threshold-1))
+	defer secmem.Wipe(coefficients)
+	if _, err := rand.Read(coefficients); err != nil {
+		return "", nil, fmt.Errorf("failed to generate key shares: %w", err)
+	}
+	share := make([]byte, shareSize)
+	defer secmem.Wipe(share)
+	for x := 1; x <= count; x++ {
+		copy(share, setID)
+		share[shareSetIDSize] = byte(threshold)
+		share[shareSetIDSize+1] = byte(x)
+		for i, constant := range key {
+			// Horner's rule, from the highest coefficient down.
+			var y byte
+			for j := threshold - 2; j >= 0; j-- {
+				y = gfMul(y, byte(x)) ^ coefficients[i*(threshold-1)+j]
+			}
+			share[shareSetIDSize+2+i] = gfMul(y, byte(x)) ^ constant
+		}
+		shares = append(shares, sharePrefix+keyEncoding.EncodeToString(share))
+	}
+	return shareMarker + string(key), shares, nil
+}
+
+// ShareSecret reads the share files at sharePaths and returns the secret that
+// opens the share key slot of their archive. At least as many shares as the
+// threshold are required, all of the same set and with different indices.
+func ShareSecret(sharePaths []string) (string, error) {
+	var shares [][]byte
+	defer func() {
+		for _, share := range shares {
+			secmem.Wipe(share)
+		}
+	}()
+	for _, sharePath := range sharePaths {
+		line, err := keyFileLine(sharePath, "key share")
+		if err != nil {
+			return "", err
+		}
+		share, err := decodeShare(line)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", sharePath, err)
+		}
+		for _, other := range shares {
+			if subtle.ConstantTimeCompare(share[:shareSetIDSize], other[:shareSetIDSize]) != 1 {
+				secmem.Wipe(share)
+				return "", newClassError(ErrAuthentication, "the key shares belong to different archives or splits")
+			}
+			if index := share[shareSetIDSize+1]; index == other[shareSetIDSize+1] {
+				secmem.Wipe(share)
+				return "", fmt.Errorf("key share %d was given twice", index)
+			}
+		}
+		shares = append(shares, share)
+	}
+	if len(shares) == 0 {
+		return "", errors.New("no key shares given")
+	}
+	threshold := int(shares[0][shareSetIDSize])
+	if len(shares) < threshold {
+		return "", fmt.Errorf("not enough key shares: %d given, %d required", len(shares), threshold)
+	}
+	shares = shares[:threshold]
+
+	// Lagrange interpolation at x = 0.
+	key := make([]byte, xKeyLength)
+	for i, share := range shares {
+		xi := share[shareSetIDSize+1]
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				xj := other[shareSetIDSize+1]
+				basis = gfMul(basis, gfMul(xj, gfInverse(xj^xi)))
+			}
+		}
+		for k := range key {
+			key[k] ^= gfMul(basis, share[shareSetIDSize+2+k])
+		}
+	}
+	defer secmem.Wipe(key)
+	if subtle.ConstantTimeCompare(shareSetID(key), shares[0][:shareSetIDSize]) != 1 {
+		return "", newClassError(ErrAuthentication, "the key shares do not fit together; one of them is damaged")
+	}
+	return shareMarker + string(key), nil
+}
+
+// decodeShare decodes one encoded key share.
+func decodeShare(text string) ([]byte, error) {
+	rest, ok := strings.CutPrefix(strings.ToUpper(text), sharePrefix)
+	if !ok {
+		return nil, fmt.Errorf("invalid key share: it must start with %q", sharePrefix)
+	}
+	share, err := keyEncoding.DecodeString(rest)
+	if err != nil || len(share) != shareSize || share[shareSetIDSize] < 2 || share[shareSetIDSize+1] == 0 {
+		return nil, errors.New("invalid key share: malformed share")
+	}
+	return share, nil
+}
+
+// shareSetID returns the set id of the shares of a secret.
+func shareSetID(key []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte(shareSetLabel))
+	hash.Write(key)
+	return hash.Sum(nil)[:shareSetIDSize]
+}
+
+// shareKey derives the key of a share key slot from its secret.
+func shareKey(secret string, salt []byte) ([]byte, error) {
+	shared, ok := strings.CutPrefix(secret, shareMarker)
+	if !ok {
+		return nil, errors.New("the key of this archive is split into shares; enough of them are required to open it")
+	}
+	key := make([]byte, xKeyLength)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(shared), salt, []byte(shareKeyLabel)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial, without branches or
+// table lookups that depend on the secret.
+func gfMul(a, b byte) byte {
+	var product byte
+	for range 8 {
+		product ^= a & -(b & 1)
+		a = a<<1 ^ 0x1b&-(a>>7)
+		b >>= 1
+	}
+	return product
+}
+
+// gfInverse returns the multiplicative inverse of a non-zero element, a^254.
+func gfInverse(a byte) byte {
+	result := byte(1)
+	for exponent := 254; exponent > 0; exponent >>= 1 {
+		if exponent&1 != 0 {
+			result = gfMul(result, a)
+		}
+		a = gfMul(a, a)
+	}
+	return result
+}
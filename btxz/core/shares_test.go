@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeShares writes each share to a file of its own and returns the paths.
+func writeShares(t *testing.T, shares []string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	paths := make([]string, len(shares))
+	for i, share := range shares {
+		paths[i] = filepath.Join(dir, fmt.Sprintf("%d.share", i+1))
+		if err := os.WriteFile(paths[i], []byte(share+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+func TestKeySharesThreshold(t *testing.T) {
+	secret, shares, err := NewKeyShares(3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := encryptedTestArchive(t, secret, CreateOptions{})
+	paths := writeShares(t, shares)
+
+	// Every choice of exactly three shares opens the archive.
+	for i := 0; i < len(paths); i++ {
+		for j := i + 1; j < len(paths); j++ {
+			for k := j + 1; k < len(paths); k++ {
+				combined, err := ShareSecret([]string{paths[i], paths[j], paths[k]})
+				if err != nil {
+					t.Fatalf("shares %d, %d and %d: %v", i+1, j+1, k+1, err)
+				}
+				if err := openWith(t, archive, combined, ExtractOptions{}); err != nil {
+					t.Errorf("shares %d, %d and %d do not open the archive: %v", i+1, j+1, k+1, err)
+				}
+			}
+		}
+	}
+
+	if _, err := ShareSecret(paths[:2]); err == nil {
+		t.Error("two of three required shares were accepted")
+	}
+	if _, err := ShareSecret([]string{paths[0], paths[1], paths[1]}); err == nil {
+		t.Error("a share given twice was counted twice")
+	}
+}
+
+func TestKeySharesOfDifferentArchives(t *testing.T) {
+	secret, shares, err := NewKeyShares(3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, others, err := NewKeyShares(3, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := encryptedTestArchive(t, secret, CreateOptions{})
+	paths, otherPaths := writeShares(t, shares), writeShares(t, others)
+
+	mixed := []string{paths[0], paths[1], otherPaths[2]}
+	if _, err := ShareSecret(mixed); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("mixed shares: err = %v, want ErrAuthentication", err)
+	}
+	// Shares of another split combine, but do not open this archive.
+	combined, err := ShareSecret(otherPaths[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := openWith(t, archive, combined, ExtractOptions{}); !errors.Is(err, ErrAuthentication) {
+		t.Errorf("shares of another split: err = %v, want ErrAuthentication", err)
+	}
+}
+
+func TestNewKeySharesRejectsInvalidSplits(t *testing.T) {
+	for _, split := range [][2]int{{1, 3}, {4, 3}, {2, 256}} {
+		if _, _, err := NewKeyShares(split[0], split[1]); err == nil {
+			t.Errorf("the split %d/%d was accepted", split[0], split[1])
+		}
+	}
+}
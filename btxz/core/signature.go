@@ -0,0 +1,188 @@
+// File: core/signature.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements detached Ed25519 signatures. Encryption shows that only
+// key holders can read an archive, but anyone who knows a shared password can
+// also create one; a signature proves who did. It covers the whole archive
+// file, header and ciphertext, and is appended as a fixed-size trailer that
+// readers of the archive ignore:
+//
+//	[64-byte signature][32-byte signer public key][8-byte magic "BTXZSIG1"]
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"btxz/internal/secmem"
+)
+
+const (
+	// signingKeyPrefix starts an encoded Ed25519 private key (seed).
+	signingKeyPrefix = "BTXZ-SIGN-SECRET-KEY-1"
+	// verifyKeyPrefix starts an encoded Ed25519 public key.
+	verifyKeyPrefix = "btxzsig1"
+	// signatureMagic ends a signed archive.
+	signatureMagic = "BTXZSIG1"
+	// signatureLabel is signed in front of the archive digest.
+	signatureLabel = "BTXZ archive signature\x00"
+	// signatureTrailerSize is the size of the trailer of a signed archive.
+	signatureTrailerSize = ed25519.SignatureSize + ed25519.PublicKeySize + len(signatureMagic)
+)
+
+// ErrNotSigned is returned by VerifySignature for an archive without a signature.
+var ErrNotSigned = errors.New("the archive is not signed")
+
+// errBadSignature is returned when a signature does not match the archive or key.
+var errBadSignature = newClassError(ErrIntegrity, "signature verification failed: the archive was modified or not signed by this key")
+
+// GenerateSigningKey creates a new Ed25519 key pair. It returns the encoded
+// signing key, which must be kept secret, and the public key to verify with.
+func GenerateSigningKey() (signingKey, verifyKey string, err error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	defer secmem.Wipe(private)
+	return signingKeyPrefix + keyEncoding.EncodeToString(private.Seed()), encodeVerifyKey(public), nil
+}
+
+// encodeVerifyKey returns the text form of an Ed25519 public key.
+func encodeVerifyKey(public []byte) string {
+	return verifyKeyPrefix + strings.ToLower(keyEncoding.EncodeToString(public))
+}
+
+// SignArchive appends a signature made with the signing key file at keyPath to
+// the archive. An existing signature is replaced. Only single-file v4 archives
+// can be signed.
+func SignArchive(archivePath, keyPath string) error {
+	if splitArchiveBase(archivePath) != "" {
+		return errors.New("split (multi-volume) archives cannot be signed")
+	}
+	version, err := peekVersion(archivePath)
+	if err != nil {
+		return err
+	}
+	if version != coreVersionV4 {
+		return fmt.Errorf("v%d archives cannot be signed; convert them to v4 first", version)
+	}
+	seed, err := readKeyFile(keyPath, signingKeyPrefix, "signing key")
+	if err != nil {
+		return err
+	}
+	private := ed25519.NewKeyFromSeed(seed)
+	secmem.Wipe(seed)
+	defer secmem.Wipe(private)
+
+	file, err := os.OpenFile(archivePath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	length, _, err := signedLength(file)
+	if err != nil {
+		return err
+	}
+	digest, err := archiveDigest(file, length)
+	if err != nil {
+		return err
+	}
+
+	trailer := make([]byte, 0, signatureTrailerSize)
+	trailer = append(trailer, ed25519.Sign(private, digest)...)
+	trailer = append(trailer, private.Public().(ed25519.PublicKey)...)
+	trailer = append(trailer, signatureMagic...)
+	if err := file.Truncate(length); err != nil {
+		return err
+	}
+	if _, err := file.WriteAt(trailer, length); err != nil {
+		return fmt.Errorf("could not write signature: %w", err)
+	}
+	return file.Close()
+}
+
+// VerifySignature checks the signature of an archive against the public key
+// in the file at keyPath, or given directly as "btxzsig1...". It returns
+// ErrNotSigned if the archive carries no signature. Only the file is read;
+// no password is needed.
+func VerifySignature(archivePath, keyPath string) error {
+	var public []byte
+	var err error
+	if key := strings.TrimSpace(keyPath); strings.HasPrefix(strings.ToLower(key), verifyKeyPrefix) {
+		public, err = decodeKey(key, verifyKeyPrefix, "verification key")
+	} else {
+		public, err = readKeyFile(keyPath, verifyKeyPrefix, "verification key")
+	}
+	if err != nil {
+		return err
+	}
+
+	file, err := openArchiveFile(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	length, trailer, err := signedLength(file)
+	if err != nil {
+		return err
+	}
+	if trailer == nil {
+		return ErrNotSigned
+	}
+	signature := trailer[:ed25519.SignatureSize]
+	signer := trailer[ed25519.SignatureSize : ed25519.SignatureSize+ed25519.PublicKeySize]
+	if subtle.ConstantTimeCompare(signer, public) != 1 {
+		return newClassError(ErrIntegrity, fmt.Sprintf("signature verification failed: the archive was signed by a different key (%s)", encodeVerifyKey(signer)))
+	}
+	digest, err := archiveDigest(file, length)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(public, digest, signature) {
+		return errBadSignature
+	}
+	return nil
+}
+
+// signedLength returns the length of the archive data before the signature
+// trailer, and the trailer itself, or nil if the archive is not signed.
+func signedLength(file io.ReadSeeker) (int64, []byte, error) {
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, nil, err
+	}
+	if size < int64(signatureTrailerSize) {
+		return size, nil, nil
+	}
+	trailer := make([]byte, signatureTrailerSize)
+	if _, err := file.Seek(size-int64(signatureTrailerSize), io.SeekStart); err != nil {
+		return 0, nil, err
+	}
+	if _, err := io.ReadFull(file, trailer); err != nil {
+		return 0, nil, err
+	}
+	if string(trailer[signatureTrailerSize-len(signatureMagic):]) != signatureMagic {
+		return size, nil, nil
+	}
+	return size - int64(signatureTrailerSize), trailer, nil
+}
+
+// archiveDigest returns the message that is signed for the first length bytes
+// of an archive: a fixed label followed by their SHA-512 digest.
+func archiveDigest(file io.ReadSeeker, length int64) ([]byte, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	hash := sha512.New()
+	if _, err := io.CopyN(hash, file, length); err != nil {
+		return nil, fmt.Errorf("could not read archive: %w", err)
+	}
+	return hash.Sum([]byte(signatureLabel)), nil
+}
@@ -0,0 +1,118 @@
+// File: core/space.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the free space check before extraction. The index of a
+// v4 archive records the size of every file, so before anything is written
+// the space the selected files need is compared with what is free on the file
+// system of the output directory, which need not be that of the working
+// directory. Files already at their paths are replaced or kept, so only what
+// an entry adds to them counts. Where the free space cannot be read, the check
+// is left out (see space_unix.go, space_windows.go and space_other.go).
+package core
+
+import (
+	"archive/tar"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SpaceError is returned when the file system of the output directory has
+// less free space than the files to extract need. ExtractOptions.NoSpaceCheck
+// skips the check.
+type SpaceError struct {
+	Dir  string // The output directory, or its nearest existing parent
+	Need int64  // Bytes the files need
+	Free uint64 // Bytes available
+}
+
+func (e *SpaceError) Error() string {
+	return fmt.Sprintf("not enough free space in %s: the files need %d bytes, but only %d are available", e.Dir, e.Need, e.Free)
+}
+
+// Short returns how many bytes are missing.
+func (e *SpaceError) Short() int64 {
+	return e.Need - int64(e.Free)
+}
+
+// checkFreeSpace returns a *SpaceError if the regular files and deduplicated
+// copies among entries need more space than is free for outputDir.
+func checkFreeSpace(entries []indexEntry, outputDir string, opts ExtractOptions) error {
+	if opts.NoSpaceCheck || opts.DryRun {
+		return nil
+	}
+	var contents []indexEntry
+	var total int64
+	for _, e := range entries {
+		if e.Type == 0 || e.Type == tar.TypeLink && e.Dedup {
+			contents = append(contents, e)
+			total += e.Size
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+	dir, ok := existingDir(outputDir)
+	if !ok {
+		return nil
+	}
+	free, ok := freeSpace(dir)
+	if !ok || uint64(total) <= free {
+		return nil
+	}
+	// Only now look at the files already there, which most extractions into a
+	// new directory would not find.
+	diskPath, err := opts.diskPaths()
+	if err != nil {
+		return err
+	}
+	var need int64
+	for _, e := range contents {
+		size := e.Size
+		if info, err := os.Lstat(filepath.Join(outputDir, diskPath(e.Name))); err == nil && info.Mode().IsRegular() {
+			size -= info.Size()
+		}
+		if size > 0 {
+			need += size
+		}
+	}
+	if uint64(need) <= free {
+		return nil
+	}
+	return &SpaceError{Dir: dir, Need: need, Free: free}
+}
+
+// diskPaths returns the function that turns an entry name into the relative
+// path it is extracted to, as extractTarStream does before collisions.
+func (opts *ExtractOptions) diskPaths() (func(string) string, error) {
+	names, err := nameNormalizer(opts.NormalizeNames)
+	if err != nil {
+		return nil, err
+	}
+	portable := opts.portableNames()
+	return func(name string) string {
+		name = entryPath(name)
+		if names != nil {
+			name = names(name)
+		}
+		if portable {
+			name = PortableName(name)
+		}
+		return filepath.FromSlash(name)
+	}, nil
+}
+
+// existingDir returns dir, or its nearest parent that exists, as a directory
+// to be created lives on the file system of that parent.
+func existingDir(dir string) (string, bool) {
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,11 @@
+// File: core/space_other.go
+
+//go:build !linux && !darwin && !freebsd && !windows
+
+package core
+
+// freeSpace reports that the free space is unknown on this platform, so the
+// check before extraction is left out.
+func freeSpace(dir string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,146 @@
+// File: core/spool.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements archiving a stream, such as standard input, whose size
+// is unknown until it ends. A tar header records the size before the content,
+// so the stream is read to the end first. Small streams are held in memory;
+// larger ones are spooled to a temporary file, sealed in chunks with a key that
+// exists only in memory, so the data never reaches the disk unencrypted.
+package core
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"btxz/internal/secmem"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// StdinPath is the input path that stands for CreateOptions.Stdin.
+	StdinPath = "-"
+	// defaultStdinName is the entry name of standard input if none is given.
+	defaultStdinName = "stdin"
+	// maxMemorySpool is the amount of a stream held in memory before it is
+	// spooled to an encrypted temporary file.
+	maxMemorySpool = 32 * 1024 * 1024 // 32 MiB
+	// spoolChunkSize is the amount of data sealed in each chunk of the spool.
+	spoolChunkSize = 1024 * 1024 // 1 MiB
+)
+
+// spool holds a stream that was read to its end, to be read again once.
+type spool struct {
+	mem   []byte
+	file  *os.File // Encrypted temporary file (nil = in memory)
+	aead  cipher.AEAD
+	nonce [xNonceSize]byte
+	size  int64
+}
+
+// newSpool reads r to its end. The caller must call Close.
+func newSpool(r io.Reader) (*spool, error) {
+	s := &spool{}
+	head, err := io.ReadAll(io.LimitReader(r, maxMemorySpool+1))
+	if err != nil {
+		return nil, err
+	}
+	s.size = int64(len(head))
+	if len(head) <= maxMemorySpool {
+		s.mem = head
+		return s, nil
+	}
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(s.nonce[:]); err != nil {
+		return nil, err
+	}
+	defer secmem.Wipe(key)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	s.aead = aead
+	if s.file, err = os.CreateTemp("", "btxz-spool-*"); err != nil {
+		return nil, fmt.Errorf("could not create spool file: %w", err)
+	}
+	buf := bufio.NewWriter(s.file)
+	chunks := newChunkWriter(buf, aead, s.nonce, spoolChunkSize)
+	if _, err := chunks.Write(head); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("could not write spool file: %w", err)
+	}
+	n, err := io.Copy(chunks, r)
+	s.size += n
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := chunks.Close(); err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("could not write spool file: %w", err)
+	}
+	return s, nil
+}
+
+// reader returns the content of the spool from its start.
+func (s *spool) reader() (io.Reader, error) {
+	if s.file == nil {
+		return bytes.NewReader(s.mem), nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return newChunkReader(bufio.NewReader(s.file), s.aead, s.nonce, spoolChunkSize, 0), nil
+}
+
+// Close removes the spool file, if any.
+func (s *spool) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+// addStream writes the content of r as a regular file named name, once r has
+// ended, and returns its size.
+func (w *writerV4) addStream(r io.Reader, name string) (int64, error) {
+	if r == nil {
+		return 0, errors.New("no reader given for standard input")
+	}
+	if name == "" {
+		name = defaultStdinName
+	}
+	s, err := newSpool(contextReader(w.ctx, r))
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s: %w", name, err)
+	}
+	defer s.Close()
+	content, err := s.reader()
+	if err != nil {
+		return 0, err
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     w.normalize(name),
+		Mode:     0o600,
+		Size:     s.size,
+		ModTime:  time.Now(),
+	}
+	return s.size, w.addEntry(header, content)
+}
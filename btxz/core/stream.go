@@ -0,0 +1,351 @@
+// File: core/stream.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the chunked encryption stream and the segmented
+// compression layer used by v4 archives.
+package core
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"btxz/internal/secmem"
+)
+
+// errDecryptionFailed is returned when a secret does not open the archive, or
+// when the single authentication tag of a legacy payload does not match.
+var errDecryptionFailed = newClassError(ErrAuthentication, "decryption failed: incorrect password or tampered archive")
+
+// errTampered is returned when a chunk or the index fails authentication. The
+// key was accepted, so the archive is damaged rather than the password wrong.
+var errTampered = newClassError(ErrIntegrity, "decryption failed: data failed authentication; the archive is damaged or was tampered with")
+
+// errTruncated is returned when the encrypted stream ends before its final chunk.
+var errTruncated = newClassError(ErrIntegrity, "decryption failed: archive is truncated (final chunk missing)")
+
+// isDecryptionError reports whether err originates from chunk authentication.
+func isDecryptionError(err error) bool {
+	return errors.Is(err, errDecryptionFailed) || errors.Is(err, errTampered) || errors.Is(err, errTruncated)
+}
+
+// chunkNonce derives the nonce of the chunk at position counter from the base nonce.
+func chunkNonce(base [xNonceSize]byte, counter uint64) []byte {
+	nonce := base
+	var ctr [8]byte
+	binary.BigEndian.PutUint64(ctr[:], counter)
+	for i := 0; i < 8; i++ {
+		nonce[xNonceSize-8+i] ^= ctr[i]
+	}
+	return nonce[:]
+}
+
+// chunkWriter buffers plaintext and writes it as a sequence of sealed chunks.
+// Each chunk is stored as a 4-byte prefix (plaintext length | final flag) followed
+// by the ciphertext; the prefix is authenticated as additional data.
+type chunkWriter struct {
+	w         io.Writer
+	aead      cipher.AEAD
+	nonce     [xNonceSize]byte
+	counter   uint64
+	buf       []byte
+	chunkSize int
+	sealed    []byte
+	closed    bool
+}
+
+func newChunkWriter(w io.Writer, aead cipher.AEAD, nonce [xNonceSize]byte, chunkSize int) *chunkWriter {
+	return &chunkWriter{
+		w:         w,
+		aead:      aead,
+		nonce:     nonce,
+		buf:       make([]byte, 0, chunkSize),
+		chunkSize: chunkSize,
+	}
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	if cw.closed {
+		return 0, errors.New("write to closed chunk writer")
+	}
+	written := 0
+	for len(p) > 0 {
+		n := copy(cw.buf[len(cw.buf):cw.chunkSize], p)
+		cw.buf = cw.buf[:len(cw.buf)+n]
+		p = p[n:]
+		written += n
+		// Only seal a full chunk once more data arrives, so the last chunk
+		// can always be marked as final on Close.
+		if len(cw.buf) == cw.chunkSize && len(p) > 0 {
+			if err := cw.seal(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush seals any buffered data as a (short) non-final chunk, so the next
+// write starts a new chunk. It is used to align segments with chunk boundaries.
+func (cw *chunkWriter) Flush() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	return cw.seal(false)
+}
+
+// Close seals the remaining buffered data as the final chunk and wipes the
+// plaintext buffer.
+func (cw *chunkWriter) Close() error {
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	err := cw.seal(true)
+	secmem.Wipe(cw.buf[:cap(cw.buf)])
+	return err
+}
+
+func (cw *chunkWriter) seal(final bool) error {
+	var prefix [chunkPrefixSize]byte
+	value := uint32(len(cw.buf))
+	if final {
+		value |= chunkFinalFlag
+	}
+	binary.LittleEndian.PutUint32(prefix[:], value)
+
+	cw.sealed = cw.aead.Seal(cw.sealed[:0], chunkNonce(cw.nonce, cw.counter), cw.buf, prefix[:])
+	cw.counter++
+	cw.buf = cw.buf[:0]
+
+	if _, err := cw.w.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err := cw.w.Write(cw.sealed)
+	return err
+}
+
+// chunkReader authenticates and decrypts a chunk sequence written by chunkWriter.
+type chunkReader struct {
+	r         io.Reader
+	aead      cipher.AEAD
+	nonce     [xNonceSize]byte
+	counter   uint64
+	chunkSize int
+	sealed    []byte
+	plain     []byte
+	pos       int
+	limit     uint64 // Stop before this chunk counter (0 = read to the final chunk)
+	done      bool
+	err       error
+}
+
+// newChunkReader returns a reader starting at the chunk numbered counter;
+// r must be positioned at the start of that chunk.
+func newChunkReader(r io.Reader, aead cipher.AEAD, nonce [xNonceSize]byte, chunkSize int, counter uint64) *chunkReader {
+	return &chunkReader{
+		r:         r,
+		aead:      aead,
+		nonce:     nonce,
+		chunkSize: chunkSize,
+		counter:   counter,
+	}
+}
+
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	for cr.pos == len(cr.plain) {
+		if cr.err != nil {
+			return 0, cr.err
+		}
+		if cr.done || (cr.limit != 0 && cr.counter >= cr.limit) {
+			// Nothing more is decrypted into the buffer.
+			secmem.Wipe(cr.plain[:cap(cr.plain)])
+			return 0, io.EOF
+		}
+		cr.err = cr.next()
+	}
+	n := copy(p, cr.plain[cr.pos:])
+	cr.pos += n
+	return n, nil
+}
+
+// next reads, authenticates and decrypts the following chunk.
+func (cr *chunkReader) next() error {
+	var prefix [chunkPrefixSize]byte
+	if _, err := io.ReadFull(cr.r, prefix[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errTruncated
+		}
+		return err
+	}
+	value := binary.LittleEndian.Uint32(prefix[:])
+	length := int(value & chunkLengthMask)
+	if length > cr.chunkSize {
+		return errTampered
+	}
+
+	sealedLen := length + cr.aead.Overhead()
+	if cap(cr.sealed) < sealedLen {
+		cr.sealed = make([]byte, sealedLen)
+	}
+	cr.sealed = cr.sealed[:sealedLen]
+	if _, err := io.ReadFull(cr.r, cr.sealed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errTruncated
+		}
+		return err
+	}
+
+	plain, err := cr.aead.Open(cr.plain[:0], chunkNonce(cr.nonce, cr.counter), cr.sealed, prefix[:])
+	if err != nil {
+		return errTampered
+	}
+	cr.counter++
+	cr.plain = plain
+	cr.pos = 0
+	cr.done = value&chunkFinalFlag != 0
+	return nil
+}
+
+// --- Segmented Compression ---
+
+// compressorFunc creates a new compressor writing a complete, self-contained
+// compressed stream to w once closed.
+type compressorFunc func(w io.Writer) (io.WriteCloser, error)
+
+// indexSegment describes an independently decompressible part of the payload.
+// Every segment starts at a chunk boundary and at a tar header boundary.
+type indexSegment struct {
+	Chunk     uint64 `json:"chunk"`           // Counter of the first chunk of the segment
+	Offset    int64  `json:"offset"`          // File offset of the first chunk
+	TarOffset int64  `json:"tar_offset"`      // Offset of the segment start in the tar stream
+	Codec     uint8  `json:"codec,omitempty"` // Compression backend of the segment
+	// Blocks after the first, for segments compressed in parallel (see parallel.go)
+	Blocks []indexBlock `json:"blocks,omitempty"`
+}
+
+// segmentWriter compresses the tar stream into a sequence of independent
+// compressed streams. Readers can decompress the concatenation sequentially,
+// or start at any segment to reach an entry without decoding what precedes it.
+// A segment is opened lazily on the first write after a cut.
+type segmentWriter struct {
+	chunks       *chunkWriter
+	file         *countingWriter // Counts bytes written to the archive file
+	codecID      uint8
+	newCodec     compressorFunc
+	codec        io.WriteCloser
+	open         bool  // A segment has been started and not yet cut
+	segmentStart int64 // Tar offset of the open segment
+	started      int   // Segments started, including those still being compressed
+	tarOffset    int64
+	segments     []indexSegment
+	blocks       *blockCompressor // Compresses blocks in parallel (nil = one stream per segment)
+}
+
+func newSegmentWriter(chunks *chunkWriter, file *countingWriter, codecID uint8, newCodec compressorFunc) *segmentWriter {
+	return &segmentWriter{chunks: chunks, file: file, codecID: codecID, newCodec: newCodec}
+}
+
+// useCodec switches the codec for the following writes. A change of codec
+// finishes the open segment, since every segment has a single codec.
+func (sw *segmentWriter) useCodec(codecID uint8, newCodec compressorFunc) error {
+	if codecID == sw.codecID {
+		return nil
+	}
+	if err := sw.cut(); err != nil {
+		return err
+	}
+	sw.codecID = codecID
+	sw.newCodec = newCodec
+	return nil
+}
+
+func (sw *segmentWriter) Write(p []byte) (int, error) {
+	if sw.blocks != nil {
+		return sw.blocks.write(sw, p)
+	}
+	if !sw.open {
+		codec, err := sw.newCodec(sw.chunks)
+		if err != nil {
+			return 0, err
+		}
+		sw.codec = codec
+		sw.open = true
+		sw.segmentStart = sw.tarOffset
+		sw.started++
+		sw.startSegment(sw.codecID, sw.tarOffset)
+	}
+	n, err := sw.codec.Write(p)
+	sw.tarOffset += int64(n)
+	return n, err
+}
+
+// startSegment records a new segment starting at tarOffset at the current
+// chunk boundary.
+func (sw *segmentWriter) startSegment(codecID uint8, tarOffset int64) {
+	sw.segments = append(sw.segments, indexSegment{
+		Chunk:     sw.chunks.counter,
+		Offset:    sw.file.n,
+		TarOffset: tarOffset,
+		Codec:     codecID,
+	})
+}
+
+// current returns the number of the open or last segment.
+func (sw *segmentWriter) current() int {
+	return sw.started - 1
+}
+
+// segmentLen is the amount of uncompressed data in the open segment.
+func (sw *segmentWriter) segmentLen() int64 {
+	if !sw.open {
+		return 0
+	}
+	return sw.tarOffset - sw.segmentStart
+}
+
+// cut finishes the open compressed stream, if any, so the next write starts
+// a new segment on a fresh chunk.
+func (sw *segmentWriter) cut() error {
+	if !sw.open {
+		return nil
+	}
+	sw.open = false
+	if sw.blocks != nil {
+		if err := sw.blocks.finish(sw); err != nil {
+			return err
+		}
+		return sw.chunks.Flush()
+	}
+	if err := sw.codec.Close(); err != nil {
+		return err
+	}
+	sw.codec = nil
+	return sw.chunks.Flush()
+}
+
+// copySegment appends an already compressed segment taken verbatim from
+// another archive. tarLen is the amount of tar data it decompresses to, and
+// blocks are its parallel blocks, whose offsets are relative to the segment.
+func (sw *segmentWriter) copySegment(compressed io.Reader, tarLen int64, codecID uint8, blocks []indexBlock) error {
+	if err := sw.cut(); err != nil {
+		return err
+	}
+	sw.startSegment(codecID, sw.tarOffset)
+	sw.segments[len(sw.segments)-1].Blocks = blocks
+	sw.started++
+	if _, err := io.Copy(sw.chunks, compressed); err != nil {
+		return err
+	}
+	sw.tarOffset += tarLen
+	return sw.chunks.Flush()
+}
+
+// Close finishes the last segment and seals the final chunk.
+func (sw *segmentWriter) Close() error {
+	if err := sw.cut(); err != nil {
+		return err
+	}
+	return sw.chunks.Close()
+}
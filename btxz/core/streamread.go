@@ -0,0 +1,309 @@
+// File: core/streamread.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements reading an archive once, front to back, from a stream
+// such as standard input or a download, without a file on disk. The version is
+// peeked from the buffered stream, which is then read on from its start. The
+// index follows the payload, so what it records, such as the checksums, is
+// only known once every entry has been read.
+package core
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrStreamEnded is returned when a stream ends before the archive it carries
+// is complete, as when a download or a pipe is cut short. A wrong password is
+// detected from the header, before any entry is read, and gives a different
+// error.
+var ErrStreamEnded = errors.New("stream ended early: the archive is incomplete")
+
+// ExtractArchiveFrom extracts the archive read from r like ExtractArchiveContext.
+// Entries are written as they arrive; the progress total is unknown.
+func ExtractArchiveFrom(ctx context.Context, r io.Reader, outputDir, password string, opts ExtractOptions) (ExtractStats, error) {
+	stream, err := OpenArchiveStream(r)
+	if err != nil {
+		return ExtractStats{}, err
+	}
+	return stream.Extract(ctx, outputDir, password, opts)
+}
+
+// ListArchiveFrom lists the archive read from r like ListArchiveContext.
+func ListArchiveFrom(ctx context.Context, r io.Reader, password string) ([]ArchiveEntry, ArchiveInfo, error) {
+	stream, err := OpenArchiveStream(r)
+	if err != nil {
+		return nil, ArchiveInfo{}, err
+	}
+	return stream.List(ctx, password)
+}
+
+// VerifyArchiveFrom verifies the archive read from r like VerifyArchiveContext.
+func VerifyArchiveFrom(ctx context.Context, r io.Reader, password string) (ArchiveInfo, error) {
+	stream, err := OpenArchiveStream(r)
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+	return stream.Verify(ctx, password)
+}
+
+// ArchiveStream is a v4 archive read from a stream. OpenArchiveStream reads
+// its header, so the secrets it needs are known before a password is asked
+// for; then one call of Extract, List or Verify reads the rest of the stream.
+type ArchiveStream struct {
+	r      *bufio.Reader
+	source io.Reader // What r reads, used by List if it can seek
+	header BtxzHeaderV4
+	read   bool // The payload has been read
+}
+
+// OpenArchiveStream reads the header of the archive at the start of r. Legacy
+// archives need a file and are refused, as are archives created with
+// --codec auto once they are read.
+func OpenArchiveStream(r io.Reader) (*ArchiveStream, error) {
+	s := &ArchiveStream{r: bufio.NewReader(r), source: r}
+	start, err := s.r.Peek(6)
+	if err != nil {
+		if len(start) == 0 && err == io.EOF {
+			return nil, errors.New("the stream is empty: no archive was received")
+		}
+		if len(start) > 0 && !bytes.HasPrefix([]byte(magicSignature), start[:min(len(start), len(magicSignature))]) {
+			return nil, errors.New("not a valid BTXZ archive")
+		}
+		return nil, streamError(err)
+	}
+	version, err := parseVersion(start)
+	if err != nil {
+		return nil, err
+	}
+	if version != coreVersionV4 {
+		return nil, fmt.Errorf("legacy archive version v%d cannot be read from a stream; save it to a file first", version)
+	}
+	if s.header, err = readHeaderV4(s.r); err != nil {
+		return nil, streamError(err)
+	}
+	return s, nil
+}
+
+// RequiredSecrets reports which secrets open the archive, like the function
+// of the same name for an archive file.
+func (s *ArchiveStream) RequiredSecrets() ([]SecretKinds, error) {
+	return s.header.secretKinds()
+}
+
+// FIDO2 returns the security key binding of the archive, like ArchiveFIDO2.
+func (s *ArchiveStream) FIDO2() *FIDO2Binding {
+	return s.header.fido2Binding()
+}
+
+// Extract writes the entries of the archive below outputDir.
+func (s *ArchiveStream) Extract(ctx context.Context, outputDir, password string, opts ExtractOptions) (ExtractStats, error) {
+	if opts.Base != nil {
+		return ExtractStats{}, errBaseStream
+	}
+	reader, aead, err := s.open(ctx, password)
+	if err != nil {
+		return ExtractStats{}, err
+	}
+	opts.ctx = ctx
+	opts.trackCreated()
+	var selection *entrySelection
+	if len(opts.Names) > 0 {
+		selection = newEntrySelection(opts.Names)
+	}
+	progress := newExtractCounter(opts.Progress, 0)
+	stats, err := extractVerified(opts, func(opts ExtractOptions) (ExtractStats, error) {
+		return extractTarStream(reader.tr, outputDir, selection, nil, progress, opts)
+	})
+	if err == nil && (opts.DryRun || opts.ApplyDeletions) {
+		// Read on to the end, so every chunk and the index are authenticated;
+		// the index lists the deletions.
+		var index *archiveIndex
+		if index, err = s.finish(reader, aead); err == nil && opts.ApplyDeletions && index != nil {
+			stats.Deleted, err = applyDeletions(outputDir, index.Deleted, opts)
+		}
+	}
+	if err != nil {
+		err = s.fail(ctx, err)
+	} else {
+		err = selection.missingError()
+	}
+	opts.created.cleanup(ctx, err, &stats)
+	return stats, err
+}
+
+// List returns the entries of the archive and its metadata. The entries are
+// read from the tar stream and, once it ends, replaced by those of the index,
+// which carry the checksums. If the stream can seek, such as a file or a
+// download from a server that supports range requests, and the archive has an
+// index, only the index is read.
+func (s *ArchiveStream) List(ctx context.Context, password string) ([]ArchiveEntry, ArchiveInfo, error) {
+	if seeker, ok := s.source.(io.ReadSeeker); ok && (s.header.Flags&headerFlagIndexTrailer != 0 || s.header.IndexOffset != 0) {
+		if _, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			return s.listIndex(ctx, seeker, password)
+		}
+	}
+	reader, aead, err := s.open(ctx, password)
+	if err != nil {
+		return nil, ArchiveInfo{}, err
+	}
+	var contents []ArchiveEntry
+	for {
+		hdr, err := reader.entries.next(reader.tr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ArchiveInfo{}, s.fail(ctx, err)
+		}
+		contents = append(contents, tarArchiveEntry(hdr))
+	}
+	index, err := s.finish(reader, aead)
+	if err != nil {
+		return nil, ArchiveInfo{}, s.fail(ctx, err)
+	}
+	if index == nil {
+		return contents, s.header.levelInfo(ArchiveInfo{}), nil
+	}
+	return index.contents(), s.header.levelInfo(index.info()), nil
+}
+
+// listIndex lists the archive from its index, read through seeker.
+func (s *ArchiveStream) listIndex(ctx context.Context, seeker io.ReadSeeker, password string) ([]ArchiveEntry, ArchiveInfo, error) {
+	if s.read {
+		return nil, ArchiveInfo{}, errors.New("the archive stream has already been read")
+	}
+	s.read = true
+	aead, _, err := unlockV4(&s.header, password)
+	if err != nil {
+		return nil, ArchiveInfo{}, err
+	}
+	header := s.header
+	if err := locateIndex(seeker, &header); err != nil {
+		return nil, ArchiveInfo{}, s.fail(ctx, err)
+	}
+	archive := &archiveV4{file: seekCloser{seeker}, header: header, aead: aead}
+	index, err := archive.index()
+	if err != nil {
+		return nil, ArchiveInfo{}, s.fail(ctx, err)
+	}
+	if index == nil {
+		// The trailer naming the index is missing: the archive was cut short.
+		return nil, ArchiveInfo{}, ErrStreamEnded
+	}
+	return index.contents(), header.levelInfo(index.info()), nil
+}
+
+// seekCloser is an archiveFile that leaves closing to the owner of the stream.
+type seekCloser struct {
+	io.ReadSeeker
+}
+
+func (seekCloser) Close() error { return nil }
+
+// Verify authenticates and decompresses the whole archive, and checks every
+// file against the checksum the index records for it.
+func (s *ArchiveStream) Verify(ctx context.Context, password string) (ArchiveInfo, error) {
+	reader, aead, err := s.open(ctx, password)
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+	// The checksums follow the content, so every file is hashed as it passes.
+	sums := make(map[string][]byte)
+	var index *archiveIndex
+	for err == nil {
+		var hdr *tar.Header
+		if hdr, err = reader.entries.next(reader.tr); err != nil {
+			break
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			hash := sha256.New()
+			_, err = io.Copy(hash, reader.tr)
+			sums[hdr.Name] = hash.Sum(nil)
+		}
+	}
+	if err == io.EOF {
+		index, err = s.finish(reader, aead)
+	}
+	if err != nil {
+		err = s.fail(ctx, err)
+		if isDecryptionError(err) || errors.Is(err, ErrStreamEnded) || isLimitError(err) || canceledBy(ctx, err) {
+			return ArchiveInfo{}, err
+		}
+		return ArchiveInfo{}, &integrityError{fmt.Errorf("data corruption detected: %w", err)}
+	}
+	if index == nil {
+		return s.header.levelInfo(ArchiveInfo{}), nil
+	}
+	var mismatched []string
+	for name, want := range index.digests() {
+		if sum, ok := sums[name]; ok && !bytes.Equal(sum, want) {
+			mismatched = append(mismatched, name)
+		}
+	}
+	if err := checksumError(mismatched); err != nil {
+		return ArchiveInfo{}, &integrityError{err}
+	}
+	return s.header.levelInfo(index.info()), nil
+}
+
+// open derives the key and returns a Reader over the payload, which fails
+// once ctx is done. The stream can only be read once.
+func (s *ArchiveStream) open(ctx context.Context, password string) (*Reader, cipher.AEAD, error) {
+	if s.read {
+		return nil, nil, errors.New("the archive stream has already been read")
+	}
+	s.read = true
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	aead, _, err := unlockV4(&s.header, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := newStreamReaderV4(contextReader(ctx, s.r), s.header, aead)
+	if err != nil {
+		// The decoder reads the start of the payload.
+		return nil, nil, s.fail(ctx, err)
+	}
+	return reader, aead, nil
+}
+
+// finish reads the rest of the payload and the index that follows it. It
+// returns nil for an archive written without an index.
+func (s *ArchiveStream) finish(reader *Reader, aead cipher.AEAD) (*archiveIndex, error) {
+	if _, err := io.Copy(io.Discard, reader.stream); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(io.Discard, reader.payload); err != nil {
+		return nil, err
+	}
+	if s.header.IndexOffset == 0 && s.header.Flags&headerFlagIndexTrailer == 0 {
+		return nil, nil
+	}
+	return readIndex(s.r, aead, s.header.additionalData())
+}
+
+// fail returns the error to report for err, met while reading the stream.
+func (s *ArchiveStream) fail(ctx context.Context, err error) error {
+	if canceledBy(ctx, err) {
+		return ctx.Err()
+	}
+	return streamError(err)
+}
+
+// streamError returns ErrStreamEnded for an error caused by the end of the
+// stream, and err otherwise.
+func streamError(err error) error {
+	if errors.Is(err, errTruncated) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return ErrStreamEnded
+	}
+	return err
+}
@@ -19,8 +19,9 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
 
+	"btxz/internal/secmem"
 	"github.com/ulikunitz/xz"
 	"golang.org/x/crypto/argon2"
 )
@@ -67,9 +68,17 @@ type BtxzHeaderV1 struct {
 // ArchiveEntry holds structured information about a single file within the archive,
 // used primarily for the 'list' command.
 type ArchiveEntry struct {
-	Mode string
-	Size int64
-	Name string
+	Mode     string
+	FileMode os.FileMode // Mode as a value, of which Mode is the string form
+	Size     int64
+	Name     string
+	ModTime  time.Time
+	Typeflag byte   // Tar type of the entry, such as tar.TypeReg, tar.TypeDir or tar.TypeSymlink
+	Offset   int64  // Offset in the uncompressed tar stream, when known from an index
+	Link     string // Target of a link entry
+	Hardlink bool   // The entry is a hard link to Link
+	Dedup    bool   // The entry is a deduplicated copy of Link
+	SHA256   string // Hex SHA-256 digest of the content, when recorded
 }
 
 
@@ -108,6 +117,7 @@ func CreateArchiveV1(archivePath string, inputPaths []string, password string) e
 		}
 		key = argon2.IDKey([]byte(password), header.Salt[:], header.Argon2Time, header.Argon2Memory, header.Argon2Threads, argon2KeyLength)
 	}
+	defer secmem.Wipe(key)
 
 	// 2. Write the final header to the archive file.
 	if err := binary.Write(archiveFile, binary.LittleEndian, &header); err != nil {
@@ -155,6 +165,7 @@ func CreateArchiveV1(archivePath string, inputPaths []string, password string) e
 		block, _ := aes.NewCipher(key)
 		gcm, _ := cipher.NewGCM(block)
 		encryptedPayload := gcm.Seal(nil, header.Nonce[:], compressedBuffer.Bytes(), nil)
+		secmem.Wipe(compressedBuffer.Bytes())
 		_, err = archiveFile.Write(encryptedPayload)
 	} else {
 		_, err = io.Copy(archiveFile, compressedBuffer)
@@ -224,9 +235,14 @@ func getDecryptedReaderV1(archivePath string, password string) (io.ReadCloser, e
 		return nil, errors.New("archive is encrypted, but no password was provided")
 	}
 
+	if err := checkHeaderKDF(header.Argon2Memory, header.Argon2Time, header.Argon2Threads); err != nil {
+		archiveFile.Close()
+		return nil, err
+	}
 	key := argon2.IDKey([]byte(password), header.Salt[:], header.Argon2Time, header.Argon2Memory, header.Argon2Threads, argon2KeyLength)
 	block, _ := aes.NewCipher(key)
 	gcm, _ := cipher.NewGCM(block)
+	secmem.Wipe(key)
 
 	encryptedPayload, err := io.ReadAll(archiveFile)
 	archiveFile.Close() // Close file immediately after reading.
@@ -236,71 +252,61 @@ func getDecryptedReaderV1(archivePath string, password string) (io.ReadCloser, e
 
 	decryptedPayload, err := gcm.Open(nil, header.Nonce[:], encryptedPayload, nil)
 	if err != nil {
-		return nil, errors.New("decryption failed: incorrect password or tampered archive")
+		return nil, errDecryptionFailed
 	}
-	return io.NopCloser(bytes.NewReader(decryptedPayload)), nil
+	// The payload is wiped once the caller has read it or closes the reader.
+	return secmem.NewReader(decryptedPayload), nil
 }
 
 // ExtractArchiveV1 reads a v1 archive and extracts its contents to a specified directory.
-func ExtractArchiveV1(archivePath, outputDir, password string) ([]string, error) {
-	var skippedFiles []string
+func ExtractArchiveV1(archivePath, outputDir, password string, opts ExtractOptions) (ExtractStats, error) {
+	return ExtractEntriesV1(archivePath, outputDir, password, nil, opts)
+}
+
+// ExtractEntriesV1 extracts only the named entries of a v1 archive, stopping
+// as soon as all of them have been written.
+func ExtractEntriesV1(archivePath, outputDir, password string, names []string, opts ExtractOptions) (ExtractStats, error) {
 	payloadReader, err := getDecryptedReaderV1(archivePath, password)
 	if err != nil {
-		return nil, err // Return immediately on fatal read/decryption errors.
+		return ExtractStats{}, err
 	}
 	defer payloadReader.Close()
 
 	xzReader, err := xz.NewReader(payloadReader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create xz reader: %w", err)
+		return ExtractStats{}, fmt.Errorf("failed to create xz reader: %w", err)
 	}
-	tarReader := tar.NewReader(xzReader)
 
-	cleanOutputDir, err := filepath.Abs(filepath.Clean(outputDir))
+	selection := newEntrySelection(names)
+	stats, err := extractTarStream(tar.NewReader(limitOutput(xzReader)), outputDir, selection, nil, newExtractCounter(opts.Progress, 0), opts)
 	if err != nil {
-		return nil, fmt.Errorf("could not resolve output directory path: %w", err)
+		return stats, err
 	}
+	return stats, selection.missingError()
+}
 
-	for {
-		hdr, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return skippedFiles, fmt.Errorf("error reading archive stream: %w", err)
-		}
-		
-		targetPath := filepath.Join(cleanOutputDir, hdr.Name)
-		cleanTargetPath := filepath.Clean(targetPath)
-
-		if !strings.HasPrefix(cleanTargetPath, cleanOutputDir) {
-			skippedFiles = append(skippedFiles, hdr.Name)
-			continue
-		}
+// TestArchiveV1 verifies the integrity of a v1 archive by decrypting it,
+// decompressing it and reading every entry of the tar stream, as extraction
+// would. An unencrypted archive has no authentication tag, so only the checks
+// of the xz stream apply to it.
+func TestArchiveV1(archivePath, password string) error {
+	payloadReader, err := getDecryptedReaderV1(archivePath, password)
+	if err != nil {
+		return err
+	}
+	defer payloadReader.Close()
 
-		switch hdr.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(targetPath, os.FileMode(hdr.Mode)); err != nil {
-				return skippedFiles, err
-			}
-		case tar.TypeReg:
-			if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-				return skippedFiles, err
-			}
-			outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, os.FileMode(hdr.Mode))
-			if err != nil {
-				return skippedFiles, err
-			}
-			func() {
-				defer outFile.Close()
-				_, err = io.Copy(outFile, tarReader)
-			}()
-			if err != nil {
-				return skippedFiles, err
-			}
+	xzReader, err := xz.NewReader(payloadReader)
+	if err != nil {
+		return &integrityError{fmt.Errorf("invalid compressed data: %w", err)}
+	}
+	if err := visitTarEntries(tar.NewReader(limitOutput(xzReader)), discardEntry); err != nil {
+		if isLimitError(err) {
+			return err
 		}
+		return &integrityError{fmt.Errorf("data corruption detected: %w", err)}
 	}
-	return skippedFiles, nil
+	return nil
 }
 
 // ListArchiveContentsV1 reads a v1 archive and returns a slice of ArchiveEntry structs.
@@ -315,11 +321,12 @@ func ListArchiveContentsV1(archivePath, password string) ([]ArchiveEntry, error)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create xz reader: %w", err)
 	}
-	tarReader := tar.NewReader(xzReader)
+	tarReader := tar.NewReader(limitOutput(xzReader))
+	var entries entryCounter
 
 	var contents []ArchiveEntry
 	for {
-		hdr, err := tarReader.Next()
+		hdr, err := entries.next(tarReader)
 		if err == io.EOF {
 			break
 		}
@@ -327,9 +334,13 @@ func ListArchiveContentsV1(archivePath, password string) ([]ArchiveEntry, error)
 			return nil, err
 		}
 		entry := ArchiveEntry{
-			Mode: os.FileMode(hdr.Mode).String(),
-			Size: hdr.Size,
-			Name: hdr.Name,
+			Mode:     os.FileMode(hdr.Mode).String(),
+			FileMode: os.FileMode(hdr.Mode),
+			Size:     hdr.Size,
+			Name:     hdr.Name,
+			ModTime:  hdr.ModTime,
+			Typeflag: hdr.Typeflag,
+			Link:     hdr.Linkname,
 		}
 		contents = append(contents, entry)
 	}
@@ -6,6 +6,7 @@
 package core
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bytes"
 	"crypto/aes"
@@ -19,6 +20,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"btxz/internal/secmem"
 	"github.com/klauspost/compress/zstd"
 	"golang.org/x/crypto/argon2"
 )
@@ -93,6 +95,7 @@ func CreateArchiveV2(archivePath string, inputPaths []string, password string, l
 		return fmt.Errorf("failed to generate nonce: %w", err)
 	}
 	key := argon2.IDKey([]byte(password), header.Salt[:], header.Argon2Time, header.Argon2Memory, header.Argon2Threads, argon2KeyLength)
+	defer secmem.Wipe(key)
 
 	// 2. Prepare ZIP and ZSTD writers to stream data into an in-memory buffer.
 	// Flow: Files -> ZIP (Store) -> ZSTD -> Buffer
@@ -124,6 +127,7 @@ func CreateArchiveV2(archivePath string, inputPaths []string, password string, l
 	block, _ := aes.NewCipher(key)
 	gcm, _ := cipher.NewGCM(block)
 	encryptedPayload := gcm.Seal(nil, header.Nonce[:], compressedBuffer.Bytes(), nil)
+	secmem.Wipe(compressedBuffer.Bytes())
 	_, err = archiveFile.Write(encryptedPayload)
 
 	return err
@@ -193,9 +197,13 @@ func getDecryptedReaderV2(archivePath string, password string) (io.Reader, error
 		return nil, fmt.Errorf("failed to read v2 archive header: %w", err)
 	}
 
+	if err := checkHeaderKDF(header.Argon2Memory, header.Argon2Time, header.Argon2Threads); err != nil {
+		return nil, err
+	}
 	key := argon2.IDKey([]byte(password), header.Salt[:], header.Argon2Time, header.Argon2Memory, header.Argon2Threads, argon2KeyLength)
 	block, _ := aes.NewCipher(key)
 	gcm, _ := cipher.NewGCM(block)
+	secmem.Wipe(key)
 
 	encryptedPayload, err := io.ReadAll(archiveFile)
 	if err != nil {
@@ -204,19 +212,35 @@ func getDecryptedReaderV2(archivePath string, password string) (io.Reader, error
 
 	decryptedPayload, err := gcm.Open(nil, header.Nonce[:], encryptedPayload, nil)
 	if err != nil {
-		return nil, errors.New("decryption failed: incorrect password or tampered archive")
+		return nil, errDecryptionFailed
 	}
 
-	return bytes.NewReader(decryptedPayload), nil
+	// The payload is wiped once the caller has read it.
+	return secmem.NewReader(decryptedPayload), nil
 }
 
 // ExtractArchiveV2 reads a v2 archive and extracts its contents.
-func ExtractArchiveV2(archivePath, outputDir, password string) ([]string, error) {
-	var skippedFiles []string
+func ExtractArchiveV2(archivePath, outputDir, password string, opts ExtractOptions) (ExtractStats, error) {
+	return extractArchiveV2(archivePath, outputDir, password, nil, opts)
+}
+
+// ExtractEntriesV2 extracts only the named entries of a v2 archive.
+func ExtractEntriesV2(archivePath, outputDir, password string, names []string, opts ExtractOptions) (ExtractStats, error) {
+	selection := newEntrySelection(names)
+	stats, err := extractArchiveV2(archivePath, outputDir, password, selection, opts)
+	if err != nil {
+		return stats, err
+	}
+	return stats, selection.missingError()
+}
+
+// extractArchiveV2 extracts the selected entries (all when selection is nil).
+func extractArchiveV2(archivePath, outputDir, password string, selection *entrySelection, opts ExtractOptions) (ExtractStats, error) {
+	var stats ExtractStats
 
 	payloadReader, err := getDecryptedReaderV2(archivePath, password)
 	if err != nil {
-		return nil, err
+		return stats, err
 	}
 
 	// Decompress the entire payload in memory first.
@@ -224,66 +248,161 @@ func ExtractArchiveV2(archivePath, outputDir, password string) ([]string, error)
 	// zstd decompressor does not provide.
 	zstdReader, err := zstd.NewReader(payloadReader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		return stats, fmt.Errorf("failed to create zstd reader: %w", err)
 	}
 	defer zstdReader.Close()
 	
-	unzippedData, err := io.ReadAll(zstdReader)
+	unzippedData, err := io.ReadAll(limitOutput(zstdReader))
 	if err != nil {
-		return nil, fmt.Errorf("failed to decompress archive data: %w", err)
+		return stats, fmt.Errorf("failed to decompress archive data: %w", err)
 	}
+	defer secmem.Wipe(unzippedData)
 
 	// Now read the decompressed (but still zipped) data.
 	zipArchive, err := zip.NewReader(bytes.NewReader(unzippedData), int64(len(unzippedData)))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read zip stream from decompressed data: %w", err)
+		return stats, fmt.Errorf("failed to read zip stream from decompressed data: %w", err)
+	}
+	if err := checkZipLimits(zipArchive.File); err != nil {
+		return stats, err
 	}
 
 	cleanOutputDir, err := filepath.Abs(filepath.Clean(outputDir))
 	if err != nil {
-		return nil, fmt.Errorf("could not resolve output directory path: %w", err)
+		return stats, fmt.Errorf("could not resolve output directory path: %w", err)
+	}
+	names, err := nameNormalizer(opts.NormalizeNames)
+	if err != nil {
+		return stats, err
+	}
+	overwrite, err := newOverwritePolicy(opts)
+	if err != nil {
+		return stats, err
+	}
+
+	// The zip directory records the file sizes, so the total is known up front.
+	var total int64
+	for _, file := range zipArchive.File {
+		if selection.wants(file.Name) && !file.FileInfo().IsDir() {
+			total += int64(file.UncompressedSize64)
+		}
 	}
+	progress := newExtractCounter(opts.Progress, total)
 
 	for _, file := range zipArchive.File {
-		targetPath := filepath.Join(cleanOutputDir, file.Name)
+		if err := contextErr(opts.ctx); err != nil {
+			return stats, err
+		}
+		if !selection.wants(file.Name) {
+			continue
+		}
+		selection.done(file.Name)
+		progress.entry(file.Name)
+
+		name := entryPath(file.Name)
+		if names != nil {
+			name = names(name)
+		}
+		targetPath := filepath.Join(cleanOutputDir, filepath.FromSlash(name))
 		cleanTargetPath := filepath.Clean(targetPath)
 
 		// SECURITY: Prevent path traversal attacks.
 		if !strings.HasPrefix(cleanTargetPath, cleanOutputDir) {
-			skippedFiles = append(skippedFiles, file.Name)
+			stats.Skipped = append(stats.Skipped, file.Name)
+			if opts.DryRun {
+				stats.Planned = append(stats.Planned, PlannedEntry{Name: file.Name, Action: PlanReject})
+			}
 			continue
 		}
 
 		if file.FileInfo().IsDir() {
-			os.MkdirAll(targetPath, file.Mode())
+			if opts.DryRun {
+				stats.Planned = append(stats.Planned, PlannedEntry{Name: file.Name, Action: PlanCreate})
+			} else {
+				os.MkdirAll(targetPath, file.Mode())
+			}
+			stats.Extracted = append(stats.Extracted, file.Name)
 			continue
 		}
 
+		write, replaces := overwrite.allows(targetPath, file.Name, file.Modified)
+		if !write {
+			stats.Kept = append(stats.Kept, file.Name)
+			if opts.DryRun {
+				stats.Planned = append(stats.Planned, PlannedEntry{Name: file.Name, Action: PlanSkip})
+			}
+			progress.add(int64(file.UncompressedSize64))
+			continue
+		}
+		if opts.DryRun {
+			// Reading the file checks its CRC-32.
+			rc, err := file.Open()
+			if err != nil {
+				return stats, err
+			}
+			_, err = io.Copy(io.Discard, contextReader(opts.ctx, progress.reader(rc)))
+			rc.Close()
+			if err != nil {
+				return stats, err
+			}
+			stats.Extracted = append(stats.Extracted, file.Name)
+			if replaces {
+				stats.Overwritten = append(stats.Overwritten, file.Name)
+			}
+			stats.Planned = append(stats.Planned, PlannedEntry{Name: file.Name, Action: plannedAction(false, replaces, opts)})
+			continue
+		}
 		if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
-			return skippedFiles, err
+			return stats, err
+		}
+		if err := removeExisting(targetPath); err != nil {
+			return stats, err
 		}
 
 		outFile, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, file.Mode())
 		if err != nil {
-			return skippedFiles, err
+			return stats, err
 		}
 
 		rc, err := file.Open()
 		if err != nil {
 			outFile.Close()
-			return skippedFiles, err
+			return stats, err
 		}
 
-		_, err = io.Copy(outFile, rc)
+		_, err = io.Copy(outFile, opts.written.content(targetPath, file.Name, contextReader(opts.ctx, progress.reader(rc))))
 
 		rc.Close()
 		outFile.Close()
 
 		if err != nil {
-			return skippedFiles, err
+			if canceledBy(opts.ctx, err) {
+				os.Remove(targetPath)
+			}
+			return stats, err
 		}
+		if !opts.NoTimes {
+			if err := os.Chtimes(targetPath, file.Modified, file.Modified); err != nil {
+				return stats, err
+			}
+		}
+		stats.Extracted = append(stats.Extracted, file.Name)
+		if replaces {
+			stats.Overwritten = append(stats.Overwritten, file.Name)
+		}
+	}
+	return stats, nil
+}
+
+// TestArchiveV2 verifies the integrity of a v2 archive by decrypting it,
+// decompressing it and reading every member of the zip stream, which checks
+// its CRC-32, as extraction would.
+func TestArchiveV2(archivePath, password string) error {
+	err := visitZipEntriesV2(archivePath, password, discardEntry)
+	if err != nil && !isDecryptionError(err) && !isLimitError(err) {
+		return &integrityError{fmt.Errorf("data corruption detected: %w", err)}
 	}
-	return skippedFiles, nil
+	return err
 }
 
 // ListArchiveContentsV2 reads a v2 archive and lists its contents.
@@ -299,22 +418,32 @@ func ListArchiveContentsV2(archivePath, password string) ([]ArchiveEntry, error)
 	}
 	defer zstdReader.Close()
 
-	unzippedData, err := io.ReadAll(zstdReader)
+	unzippedData, err := io.ReadAll(limitOutput(zstdReader))
 	if err != nil {
 		return nil, fmt.Errorf("failed to decompress archive data: %w", err)
 	}
+	defer secmem.Wipe(unzippedData)
 
 	zipArchive, err := zip.NewReader(bytes.NewReader(unzippedData), int64(len(unzippedData)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read zip stream: %w", err)
 	}
+	if err := checkZipLimits(zipArchive.File); err != nil {
+		return nil, err
+	}
 
 	var contents []ArchiveEntry
 	for _, file := range zipArchive.File {
 		entry := ArchiveEntry{
-			Mode: file.Mode().String(),
-			Size: int64(file.UncompressedSize64),
-			Name: file.Name,
+			Mode:     file.Mode().String(),
+			FileMode: file.Mode(),
+			Size:     int64(file.UncompressedSize64),
+			Name:     file.Name,
+			ModTime:  file.Modified,
+			Typeflag: tar.TypeReg,
+		}
+		if file.FileInfo().IsDir() {
+			entry.Typeflag = tar.TypeDir
 		}
 		contents = append(contents, entry)
 	}
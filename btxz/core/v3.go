@@ -19,8 +19,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 
+	"btxz/internal/secmem"
 	"github.com/ulikunitz/xz"
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/chacha20poly1305"
@@ -45,7 +45,7 @@ const (
 type BtxzHeaderV3 struct {
 	Signature        [4]byte // "BTXZ"
 	Version          uint16  // 3
-	CompressionLevel uint8   // 1=Fast, 2=Default, 3=Best
+	CompressionLevel uint8   // 1=Fast, 2=Default, 3=Best, 0x10+n=level n
 	Salt             [saltSize]byte
 	Argon2Time       uint32
 	Argon2Memory     uint32
@@ -76,26 +76,11 @@ func CreateArchiveV3(archivePath string, inputPaths []string, password string, l
 		Argon2Threads: argon2Threads,
 	}
 
-	// Adaptive Profiles Configuration
-	var xzDictCap int
-	
-	switch level {
-	case "fast", "low": // Low-End Hardware Mode
-		header.CompressionLevel = levelFast
-		header.Argon2Memory = 64 * 1024       // 64 MB (Good for Pi/Mobile)
-		header.Argon2Time = 1                 // 1 Pass
-		xzDictCap = 1 * 1024 * 1024           // 1 MiB Dictionary (Very low memory usage)
-	case "best", "max": // Max Security & Compression Mode
-		header.CompressionLevel = levelBest
-		header.Argon2Memory = 512 * 1024      // 512 MB (High Security)
-		header.Argon2Time = 4                 // 4 Passes
-		xzDictCap = 64 * 1024 * 1024          // 64 MiB Dictionary (Better compression, higher memory)
-	default: // Default / Balanced Mode
-		header.CompressionLevel = levelDefault
-		header.Argon2Memory = 128 * 1024      // 128 MB Standard
-		header.Argon2Time = 1                 // 1 Pass
-		xzDictCap = 8 * 1024 * 1024           // 8 MiB Dictionary
-	}
+	// Adaptive Profiles Configuration: a named profile or a compression level 0-9
+	profile := profileForLevel(level)
+	header.CompressionLevel = profile.compressionLevel
+	header.Argon2Memory = profile.argon2Memory
+	header.Argon2Time = profile.argon2Time
 
 	// Generate Salt and Nonce
 	if _, err := rand.Read(header.Salt[:]); err != nil {
@@ -107,6 +92,7 @@ func CreateArchiveV3(archivePath string, inputPaths []string, password string, l
 
 	// Derive Key
 	key := argon2.IDKey([]byte(password), header.Salt[:], header.Argon2Time, header.Argon2Memory, header.Argon2Threads, xKeyLength)
+	defer secmem.Wipe(key)
 
 	// 2. Prepare Tar and XZ Writers
 	compressedBuffer := new(bytes.Buffer)
@@ -114,7 +100,7 @@ func CreateArchiveV3(archivePath string, inputPaths []string, password string, l
 	// Configure XZ Writer with Profile Settings
 	// Using a larger dictionary improves compression but requires more memory for both compression and decompression.
 	xzConfig := xz.WriterConfig{
-		DictCap: xzDictCap,
+		DictCap: profile.dictCap,
 	}
 	xzWriter, err := xzConfig.NewWriter(compressedBuffer)
 	if err != nil {
@@ -170,6 +156,7 @@ func CreateArchiveV3(archivePath string, inputPaths []string, password string, l
 
 	// Seal appends to the first argument (dst). We pass nil to allocate new slice.
 	encryptedPayload := aead.Seal(nil, header.Nonce[:], compressedBuffer.Bytes(), nil)
+	secmem.Wipe(compressedBuffer.Bytes())
 
 	if _, err := archiveFile.Write(encryptedPayload); err != nil {
 		return fmt.Errorf("failed to write encrypted payload: %w", err)
@@ -191,6 +178,9 @@ func getDecryptedReaderV3(archivePath string, password string) (io.Reader, error
 		return nil, fmt.Errorf("failed to read v3 archive header: %w", err)
 	}
 
+	if err := checkHeaderKDF(header.Argon2Memory, header.Argon2Time, header.Argon2Threads); err != nil {
+		return nil, err
+	}
 	key := argon2.IDKey([]byte(password), header.Salt[:], header.Argon2Time, header.Argon2Memory, header.Argon2Threads, xKeyLength)
 
 	// Read Encrypted Payload
@@ -201,70 +191,44 @@ func getDecryptedReaderV3(archivePath string, password string) (io.Reader, error
 
 	// Decrypt
 	aead, err := chacha20poly1305.NewX(key)
+	secmem.Wipe(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create XChaCha20-Poly1305 AEAD: %w", err)
 	}
 
 	decryptedPayload, err := aead.Open(nil, header.Nonce[:], encryptedPayload, nil)
 	if err != nil {
-		return nil, errors.New("decryption failed: incorrect password or tampered archive")
+		return nil, errDecryptionFailed
 	}
 
-	return bytes.NewReader(decryptedPayload), nil
+	// The payload is wiped once the caller has read it.
+	return secmem.NewReader(decryptedPayload), nil
 }
 
 // ExtractArchiveV3 extracts a v3 archive.
-func ExtractArchiveV3(archivePath, outputDir, password string) ([]string, error) {
-	var skippedFiles []string
-	
+func ExtractArchiveV3(archivePath, outputDir, password string, opts ExtractOptions) (ExtractStats, error) {
+	return ExtractEntriesV3(archivePath, outputDir, password, nil, opts)
+}
+
+// ExtractEntriesV3 extracts only the named entries of a v3 archive, stopping
+// as soon as all of them have been written.
+func ExtractEntriesV3(archivePath, outputDir, password string, names []string, opts ExtractOptions) (ExtractStats, error) {
 	payloadReader, err := getDecryptedReaderV3(archivePath, password)
 	if err != nil {
-		return nil, err
+		return ExtractStats{}, err
 	}
 
-	// Decompress XZ
 	xzReader, err := xz.NewReader(payloadReader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create xz reader: %w", err)
+		return ExtractStats{}, fmt.Errorf("failed to create xz reader: %w", err)
 	}
-	
-	tarReader := tar.NewReader(xzReader)
-	cleanOutputDir, _ := filepath.Abs(filepath.Clean(outputDir))
 
-	for {
-		hdr, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return skippedFiles, fmt.Errorf("error reading tar stream: %w", err)
-		}
-
-		targetPath := filepath.Join(cleanOutputDir, hdr.Name)
-		cleanTargetPath := filepath.Clean(targetPath)
-
-		if !strings.HasPrefix(cleanTargetPath, cleanOutputDir) {
-			skippedFiles = append(skippedFiles, hdr.Name)
-			continue
-		}
-
-		switch hdr.Typeflag {
-		case tar.TypeDir:
-			os.MkdirAll(targetPath, os.FileMode(hdr.Mode))
-		case tar.TypeReg:
-			os.MkdirAll(filepath.Dir(targetPath), 0755)
-			outFile, err := os.OpenFile(targetPath, os.O_CREATE|os.O_RDWR, os.FileMode(hdr.Mode))
-			if err != nil {
-				return skippedFiles, err
-			}
-			if _, err := io.Copy(outFile, tarReader); err != nil {
-				outFile.Close()
-				return skippedFiles, err
-			}
-			outFile.Close()
-		}
+	selection := newEntrySelection(names)
+	stats, err := extractTarStream(tar.NewReader(limitOutput(xzReader)), outputDir, selection, nil, newExtractCounter(opts.Progress, 0), opts)
+	if err != nil {
+		return stats, err
 	}
-	return skippedFiles, nil
+	return stats, selection.missingError()
 }
 
 // TestArchiveV3 verifies the integrity of a v3 archive.
@@ -276,12 +240,15 @@ func TestArchiveV3(archivePath, password string) error {
 
 	xzReader, err := xz.NewReader(payloadReader)
 	if err != nil {
-		return fmt.Errorf("integrity check failed: invalid compressed data: %w", err)
+		return &integrityError{fmt.Errorf("invalid compressed data: %w", err)}
 	}
 
 	// Read and discard output to verify stream integrity
-	if _, err := io.Copy(io.Discard, xzReader); err != nil {
-		return fmt.Errorf("integrity check failed: data corruption detected: %w", err)
+	if _, err := io.Copy(io.Discard, limitOutput(xzReader)); err != nil {
+		if isLimitError(err) {
+			return err
+		}
+		return &integrityError{fmt.Errorf("data corruption detected: %w", err)}
 	}
 
 	return nil
@@ -299,11 +266,12 @@ func ListArchiveContentsV3(archivePath, password string) ([]ArchiveEntry, error)
 		return nil, fmt.Errorf("failed to create xz reader: %w", err)
 	}
 	
-	tarReader := tar.NewReader(xzReader)
+	tarReader := tar.NewReader(limitOutput(xzReader))
+	var entries entryCounter
 	var contents []ArchiveEntry
 
 	for {
-		hdr, err := tarReader.Next()
+		hdr, err := entries.next(tarReader)
 		if err == io.EOF {
 			break
 		}
@@ -311,10 +279,15 @@ func ListArchiveContentsV3(archivePath, password string) ([]ArchiveEntry, error)
 			return nil, err
 		}
 		entry := ArchiveEntry{
-			Mode: os.FileMode(hdr.Mode).String(),
-			Size: hdr.Size,
-			Name: hdr.Name,
+			Mode:     os.FileMode(hdr.Mode).String(),
+			FileMode: os.FileMode(hdr.Mode),
+			Size:     hdr.Size,
+			Name:     hdr.Name,
+			ModTime:  hdr.ModTime,
+			Typeflag: hdr.Typeflag,
+			Link:     hdr.Linkname,
 		}
+
 		contents = append(contents, entry)
 	}
 	return contents, nil
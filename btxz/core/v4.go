@@ -0,0 +1,1345 @@
+// File: core/v4.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements the v4 specification (The "Streaming" Version).
+// Improvements:
+//   - Memory: The Tar -> XZ stream is no longer buffered in RAM and sealed as a single
+//     AEAD message. It is split into fixed-size chunks, each sealed independently with
+//     XChaCha20-Poly1305 and written incrementally, so peak memory is bounded by the
+//     chunk size plus the XZ dictionary regardless of the archive size.
+//   - Integrity: Every chunk carries an authenticated length/final flag and a nonce
+//     derived from its position, so reordering, truncation, and splicing are detected.
+//   - Codecs: XZ remains the default; faster backends can be selected at creation time
+//     and are recorded in the header, so readers never have to be told which one to use.
+//
+// Core Version: v4
+package core
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"btxz/internal/secmem"
+)
+
+// --- v4 Core Constants & Header Definition ---
+
+const (
+	// coreVersionV4 is the integer identifier for this version of the format.
+	coreVersionV4 = 4
+
+	// defaultChunkSize is the amount of plaintext sealed in each encrypted chunk.
+	defaultChunkSize = 4 * 1024 * 1024 // 4 MiB
+	// maxChunkSize bounds the chunk size accepted from an archive header, so a
+	// crafted file cannot force huge buffer allocations.
+	maxChunkSize = 64 * 1024 * 1024 // 64 MiB
+
+	// chunkFinalFlag marks the last chunk of the encrypted stream in the chunk prefix.
+	chunkFinalFlag = uint32(1) << 31
+	// chunkLengthMask extracts the plaintext length from the chunk prefix.
+	chunkLengthMask = chunkFinalFlag - 1
+	// chunkPrefixSize is the size of the little-endian length/flag prefix of each chunk.
+	chunkPrefixSize = 4
+
+	// minSegmentSize is the smallest amount of tar data per compressed segment.
+	minSegmentSize = 16 * 1024 * 1024 // 16 MiB
+
+	// keyCheckSize is the size of the password verifier stored in the header.
+	keyCheckSize = 16
+	// keyCheckLabel derives the key of the password verifier from the
+	// archive key.
+	keyCheckLabel = "BTXZ v4 key check"
+)
+
+// BtxzHeaderV4 defines the binary structure of the v4 archive header.
+// The Nonce is a base value; the nonce of chunk N is the base with its last
+// 8 bytes XORed with N (big endian), so no two chunks ever share a nonce.
+type BtxzHeaderV4 struct {
+	Signature        [4]byte // "BTXZ"
+	Version          uint16  // 4
+	CompressionLevel uint8   // 1=Fast, 2=Default, 3=Best, 0x10+n=level n
+	Codec            uint8   // Compression backend (see codec.go)
+	Flags            uint8   // headerFlag* bits, e.g. an unencrypted payload (see plaintext.go)
+	Cipher           uint8   // Payload cipher (see cascade.go)
+	Argon2Time       uint32  // Argon2 parameters shared by all password and keyfile slots
+	Argon2Memory     uint32
+	Argon2Threads    uint8
+	ChunkSize        uint32                 // Plaintext bytes per encrypted chunk
+	DictSize         uint32                 // Dictionary (xz) or window (zstd) size in bytes
+	Nonce            [xNonceSize]byte       // Base nonce for the chunk sequence
+	IndexOffset      uint64                 // File offset of the encrypted index footer (0 = none)
+	KeyCheck         [keyCheckSize]byte     // Verifier of the archive key, see keyCheckValue
+	KeySlots         [maxKeySlots]keySlotV4 // The archive key, wrapped once per secret (see keyslot.go)
+	FIDO2            fido2HeaderV4          // Security key of the slots that need one (see fido2.go)
+}
+
+// profileV4 holds the concrete parameters behind an adaptive profile or a
+// numeric compression level.
+type profileV4 struct {
+	name             string // Canonical profile name: low, default, max, or the level digit
+	level            int    // Numeric compression level 0-9
+	compressionLevel uint8  // Value stored in the header
+	argon2Memory     uint32
+	argon2Time       uint32
+	dictCap          int // Dictionary (xz) or window (zstd) size
+}
+
+// levelNumeric marks a numeric compression level in the header: the stored
+// value is levelNumeric plus the level. The adaptive profiles keep their own
+// values, since they also choose the Argon2 parameters.
+const levelNumeric = uint8(0x10)
+
+// levelDictCaps holds the dictionary size of each numeric compression level,
+// after the presets of xz(1). The xz package has a single practical match
+// finder (its binary tree is slower and compresses worse than the hash
+// chain), so the levels differ in dictionary size and, for zstd and s2, in
+// encoder mode.
+var levelDictCaps = [10]int{
+	256 * 1024,
+	1 * 1024 * 1024,
+	2 * 1024 * 1024,
+	3 * 1024 * 1024,
+	4 * 1024 * 1024,
+	6 * 1024 * 1024,
+	8 * 1024 * 1024,
+	16 * 1024 * 1024,
+	32 * 1024 * 1024,
+	64 * 1024 * 1024,
+}
+
+const (
+	// MinDictSize is the smallest accepted dictionary size in bytes, the
+	// minimum of the LZMA2 format.
+	MinDictSize = 4 * 1024
+	// MaxDictSize bounds the dictionary size, both when creating and when
+	// reading a header. It is the limit of xz(1).
+	MaxDictSize = 1536 * 1024 * 1024
+)
+
+// DictMemory estimates the RAM, in bytes, that compressing and extracting
+// with a dictionary of the given size need. The xz encoder keeps a hash table
+// of about four times the dictionary next to it; the decoder needs the
+// dictionary alone.
+func DictMemory(size int64) (compress, extract int64) {
+	return 5 * size, size
+}
+
+// AvailableMemory returns the RAM available for new allocations in bytes, or
+// 0 if it cannot be determined on this platform.
+func AvailableMemory() uint64 {
+	return availableMemory()
+}
+
+// withDictSize returns the profile with its dictionary replaced by size bytes.
+// Zero keeps the dictionary of the profile.
+func (profile profileV4) withDictSize(size int64) (profileV4, error) {
+	if size == 0 {
+		return profile, nil
+	}
+	if size < MinDictSize || size > MaxDictSize {
+		return profile, fmt.Errorf("the dictionary size must be between %d KiB and %d MiB", MinDictSize/1024, MaxDictSize/(1024*1024))
+	}
+	profile.dictCap = int(size)
+	return profile, nil
+}
+
+// numericProfile returns the parameters of a numeric compression level. It
+// uses the Argon2 parameters of the default profile; --kdf-memory and
+// --kdf-time change them.
+func numericProfile(level int) profileV4 {
+	return profileV4{
+		name:             strconv.Itoa(level),
+		level:            level,
+		compressionLevel: levelNumeric + uint8(level),
+		argon2Memory:     128 * 1024,
+		argon2Time:       1,
+		dictCap:          levelDictCaps[level],
+	}
+}
+
+// profileForLevel maps a profile name or a compression level 0-9 to its
+// parameters. The adaptive profiles are identical to v3 and compress like
+// levels 1, 6 and 9.
+func profileForLevel(level string) profileV4 {
+	switch level {
+	case "fast", "low": // Low-End Hardware Mode
+		return profileV4{name: "low", level: 1, compressionLevel: levelFast, argon2Memory: 64 * 1024, argon2Time: 1, dictCap: levelDictCaps[1]}
+	case "best", "max": // Max Security & Compression Mode
+		return profileV4{name: "max", level: 9, compressionLevel: levelBest, argon2Memory: 512 * 1024, argon2Time: 4, dictCap: levelDictCaps[9]}
+	}
+	if len(level) == 1 && level[0] >= '0' && level[0] <= '9' {
+		return numericProfile(int(level[0] - '0'))
+	}
+	// Default / Balanced Mode
+	return profileV4{name: "default", level: 6, compressionLevel: levelDefault, argon2Memory: 128 * 1024, argon2Time: 1, dictCap: levelDictCaps[6]}
+}
+
+// profileForCompressionLevel maps the compression level stored in a header back to its profile.
+func profileForCompressionLevel(level uint8) profileV4 {
+	switch {
+	case level == levelFast:
+		return profileForLevel("low")
+	case level == levelBest:
+		return profileForLevel("max")
+	case level >= levelNumeric && level <= levelNumeric+9:
+		return numericProfile(int(level - levelNumeric))
+	default:
+		return profileForLevel("default")
+	}
+}
+
+// profileForHeader returns the profile an archive was written with, including
+// a dictionary size that overrides the one of its compression level.
+func profileForHeader(header BtxzHeaderV4) profileV4 {
+	profile := profileForCompressionLevel(header.CompressionLevel)
+	if header.DictSize != 0 {
+		profile.dictCap = int(header.DictSize)
+	}
+	return profile
+}
+
+// newHeaderV4 builds a header for the given profile and codec with a base nonce
+// read from random. The key slots are filled by sealKeySlots.
+func newHeaderV4(profile profileV4, codec uint8, random io.Reader) (BtxzHeaderV4, error) {
+	header := BtxzHeaderV4{
+		Signature:        [4]byte{'B', 'T', 'X', 'Z'},
+		Version:          coreVersionV4,
+		CompressionLevel: profile.compressionLevel,
+		Codec:            codec,
+		Argon2Time:       profile.argon2Time,
+		Argon2Memory:     profile.argon2Memory,
+		Argon2Threads:    argon2Threads,
+		ChunkSize:        defaultChunkSize,
+		DictSize:         uint32(profile.dictCap),
+	}
+	if _, err := io.ReadFull(random, header.Nonce[:]); err != nil {
+		return header, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return header, nil
+}
+
+// newAEADV4 returns the payload cipher of the given kind for an archive key,
+// together with the key its key check values are computed with (see
+// keyCheckValue). The key is wiped; the cipher keeps its own copy.
+func newAEADV4(key []byte, cipherID uint8) (cipher.AEAD, []byte, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(keyCheckLabel))
+	aead, err := newPayloadCipher(cipherID, key)
+	secmem.Wipe(key)
+	return aead, mac.Sum(nil), err
+}
+
+// keyCheckValue returns the verifier of an archive key stored in header: a
+// truncated HMAC-SHA256 of the header's additional data, keyed with the
+// checkKey newAEADV4 returned for the archive key. It confirms that the key
+// unwrapped from a slot is the one the payload was sealed with, and that the
+// header is the one it was written with; it reveals nothing about the key
+// beyond what trying it against the payload would.
+func keyCheckValue(checkKey []byte, header *BtxzHeaderV4) [keyCheckSize]byte {
+	mac := hmac.New(sha256.New, checkKey)
+	mac.Write(header.additionalData())
+	var check [keyCheckSize]byte
+	copy(check[:], mac.Sum(nil))
+	return check
+}
+
+// additionalData returns the header as it is authenticated by the key check
+// and the index: serialized without what may change once the archive is
+// written. Those are the key slots and the security key, which rekey
+// replaces, the key check itself, and where the index is, which is only
+// known at the end.
+func (header BtxzHeaderV4) additionalData() []byte {
+	header.KeySlots, header.FIDO2, header.KeyCheck = [maxKeySlots]keySlotV4{}, fido2HeaderV4{}, [keyCheckSize]byte{}
+	header.IndexOffset = 0
+	header.Flags &^= headerFlagIndexTrailer
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &header)
+	return buf.Bytes()
+}
+
+// CreateArchiveV4 creates a new archive using the v4 format
+// (Tar -> XZ or Zstd -> chunked XChaCha20-Poly1305), streaming directly to disk.
+func CreateArchiveV4(archivePath string, inputPaths []string, password string, opts CreateOptions) (CreateStats, error) {
+	fill, err := pathFiller(inputPaths, opts)
+	if err != nil {
+		return CreateStats{}, err
+	}
+	if err := opts.digestPaths(inputPaths); err != nil {
+		return CreateStats{}, err
+	}
+	return createArchiveV4(archivePath, password, opts, fill)
+}
+
+// pathFiller checks the input paths and returns the fill function that adds
+// them, in order, to a new archive.
+func pathFiller(inputPaths []string, opts CreateOptions) (func(writer *writerV4) error, error) {
+	if len(inputPaths) == 0 {
+		return nil, errors.New("no input files or folders specified")
+	}
+	var paths []string
+	for _, path := range inputPaths {
+		if path != StdinPath {
+			paths = append(paths, path)
+		}
+	}
+	if len(inputPaths)-len(paths) > 1 {
+		return nil, errors.New("standard input can only be given once")
+	}
+	return func(writer *writerV4) error {
+		progress, err := newProgressCounter(paths, writer.walk, opts.Progress)
+		if err != nil {
+			return err
+		}
+		writer.progress = progress
+		for _, path := range inputPaths {
+			if path == StdinPath {
+				if writer.stdinBytes, err = writer.addStream(opts.Stdin, opts.StdinName); err != nil {
+					return err
+				}
+			} else if err := writer.addPaths([]string{path}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// createArchiveV4 writes a new v4 archive whose entries are added by fill. It
+// is a Writer on the partial archive file, or on the volume writer of a split
+// archive, which is moved into place once complete (see atomic.go).
+func createArchiveV4(archivePath, password string, opts CreateOptions, fill func(writer *writerV4) error) (CreateStats, error) {
+	return writeArchiveV4(archivePath, password, opts, fill, nil)
+}
+
+// writeArchiveV4 implements createArchiveV4. check, if not nil, is called
+// with the path of the complete partial file of a single-file archive before
+// it is moved into place, and an error from it removes the file instead.
+func writeArchiveV4(archivePath, password string, opts CreateOptions, fill func(writer *writerV4) error, check func(partialPath string) error) (CreateStats, error) {
+	setup, err := newWriterSetup(password, opts)
+	if err != nil {
+		return CreateStats{}, err
+	}
+	if err := opts.checkNoArchive(archivePath); err != nil {
+		return setup.stats, err
+	}
+
+	var archiveFile io.WriteSeeker
+	var output archiveOutput
+	var partial *partialFile
+	if opts.VolumeSize > 0 {
+		volumes, err := newVolumeWriter(archivePath+partialSuffix, opts.VolumeSize)
+		if err != nil {
+			return setup.stats, err
+		}
+		archiveFile, output = volumes, volumes
+	} else {
+		file, err := createPartial(archivePath)
+		if err != nil {
+			return setup.stats, err
+		}
+		archiveFile, output, partial = file, file, file
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			output.abort()
+		}
+	}()
+
+	writer, err := setup.start(archiveFile)
+	if err != nil {
+		return setup.stats, err
+	}
+	if err := fill(writer.w); err != nil {
+		return writer.Stats(), err
+	}
+	// Finish the payload, append the index and patch the header. The stats
+	// include what closing records, such as the deletions of a snapshot.
+	if err := writer.Close(); err != nil {
+		return writer.Stats(), err
+	}
+	if check != nil && partial != nil {
+		if err := check(partial.Name()); err != nil {
+			return writer.Stats(), err
+		}
+	}
+	if err := opts.checkNoArchive(archivePath); err != nil {
+		return writer.Stats(), err
+	}
+	if err := output.commit(archivePath); err != nil {
+		return writer.Stats(), err
+	}
+	committed = true
+	return writer.Stats(), nil
+}
+
+// finishArchiveV4 closes the writer, flushes the file and rewrites the header,
+// which now carries the index offset.
+func finishArchiveV4(archiveFile io.WriteSeeker, fileWriter *bufio.Writer, writer *writerV4) error {
+	if err := writer.close(); err != nil {
+		return err
+	}
+	if err := fileWriter.Flush(); err != nil {
+		return fmt.Errorf("failed to write encrypted payload: %w", err)
+	}
+	if _, err := archiveFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to update archive header: %w", err)
+	}
+	if err := binary.Write(archiveFile, binary.LittleEndian, &writer.header); err != nil {
+		return fmt.Errorf("failed to update archive header: %w", err)
+	}
+	return nil
+}
+
+// segmentSizeFor returns the amount of uncompressed data after which a new
+// compressed segment is started. Segments are kept well above the dictionary
+// size so the ratio loss from restarting the compressor stays negligible.
+func segmentSizeFor(dictCap int) int64 {
+	size := int64(4 * dictCap)
+	if size < minSegmentSize {
+		size = minSegmentSize
+	}
+	return size
+}
+
+// writerV4 streams entries into a v4 archive while recording an index entry
+// (including the tar offset and segment) for every member it writes.
+type writerV4 struct {
+	out          *countingWriter // Counts bytes written to the archive file
+	header       BtxzHeaderV4
+	aead         cipher.AEAD
+	segments     *segmentWriter
+	tw           *tar.Writer
+	segmentSize  int64
+	index        archiveIndex
+	profile      profileV4
+	compressors  map[uint8]compressorFunc // Compressor factories by codec, for --codec auto
+	chooser      *codecChooser            // Picks the codec of each entry (nil = header codec)
+	dedup        *deduplicator            // Finds duplicate files (nil = disabled)
+	links        hardlinkTracker          // First entry name of every multiply-linked file
+	sizes        map[string]int64         // Content size of every entry written so far
+	names        func(string) string      // Converts names to a Unicode normal form (nil = keep)
+	progress     *progressCounter         // Counts the input bytes read (nil = not reported)
+	ctx          context.Context          // Stops the walk and the reads once done (nil = never)
+	stdinBytes   int64                    // Bytes read from standard input
+	walk         walkOptions              // Picks the input entries to store
+	excluded     int                      // Entries left out by the filter of walk
+	inputBytes   int64                    // Content size of the regular files added
+	random       io.Reader                // Source of the index nonce
+	reproducible *Reproducible            // Normalizes the entry headers (nil = stored as found)
+	inputs       []reproducibleInput      // The inputs a reproducible archive was digested from
+	nextInput    int                      // Index in inputs of the next entry to write
+	snapshot     *Snapshot                // Leaves out unchanged files (nil = stores everything)
+	unchanged    int                      // Files left out as they match snapshot
+	base         *DiffBase                // Leaves out files matching the base archive (nil = stores everything)
+}
+
+// newWriterV4 writes the header to w and prepares the compression and encryption
+// pipeline for the header's codec. With the auto codec, each entry is compressed
+// or stored as picked by the default codecChooser.
+func newWriterV4(w io.Writer, header BtxzHeaderV4, aead cipher.AEAD, profile profileV4) (*writerV4, error) {
+	writer := &writerV4{
+		header:      header,
+		aead:        aead,
+		segmentSize: segmentSizeFor(profile.dictCap),
+		profile:     profile,
+		compressors: make(map[uint8]compressorFunc),
+		links:       make(hardlinkTracker),
+		sizes:       make(map[string]int64),
+		random:      rand.Reader,
+	}
+	codec := header.Codec
+	if codec == codecAuto {
+		writer.chooser = newCodecChooser(nil)
+		codec = autoCompressedCodec
+	}
+	newCodec, err := writer.compressor(codec)
+	if err != nil {
+		return nil, err
+	}
+
+	writer.out = &countingWriter{w: w}
+	if err := binary.Write(writer.out, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to write archive header: %w", err)
+	}
+
+	chunks := newChunkWriter(writer.out, aead, header.Nonce, int(header.ChunkSize))
+	writer.segments = newSegmentWriter(chunks, writer.out, codec, newCodec)
+	writer.tw = tar.NewWriter(writer.segments)
+	return writer, nil
+}
+
+// compressor returns the (cached) compressor factory for a codec.
+func (w *writerV4) compressor(codec uint8) (compressorFunc, error) {
+	if newCodec, ok := w.compressors[codec]; ok {
+		return newCodec, nil
+	}
+	newCodec, err := newCompressorV4(codec, w.profile)
+	if err != nil {
+		return nil, err
+	}
+	w.compressors[codec] = newCodec
+	return newCodec, nil
+}
+
+// addPaths walks every input path and writes its files, directories and symlinks into the tar stream.
+// Files are stored relative to the parent of the input (or to the input itself for directories).
+func (w *writerV4) addPaths(inputPaths []string) error {
+	excluded, err := walkIncluded(inputPaths, w.walk, func(filePath, basePath string) error {
+		if err := contextErr(w.ctx); err != nil {
+			return err
+		}
+		return w.addFile(filePath, basePath)
+	})
+	w.excluded += excluded
+	return err
+}
+
+// walkInputs calls fn for every file, directory and symlink below the input paths
+// together with the base path its archive name is relative to. Directories come
+// before their contents, and symlinks are not followed.
+func walkInputs(inputPaths []string, fn func(filePath, basePath string) error) error {
+	_, err := walkIncluded(inputPaths, walkOptions{}, fn)
+	return err
+}
+
+// walkIncluded is walkInputs for the entries kept by the filter of opts, which
+// follows symlinks if asked to (see dereference.go). It returns the number of
+// entries excluded; the contents of an excluded directory are neither walked
+// nor counted.
+func walkIncluded(inputPaths []string, opts walkOptions, fn func(filePath, basePath string) error) (int, error) {
+	excluded := 0
+	for _, path := range inputPaths {
+		basePath := filepath.Dir(path)
+		info, err := opts.stat(path)
+		if err != nil {
+			return excluded, fmt.Errorf("could not stat input path %s: %w", path, err)
+		}
+		switch {
+		case info.IsDir() && opts.keepRoot:
+			// "." and ".." have no name to keep; the absolute path has.
+			if base := filepath.Base(filepath.Clean(path)); base == "." || base == ".." {
+				abs, err := filepath.Abs(path)
+				if err != nil {
+					return excluded, fmt.Errorf("could not resolve input path %s: %w", path, err)
+				}
+				path = abs
+			}
+			basePath = filepath.Dir(filepath.Clean(path))
+		case info.IsDir():
+			basePath = path
+		}
+
+		root := path
+		if opts.dereference {
+			if root, err = filepath.EvalSymlinks(path); err != nil {
+				return excluded, fmt.Errorf("could not resolve input path %s: %w", path, err)
+			}
+		}
+		tree := &inputTree{opts: opts, basePath: basePath, fn: fn}
+		walkErr := tree.walk(root, path, nil)
+		excluded += tree.excluded
+		if walkErr != nil {
+			return excluded, fmt.Errorf("failed while walking path %s: %w", path, walkErr)
+		}
+	}
+	return excluded, nil
+}
+
+// entryName returns the archive name of filePath relative to basePath.
+func entryName(filePath, basePath string) string {
+	// Use relative paths within the archive for portability.
+	name, _ := filepath.Rel(basePath, filePath)
+	// Use forward slashes for cross-platform compatibility.
+	return filepath.ToSlash(name)
+}
+
+// normalize applies the requested Unicode normalization to an entry name.
+func (w *writerV4) normalize(name string) string {
+	if w.names == nil {
+		return name
+	}
+	return w.names(name)
+}
+
+// addFile writes a single file, directory or symlink into the tar stream.
+func (w *writerV4) addFile(filePath, basePath string) error {
+	info, err := w.walk.stat(filePath)
+	if err != nil {
+		return err
+	}
+	if w.snapshot.unchanged(w.normalize(entryName(filePath, basePath)), info) {
+		if info.Mode().IsRegular() {
+			w.progress.add(info.Size())
+		}
+		w.unchanged++
+		return nil
+	}
+
+	if info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+		// Neither has content. A symlink is stored as the link itself rather
+		// than what it points to.
+		var target string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if target, err = os.Readlink(filePath); err != nil {
+				return err
+			}
+		}
+		if _, err := w.checkInput(filePath, entryName(filePath, basePath), info, target); err != nil {
+			return err
+		}
+		if target != "" {
+			target = w.normalize(filepath.ToSlash(target))
+		}
+		header, err := tar.FileInfoHeader(info, target)
+		if err != nil {
+			return err
+		}
+		header.Name = w.normalize(entryName(filePath, basePath))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		return w.addEntry(header, nil)
+	}
+
+	name := w.normalize(entryName(filePath, basePath))
+	if taken, err := w.takeFromBase(name, filePath, info); taken || err != nil {
+		return err
+	}
+	input, err := w.checkInput(filePath, entryName(filePath, basePath), info, "")
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header, err := tar.FileInfoHeader(info, info.Name())
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	content, open := io.Reader(file), func() (io.ReadCloser, error) { return os.Open(filePath) }
+	if input != nil {
+		spooled := input.content(file)
+		defer spooled.Close()
+		content, open = spooled, input.reopen(open)
+	}
+	return w.addRegular(header, info, content, open)
+}
+
+// addRegular writes a regular file read from content, or a link entry if the
+// file is a further hard link to, or a duplicate of, an entry written before.
+// open opens the file again, for the deduplicator to hash it.
+func (w *writerV4) addRegular(header *tar.Header, info os.FileInfo, content io.Reader, open func() (io.ReadCloser, error)) error {
+	w.inputBytes += header.Size
+	if target := w.links.find(info, header.Name); target != "" {
+		w.progress.add(header.Size)
+		linkHeader(header, target)
+		return w.addEntry(header, nil)
+	}
+	if w.dedup != nil && header.Size > 0 {
+		target, err := w.dedup.find(header.Name, header.Size, open)
+		if err != nil {
+			return err
+		}
+		if target != "" {
+			w.progress.add(header.Size)
+			dedupHeader(header, target)
+			return w.addEntry(header, nil)
+		}
+	}
+	return w.addEntry(header, contextReader(w.ctx, w.progress.reader(content)))
+}
+
+// addEntry writes a tar header and its content and indexes the entry.
+func (w *writerV4) addEntry(header *tar.Header, content io.Reader) error {
+	// Flush pads the previous entry, so the stream is at a header boundary
+	// where a new segment may start.
+	if err := w.tw.Flush(); err != nil {
+		return err
+	}
+	if w.chooser != nil && header.Typeflag == tar.TypeReg {
+		codec, sampled, err := w.chooser.choose(header.Name, content)
+		if err != nil {
+			return err
+		}
+		content = sampled
+		newCodec, err := w.compressor(codec)
+		if err != nil {
+			return err
+		}
+		if err := w.segments.useCodec(codec, newCodec); err != nil {
+			return err
+		}
+	}
+	if w.segments.segmentLen() >= w.segmentSize {
+		if err := w.segments.cut(); err != nil {
+			return err
+		}
+	}
+	offset := w.segments.tarOffset
+	if err := w.writeHeader(header); err != nil {
+		return err
+	}
+	var sum string
+	if header.Typeflag == tar.TypeReg {
+		hash := sha256.New()
+		if content != nil {
+			if _, err := io.Copy(io.MultiWriter(w.tw, hash), content); err != nil {
+				return err
+			}
+		}
+		sum = hex.EncodeToString(hash.Sum(nil))
+	} else if content != nil {
+		if _, err := io.Copy(w.tw, content); err != nil {
+			return err
+		}
+	}
+
+	entry := indexEntry{
+		Name:    header.Name,
+		Size:    header.Size,
+		Mode:    header.Mode,
+		ModTime: header.ModTime.UnixNano(),
+		Offset:  offset,
+		// The entry lives in the segment opened by the header write.
+		Segment: w.segments.current(),
+		Link:    header.Linkname,
+		SHA256:  sum,
+	}
+	if header.Typeflag != tar.TypeReg {
+		entry.Type = header.Typeflag
+	}
+	if header.Typeflag == tar.TypeLink {
+		entry.Dedup = isDedupEntry(header)
+		entry.Size = w.sizes[header.Linkname]
+	}
+	w.sizes[entry.Name] = entry.Size
+	w.index.Entries = append(w.index.Entries, entry)
+	return nil
+}
+
+// writeHeader writes the tar header of an entry, normalized if the archive is
+// reproducible. PAX headers represent any size and name length, sub-second
+// times and access times, which the writer's default format choice may not.
+func (w *writerV4) writeHeader(header *tar.Header) error {
+	w.reproducible.normalize(header)
+	header.Format = tar.FormatPAX
+	return w.tw.WriteHeader(header)
+}
+
+// copySegment appends a compressed segment from another archive without
+// recompressing it. The entries it contains are re-indexed relative to their
+// new position.
+func (w *writerV4) copySegment(compressed io.Reader, segment indexSegment, tarLen int64, entries []indexEntry) error {
+	if err := w.tw.Flush(); err != nil {
+		return err
+	}
+	newTarOffset := w.segments.tarOffset
+	if err := w.segments.copySegment(compressed, tarLen, segment.Codec, segment.Blocks); err != nil {
+		return err
+	}
+	newSegment := w.segments.current()
+	for _, e := range entries {
+		e.Offset = e.Offset - segment.TarOffset + newTarOffset
+		e.Segment = newSegment
+		w.sizes[e.Name] = e.Size
+		w.index.Entries = append(w.index.Entries, e)
+	}
+	return nil
+}
+
+// close finishes the tar stream and the payload, then appends the encrypted
+// index footer and records its position in the header.
+func (w *writerV4) close() error {
+	if err := w.checkInputsDone(); err != nil {
+		return err
+	}
+	if err := w.tw.Close(); err != nil {
+		return fmt.Errorf("failed to close tar writer: %w", err)
+	}
+	if err := w.segments.Close(); err != nil {
+		return fmt.Errorf("failed to write encrypted payload: %w", err)
+	}
+	w.index.Segments = w.segments.segments
+	w.index.TotalSize = contentSize(w.index.Entries)
+	w.index.EntryCount = len(w.index.Entries)
+	if w.snapshot != nil {
+		w.index.Backup, w.index.Deleted = w.snapshot.backup(), w.snapshot.deleted()
+	}
+	if w.base != nil {
+		w.index.Base = w.base.fingerprint
+	}
+
+	w.header.IndexOffset = uint64(w.out.n)
+	if err := writeIndex(w.out, w.aead, w.header.additionalData(), &w.index, w.random); err != nil {
+		return fmt.Errorf("failed to write archive index: %w", err)
+	}
+	return nil
+}
+
+// archiveV4 holds an opened v4 archive together with its derived cipher.
+type archiveV4 struct {
+	file     archiveFile
+	header   BtxzHeaderV4
+	aead     cipher.AEAD
+	checkKey []byte // Computes the key check of a header for the archive key (nil if unencrypted)
+}
+
+// readHeaderV4 reads and validates a v4 archive header.
+func readHeaderV4(r io.Reader) (BtxzHeaderV4, error) {
+	var header BtxzHeaderV4
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return header, fmt.Errorf("failed to read v4 archive header: %w", err)
+	}
+	if header.ChunkSize == 0 || header.ChunkSize > maxChunkSize {
+		return header, fmt.Errorf("invalid v4 archive header: chunk size %d out of range", header.ChunkSize)
+	}
+	if header.DictSize > MaxDictSize {
+		return header, fmt.Errorf("invalid v4 archive header: dictionary size %d out of range", header.DictSize)
+	}
+	if header.Argon2Memory > maxKDFMemory || header.Argon2Time > maxKDFTime {
+		return header, errors.New("invalid v4 archive header: Argon2 parameters out of range")
+	}
+	if header.Cipher != cipherXChaCha20 && header.Cipher != cipherCascade {
+		return header, fmt.Errorf("unsupported v4 archive: unknown cipher %d; a newer version of btxz is required", header.Cipher)
+	}
+	if header.FIDO2.CredentialLength > maxFIDO2Credential {
+		return header, errors.New("invalid v4 archive header: FIDO2 credential id out of range")
+	}
+	if header.Flags&^knownHeaderFlags != 0 {
+		return header, fmt.Errorf("unsupported v4 archive: unknown header flags %#x; a newer version of btxz is required", header.Flags&^knownHeaderFlags)
+	}
+	return header, nil
+}
+
+// openArchiveV4 opens a v4 archive, reads its header and derives the key.
+// The password is ignored for unencrypted archives. The caller must call Close.
+func openArchiveV4(archivePath string, password string) (*archiveV4, error) {
+	archiveFile, err := openArchiveFile(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := newArchiveV4(archiveFile, password)
+	if err != nil {
+		archiveFile.Close()
+		return nil, err
+	}
+	return archive, nil
+}
+
+// newArchiveV4 reads the header of the archive at the start of file, derives
+// the key and locates the index. Close closes file.
+func newArchiveV4(file archiveFile, password string) (*archiveV4, error) {
+	header, err := readHeaderV4(file)
+	if err != nil {
+		return nil, err
+	}
+	aead, checkKey, err := unlockV4(&header, password)
+	if err != nil {
+		return nil, err
+	}
+	if err := locateIndex(file, &header); err != nil {
+		return nil, err
+	}
+	return &archiveV4{file: file, header: header, aead: aead, checkKey: checkKey}, nil
+}
+
+// unlockHeaderV4 reads a v4 header from r and derives the cipher of the
+// payload that follows it.
+func unlockHeaderV4(r io.Reader, password string) (BtxzHeaderV4, cipher.AEAD, error) {
+	header, err := readHeaderV4(r)
+	if err != nil {
+		return header, nil, err
+	}
+	aead, _, err := unlockV4(&header, password)
+	return header, aead, err
+}
+
+// unlockV4 derives the cipher of the payload that follows a v4 header, and
+// the key that computes its key check (nil if the archive is unencrypted).
+// A header that does not match its key check was changed after it was
+// written, and fails with errTampered.
+func unlockV4(header *BtxzHeaderV4, password string) (cipher.AEAD, []byte, error) {
+	if string(header.Signature[:]) != magicSignature || header.Version != coreVersionV4 {
+		return nil, nil, errors.New("not a v4 BTXZ archive")
+	}
+	if !header.encrypted() {
+		return plaintextCipher{}, nil, nil
+	}
+
+	// A wrong password fails here, before any payload is read. The error is
+	// the same as for a chunk that fails authentication.
+	key, _, err := openKeySlots(header, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, checkKey, err := newAEADV4(key, header.Cipher)
+	if err != nil {
+		return nil, nil, err
+	}
+	if keyCheck := keyCheckValue(checkKey, header); !hmac.Equal(keyCheck[:], header.KeyCheck[:]) {
+		return nil, nil, errTampered
+	}
+	return aead, checkKey, nil
+}
+
+// Close releases the underlying archive file.
+func (a *archiveV4) Close() error {
+	return a.file.Close()
+}
+
+// payload returns a reader over the decrypted (still compressed) payload,
+// positioned right after the header.
+func (a *archiveV4) payload() (io.Reader, error) {
+	return a.payloadAt(int64(binary.Size(a.header)), 0)
+}
+
+// payloadAt returns a reader over the decrypted payload starting at the chunk
+// numbered counter, which begins at the given file offset.
+func (a *archiveV4) payloadAt(offset int64, counter uint64) (io.Reader, error) {
+	if _, err := a.file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return newChunkReader(bufio.NewReader(a.file), a.aead, a.header.Nonce, int(a.header.ChunkSize), counter), nil
+}
+
+// segmentPayload returns a reader over exactly the compressed bytes of segment i.
+func (a *archiveV4) segmentPayload(index *archiveIndex, i int) (io.Reader, error) {
+	segment := index.Segments[i]
+	reader, err := a.payloadAt(segment.Offset, segment.Chunk)
+	if err != nil {
+		return nil, err
+	}
+	if i+1 < len(index.Segments) {
+		reader.(*chunkReader).limit = index.Segments[i+1].Chunk
+	}
+	return reader, nil
+}
+
+// segmentCodec returns the codec a segment was compressed with.
+func (a *archiveV4) segmentCodec(segment indexSegment) uint8 {
+	if a.header.Codec == codecAuto {
+		return segment.Codec
+	}
+	return a.header.Codec
+}
+
+// segmentReader returns the decompressed tar data of segment i.
+func (a *archiveV4) segmentReader(index *archiveIndex, i int) (io.Reader, error) {
+	payloadReader, err := a.segmentPayload(index, i)
+	if err != nil {
+		return nil, err
+	}
+	return newDecompressorV4(a.segmentCodec(index.Segments[i]), payloadReader)
+}
+
+// tarStream returns the complete decompressed tar stream. Archives with a single
+// codec are decoded as one concatenated stream; with per-entry codecs, the index
+// is used to decode segment after segment.
+func (a *archiveV4) tarStream() (io.Reader, error) {
+	if a.header.Codec != codecAuto {
+		payloadReader, err := a.payload()
+		if err != nil {
+			return nil, err
+		}
+		return newDecompressorV4(a.header.Codec, payloadReader)
+	}
+
+	index, err := a.index()
+	if err != nil {
+		return nil, err
+	}
+	if index == nil || len(index.Segments) == 0 {
+		return nil, errors.New("invalid v4 archive: per-entry codecs require an index")
+	}
+	// Each segment is bounded on its own, and the stream as a whole.
+	return limitOutput(&segmentStream{archive: a, index: index}), nil
+}
+
+// segmentStream reads the segments of an archive one after another.
+type segmentStream struct {
+	archive *archiveV4
+	index   *archiveIndex
+	next    int
+	current io.Reader
+}
+
+func (s *segmentStream) Read(p []byte) (int, error) {
+	for {
+		if s.current == nil {
+			if s.next == len(s.index.Segments) {
+				return 0, io.EOF
+			}
+			reader, err := s.archive.segmentReader(s.index, s.next)
+			if err != nil {
+				return 0, err
+			}
+			s.current = reader
+			s.next++
+		}
+		n, err := s.current.Read(p)
+		if err == io.EOF {
+			s.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// index reads the encrypted index footer. It returns nil if the archive has none.
+func (a *archiveV4) index() (*archiveIndex, error) {
+	if a.header.IndexOffset == 0 {
+		return nil, nil
+	}
+	if _, err := a.file.Seek(int64(a.header.IndexOffset), io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not seek to archive index: %w", err)
+	}
+	return readIndex(bufio.NewReader(a.file), a.aead, a.header.additionalData())
+}
+
+// ExtractArchiveV4 extracts a v4 archive, decrypting it chunk by chunk.
+func ExtractArchiveV4(archivePath, outputDir, password string, opts ExtractOptions) (ExtractStats, error) {
+	archive, err := openArchiveV4(archivePath, password)
+	if err != nil {
+		return ExtractStats{}, err
+	}
+	defer archive.Close()
+
+	reader, err := newReaderV4(opts.ctx, archive)
+	if err != nil {
+		return ExtractStats{}, err
+	}
+	if err := opts.Base.check(reader.index); err != nil {
+		return ExtractStats{}, err
+	}
+	if err := checkIndexCollisions(reader.index, outputDir, opts); err != nil {
+		return ExtractStats{}, err
+	}
+	if reader.index != nil {
+		if err := checkFreeSpace(reader.index.Entries, outputDir, opts); err != nil {
+			return ExtractStats{}, err
+		}
+	}
+	var total int64
+	if reader.index != nil {
+		total = reader.index.TotalSize
+	}
+	progress := newExtractCounter(opts.Progress, total)
+	stats, err := extractTarStream(reader.tr, outputDir, nil, reader.digests, progress, opts)
+	if err == nil && opts.DryRun {
+		// Read what follows the tar stream too, so every chunk is authenticated.
+		_, err = io.Copy(io.Discard, reader.stream)
+	}
+	if err == nil {
+		err = extractFromBase(outputDir, reader.index, &stats, opts)
+	}
+	if err == nil && opts.ApplyDeletions && reader.index != nil {
+		stats.Deleted, err = applyDeletions(outputDir, reader.index.Deleted, opts)
+	}
+	return stats, err
+}
+
+// ExtractEntriesV4 extracts only the named entries of a v4 archive. With an index,
+// it seeks straight to the segments holding them and decompresses nothing else.
+func ExtractEntriesV4(archivePath, outputDir, password string, names []string, opts ExtractOptions) (ExtractStats, error) {
+	archive, err := openArchiveV4(archivePath, password)
+	if err != nil {
+		return ExtractStats{}, err
+	}
+	defer archive.Close()
+
+	selection := newEntrySelection(names)
+	index, err := archive.index()
+	if err != nil {
+		return ExtractStats{}, err
+	}
+
+	if index == nil {
+		// No index: scan the tar stream and stop once everything is written.
+		decompressed, err := archive.tarStream()
+		if err != nil {
+			return ExtractStats{}, err
+		}
+		progress := newExtractCounter(opts.Progress, 0)
+		stats, err := extractTarStream(tar.NewReader(decompressed), outputDir, selection, nil, progress, opts)
+		if err != nil {
+			return stats, err
+		}
+		return stats, selection.missingError()
+	}
+
+	// Hard links and deduplicated copies are restored from their original, so
+	// originals that were not requested are extracted too and removed again at the end.
+	helpers := make(map[string]bool)
+	for _, e := range index.Entries {
+		if e.Type == tar.TypeLink && selection.wants(e.Name) && !selection.wants(e.Link) {
+			helpers[normalizeEntryName(e.Link)] = true
+		}
+	}
+
+	// Group the requested entries by segment so each segment is decoded once.
+	var wanted []indexEntry
+	for _, e := range index.Entries {
+		if selection.wants(e.Name) || helpers[normalizeEntryName(e.Name)] {
+			wanted = append(wanted, e)
+		}
+	}
+	if len(wanted) == 0 {
+		return ExtractStats{}, selection.missingError()
+	}
+	var stats ExtractStats
+	defer func() {
+		if opts.DryRun {
+			return
+		}
+		// A helper the overwrite policy kept is a file that was there before.
+		for _, name := range stats.Kept {
+			delete(helpers, normalizeEntryName(name))
+		}
+		for name := range helpers {
+			os.Remove(filepath.Join(outputDir, filepath.FromSlash(name)))
+		}
+	}()
+
+	if err := checkFreeSpace(wanted, outputDir, opts); err != nil {
+		return ExtractStats{}, err
+	}
+	digests := index.digests()
+	// The same directory extractTarStream resolves the entries against.
+	cleanOutputDir, err := filepath.Abs(filepath.Clean(outputDir))
+	if err != nil {
+		return stats, fmt.Errorf("could not resolve output directory path: %w", err)
+	}
+	if opts.collisions, err = newCollisionCheck(cleanOutputDir, opts.CaseCollisions); err != nil {
+		return stats, err
+	}
+	progress := newExtractCounter(opts.Progress, contentSize(wanted))
+	for start := 0; start < len(wanted); {
+		segmentID := wanted[start].Segment
+		if segmentID < 0 || segmentID >= len(index.Segments) {
+			return stats, errors.New("invalid archive index: segment out of range")
+		}
+		segment := index.Segments[segmentID]
+
+		groupSelection := exactSelection()
+		end := start
+		for ; end < len(wanted) && wanted[end].Segment == segmentID; end++ {
+			groupSelection.names[normalizeEntryName(wanted[end].Name)] = true
+		}
+
+		decompressed, err := archive.segmentReader(index, segmentID)
+		if err != nil {
+			return stats, err
+		}
+		// Skip the part of the segment that precedes the first wanted entry.
+		if _, err := io.CopyN(io.Discard, decompressed, wanted[start].Offset-segment.TarOffset); err != nil {
+			return stats, fmt.Errorf("error seeking in tar stream: %w", err)
+		}
+
+		groupStats, err := extractTarStream(tar.NewReader(decompressed), outputDir, groupSelection, digests, progress, opts)
+		stats.Skipped = append(stats.Skipped, groupStats.Skipped...)
+		stats.OwnerSkipped = append(stats.OwnerSkipped, groupStats.OwnerSkipped...)
+		stats.Corrupted = append(stats.Corrupted, groupStats.Corrupted...)
+		stats.Extracted = append(stats.Extracted, groupStats.Extracted...)
+		stats.Kept = append(stats.Kept, groupStats.Kept...)
+		stats.Overwritten = append(stats.Overwritten, groupStats.Overwritten...)
+		stats.Planned = append(stats.Planned, groupStats.Planned...)
+		stats.Renamed = append(stats.Renamed, groupStats.Renamed...)
+		stats.Collided = append(stats.Collided, groupStats.Collided...)
+		stats.Escaped = append(stats.Escaped, groupStats.Escaped...)
+		if groupStats.Truncated != "" {
+			stats.Truncated = groupStats.Truncated
+		}
+		if err != nil {
+			return stats, err
+		}
+		for ; start < end; start++ {
+			selection.done(wanted[start].Name)
+		}
+	}
+	if opts.DryRun {
+		// A helper is not what was asked for, so it is not in the plan.
+		planned := stats.Planned[:0]
+		for _, entry := range stats.Planned {
+			if !helpers[normalizeEntryName(entry.Name)] {
+				planned = append(planned, entry)
+			}
+		}
+		stats.Planned = planned
+	}
+	return stats, selection.missingError()
+}
+
+// TestArchiveV4 verifies the integrity of a v4 archive by authenticating every
+// chunk and decompressing the stream without writing to disk.
+func TestArchiveV4(archivePath, password string) error {
+	_, err := VerifyArchiveV4(archivePath, password)
+	return err
+}
+
+// VerifyArchiveV4 verifies a v4 archive like TestArchiveV4 and returns its
+// metadata. Files with a recorded checksum are re-hashed, and mismatches are
+// reported by name.
+func VerifyArchiveV4(archivePath, password string) (ArchiveInfo, error) {
+	return verifyArchiveV4(context.Background(), archivePath, password)
+}
+
+// verifyArchiveV4 verifies a v4 archive like VerifyArchiveV4, stopping once
+// ctx is done.
+func verifyArchiveV4(ctx context.Context, archivePath, password string) (ArchiveInfo, error) {
+	archive, err := openArchiveV4(archivePath, password)
+	if err != nil {
+		return ArchiveInfo{}, err
+	}
+	defer archive.Close()
+
+	// The index footer is authenticated separately from the payload.
+	reader, err := newReaderV4(ctx, archive)
+	if err != nil {
+		if isDecryptionError(err) || isLimitError(err) {
+			return ArchiveInfo{}, err
+		}
+		return ArchiveInfo{}, &integrityError{err}
+	}
+	index := reader.index
+
+	// Walk the stream, re-hashing file content, then read any trailing padding
+	// to verify the rest of the stream.
+	mismatched, err := verifyTarStream(reader.tr, reader.digests)
+	if err == nil {
+		_, err = io.Copy(io.Discard, reader.stream)
+	}
+	if err != nil {
+		if isDecryptionError(err) || isLimitError(err) || canceledBy(ctx, err) {
+			return ArchiveInfo{}, err
+		}
+		return ArchiveInfo{}, &integrityError{fmt.Errorf("data corruption detected: %w", err)}
+	}
+	if err := checksumError(mismatched); err != nil {
+		return ArchiveInfo{}, &integrityError{err}
+	}
+	if index == nil {
+		return archive.header.levelInfo(ArchiveInfo{}), nil
+	}
+	return archive.header.levelInfo(index.info()), nil
+}
+
+// ListArchiveContentsV4 lists contents of a v4 archive.
+func ListArchiveContentsV4(archivePath, password string) ([]ArchiveEntry, error) {
+	contents, _, err := ListArchiveV4(archivePath, password)
+	return contents, err
+}
+
+// ListArchiveV4 lists contents and metadata of a v4 archive. When the archive
+// carries an index footer only the index is decrypted; otherwise the tar stream
+// is walked.
+func ListArchiveV4(archivePath, password string) ([]ArchiveEntry, ArchiveInfo, error) {
+	return listArchiveV4(context.Background(), archivePath, password)
+}
+
+// listArchiveV4 lists a v4 archive like ListArchiveV4, stopping once ctx is
+// done.
+func listArchiveV4(ctx context.Context, archivePath, password string) ([]ArchiveEntry, ArchiveInfo, error) {
+	archive, err := openArchiveV4(archivePath, password)
+	if err != nil {
+		return nil, ArchiveInfo{}, err
+	}
+	defer archive.Close()
+
+	index, err := archive.index()
+	if err != nil {
+		return nil, ArchiveInfo{}, err
+	}
+	if index != nil {
+		return index.contents(), archive.header.levelInfo(index.info()), nil
+	}
+
+	reader, err := newReaderV4(ctx, archive)
+	if err != nil {
+		return nil, ArchiveInfo{}, err
+	}
+
+	tarReader := reader.tr
+	var entries entryCounter
+	var contents []ArchiveEntry
+
+	for {
+		hdr, err := entries.next(tarReader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ArchiveInfo{}, err
+		}
+		contents = append(contents, tarArchiveEntry(hdr))
+	}
+	return contents, archive.header.levelInfo(ArchiveInfo{}), nil
+}
+
+// contents lists the entries recorded in the index.
+func (index *archiveIndex) contents() []ArchiveEntry {
+	contents := make([]ArchiveEntry, 0, len(index.Entries))
+	sums := make(map[string]string)
+	for _, e := range index.Entries {
+		entry := e.toArchiveEntry()
+		// Links share the content, and so the digest, of their target.
+		if e.Type == tar.TypeLink {
+			entry.SHA256 = sums[e.Link]
+		}
+		sums[e.Name] = entry.SHA256
+		contents = append(contents, entry)
+	}
+	return contents
+}
+
+// tarArchiveEntry describes an entry of a tar stream, for archives without an
+// index.
+func tarArchiveEntry(hdr *tar.Header) ArchiveEntry {
+	return ArchiveEntry{
+		Mode:     hdr.FileInfo().Mode().String(),
+		FileMode: hdr.FileInfo().Mode(),
+		Size:     hdr.Size,
+		Name:     hdr.Name,
+		ModTime:  hdr.ModTime,
+		Typeflag: hdr.Typeflag,
+		Link:     hdr.Linkname,
+		Hardlink: hdr.Typeflag == tar.TypeLink && !isDedupEntry(hdr),
+		Dedup:    isDedupEntry(hdr),
+	}
+}
+
+// levelInfo adds the format version, the compression level and the dictionary
+// size of the header to info. Codecs without a dictionary leave the size at zero.
+func (header BtxzHeaderV4) levelInfo(info ArchiveInfo) ArchiveInfo {
+	info.Version = coreVersionV4
+	profile := profileForHeader(header)
+	info.Level = strconv.Itoa(profile.level)
+	switch header.Codec {
+	case codecXZ, codecAuto:
+		info.DictSize = int64(profile.dictCap)
+	case codecZstd:
+		info.DictSize = int64(zstdWindowFor(profile.dictCap))
+	}
+	return info
+}
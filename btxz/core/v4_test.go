@@ -0,0 +1,137 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// foldCase makes the collision check behave as on a file system that ignores
+// case, until the test ends.
+func foldCase(t *testing.T) {
+	t.Helper()
+	probe := probeFolding
+	probeFolding = func(string) (bool, bool) { return true, false }
+	t.Cleanup(func() { probeFolding = probe })
+}
+
+func TestExtractEntriesV4ReportsCollisions(t *testing.T) {
+	foldCase(t)
+	archive := writeTestArchive(t, []testEntry{
+		{name: "Readme.md", content: "one"},
+		{name: "README.md", content: "two"},
+	})
+	names := []string{"Readme.md", "README.md"}
+
+	stats, err := ExtractEntriesV4(archive, filepath.Join(t.TempDir(), "out"), "", names, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RenamedEntry{{Name: "README.md", As: "README~2.md"}}
+	if !slices.Equal(stats.Renamed, want) {
+		t.Errorf("renamed %v, want %v", stats.Renamed, want)
+	}
+
+	stats, err = ExtractEntriesV4(archive, filepath.Join(t.TempDir(), "out"), "", names, ExtractOptions{CaseCollisions: CaseCollisionSkip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(stats.Collided, []string{"README.md"}) {
+		t.Errorf("collided %v, want [README.md]", stats.Collided)
+	}
+}
+
+func TestExtractEntriesV4ReportsEscapedNames(t *testing.T) {
+	archive := writeTestArchive(t, []testEntry{
+		{name: "src/aux.c", content: "int main;"},
+		{name: "notes?", content: "x"},
+	})
+	stats, err := ExtractEntriesV4(archive, filepath.Join(t.TempDir(), "out"), "", []string{"src/aux.c", "notes?"}, ExtractOptions{PortableNames: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RenamedEntry{{Name: "src/aux.c", As: "src/%61ux.c"}, {Name: "notes?", As: "notes%3F"}}
+	if !slices.Equal(stats.Escaped, want) {
+		t.Errorf("escaped %v, want %v", stats.Escaped, want)
+	}
+}
+
+func TestExtractEntriesV4ReportsTruncatedFile(t *testing.T) {
+	archive := writeTestArchive(t, []testEntry{
+		{name: "first", content: "one"},
+		{name: "second", content: "two"},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := ExtractOptions{ctx: ctx, Progress: func(name string, done, total int64) {
+		if name == "second" {
+			cancel()
+		}
+	}}
+	stats, err := ExtractEntriesV4(archive, filepath.Join(t.TempDir(), "out"), "", []string{"first", "second"}, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if stats.Truncated != "second" {
+		t.Errorf("truncated %q, want second", stats.Truncated)
+	}
+}
+
+// editHeader returns a copy of archive with its header changed by edit.
+func editHeader(t *testing.T, archive string, edit func(header *BtxzHeaderV4)) string {
+	t.Helper()
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := readHeaderV4(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(&header)
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	edited := filepath.Join(t.TempDir(), "edited.btxz")
+	if err := os.WriteFile(edited, append(buf.Bytes(), data[buf.Len():]...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return edited
+}
+
+func TestHeaderIsAuthenticated(t *testing.T) {
+	encrypted := encryptedTestArchive(t, "secret", CreateOptions{})
+	plain := writeTestArchive(t, []testEntry{{name: "a.txt", content: "alpha"}})
+	for _, test := range []struct {
+		name     string
+		archive  string
+		password string
+		edit     func(header *BtxzHeaderV4)
+	}{
+		{"dictionary size", encrypted, "secret", func(h *BtxzHeaderV4) { h.DictSize *= 2 }},
+		{"codec", encrypted, "secret", func(h *BtxzHeaderV4) { h.Codec ^= 1 }},
+		{"nonce", encrypted, "secret", func(h *BtxzHeaderV4) { h.Nonce[0] ^= 1 }},
+		{"unencrypted", plain, "", func(h *BtxzHeaderV4) { h.CompressionLevel ^= 1 }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			edited := editHeader(t, test.archive, test.edit)
+			_, _, err := ListArchive(edited, test.password)
+			if !errors.Is(err, ErrIntegrity) || errors.Is(err, ErrAuthentication) {
+				t.Errorf("err = %v, want ErrIntegrity", err)
+			}
+		})
+	}
+	// The key slots are not covered, so a rekeyed archive still opens.
+	if err := RekeyArchive(encrypted, "secret", "new secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ListArchive(encrypted, "new secret"); err != nil {
+		t.Errorf("the rekeyed archive fails: %v", err)
+	}
+}
@@ -0,0 +1,286 @@
+// File: core/volume.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements multi-volume (split) archives. A split archive is a plain
+// archive cut into numbered files (name.btxz.001, name.btxz.002, ...) that are
+// read back as one continuous stream. Every volume except the last has exactly the
+// volume size, which lets readers detect missing or misplaced volumes.
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// minVolumeSize is the smallest accepted volume size. It keeps the archive
+// header, which is rewritten after the payload, inside the first volume.
+const minVolumeSize = 64 * 1024 // 64 KiB
+
+// volumeSuffix matches the numeric extension of a volume file.
+var volumeSuffix = regexp.MustCompile(`\.(\d{3})$`)
+
+// archiveFile is the random-access view of an archive, either a single file or a volume set.
+type archiveFile interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// volumePath returns the path of volume n (1-based) of a split archive.
+func volumePath(base string, n int) string {
+	return fmt.Sprintf("%s.%03d", base, n)
+}
+
+// splitArchiveBase returns the base path of a split archive, or "" if archivePath
+// is a regular single-file archive. Both a volume path (backup.btxz.002) and the
+// base path (backup.btxz) of a split archive are accepted.
+func splitArchiveBase(archivePath string) string {
+	if m := volumeSuffix.FindStringSubmatch(archivePath); m != nil {
+		if n, _ := strconv.Atoi(m[1]); n > 0 {
+			base := archivePath[:len(archivePath)-len(m[0])]
+			if _, err := os.Stat(volumePath(base, 1)); err == nil {
+				return base
+			}
+		}
+	}
+	if _, err := os.Stat(archivePath); os.IsNotExist(err) {
+		if _, err := os.Stat(volumePath(archivePath, 1)); err == nil {
+			return archivePath
+		}
+	}
+	return ""
+}
+
+// openArchiveFile opens an archive for reading, transparently joining the volumes
+// of a split archive.
+func openArchiveFile(archivePath string) (archiveFile, error) {
+	if base := splitArchiveBase(archivePath); base != "" {
+		return openVolumes(base)
+	}
+	return os.Open(archivePath)
+}
+
+// volumeReader reads a set of volumes as one continuous file.
+type volumeReader struct {
+	base  string
+	files []*os.File
+	sizes []int64
+	total int64
+	pos   int64
+}
+
+// openVolumes opens every volume of the split archive at base and checks that
+// the set is contiguous.
+func openVolumes(base string) (*volumeReader, error) {
+	vr := &volumeReader{base: base}
+	for n := 1; ; n++ {
+		file, err := os.Open(volumePath(base, n))
+		if os.IsNotExist(err) {
+			// A later volume without this one means a gap in the set.
+			if _, err := os.Stat(volumePath(base, n+1)); err == nil {
+				vr.Close()
+				return nil, fmt.Errorf("missing volume %s", volumePath(base, n))
+			}
+			break
+		}
+		if err != nil {
+			vr.Close()
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			vr.Close()
+			return nil, err
+		}
+		vr.files = append(vr.files, file)
+		vr.sizes = append(vr.sizes, info.Size())
+		vr.total += info.Size()
+	}
+
+	for i := 1; i < len(vr.sizes)-1; i++ {
+		if vr.sizes[i] != vr.sizes[0] {
+			vr.Close()
+			return nil, fmt.Errorf("volume %s has an unexpected size; the volume set is damaged or mixed", volumePath(base, i+1))
+		}
+	}
+	return vr, nil
+}
+
+func (vr *volumeReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if vr.pos >= vr.total {
+		// A complete archive is never read past its end, so the data must
+		// continue in a volume that is not there.
+		return 0, fmt.Errorf("%w: missing volume %s", io.ErrUnexpectedEOF, volumePath(vr.base, len(vr.files)+1))
+	}
+
+	start := int64(0)
+	for i, size := range vr.sizes {
+		if vr.pos < start+size {
+			if int64(len(p)) > start+size-vr.pos {
+				p = p[:start+size-vr.pos]
+			}
+			n, err := vr.files[i].ReadAt(p, vr.pos-start)
+			vr.pos += int64(n)
+			if err == io.EOF && n > 0 {
+				err = nil
+			}
+			return n, err
+		}
+		start += size
+	}
+	return 0, io.EOF
+}
+
+func (vr *volumeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += vr.pos
+	case io.SeekEnd:
+		offset += vr.total
+	default:
+		return 0, errors.New("invalid seek whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative seek position")
+	}
+	vr.pos = offset
+	return offset, nil
+}
+
+// Close closes every volume of the set.
+func (vr *volumeReader) Close() error {
+	var firstErr error
+	for _, f := range vr.files {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// volumeWriter writes an archive as a sequence of volumes of a fixed size. Each
+// full volume is synced and closed right away. The first volume stays open so the
+// header can be rewritten once the payload is complete.
+type volumeWriter struct {
+	base    string
+	size    int64
+	first   *os.File
+	current *os.File
+	count   int   // Number of volumes created
+	curLen  int64 // Bytes in the current volume
+	written int64 // Total bytes appended
+	pos     int64 // Write position
+}
+
+// newVolumeWriter creates the first volume of a split archive at base.
+func newVolumeWriter(base string, size int64) (*volumeWriter, error) {
+	if size < minVolumeSize {
+		return nil, fmt.Errorf("volume size must be at least %d bytes", minVolumeSize)
+	}
+	vw := &volumeWriter{base: base, size: size}
+	if err := vw.next(); err != nil {
+		return nil, err
+	}
+	vw.first = vw.current
+	return vw, nil
+}
+
+// next finishes the current volume and starts a new one.
+func (vw *volumeWriter) next() error {
+	if vw.current != nil && vw.current != vw.first {
+		if err := vw.current.Sync(); err != nil {
+			return err
+		}
+		if err := vw.current.Close(); err != nil {
+			return err
+		}
+	}
+	file, err := os.Create(volumePath(vw.base, vw.count+1))
+	if err != nil {
+		return fmt.Errorf("could not create archive volume: %w", err)
+	}
+	vw.count++
+	vw.current = file
+	vw.curLen = 0
+	return nil
+}
+
+func (vw *volumeWriter) Write(p []byte) (int, error) {
+	if vw.pos < vw.written {
+		// Rewrites are only needed for the header in the first volume.
+		if vw.pos+int64(len(p)) > vw.size || vw.pos+int64(len(p)) > vw.written {
+			return 0, errors.New("cannot rewrite data beyond the first volume")
+		}
+		n, err := vw.first.WriteAt(p, vw.pos)
+		vw.pos += int64(n)
+		return n, err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if vw.curLen == vw.size {
+			if err := vw.next(); err != nil {
+				return written, err
+			}
+		}
+		chunk := p
+		if int64(len(chunk)) > vw.size-vw.curLen {
+			chunk = chunk[:vw.size-vw.curLen]
+		}
+		n, err := vw.current.Write(chunk)
+		written += n
+		vw.curLen += int64(n)
+		vw.written += int64(n)
+		vw.pos = vw.written
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Seek positions the writer. Only positions inside data already written to the
+// first volume, or the end of the data, can be written to.
+func (vw *volumeWriter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += vw.pos
+	case io.SeekEnd:
+		offset += vw.written
+	default:
+		return 0, errors.New("invalid seek whence")
+	}
+	if offset < 0 || offset > vw.written {
+		return 0, errors.New("invalid seek position")
+	}
+	vw.pos = offset
+	return offset, nil
+}
+
+// Close closes all volumes and removes stale higher-numbered volumes left
+// behind at the same base by an earlier, larger archive.
+func (vw *volumeWriter) Close() error {
+	var firstErr error
+	if vw.current != vw.first {
+		firstErr = vw.current.Close()
+	}
+	if err := vw.first.Close(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+	for n := vw.count + 1; ; n++ {
+		if err := os.Remove(volumePath(vw.base, n)); err != nil {
+			break
+		}
+	}
+	return firstErr
+}
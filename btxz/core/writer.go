@@ -0,0 +1,356 @@
+// File: core/writer.go
+
+// Package core contains the stable, versioned logic for the BTXZ archive format.
+// This file implements Writer, which writes a v4 archive to any io.Writer in the
+// manner of archive/tar. The path-based creation functions are built on it.
+// The header is rewritten at the end to record where the index starts, which
+// needs a destination that can seek back to the start of the archive. On any
+// other destination, such as a pipe or a network connection, the header is
+// flagged instead, and the index is followed by a trailer holding its offset:
+//
+//	[8-byte LE index offset][8-byte magic "BTXZIDX1"]
+package core
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// indexTrailerMagic ends the index trailer of a streamed archive.
+	indexTrailerMagic = "BTXZIDX1"
+	// indexTrailerSize is the size of the index trailer.
+	indexTrailerSize = 8 + len(indexTrailerMagic)
+)
+
+// Writer writes the entries of a new v4 archive. Entries are added with
+// AddFile, and Close finishes the archive; the destination is not closed.
+type Writer struct {
+	w      *writerV4
+	buf    *bufio.Writer
+	seeker io.WriteSeeker // Destination whose header is rewritten (nil = index trailer)
+	stats  CreateStats
+	closed bool
+}
+
+// NewWriter starts a new archive on w, writing its header at once. The options
+// apply as for CreateArchiveWithOptions, except for VolumeSize and Progress,
+// which only apply to archives created from files on disk.
+func NewWriter(w io.Writer, password string, opts CreateOptions) (*Writer, error) {
+	if opts.VolumeSize > 0 {
+		return nil, errors.New("only archives created from paths can be split into volumes")
+	}
+	if opts.Snapshot != nil {
+		return nil, errors.New("only archives created from paths can be incremental")
+	}
+	if opts.Base != nil {
+		return nil, errors.New("only archives created from paths can have a base archive")
+	}
+	return newWriter(w, password, opts)
+}
+
+// newWriter is NewWriter for the path-based creation functions.
+func newWriter(w io.Writer, password string, opts CreateOptions) (*Writer, error) {
+	setup, err := newWriterSetup(password, opts)
+	if err != nil {
+		return nil, err
+	}
+	return setup.start(w)
+}
+
+// AddFile writes an entry with the given header, followed by hdr.Size bytes of
+// content for a regular file. The name is converted to the normal form chosen
+// by CreateOptions.NormalizeNames. Hard links must name an entry written before.
+func (w *Writer) AddFile(hdr *tar.Header, content io.Reader) error {
+	if w.closed {
+		return errors.New("write to closed archive writer")
+	}
+	if hdr.Name == "" {
+		return errors.New("an entry needs a name")
+	}
+	header := *hdr
+	header.Name = w.w.normalize(header.Name)
+	if header.Typeflag == tar.TypeLink {
+		header.Linkname = w.w.normalize(header.Linkname)
+	}
+	return w.w.addEntry(&header, contextReader(w.w.ctx, content))
+}
+
+// Close finishes the payload, writes the index and flushes the archive. It
+// does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.seeker != nil {
+		return finishArchiveV4(w.seeker, w.buf, w.w)
+	}
+	if err := w.w.close(); err != nil {
+		return err
+	}
+	var trailer [indexTrailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[:8], w.w.header.IndexOffset)
+	copy(trailer[8:], indexTrailerMagic)
+	if _, err := w.buf.Write(trailer[:]); err != nil {
+		return fmt.Errorf("failed to write archive index: %w", err)
+	}
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write encrypted payload: %w", err)
+	}
+	return nil
+}
+
+// CreateArchiveTo creates a v4 archive from the given paths like
+// CreateArchiveContext, but writes it to dst, such as standard output, instead
+// of a file. A dst that cannot seek gets the index trailer. VolumeSize does
+// not apply; nothing is removed if ctx is done, as dst is the caller's.
+func CreateArchiveTo(ctx context.Context, dst io.Writer, inputPaths []string, password string, opts CreateOptions) (CreateStats, error) {
+	if err := ctx.Err(); err != nil {
+		return CreateStats{}, err
+	}
+	opts.ctx = ctx
+	fill, err := pathFiller(inputPaths, opts)
+	if err != nil {
+		return CreateStats{}, err
+	}
+	if err := opts.digestPaths(inputPaths); err != nil {
+		if canceledBy(ctx, err) {
+			return CreateStats{}, ctx.Err()
+		}
+		return CreateStats{}, err
+	}
+	writer, err := newWriter(dst, password, opts)
+	if err != nil {
+		return CreateStats{}, err
+	}
+	err = fill(writer.w)
+	if err == nil {
+		err = writer.Close()
+	}
+	if canceledBy(ctx, err) {
+		return writer.Stats(), ctx.Err()
+	}
+	return writer.Stats(), err
+}
+
+// Stats reports the effective settings of the archive and, once files were
+// added from disk, the deduplication savings.
+func (w *Writer) Stats() CreateStats {
+	stats := w.stats
+	stats.StdinBytes = w.w.stdinBytes
+	stats.Excluded = w.w.excluded
+	stats.InputBytes = w.w.inputBytes
+	stats.Backup = w.w.index.Backup
+	stats.Unchanged = w.w.unchanged
+	stats.Deleted = len(w.w.index.Deleted)
+	stats.FromBase = len(w.w.index.BaseEntries)
+	stats.NotPortable = notPortable(w.w.index.Entries)
+	if w.w.dedup != nil {
+		stats.DedupFiles = w.w.dedup.files
+		stats.DedupBytes = w.w.dedup.saved
+	}
+	return stats
+}
+
+// writerSetup is the validated configuration of a new archive: its header with
+// sealed key slots, and its cipher. It is prepared before the destination is
+// created, so invalid options leave no file behind.
+type writerSetup struct {
+	header  BtxzHeaderV4
+	aead    cipher.AEAD
+	profile profileV4
+	filter  *entryFilter
+	opts    CreateOptions
+	stats   CreateStats
+	random  io.Reader // Source of the keys, salts and nonces
+}
+
+// newWriterSetup validates the options and seals the key slots.
+func newWriterSetup(password string, opts CreateOptions) (*writerSetup, error) {
+	setup := &writerSetup{opts: opts}
+	if opts.NoEncrypt {
+		if password != "" || len(opts.Secrets) > 0 {
+			return nil, errors.New("an unencrypted archive cannot have a password or key")
+		}
+	} else if password == "" {
+		return nil, errors.New("a password or keyfile is required for v4 archives")
+	}
+	for _, secret := range opts.Secrets {
+		if secret == "" {
+			return nil, errors.New("additional passwords must not be empty")
+		}
+	}
+	if len(opts.Comment) > maxCommentSize {
+		return nil, fmt.Errorf("comment is too long (at most %d bytes)", maxCommentSize)
+	}
+	if !utf8.ValidString(opts.Comment) {
+		return nil, errors.New("comment must be valid UTF-8")
+	}
+
+	profile, err := profileForLevel(opts.Level).withDictSize(opts.DictSize)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := parseCodec(opts.Codec)
+	if err != nil {
+		return nil, err
+	}
+	cipherID, err := parseCipher(opts.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	if opts.NoEncrypt && cipherID != cipherXChaCha20 {
+		return nil, errors.New("an unencrypted archive cannot use the cascade cipher")
+	}
+	if opts.NoEncrypt && opts.FIDO2 != nil {
+		return nil, errors.New("an unencrypted archive cannot be bound to a security key")
+	}
+	kdf, err := profile.kdfParams(opts.KDF)
+	if err != nil {
+		return nil, err
+	}
+	setup.random = rand.Reader
+	if opts.Reproducible != nil {
+		if setup.random, err = reproducibleRandom(password, opts, kdf); err != nil {
+			return nil, err
+		}
+	}
+	header, err := newHeaderV4(profile, codec, setup.random)
+	if err != nil {
+		return nil, err
+	}
+	if opts.NoEncrypt {
+		// No key is derived, so the header carries no Argon2 parameters.
+		header.Argon2Memory, header.Argon2Time, header.Argon2Threads = 0, 0, 0
+		header.Flags |= headerFlagPlaintext
+		setup.aead = plaintextCipher{}
+	} else {
+		header.Argon2Memory, header.Argon2Time, header.Argon2Threads = kdf.Memory, kdf.Time, kdf.Threads
+		header.Cipher = cipherID
+		if opts.FIDO2 != nil {
+			if err := opts.FIDO2.store(&header, password); err != nil {
+				return nil, err
+			}
+		}
+		setup.stats.KDF = kdf
+		key, err := sealKeySlots(&header, append([]string{password}, opts.Secrets...), setup.random)
+		if err != nil {
+			return nil, err
+		}
+		var checkKey []byte
+		if setup.aead, checkKey, err = newAEADV4(key, header.Cipher); err != nil {
+			return nil, err
+		}
+		header.KeyCheck = keyCheckValue(checkKey, &header)
+	}
+	if setup.filter, err = newEntryFilter(opts.Include, opts.Exclude); err != nil {
+		return nil, err
+	}
+	setup.header, setup.profile = header, profile
+	return setup, nil
+}
+
+// start writes the header to dst and returns a Writer for the entries. The
+// header is rewritten on Close if dst can seek and the archive starts at its
+// current position 0; otherwise the index offset goes into the trailer.
+func (s *writerSetup) start(dst io.Writer) (*Writer, error) {
+	writer := &Writer{stats: s.stats}
+	if seeker, ok := dst.(io.WriteSeeker); ok {
+		if pos, err := seeker.Seek(0, io.SeekCurrent); err == nil && pos == 0 {
+			writer.seeker = seeker
+		}
+	}
+	header := s.header
+	if writer.seeker == nil {
+		header.Flags |= headerFlagIndexTrailer
+	}
+
+	// Stream Tar -> Compressed Segments -> Encrypted Chunks -> Destination
+	writer.buf = bufio.NewWriter(dst)
+	w, err := newWriterV4(writer.buf, header, s.aead, s.profile)
+	if err != nil {
+		return nil, err
+	}
+	opts := s.opts
+	if w.chooser != nil {
+		w.chooser = newCodecChooser(opts.StoreExtensions)
+	}
+	if !opts.NoDedup {
+		w.dedup = newDeduplicator()
+	}
+	writer.stats.Threads = 1
+	if opts.Threads > 1 && opts.Reproducible == nil {
+		// Reproducible archives are compressed in a single stream, so their
+		// bytes do not depend on the number of CPUs.
+		blockSize := blockSizeFor(w.segmentSize)
+		writer.stats.Threads = parallelThreads(opts.Threads, blockSize, s.profile.dictCap)
+		w.segments.blocks = newBlockCompressor(writer.stats.Threads, blockSize)
+	}
+	if w.names, err = nameNormalizer(opts.NormalizeNames); err != nil {
+		return nil, err
+	}
+	w.ctx = opts.ctx
+	w.walk = walkOptions{filter: s.filter, dereference: opts.Dereference, keepRoot: opts.KeepRoot}
+	w.index.Comment = opts.Comment
+	w.index.Creator = Creator
+	w.index.Created = time.Now().UnixNano()
+	if opts.Reproducible != nil {
+		w.index.Created = 0
+		if !opts.Reproducible.Epoch.IsZero() {
+			w.index.Created = opts.Reproducible.Epoch.UnixNano()
+		}
+	}
+	w.random, w.reproducible, w.inputs = s.random, opts.Reproducible, opts.inputs
+	if opts.Snapshot != nil {
+		opts.Snapshot.start()
+		w.snapshot = opts.Snapshot
+	}
+	w.base = opts.Base
+	w.index.Profile = s.profile.name
+	writer.w = w
+	return writer, nil
+}
+
+// locateIndex reads the index offset of a streamed archive from its trailer.
+// An archive whose trailer is missing, because the stream was cut short, is
+// treated as one without an index, so what was written can still be read.
+func locateIndex(file io.ReadSeeker, header *BtxzHeaderV4) error {
+	if header.Flags&headerFlagIndexTrailer == 0 || header.IndexOffset != 0 {
+		return nil
+	}
+	// A signature is appended after the trailer.
+	length, _, err := signedLength(file)
+	if err != nil {
+		return err
+	}
+	headerSize := int64(binary.Size(*header))
+	if length < headerSize+int64(indexTrailerSize) {
+		return nil
+	}
+	if _, err := file.Seek(length-int64(indexTrailerSize), io.SeekStart); err != nil {
+		return err
+	}
+	var trailer [indexTrailerSize]byte
+	if _, err := io.ReadFull(file, trailer[:]); err != nil {
+		return err
+	}
+	if string(trailer[8:]) != indexTrailerMagic {
+		return nil
+	}
+	offset := binary.LittleEndian.Uint64(trailer[:8])
+	if offset < uint64(headerSize) || offset >= uint64(length-int64(indexTrailerSize)) {
+		return errors.New("invalid v4 archive: index offset out of range")
+	}
+	header.IndexOffset = offset
+	return nil
+}
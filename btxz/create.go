@@ -0,0 +1,998 @@
+// File: create.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements the create command and the helpers that choose the
+// name, profile and options of a new archive.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"btxz/core"
+	"btxz/output"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// NewCreateCmd configures the 'create' command.
+func NewCreateCmd() *cobra.Command {
+	var (
+		outputFile    string
+		password      string
+		source        passwordSource
+		keys          keychainOption
+		keyfile       string
+		recipient     string
+		addPasswords  []string
+		kdfMemory     string
+		kdfTime       uint32
+		kdfThreads    uint8
+		kdfTarget     time.Duration
+		allowWeak     bool
+		noEncrypt     bool
+		cipherMode    string
+		signKey       string
+		splitKey      string
+		useFIDO2      bool
+		level         string
+		dictSize      string
+		threads       int
+		syncArchive   string
+		deleteMissing bool
+		volumeSize    string
+		codec         string
+		storeExts     []string
+		noDedup       bool
+		normalize     string
+		comment       string
+		stdinName     string
+		excludes      []string
+		includes      []string
+		dereference   bool
+		keepRoot      bool
+		contentsOnly  bool
+		reproducible  bool
+		seed          string
+		snapshotFile  string
+		diffBase      string
+		force         bool
+		noAutoExt     bool
+	)
+	createCmd := &cobra.Command{
+		Use:   "create [file/folder...]",
+		Short: "Create a new secure archive",
+		Long: `Packages files into a secure .btxz archive using the V4 format (chunked XChaCha20-Poly1305 + LZMA2).
+
+ADAPTIVE PROFILES:
+  --level low   : Low memory mode (64MB RAM, 1 pass). Good for Raspberry Pi/Mobile.
+  --level default: Balanced mode (128MB RAM, 1 pass). Good for most laptops.
+  --level max   : Paranoid mode (512MB RAM, 4 passes, Ultra Compression). High-end hardware only.
+  --level auto  : Chosen for this machine: the strongest of max, default with a 256MB
+                  2-pass KDF, default and low whose KDF and compression fit in half of
+                  the available RAM, whose CPUs keep up (4 for max, 2 for default), and
+                  whose key derivation takes at most about a second, timed with a 200 ms
+                  benchmark. The choice and why are printed; the archive records the
+                  concrete parameters, so extraction is unaffected. --kdf-memory,
+                  --kdf-time and --kdf-threads still override single values.
+  --level 0..9  : Compression level alone, from fastest (0) to smallest (9), with the
+                  default KDF (128MB RAM, 1 pass). The profiles compress like levels
+                  1 (low), 6 (default) and 9 (max). Higher levels use a larger
+                  dictionary and need more memory to create and to extract.
+  --dict-size 32MiB overrides the dictionary of the level. Extraction needs about that much
+  RAM, compression about five times as much; list shows the size stored in the archive.
+
+PARALLEL COMPRESSION:
+  --threads N (default: all CPUs) cuts the data into large blocks (64 MiB at the default
+  level) and compresses N of them at once, like pixz. Blocks are written in order, so the output
+  is the same for any N above 1; --threads 1 compresses each segment as a single stream
+  as before. Fewer threads are used if the blocks would not fit into half the free RAM.
+
+CODECS:
+  --codec xz    : LZMA2/XZ (default). Best ratio, slowest.
+  --codec zstd  : Zstandard. Close to XZ's ratio at several times the speed.
+  --codec s2    : S2 (alias: lz4). Hundreds of MB/s, for logs and data where speed matters most.
+  --codec store : No compression. For media and other already-compressed data.
+  --codec auto  : Decides per file: known compressed formats (jpg, mp4, zip, ...) and data that
+                  does not compress in a quick sample are stored, everything else uses XZ.
+                  Add extensions to always store with --store-ext.
+  The codec is stored in the archive; extract, list and test detect it automatically.
+
+STANDARD INPUT:
+  An input of - reads standard input to its end and stores it as a single file named by
+  --stdin-name (default "stdin"). Data beyond 32 MiB is spooled to a temporary file that is
+  encrypted with a key held only in memory. Prompts then read from the terminal, so the
+  password can still be typed; --password-fd 0 and --sync cannot be used.
+
+STANDARD OUTPUT:
+  -o - writes the archive to standard output, e.g. into ssh or an upload tool. The banner is
+  left out and all messages and prompts go to standard error. The index offset is stored in a
+  trailer at the end, since the header cannot be rewritten. It cannot be combined with --sync,
+  --volume-size, --sign-key, --split-key or --use-keychain, and a terminal is refused.
+
+FILTERS:
+  --exclude <pattern> (repeatable) skips the files and folders whose path below the input
+  matches: * and ? match within a path element, ** any number of elements. A pattern
+  without a slash matches the name at any depth, so --exclude node_modules --exclude '*.o'
+  skips every node_modules folder without descending into it, and every object file;
+  'build/**/*.tmp' matches below build only.
+  --include <pattern> (repeatable) stores only the files that match one of the include
+  patterns, e.g. --include '**/*.go' --include go.mod. Exclude wins: a file matching both
+  is left out. Folders are then not stored themselves; extract creates them for the files
+  inside. The report counts what was left out; a pattern that matches nothing is fine.
+  Neither can be combined with --sync.
+
+SYMBOLIC LINKS:
+  Symlinks are stored as links. --dereference follows them instead, like tar -h: a link to a
+  file is stored as a regular file with the target's content, a link to a folder as a folder
+  with its contents. A link pointing to a folder that contains it is refused as a loop, and a
+  broken link fails the run. It cannot be combined with --sync.
+
+INCREMENTAL BACKUPS:
+  --listed-incremental <snapshot.json> works like GNU tar's: the first run stores everything and
+  writes the snapshot, recording the size, modification time and inode of every file. Later runs
+  store only files that are new or changed since, plus every folder, and record the entries that
+  were deleted; the snapshot is updated once the archive is written. Restore by extracting the
+  full archive, then each incremental one in order with extract --incremental. list shows
+  whether an archive is a full or an incremental backup.
+
+DIFFERENTIAL ARCHIVES:
+  --diff-base <full.btxz> reads the listing of an earlier archive and stores only the files
+  whose content differs from it or that are new; the others are recorded as taken from the
+  base, together with its fingerprint. Restore with extract --base full.btxz, which needs
+  just the base and the one differential archive. The base is opened with the same password
+  or keyfile, or asks for its own. Not with --sync, --reproducible or --listed-incremental.
+
+REPRODUCIBLE ARCHIVES:
+  --reproducible writes byte-identical archives for identical inputs, for attesting a build by
+  its digest: entries are stored without owners or access times, modification times later than
+  SOURCE_DATE_EPOCH (if set) are clamped to it, compression uses a single thread, and the key,
+  salts and nonces are derived from the content, the options, the password and --seed instead of
+  being random. Anyone who holds two such archives can tell whether they contain the same files;
+  a different --seed makes archives of the same files unrelated. Not with standard input,
+  --sync, --threads, --kdf-target, --recipient, --split-key or --fido2.
+
+FOLDER NAMES:
+  An input folder is not stored itself: 'create ./project' stores project/a.txt as a.txt,
+  which is --contents-only, the default. --keep-root stores it below the folder's name
+  instead, as project/a.txt, like tar; '.' is named after the current folder. Filters then
+  match the names with the folder's name in front. --keep-root cannot be combined with --sync.
+
+SYNC MODE:
+  --sync <archive> updates an existing archive instead of creating a new one. Files whose size
+  and modification time are unchanged are kept as-is; only new and changed files are compressed.
+  Add --delete to also drop entries whose files no longer exist.
+
+OUTPUT NAME:
+  Without -o the archive is named after the first input and written to the current folder:
+  'create ./photos' writes photos.btxz, 'create report.pdf' writes report.pdf.btxz. With
+  several inputs a warning names the result. An -o name without an extension gets .btxz
+  appended, so '-o backup' writes backup.btxz; --no-auto-extension keeps it as given.
+
+EXISTING ARCHIVES:
+  An archive that already exists at the output path is not replaced: on a terminal, create
+  asks first, and otherwise it fails before reading any input. --force replaces it without
+  asking. The new archive is written to <output>.partial and renamed into place once it is
+  complete, so an interrupted or failed run leaves the old archive untouched.
+
+SPLIT ARCHIVES:
+  --volume-size 3900M writes archive.btxz.001, archive.btxz.002, ... each at most that size
+  (suffixes K, M, G). Extract, list and test accept the first volume or any volume path.
+
+KEYFILES:
+  --keyfile <file> mixes the content of a file (at least 32 bytes, e.g. from
+  'head -c 64 /dev/urandom') into the key, so backups can run without a typed password.
+    --keyfile only            : the keyfile alone opens the archive.
+    --keyfile and --password  : both are required; either one alone fails authentication.
+    --password only           : the keyfile is not involved (the default).
+  Keep a copy of the keyfile: if it is lost or changed by a single byte, the archive
+  cannot be opened. The archive records which of the two it needs, so the other
+  commands only prompt for a password when one was used.
+
+RECIPIENTS:
+  --recipient <btxz1...> encrypts to a public key created with 'btxz keygen' instead of a
+  password. Only the matching identity file opens the archive (--identity key.txt); the
+  creator cannot read it back. It cannot be combined with --password or --keyfile.
+
+KEY SLOTS:
+  The payload is encrypted with a random archive key, stored once per password or recipient
+  in a key slot. --add-password "..." (repeatable) adds a slot for a further password, so
+  several people can open the archive with their own passphrase. Up to 8 slots fit.
+
+KDF TUNING:
+  --kdf-memory, --kdf-time and --kdf-threads override single Argon2 parameters of the
+  profile, e.g. "--level low --kdf-time 8" for 64MB but 8 passes on a constrained but
+  patient device. Memory must be at least 8M (a warning is shown below 64M); the values
+  are stored in the archive, so opening it needs no flags. The report shows the result.
+  --kdf-target 1s instead benchmarks this machine and picks memory (up to a quarter of the
+  available RAM) and passes so that deriving the key takes about one second.
+
+NO ENCRYPTION:
+  --no-encrypt writes a plain compressed archive for public data: no password is asked for,
+  no key is derived, and anyone who has the file can read it. Chunks still carry a checksum,
+  so test detects corruption, and extract, list and test open such archives without a
+  password. It cannot be combined with any password, keyfile, recipient or KDF flag.
+
+SECURITY KEY:
+  --fido2 also requires a FIDO2 security key (e.g. a YubiKey) with a resident "btxz"
+  credential created with the hmac-secret extension. Its response is mixed with the
+  password into the key derivation, so the archive opens only while the key is plugged in;
+  extract, list and test ask for it automatically. Needs the libfido2 tools (fido2-token,
+  fido2-assert). --add-password slots do not need the key and can serve as a recovery.
+
+KEY SHARES:
+  --split-key 3/5 splits the key with Shamir's Secret Sharing into five share files next to
+  the archive (<archive>.1.share ...); any three of them, passed with --key-share, open it,
+  fewer reveal nothing. Without -p or --keyfile the shares are the only way in.
+
+CASCADE CIPHER:
+  --cipher cascade encrypts every chunk with AES-256-GCM and then with XChaCha20-Poly1305,
+  using two independent keys derived from the archive key, for policies that demand an
+  AES layer or defense against a break of a single cipher. It costs a little speed and
+  16 bytes per chunk; the choice is stored in the archive, so opening it needs no flags.
+
+SIGNING:
+  --sign-key <file> appends an Ed25519 signature over the whole archive (header and
+  ciphertext), made with a key from 'btxz keygen --sign'. test and extract check it with
+  --verify-key <file.pub> before decrypting anything; add --require-signature to also
+  reject unsigned archives. Modifying an archive (add, remove, rekey, --sync) drops the
+  signature; sign it again with a fresh create. Split archives cannot be signed.`,
+		Example: `  btxz create ./doc.pdf -o archive.btxz -p "pass" --level max
+  btxz create ./photos --password-file pass.txt
+  btxz create ./projects -o nightly.btxz --keyfile /mnt/secure/backup.key
+  btxz create ./release -o release.btxz --recipient btxz1...
+  btxz create ./team -o team.btxz -p "alice pass" --add-password "bob pass" --add-password "carol pass"
+  btxz create ./vault -o vault.btxz --split-key 3/5
+  btxz create --sync nightly.btxz ./projects --delete
+  btxz create ./videos -o videos.btxz --volume-size 3900M
+  btxz create ./app -o app.btxz --exclude node_modules --exclude .git --exclude '*.o'
+  btxz create . --include '**/*.jpg' -o photos.btxz
+  btxz create ./releases/current -o release.btxz --dereference
+  btxz create ./project -o project.btxz --keep-root
+  btxz create ./home -o home-$(date +%F).btxz --listed-incremental home.snapshot
+  btxz create ./data -o diff.btxz --diff-base full.btxz
+  SOURCE_DATE_EPOCH=1700000000 btxz create ./dist -o dist.btxz --reproducible --password-file pass.txt
+  mysqldump shop | btxz create - -o db.btxz --stdin-name dump.sql --password-file pass.txt
+  btxz create ./projects -o - --password-file pass.txt | ssh backup 'cat > projects.btxz'`,
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			// With -o -, the archive goes to standard output, so the banner is
+			// left out and every message goes to standard error.
+			var stdout *os.File
+			if outputFile == "-" {
+				if jsonOutput.enabled {
+					handleUsageError("--json cannot be used with -o -: standard output carries the archive.")
+				}
+				stdout = dataOutput()
+				if term.IsTerminal(int(stdout.Fd())) {
+					handleUsageError("Refusing to write an archive to a terminal; redirect standard output or pipe it into another command.")
+				}
+			} else {
+				printCommandHeader("SECURE ARCHIVE CREATION")
+			}
+			startTime := time.Now()
+
+			if syncArchive != "" {
+				if outputFile != "" && outputFile != syncArchive {
+					handleUsageError("--sync updates the archive in place; do not combine it with a different --output.")
+				}
+				outputFile = syncArchive
+			}
+			if deleteMissing && syncArchive == "" {
+				handleUsageError("--delete can only be used together with --sync.")
+			}
+			var volumeBytes int64
+			if volumeSize != "" {
+				size, err := parseByteSize(volumeSize)
+				if err != nil {
+					handleUsageError("Invalid volume size: %v", err)
+				}
+				if syncArchive != "" {
+					handleUsageError("--volume-size cannot be combined with --sync.")
+				}
+				if signKey != "" {
+					handleUsageError("--sign-key cannot be combined with --volume-size; split archives cannot be signed.")
+				}
+				volumeBytes = size
+			}
+			switch {
+			case outputFile == "":
+				outputFile = defaultArchiveName(args[0])
+				if len(args) > 1 {
+					pterm.Warning.Printf("No --output given; naming the archive after the first input: %s\n", outputFile)
+				}
+			case outputFile != "-" && syncArchive == "" && !noAutoExt && filepath.Ext(outputFile) == "":
+				outputFile += ".btxz"
+			}
+			for _, arg := range args {
+				if arg == core.StdinPath {
+					stdinData = true
+				}
+			}
+			if stdinData {
+				if syncArchive != "" {
+					handleUsageError("Standard input (-) cannot be used with --sync.")
+				}
+				if source.fd == 0 {
+					handleUsageError("--password-fd 0 cannot be used when standard input (-) carries the data.")
+				}
+			} else if cmd.Flags().Changed("stdin-name") {
+				handleUsageError("--stdin-name only applies when an input is - (standard input).")
+			}
+			if stdout != nil {
+				switch {
+				case volumeSize != "":
+					handleUsageError("--volume-size cannot be used with -o -; standard output is a single stream.")
+				case signKey != "":
+					handleUsageError("--sign-key cannot be used with -o -; sign an archive file instead.")
+				case splitKey != "":
+					handleUsageError("--split-key cannot be used with -o -; the share files are named after the archive file.")
+				case keys.enabled:
+					handleUsageError("--use-keychain cannot be used with -o -; the keychain entry is named after the archive file.")
+				}
+			}
+			var noOverwrite bool
+			if stdout == nil && syncArchive == "" {
+				noOverwrite = !replaceArchive(outputFile, volumeBytes > 0, force)
+			}
+			
+			// Normalize level
+			level = normalizeLevel(level)
+
+			codec = strings.ToLower(codec)
+			if codec == "lz4" { codec = "s2" }
+			if codec == "none" { codec = "store" }
+
+			if codec != "xz" && codec != "zstd" && codec != "s2" && codec != "store" && codec != "auto" {
+				handleUsageError("Invalid codec. Use: xz, zstd, s2, store, or auto.")
+			}
+			if len(storeExts) > 0 && codec != "auto" {
+				handleUsageError("--store-ext can only be used with --codec auto.")
+			}
+			if threads < 1 {
+				handleUsageError("--threads must be at least 1.")
+			}
+			for _, filter := range []struct {
+				flag     string
+				patterns []string
+			}{{"--include", includes}, {"--exclude", excludes}} {
+				if len(filter.patterns) == 0 {
+					continue
+				}
+				if syncArchive != "" {
+					handleUsageError("%s cannot be used with --sync.", filter.flag)
+				}
+				if _, err := core.MatchFilter(filter.patterns, ""); err != nil {
+					handleUsageError("Invalid %s: %v", filter.flag, err)
+				}
+			}
+			if dereference && syncArchive != "" {
+				handleUsageError("--dereference cannot be used with --sync.")
+			}
+			if keepRoot && contentsOnly {
+				handleUsageError("--keep-root and --contents-only cannot be used together.")
+			}
+			if keepRoot && syncArchive != "" {
+				handleUsageError("--keep-root cannot be used with --sync.")
+			}
+			var repro *core.Reproducible
+			if reproducible {
+				if level == "auto" {
+					handleUsageError("--level auto depends on this machine and cannot be used with --reproducible; pass a fixed level.")
+				}
+				for _, name := range []string{"sync", "threads", "kdf-target", "recipient", "split-key", "fido2"} {
+					if cmd.Flags().Changed(name) {
+						handleUsageError("--%s cannot be used with --reproducible.", name)
+					}
+				}
+				if stdinData {
+					handleUsageError("Standard input (-) cannot be archived with --reproducible; its content must be known before the archive is written.")
+				}
+				repro = &core.Reproducible{Seed: []byte(seed)}
+				if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+					seconds, err := strconv.ParseInt(epoch, 10, 64)
+					if err != nil || seconds < 0 {
+						handleUsageError("Invalid SOURCE_DATE_EPOCH: %q is not a number of seconds since 1970", epoch)
+					}
+					repro.Epoch = time.Unix(seconds, 0).UTC()
+				}
+			} else if seed != "" {
+				handleUsageError("--seed only applies with --reproducible.")
+			}
+			var snapshot *core.Snapshot
+			if snapshotFile != "" {
+				if syncArchive != "" || reproducible {
+					handleUsageError("--listed-incremental cannot be used with --sync or --reproducible.")
+				}
+				var err error
+				if snapshot, err = core.LoadSnapshot(snapshotFile); err != nil {
+					handleFailure(err, "Failed to read snapshot: %v", err)
+				}
+			}
+			if diffBase != "" && (syncArchive != "" || reproducible || snapshotFile != "") {
+				handleUsageError("--diff-base cannot be used with --sync, --reproducible or --listed-incremental.")
+			}
+			if cmd.Flags().Changed("threads") && syncArchive != "" {
+				handleUsageError("--threads cannot be used with --sync.")
+			}
+			var dictBytes int64
+			if dictSize != "" {
+				size, err := parseByteSize(dictSize)
+				if err != nil {
+					handleUsageError("Invalid dictionary size: %v", err)
+				}
+				if size < core.MinDictSize || size > core.MaxDictSize {
+					handleUsageError("Invalid dictionary size: %s is outside the range of 4K to 1536M", dictSize)
+				}
+				if syncArchive != "" {
+					handleUsageError("--dict-size cannot be used with --sync; the archive keeps its settings.")
+				}
+				if codec == "s2" || codec == "store" {
+					handleUsageError("--dict-size has no effect with --codec %s, which uses no dictionary.", codec)
+				}
+				compress, extract := core.DictMemory(size)
+				if available := core.AvailableMemory(); available != 0 && uint64(compress) > available {
+					pterm.Warning.Printf("A %s dictionary needs about %s of RAM to compress, but only %s is available.\n", formatSize(size), formatSize(compress), formatSize(int64(available)))
+				}
+				pterm.Info.Printf("Extracting this archive will need about %s of RAM.\n", formatSize(extract))
+				dictBytes = size
+			}
+			if comment != "" && syncArchive != "" {
+				handleUsageError("--comment cannot be used with --sync; the existing comment is kept.")
+			}
+			cipherMode = strings.ToLower(cipherMode)
+			if cipherMode != "xchacha20" && cipherMode != "cascade" {
+				handleUsageError("Invalid cipher: %q. Use xchacha20 or cascade.", cipherMode)
+			}
+			if cmd.Flags().Changed("cipher") && syncArchive != "" {
+				handleUsageError("--cipher cannot be used with --sync; the archive keeps its cipher.")
+			}
+			
+			var shareSecret string
+			var shares, sharePaths []string
+			if splitKey != "" {
+				if syncArchive != "" {
+					handleUsageError("--split-key cannot be used with --sync; the archive keeps its keys.")
+				}
+				var threshold, count int
+				if n, _ := fmt.Sscanf(splitKey, "%d/%d", &threshold, &count); n != 2 || fmt.Sprintf("%d/%d", threshold, count) != splitKey {
+					handleUsageError("Invalid --split-key: %q. Use <threshold>/<shares>, e.g. 3/5.", splitKey)
+				}
+				var err error
+				if shareSecret, shares, err = core.NewKeyShares(threshold, count); err != nil {
+					handleUsageError("%v", err)
+				}
+				for i := range shares {
+					sharePath := fmt.Sprintf("%s.%d.share", outputFile, i+1)
+					if _, err := os.Stat(sharePath); err == nil {
+						handleCmdError("Share file %s already exists; refusing to overwrite.", sharePath)
+					}
+					sharePaths = append(sharePaths, sharePath)
+				}
+			}
+
+			password = source.resolve(password)
+			if noEncrypt {
+				if password != "" || keyfile != "" || recipient != "" || len(addPasswords) > 0 || splitKey != "" {
+					handleUsageError("--no-encrypt cannot be combined with --password, --keyfile, --recipient, --add-password or --split-key.")
+				}
+				if syncArchive != "" {
+					handleUsageError("--no-encrypt cannot be used with --sync; the archive keeps its encryption.")
+				}
+				for _, name := range []string{"kdf-memory", "kdf-time", "kdf-threads", "kdf-target", "cipher", "fido2"} {
+					if cmd.Flags().Changed(name) {
+						handleUsageError("--%s has no effect with --no-encrypt; no key is derived.", name)
+					}
+				}
+			} else if recipient != "" {
+				if password != "" || keyfile != "" {
+					handleUsageError("--recipient cannot be combined with --password or --keyfile.")
+				}
+				if syncArchive != "" {
+					handleUsageError("--recipient cannot be used with --sync; the archive keeps its keys.")
+				}
+				secret, err := core.RecipientSecret(recipient)
+				if err != nil {
+					handleUsageError("%v", err)
+				}
+				password = secret
+			} else if syncArchive != "" && unencrypted(syncArchive) {
+				// The archive stays unencrypted; no password is involved.
+				noEncrypt = true
+			} else if splitKey != "" && password == "" && keyfile == "" {
+				// The shares alone open the archive.
+				password, shareSecret = shareSecret, ""
+			} else {
+				if keyfile == "" {
+					promptForPassword(&password, allowWeak)
+				}
+				password = withKeyfile(password, keyfile)
+			}
+			if len(addPasswords) > 0 && syncArchive != "" {
+				handleUsageError("--add-password cannot be used with --sync; the archive keeps its keys.")
+			}
+			for _, extra := range addPasswords {
+				if extra == "" {
+					handleUsageError("--add-password must not be empty.")
+				}
+			}
+			var fido2Binding *core.FIDO2Binding
+			if useFIDO2 {
+				switch {
+				case recipient != "":
+					handleUsageError("--fido2 cannot be combined with --recipient; a recipient needs no secret to encrypt.")
+				case syncArchive != "":
+					handleUsageError("--fido2 cannot be used with --sync; the archive keeps its keys.")
+				case splitKey != "" && shareSecret == "":
+					handleUsageError("--fido2 protects a password or keyfile; pass one with -p or --keyfile.")
+				}
+				fido2Binding, password = bindSecurityKey(password)
+			}
+			secrets := addPasswords
+			if shareSecret != "" {
+				secrets = append(secrets, shareSecret)
+			}
+			if cmd.Flags().Changed("kdf-time") && kdfTime == 0 || cmd.Flags().Changed("kdf-threads") && kdfThreads == 0 {
+				handleUsageError("--kdf-time and --kdf-threads must be at least 1.")
+			}
+			kdf := core.KDFParams{Time: kdfTime, Threads: kdfThreads}
+			if kdfMemory != "" {
+				size, err := parseByteSize(kdfMemory)
+				if err != nil || size/1024 > math.MaxUint32 {
+					handleUsageError("Invalid KDF memory: %q is not a valid size", kdfMemory)
+				}
+				kdf.Memory = uint32(size / 1024)
+			}
+			if kdfTarget != 0 {
+				if kdf.Memory != 0 || kdf.Time != 0 {
+					handleUsageError("--kdf-target chooses memory and passes itself; do not combine it with --kdf-memory or --kdf-time.")
+				}
+				if syncArchive != "" {
+					handleUsageError("--kdf-target cannot be used with --sync; the archive keeps its keys.")
+				}
+				spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start(fmt.Sprintf("Calibrating Argon2 for %s...", kdfTarget))
+				calibrated, err := core.CalibrateKDF(kdfTarget, kdfThreads)
+				spinner.Stop()
+				if err != nil {
+					handleFailure(err, "KDF calibration failed: %v", err)
+				}
+				pterm.Info.Printf("Calibrated KDF: %s\n", calibrated)
+				kdf = calibrated
+			}
+			if kdf != (core.KDFParams{}) {
+				if syncArchive != "" {
+					handleUsageError("--kdf-memory, --kdf-time and --kdf-threads cannot be used with --sync; the archive keeps its keys.")
+				}
+				if kdf.Memory != 0 && kdf.Memory < core.WeakKDFMemory {
+					pterm.Warning.Printf("Argon2 memory below %d MiB weakens resistance to brute-force attacks.\n", core.WeakKDFMemory/1024)
+				}
+			}
+			if level == "auto" {
+				if noEncrypt || syncArchive != "" {
+					// No new key is derived, so only the compression is chosen.
+					level = autoLevel(nil)
+				} else {
+					level = autoLevel(&kdf)
+				}
+			}
+
+			var base *core.DiffBase
+			if diffBase != "" {
+				base = loadDiffBase(diffBase, password, keyfile)
+			}
+
+			pterm.DefaultSection.Println("Initialization")
+			if stdout != nil {
+				pterm.Info.Println("Target: standard output")
+			} else {
+				pterm.Info.Printf("Target: %s\n", outputFile)
+			}
+			pterm.Info.Printf("Profile: %s\n", strings.ToUpper(level))
+			pterm.Info.Printf("Codec: %s\n", strings.ToUpper(codec))
+			if noEncrypt {
+				pterm.Warning.Println("Security: DISABLED (--no-encrypt). The archive will not be encrypted.")
+			} else {
+				pterm.Info.Printf("Security: Enabled (%s)\n", cipherLabel(cipherMode))
+			}
+			if recipient != "" {
+				pterm.Info.Printf("Recipient: %s\n", recipient)
+			}
+			if len(secrets) > 0 {
+				pterm.Info.Printf("Key Slots: %d\n", 1+len(secrets))
+			}
+			if splitKey != "" {
+				pterm.Info.Printf("Key Shares: any %s\n", strings.Replace(splitKey, "/", " of ", 1))
+			}
+			if base != nil {
+				pterm.Info.Printf("Base: %s (fingerprint %s)\n", diffBase, base.Fingerprint())
+			}
+			if repro != nil {
+				pterm.Warning.Println("Reproducible: the same files, password and seed always give the same archive, so anyone holding two of them can tell whether they hold the same content.")
+			}
+			if useFIDO2 {
+				pterm.Info.Println("Security Key: FIDO2 hmac-secret (required to open)")
+			}
+
+			pterm.DefaultSection.Println("Processing")
+			task := "Compressing & Encrypting"
+			if noEncrypt {
+				task = "Compressing"
+			}
+			var stats core.SyncStats
+			var created core.CreateStats
+			var written *countingWriter
+			var err error
+			if syncArchive != "" {
+				spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start(fmt.Sprintf("%s %d inputs...", task, len(args)))
+				stats, err = core.SyncArchive(outputFile, args, password, level, deleteMissing)
+				spinner.Stop()
+			} else {
+				ctx, stop := interruptContext()
+				progress := newByteProgress(task, fmt.Sprintf("%s %d inputs...", task, len(args)))
+				opts := core.CreateOptions{Level: level, Codec: codec, StoreExtensions: storeExts, NoDedup: noDedup, VolumeSize: volumeBytes, DictSize: dictBytes, Threads: threads, NormalizeNames: normalize, Comment: comment, Secrets: secrets, KDF: kdf, Cipher: cipherMode, FIDO2: fido2Binding, Progress: progress.update, NoEncrypt: noEncrypt, Stdin: os.Stdin, StdinName: stdinName, Exclude: excludes, Include: includes, Dereference: dereference, KeepRoot: keepRoot, Reproducible: repro, Snapshot: snapshot, Base: base, NoOverwrite: noOverwrite}
+				if stdout != nil {
+					// The count feeds the report, and keeps the header as written:
+					// the index offset goes into the trailer.
+					written = &countingWriter{w: stdout}
+					created, err = core.CreateArchiveTo(ctx, written, args, password, opts)
+				} else {
+					created, err = core.CreateArchiveContext(ctx, outputFile, args, password, opts)
+				}
+				progress.stop()
+				stop()
+				cleanup := "The partial archive was removed."
+				if stdout != nil {
+					cleanup = "The archive written so far is incomplete."
+				}
+				exitIfInterrupted(err, cleanup)
+			}
+
+			if err != nil {
+				handleFailure(err, "Failed to create archive: %v", err)
+			}
+			if snapshot != nil {
+				if err := snapshot.Save(snapshotFile); err != nil {
+					handleFailure(err, "Archive created, but the snapshot could not be updated: %v", err)
+				}
+			}
+			if signKey != "" {
+				spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start("Signing archive...")
+				err := core.SignArchive(outputFile, signKey)
+				spinner.Stop()
+				if err != nil {
+					handleFailure(err, "Failed to sign archive: %v", err)
+				}
+			}
+			for i, share := range shares {
+				writeKeyFile(sharePaths[i], fmt.Sprintf("# btxz key share %d of %d for %s\n# any %s shares open the archive; give each one to a different holder\n%s\n", i+1, len(shares), filepath.Base(outputFile), strings.Split(splitKey, "/")[0], share), 0600)
+			}
+			
+			duration := time.Since(startTime)
+
+			// Show profile info
+			var profileDesc string
+			switch level {
+			case "low":
+				profileDesc = "Low-End / Fast (level 1)"
+			case "max":
+				profileDesc = "Ultra / Hardened (level 9)"
+			case "default":
+				profileDesc = "Balanced / Standard (level 6)"
+			default:
+				profileDesc = "Level " + level
+			}
+
+			pterm.DefaultSection.Println("Mission Report")
+			pterm.Success.Println("Operation Completed Successfully.")
+			security := cipherLabel(cipherMode) + " (256-bit)"
+			if noEncrypt {
+				pterm.Warning.Println("This archive is NOT encrypted: anyone who has the file can read its contents.")
+				security = "NONE (unencrypted, CRC-32C checksums only)"
+			}
+			if len(created.NotPortable) > 0 {
+				pterm.Warning.Printf("%d name(s) cannot be extracted unchanged on Windows, which reserves device names such as aux.c, names ending in a dot or a space, and <>:\"|?*; extract escapes them there, and with --portable, as %%XX:\n%s\n", len(created.NotPortable), strings.Join(created.NotPortable, "\n"))
+			}
+			
+			archiveName := outputFile
+			if stdout != nil {
+				archiveName = "(standard output)"
+			}
+			data := [][]string{
+				{"Archive", archiveName},
+				{"Security", security},
+				{"Profile", profileDesc},
+				{"Codec", strings.ToUpper(codec)},
+			}
+			if dictBytes > 0 {
+				data = append(data, []string{"Dictionary", formatSize(dictBytes)})
+			}
+			if syncArchive == "" {
+				data = append(data, []string{"Threads", strconv.Itoa(created.Threads)})
+				if created.Threads < threads && repro == nil {
+					pterm.Warning.Printf("Compressed with %d threads instead of %d to stay within the available RAM.\n", created.Threads, threads)
+				}
+			}
+			if syncArchive == "" && !noEncrypt {
+				data = append(data, []string{"KDF", created.KDF.String()})
+			}
+			if codec == "store" {
+				data = append(data, []string{"Compression", "Bypassed (stored)"})
+			}
+			if created.DedupFiles > 0 {
+				data = append(data, []string{"Deduplicated", fmt.Sprintf("%d files (%d bytes saved)", created.DedupFiles, created.DedupBytes)})
+			}
+			if stdinData {
+				data = append(data, []string{"Standard Input", fmt.Sprintf("%d bytes (stored as %s)", created.StdinBytes, stdinName)})
+			}
+			if len(excludes) > 0 || len(includes) > 0 {
+				data = append(data, []string{"Excluded", fmt.Sprintf("%d entries", created.Excluded)})
+			}
+			if in := created.InputBytes + created.StdinBytes; in > 0 && syncArchive == "" {
+				out := archiveSize(outputFile)
+				if written != nil {
+					out = written.n
+				}
+				data = append(data, []string{"Ratio", fmt.Sprintf("%.1f%% (%d -> %d bytes)", float64(out)*100/float64(in), in, out)})
+			}
+			status := "SECURED"
+			if noEncrypt {
+				status = "UNENCRYPTED"
+			}
+			if syncArchive != "" {
+				data = append(data,
+					[]string{"Added", fmt.Sprintf("%d", stats.Added)},
+					[]string{"Updated", fmt.Sprintf("%d", stats.Updated)},
+					[]string{"Unchanged", fmt.Sprintf("%d", stats.Unchanged)},
+					[]string{"Removed", fmt.Sprintf("%d", stats.Removed)},
+				)
+				status = "SYNCED"
+			}
+			if signKey != "" {
+				data = append(data, []string{"Signature", "Ed25519 (" + filepath.Base(signKey) + ")"})
+			}
+			if useFIDO2 {
+				data = append(data, []string{"Security Key", "FIDO2 hmac-secret (required to open)"})
+			}
+			if splitKey != "" {
+				data = append(data, []string{"Key Shares", fmt.Sprintf("%s (%s.1.share ...)", strings.Replace(splitKey, "/", " of ", 1), outputFile)})
+			}
+			if comment != "" {
+				data = append(data, []string{"Comment", comment})
+			}
+			if snapshot != nil {
+				data = append(data, []string{"Backup", backupLabel(created)})
+			}
+			if base != nil {
+				data = append(data, []string{"Base", fmt.Sprintf("%s (%d unchanged files taken from it)", diffBase, created.FromBase)})
+			}
+			if volumeBytes > 0 {
+				data = append(data, []string{"Volumes", fmt.Sprintf("%s.001 ... (max %s each)", outputFile, volumeSize)})
+			}
+			renderSummary(data, duration, status)
+			if jsonOutput.enabled {
+				doc := output.Create{
+					Result:       jsonOutput.result(output.StatusOK),
+					Archive:      outputFile,
+					Inputs:       args,
+					Encrypted:    !noEncrypt,
+					Codec:        codec,
+					Profile:      level,
+					InputBytes:   created.InputBytes + created.StdinBytes,
+					ArchiveBytes: archiveSize(outputFile),
+					Excluded:     created.Excluded,
+					DedupFiles:   created.DedupFiles,
+					DedupBytes:   created.DedupBytes,
+					Signed:       signKey != "",
+					KeyShares:    sharePaths,
+					NotPortable:  created.NotPortable,
+				}
+				if syncArchive != "" {
+					doc.Sync = &output.Sync{Added: stats.Added, Updated: stats.Updated, Unchanged: stats.Unchanged, Removed: stats.Removed}
+				}
+				if snapshot != nil {
+					doc.Incremental = &output.Incremental{Backup: created.Backup, Snapshot: snapshotFile, Unchanged: created.Unchanged, Deleted: created.Deleted}
+				}
+				if base != nil {
+					doc.DiffBase = &output.DiffBase{Archive: diffBase, Fingerprint: base.Fingerprint(), FromBase: created.FromBase}
+				}
+				jsonOutput.write(doc)
+			}
+			keys.offer(outputFile, password)
+		},
+	}
+	createCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Path for the new archive file (default: named after the first input, e.g. photos.btxz)")
+	createCmd.Flags().BoolVar(&noAutoExt, "no-auto-extension", false, "Use --output as given, without adding .btxz to a name that has no extension")
+	createCmd.Flags().StringVarP(&password, "password", "p", "", "Password for encryption (uses BTXZ_PASSWORD or prompts if empty, unless --keyfile is given)")
+	source.addFlags(createCmd)
+	keys.addFlags(createCmd)
+	createCmd.Flags().StringVar(&keyfile, "keyfile", "", "File whose content is mixed into the key, alone or with a password")
+	createCmd.Flags().StringVar(&recipient, "recipient", "", "Encrypt to this public key (from btxz keygen) instead of a password")
+	createCmd.Flags().StringArrayVar(&addPasswords, "add-password", nil, "Another password that opens the archive through its own key slot (repeatable)")
+	createCmd.Flags().StringVar(&kdfMemory, "kdf-memory", "", "Override the profile's Argon2 memory (e.g. 64M, 1G; at least 8M)")
+	createCmd.Flags().Uint32Var(&kdfTime, "kdf-time", 0, "Override the profile's number of Argon2 passes")
+	createCmd.Flags().Uint8Var(&kdfThreads, "kdf-threads", 0, "Override the profile's number of Argon2 threads")
+	createCmd.Flags().BoolVar(&allowWeak, "allow-weak-password", false, "Accept a weak password without asking for confirmation")
+	createCmd.Flags().BoolVar(&noEncrypt, "no-encrypt", false, "Do NOT encrypt the archive; anyone can read it (for public data)")
+	createCmd.Flags().StringVar(&cipherMode, "cipher", "xchacha20", "Payload cipher: xchacha20, or cascade (AES-256-GCM inside XChaCha20-Poly1305)")
+	createCmd.Flags().BoolVar(&useFIDO2, "fido2", false, "Also require the connected FIDO2 security key (hmac-secret) to open the archive")
+	createCmd.Flags().StringVar(&splitKey, "split-key", "", "Split the key into shares, e.g. 3/5: any 3 of the 5 share files written next to the archive open it")
+	createCmd.Flags().StringVar(&signKey, "sign-key", "", "Sign the finished archive with this Ed25519 signing key (from btxz keygen --sign)")
+	createCmd.Flags().DurationVar(&kdfTarget, "kdf-target", 0, "Benchmark Argon2 on this machine and pick memory and passes that take this long (e.g. 1s)")
+	createCmd.Flags().StringVarP(&level, "level", "l", "default", "Profile: low, default, max, auto, or a compression level 0-9")
+	createCmd.Flags().StringVar(&dictSize, "dict-size", "", "Override the dictionary (xz) or window (zstd) size of the level (e.g. 32MiB; 4K to 1536M)")
+	createCmd.Flags().IntVar(&threads, "threads", runtime.GOMAXPROCS(0), "Compress this many blocks in parallel; 1 writes a single stream per segment")
+	createCmd.Flags().StringVar(&codec, "codec", "xz", "Compression backend: xz, zstd, s2 (alias lz4), store, auto")
+	createCmd.Flags().BoolVar(&noDedup, "no-dedup", false, "Store identical files as independent copies")
+	createCmd.Flags().StringSliceVar(&storeExts, "store-ext", nil, "With --codec auto, extra extensions to store uncompressed (e.g. raw,iso)")
+	createCmd.Flags().StringVar(&syncArchive, "sync", "", "Update this existing archive, re-compressing only changed files")
+	createCmd.Flags().BoolVar(&deleteMissing, "delete", false, "With --sync, drop entries whose files no longer exist")
+	createCmd.Flags().StringVar(&volumeSize, "volume-size", "", "Split the archive into volumes of this size (e.g. 3900M, 4G)")
+	createCmd.Flags().StringVar(&normalize, "normalize-names", "none", "Convert entry names to a Unicode normal form: nfc, nfd, none")
+	createCmd.Flags().StringVar(&comment, "comment", "", "Description stored encrypted in the archive (shown by list)")
+	createCmd.Flags().StringVar(&stdinName, "stdin-name", "stdin", "Entry name of the data read from standard input (input -)")
+	createCmd.Flags().StringArrayVar(&excludes, "exclude", nil, "Skip files and folders matching this pattern, e.g. node_modules, '*.o' or 'build/**' (repeatable)")
+	createCmd.Flags().StringArrayVar(&includes, "include", nil, "Store only files matching this pattern, e.g. '**/*.go' (repeatable; --exclude wins)")
+	createCmd.Flags().BoolVar(&dereference, "dereference", false, "Follow symbolic links and store the files and folders they point to")
+	createCmd.Flags().BoolVar(&keepRoot, "keep-root", false, "Store input folders under their own name, e.g. project/a.txt rather than a.txt")
+	createCmd.Flags().BoolVar(&contentsOnly, "contents-only", false, "Store the contents of input folders relative to them, without the folder's name (default)")
+	createCmd.Flags().BoolVar(&reproducible, "reproducible", false, "Write the same bytes for the same files: clamp times to SOURCE_DATE_EPOCH, drop owners, derive keys and nonces from the content")
+	createCmd.Flags().StringVar(&seed, "seed", "", "With --reproducible, mix this value into the derived keys and nonces")
+	createCmd.Flags().StringVar(&snapshotFile, "listed-incremental", "", "Store only files changed since this snapshot file, and update it (created if missing)")
+	createCmd.Flags().StringVar(&diffBase, "diff-base", "", "Store only files that differ from this base archive; restore with extract --base")
+	createCmd.Flags().BoolVar(&force, "force", false, "Replace an existing archive at the output path without asking")
+
+	createCmd.RegisterFlagCompletionFunc("level", completeLevel)
+	return createCmd
+}
+
+// defaultArchiveName names the archive of create without --output after its
+// first input, in the current folder: ./photos gives photos.btxz, and
+// report.pdf gives report.pdf.btxz.
+func defaultArchiveName(input string) string {
+	if input == core.StdinPath {
+		handleUsageError("Name the archive with -o when the first input is standard input (-).")
+	}
+	abs, err := filepath.Abs(input)
+	if err != nil {
+		handleFailure(err, "Cannot resolve the input path: %v", err)
+	}
+	name := filepath.Base(abs)
+	if name == string(filepath.Separator) || name == "." || strings.HasSuffix(name, ":") {
+		handleUsageError("%s has no name to give the archive; name it with -o.", input)
+	}
+	return name + ".btxz"
+}
+
+// replaceArchive reports whether create may replace the archive at
+// outputFile, or the first volume of a split archive: with force, or if the
+// user agrees on a terminal. It exits if the archive exists and the user does
+// not agree. A missing archive gives false, so that one created meanwhile is
+// not replaced either.
+func replaceArchive(outputFile string, split, force bool) bool {
+	if force {
+		return true
+	}
+	path := outputFile
+	if split {
+		path = fmt.Sprintf("%s.001", outputFile)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if !confirm(fmt.Sprintf("%s already exists (%s, modified %s). Replace it?", path, formatSize(info.Size()), info.ModTime().Format("2006-01-02 15:04"))) {
+		handleCmdError("%s already exists; refusing to replace it. Pass --force to overwrite it.", path)
+	}
+	return true
+}
+
+// backupLabel describes what an archive created with --listed-incremental holds.
+func backupLabel(stats core.CreateStats) string {
+	if stats.Backup == core.BackupFull {
+		return "Full (snapshot started)"
+	}
+	return fmt.Sprintf("Incremental (%d unchanged files left out, %d deleted)", stats.Unchanged, stats.Deleted)
+}
+
+// loadDiffBase reads the base archive of a differential archive. It is opened
+// with secret, that of the command, or if that does not open it, with a
+// password asked for.
+func loadDiffBase(archivePath, secret, keyfile string) *core.DiffBase {
+	if unencrypted(archivePath) {
+		secret = ""
+	}
+	spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start(fmt.Sprintf("Reading base archive '%s'...", archivePath))
+	base, err := core.LoadDiffBase(context.Background(), archivePath, secret)
+	spinner.Stop()
+	if errors.Is(err, core.ErrAuthentication) {
+		secret = withKeyfile(readSecret("Password for the base archive"), keyfile)
+		base, err = core.LoadDiffBase(context.Background(), archivePath, secret)
+	}
+	if err != nil {
+		if errors.Is(err, core.ErrAuthentication) {
+			exitWithError(exitAuth, "Access Denied: The password does not open the base archive.")
+		}
+		handleFailure(err, "Failed to read base archive: %v", err)
+	}
+	return base
+}
+
+// parseByteSize parses a size such as "4096", "512K", "3900M", "4G" or "32MiB".
+// Suffixes are binary multiples (K = 1024 bytes).
+func parseByteSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	case strings.HasSuffix(value, "T"):
+		multiplier = 1 << 40
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q is not a valid size", s)
+	}
+	return n * multiplier, nil
+}
+
+// normalizeLevel validates a --level value: a profile name, one of its
+// aliases fast and best, or a compression level 0-9. It returns the canonical
+// profile name or the level.
+func normalizeLevel(level string) string {
+	level = strings.ToLower(strings.TrimSpace(level))
+	switch level {
+	case "fast":
+		return "low"
+	case "best":
+		return "max"
+	case "low", "default", "max", "auto", "0", "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		return level
+	}
+	handleUsageError("Invalid level. Use: low, default, max, auto, or a compression level from 0 to 9.")
+	return ""
+}
+
+// autoLevel resolves --level auto: it reads the resources of this machine,
+// prints the profile it chooses and why, and returns it. The Argon2
+// parameters of the choice fill the fields of kdf left at zero; with a nil
+// kdf, no benchmark is run and only the compression matters.
+func autoLevel(kdf *core.KDFParams) string {
+	spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start("Measuring this machine for --level auto...")
+	choice := core.ChooseLevel(core.ReadMachineStats(kdf != nil))
+	spinner.Stop()
+	pterm.Info.Printf("Auto Level: %s\n", choice.Reason)
+	if kdf != nil {
+		if kdf.Memory == 0 {
+			kdf.Memory = choice.KDF.Memory
+		}
+		if kdf.Time == 0 {
+			kdf.Time = choice.KDF.Time
+		}
+	}
+	return choice.Level
+}
+
+// cipherLabel returns the display name of a --cipher value.
+func cipherLabel(cipherMode string) string {
+	if cipherMode == "cascade" {
+		return "AES-256-GCM inside XChaCha20-Poly1305"
+	}
+	return "XChaCha20-Poly1305"
+}
@@ -0,0 +1,144 @@
+// File: diff.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements the diff command.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+	"btxz/core"
+	"btxz/output"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// NewDiffCmd configures the 'diff' command.
+func NewDiffCmd() *cobra.Command {
+	var password, keyfile, identity string
+	var keyShares []string
+	var source passwordSource
+	var keys keychainOption
+	var content bool
+	diffCmd := &cobra.Command{
+		Use:   "diff <archive.btxz> <directory>",
+		Short: "Compare an archive with a directory",
+		Long: `Compares an archive with a directory as if the archive had been extracted to it,
+and lists what differs, like 'git status --short':
+
+   D  only in the archive (missing from the directory)
+  ??  only in the directory
+   M  modified: a different size or modification time, or with --content a
+      different content; for a symlink, a different target
+   T  a different type, such as a file where the archive has a folder
+
+By default only the listing of the archive is read. --content hashes every file
+instead and compares it with the checksum the archive records; archives that
+record none are decrypted in full. The command exits with status 7 if anything
+differs, so it can gate a script.`,
+		Example: `  btxz diff backup.btxz ./restore
+  btxz diff backup.btxz . --content --password-file pass.txt`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("ARCHIVE COMPARISON")
+			startTime := time.Now()
+			archivePath, dir := args[0], args[1]
+			if archivePath == core.StdinPath {
+				handleUsageError("diff needs an archive file; standard input (-) cannot be compared.")
+			}
+			if info, err := os.Stat(dir); err != nil {
+				handleFailure(err, "Cannot read the directory: %v", err)
+			} else if !info.IsDir() {
+				handleUsageError("%s is not a directory.", dir)
+			}
+
+			password = source.resolve(password)
+			password = keys.lookup(archivePath, password, keyfile)
+			password = unlockSecret(archivePath, password, keyfile, identity, keyShares, "Enter decryption password")
+
+			pterm.DefaultSection.Println("Comparison")
+			ctx, stop := interruptContext()
+			spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start("Comparing the archive with the directory...")
+			stats, err := core.DiffArchiveContext(ctx, archivePath, dir, password, core.DiffOptions{Content: content})
+			spinner.Stop()
+			stop()
+			exitIfInterrupted(err, "The comparison did not finish.")
+			if err != nil {
+				if errors.Is(err, core.ErrAuthentication) {
+					exitWithError(exitAuth, "Access Denied: Incorrect Password.")
+				}
+				handleFailure(err, "Comparison failed: %v", err)
+			}
+
+			counts := make(map[string]int)
+			changes := make([]output.Change, 0, len(stats.Changes))
+			for _, change := range stats.Changes {
+				counts[change.Change]++
+				changes = append(changes, output.Change{Name: change.Name, Change: change.Change})
+				if !jsonOutput.enabled {
+					pterm.Println(diffCode(change.Change) + " " + change.Name)
+				}
+			}
+			status := output.StatusOK
+			if len(stats.Changes) > 0 {
+				status = output.StatusWarning
+			}
+			if jsonOutput.enabled {
+				jsonOutput.write(output.Diff{Result: jsonOutput.result(status), Archive: archivePath, Directory: dir, Content: content, Changes: changes, Unchanged: stats.Unchanged})
+			}
+
+			pterm.DefaultSection.Println("Mission Report")
+			if len(stats.Changes) > 0 {
+				pterm.Warning.Printf("The directory differs from the archive in %d paths.\n", len(stats.Changes))
+			} else {
+				pterm.Success.Println("The directory matches the archive.")
+			}
+			compared := "Size and modification time"
+			if content {
+				compared = "Content (SHA-256)"
+			}
+			data := [][]string{
+				{"Source", archiveLabel(archivePath)},
+				{"Directory", dir},
+				{"Compared", compared},
+				{"Missing", fmt.Sprintf("%d", counts[core.DiffMissing])},
+				{"Added", fmt.Sprintf("%d", counts[core.DiffAdded])},
+				{"Modified", fmt.Sprintf("%d", counts[core.DiffModified])},
+				{"Type Changed", fmt.Sprintf("%d", counts[core.DiffType])},
+				{"Unchanged", fmt.Sprintf("%d", stats.Unchanged)},
+			}
+			verdict := "IDENTICAL"
+			if len(stats.Changes) > 0 {
+				verdict = "DIFFERENT"
+			}
+			renderSummary(data, time.Since(startTime), verdict)
+			keys.offer(archivePath, password)
+			if len(stats.Changes) > 0 {
+				os.Exit(exitDifferent)
+			}
+		},
+	}
+	diffCmd.Flags().BoolVar(&content, "content", false, "Compare file content by SHA-256 instead of size and modification time")
+	addUnlockFlags(diffCmd, &password, &keyfile, &identity, &keyShares)
+	source.addFlags(diffCmd)
+	keys.addFlags(diffCmd)
+	diffCmd.ValidArgsFunction = completeArchive(true)
+	return diffCmd
+}
+
+// diffCode returns the status code of 'git status --short' for a change
+// found by diff, colored like git.
+func diffCode(change string) string {
+	switch change {
+	case core.DiffMissing:
+		return pterm.FgRed.Sprint(" D")
+	case core.DiffAdded:
+		return pterm.FgRed.Sprint("??")
+	case core.DiffType:
+		return pterm.FgYellow.Sprint(" T")
+	}
+	return pterm.FgYellow.Sprint(" M")
+}
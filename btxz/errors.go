@@ -0,0 +1,158 @@
+// File: errors.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file defines the exit statuses of btxz and reports the failures that
+// end a command with them.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"btxz/core"
+	"btxz/internal/remote"
+
+	"github.com/pterm/pterm"
+)
+
+// The exit statuses of btxz. They are part of the command-line interface, so
+// that scripts can tell a wrong password, which another secret may fix, from a
+// damaged archive or a full disk.
+const (
+	exitError       = 1   // A failure of no other class
+	exitUsage       = 2   // Invalid or conflicting flags or arguments
+	exitAuth        = 3   // The password, keyfile, identity or key shares do not open the archive
+	exitIntegrity   = 4   // The archive is damaged or was modified, or its signature does not match
+	exitIO          = 5   // A file could not be read or written: a missing file, permissions, no space left
+	exitPartial     = 6   // The command completed, but skipped files or repair found damage
+	exitDifferent   = 7   // diff found differences
+	exitVerify      = 8   // extract --verify read back files that differ from what was written
+	exitInterrupted = 130 // Stopped by Ctrl-C or SIGTERM, like a process killed by SIGINT
+)
+
+// handleCmdError prints a formatted error message and exits with exitError.
+func handleCmdError(format string, a ...interface{}) {
+	exitWithError(exitError, format, a...)
+}
+
+// handleUsageError reports invalid or conflicting flags or arguments, and
+// exits with exitUsage.
+func handleUsageError(format string, a ...interface{}) {
+	exitWithError(exitUsage, format, a...)
+}
+
+// handleFailure reports err, which stopped the command, and exits with the
+// status of its class.
+func handleFailure(err error, format string, a ...interface{}) {
+	exitIfFailed(failureError(err, format, a...))
+}
+
+// commandError is a failure that ends a command with the exit status code,
+// returned by the parts of a command that run once for each of several
+// archives so that the others still run.
+type commandError struct {
+	code    int
+	message string
+}
+
+func (e *commandError) Error() string {
+	return e.message
+}
+
+// newCommandError returns the error exitWithError would report.
+func newCommandError(code int, format string, a ...interface{}) error {
+	return &commandError{code: code, message: fmt.Sprintf(format, a...)}
+}
+
+// failureError returns the error handleFailure would report for err.
+func failureError(err error, format string, a ...interface{}) error {
+	if hint := limitHint(err); hint != "" {
+		format += ". " + hint
+	}
+	return newCommandError(exitStatus(err), format, a...)
+}
+
+// failureStatus returns the exit status for err: the code of a commandError,
+// else the status of its class.
+func failureStatus(err error) int {
+	var failure *commandError
+	if errors.As(err, &failure) {
+		return failure.code
+	}
+	return exitStatus(err)
+}
+
+// failed returns err with its exit status, for a run that returns both.
+func failed(err error) (int, error) {
+	return failureStatus(err), err
+}
+
+// exitIfFailed reports err, if it is not nil, and exits with its status.
+func exitIfFailed(err error) {
+	if err != nil {
+		exitWithError(failureStatus(err), "%v", err)
+	}
+}
+
+// exitWith ends a command with the exit status code and the result of its
+// run: err, if not nil, is reported first.
+func exitWith(code int, err error) {
+	if err != nil {
+		exitWithError(code, "%v", err)
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+}
+
+// limitHint returns the sentence that tells how to raise the limit err ran
+// into, or "" if err is not one of the limits on reading archives.
+func limitHint(err error) string {
+	var limitErr *core.LimitError
+	switch {
+	case errors.Is(err, core.ErrKDFLimit):
+		return "If you trust the archive, raise the limit with --max-kdf-memory or --max-kdf-time."
+	case errors.As(err, &limitErr):
+		flag := map[string]string{core.LimitOutputSize: "--max-output-size", core.LimitEntries: "--max-entries", core.LimitNameLength: "--max-name-length"}[limitErr.Kind]
+		return fmt.Sprintf("The archive may be a decompression bomb. If you trust it, raise the limit with %s.", flag)
+	}
+	return ""
+}
+
+// exitIfLimited reports an archive that ran into a limit on reading archives,
+// which must not be mistaken for damage.
+func exitIfLimited(err error) {
+	exitIfFailed(limitError(err))
+}
+
+// limitError returns the error exitIfLimited reports for err, or nil.
+func limitError(err error) error {
+	if limitHint(err) != "" {
+		return failureError(err, "Limit Exceeded: %v", err)
+	}
+	return nil
+}
+
+// exitWithError prints a formatted error message and exits with code.
+func exitWithError(code int, format string, a ...interface{}) {
+	pterm.Error.Printf(format+"\n", a...)
+	jsonOutput.fail(code, fmt.Sprintf(format, a...))
+	os.Exit(code)
+}
+
+// exitStatus returns the exit status for err, by the class the core package
+// or the operating system gives it.
+func exitStatus(err error) int {
+	switch {
+	case errors.Is(err, core.ErrAuthentication):
+		return exitAuth
+	case errors.Is(err, core.ErrStreamEnded), errors.Is(err, remote.ErrNetwork), errors.Is(err, fs.ErrNotExist), errors.Is(err, fs.ErrPermission),
+		errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT), errors.Is(err, syscall.EROFS), errors.Is(err, syscall.EIO):
+		return exitIO
+	case errors.Is(err, core.ErrIntegrity):
+		return exitIntegrity
+	}
+	return exitError
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// randomTree writes a folder holding a file of incompressible data, so that
+// most of an archive of it is encrypted payload, and returns its path.
+func randomTree(t *testing.T) string {
+	t.Helper()
+	src := testTree(t)
+	data := make([]byte, 256*1024)
+	rng := rand.New(rand.NewPCG(3, 4))
+	for i := range data {
+		data[i] = byte(rng.Uint32())
+	}
+	if err := os.WriteFile(filepath.Join(src, "random.bin"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+// corruptCopy copies archive with one byte in the middle flipped.
+func corruptCopy(t *testing.T, archive string) string {
+	t.Helper()
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	corrupt := filepath.Join(t.TempDir(), "corrupt.btxz")
+	if err := os.WriteFile(corrupt, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return corrupt
+}
+
+func TestExitCodes(t *testing.T) {
+	src := randomTree(t)
+	archive := createArchive(t, src, "secret")
+	corrupt := corruptCopy(t, archive)
+
+	// A directory already holding hello.txt, which --overwrite never keeps.
+	existing := t.TempDir()
+	if err := os.WriteFile(filepath.Join(existing, "hello.txt"), []byte("mine"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// A copy of the input with a file changed, for diff.
+	changed := t.TempDir()
+	if r := runBTXZ(t, nil, "extract", archive, "-o", changed, "-p", "secret"); r.code != 0 {
+		t.Fatalf("extract exited with %d: %s", r.code, r.stderr)
+	}
+	if err := os.WriteFile(filepath.Join(changed, "hello.txt"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"success", []string{"test", archive, "-p", "secret"}, 0},
+		{"entry not found", []string{"extract", archive, "-o", t.TempDir(), "-p", "secret", "--files", "missing.txt"}, exitError},
+		{"unknown flag", []string{"test", archive, "--no-such-flag"}, exitUsage},
+		{"missing argument", []string{"extract"}, exitUsage},
+		{"conflicting flags", []string{"test", archive, "-p", "secret", "--password-file", "pw"}, exitUsage},
+		{"wrong password", []string{"test", archive, "-p", "wrong"}, exitAuth},
+		{"no password and no terminal", []string{"test", archive}, exitAuth},
+		{"damaged archive", []string{"test", corrupt, "-p", "secret"}, exitIntegrity},
+		{"damaged archive on extract", []string{"extract", corrupt, "-o", t.TempDir(), "-p", "secret"}, exitIntegrity},
+		{"missing archive", []string{"test", filepath.Join(t.TempDir(), "missing.btxz"), "-p", "secret"}, exitIO},
+		{"nothing to repair", []string{"repair", archive, "-o", t.TempDir(), "-p", "secret"}, 0},
+		{"damage repaired", []string{"repair", corrupt, "-o", t.TempDir(), "-p", "secret"}, exitPartial},
+		{"kept files", []string{"extract", archive, "-o", existing, "-p", "secret", "--overwrite", "never"}, exitPartial},
+		{"no differences", []string{"diff", archive, src, "-p", "secret"}, 0},
+		{"differences", []string{"diff", archive, changed, "-p", "secret"}, exitDifferent},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if r := runBTXZ(t, nil, test.args...); r.code != test.code {
+				t.Errorf("exit code %d, want %d: %s", r.code, test.code, r.stderr)
+			}
+		})
+	}
+}
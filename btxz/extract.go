@@ -0,0 +1,565 @@
+// File: extract.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements the extract command and its reports.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+	"btxz/core"
+	"btxz/internal/remote"
+	"btxz/output"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// NewExtractCmd configures the 'extract' command.
+func NewExtractCmd() *cobra.Command {
+	var (
+		outputDir string
+		password  string
+		source        passwordSource
+		keys          keychainOption
+		keyfile   string
+		identity  string
+		keyShares []string
+		signature     signatureCheck
+		files         []string
+		noTimes       bool
+		preserveOwner bool
+		normalize     string
+		threads       int
+		overwrite     string
+		dryRun        bool
+		verify        bool
+		incremental   bool
+		baseArchive   string
+		fetch         remoteOption
+		cleanupOnError bool
+		caseCollisions string
+		portable       bool
+		noSpaceCheck   bool
+	)
+	extractCmd := &cobra.Command{
+		Use:   "extract <archive.btxz>",
+		Short: "Extract files from an archive",
+		Long: `Decompresses and decrypts a .btxz archive into the specified directory. Automatically detects v1, v2, v3, and v4 formats.
+
+Use --files to restore only specific entries. For v4 archives only the data holding
+those entries is decrypted and decompressed; older formats are scanned until they are found.
+A --files value holding *, ? or [ is a pattern, matched like the create filters: ** spans
+folders, and a pattern without a slash matches names at any depth. The folders a matched
+file needs are created even if they do not match. Names and patterns that match nothing
+are listed, and the command fails.
+
+The archive is read by one thread while --threads N (default: all CPUs) write small files
+in parallel, which speeds up restoring many small files. --threads 1 writes one at a time.
+
+EXISTING FILES:
+  --overwrite decides what happens to a file that already exists where an entry goes:
+    never  : keep the file and skip the entry; the command then exits with status 1.
+    always : replace the file.
+    newer  : replace the file only if the archived entry was modified more recently.
+    prompt : ask for each file; "all" and "none" answer for the remaining files.
+  The default is prompt on a terminal and never otherwise, so a script cannot lose data.
+  Folders are always merged. The report counts the files kept and overwritten.
+
+DRY RUN:
+  --dry-run reads the whole archive and shows, for every entry, what extraction would do:
+    create    : nothing exists at its path yet.
+    overwrite : it would replace the existing file.
+    ask       : the file exists, and --overwrite prompt would ask about it.
+    skip      : the --overwrite policy would keep the existing file, or its name collides
+                (see NAME COLLISIONS).
+    reject    : its path is unsafe, e.g. it leads outside the output directory.
+  Nothing is written. Every chunk is still authenticated and every file checked against
+  its checksum, so a dry run also tests the archive before it is restored.
+
+VERIFY:
+  --verify reads every extracted file back once everything is written, and compares
+  it with the content that was written to it. The content is hashed on its way to disk,
+  so the archive is not read a second time and no password is asked for again. Files
+  that differ are listed, and the command exits with status 8.
+
+INCREMENTAL BACKUPS:
+  --incremental applies a backup made with create --listed-incremental: its files are
+  extracted, replacing older copies (--overwrite defaults to always), and the entries it
+  records as deleted are removed from the output directory. Extract the full backup, then
+  every incremental one in the order they were made. A folder is only removed once it is
+  empty. It cannot be combined with --files.
+
+DIFFERENTIAL ARCHIVES:
+  --base <full.btxz> restores an archive made with create --diff-base: its own files are
+  extracted, then the unchanged ones are taken from the base, which must be the archive it
+  was created against; any other is refused before anything is written. The base is opened
+  with the same password or keyfile, or asks for its own. Without --base only the changed
+  files are restored, and the command exits with status 6. Not with --files, - or a URL.
+
+CLEANUP ON ERROR:
+  --cleanup-on-error records every file, link and folder the run creates and, if extraction
+  fails, as on a damaged archive, a full disk or a denied permission, removes them again, so
+  that re-running does not layer new files over a half-restored tree. Folders are removed
+  once empty; what cannot be removed is listed. It is on by default when the output
+  directory does not exist yet; --cleanup-on-error=false keeps what was written. An
+  interrupted run keeps its files and lists them.
+
+NAME COLLISIONS:
+  Names that differ only by case, such as README and Readme, or only by Unicode normal
+  form, name the same file on the default file systems of macOS and Windows, so one entry
+  would replace the other. When the output directory's file system ignores the difference,
+  --case-collisions decides what happens to the later entry:
+    rename : write it as name~2.ext, and list what was renamed (the default).
+    skip   : leave it out and list it; the command then exits with status 6.
+    error  : extract nothing and list every colliding pair (for v4 archives read from a
+             file; otherwise extraction stops at the first collision).
+  Folders that differ only by case are merged. list warns about such names.
+
+WINDOWS NAMES:
+  Windows cannot hold device names such as con.txt, aux.c or prn, names that end in a dot
+  or a space, or names with < > : " | ? * or control characters. On Windows, and anywhere
+  with --portable, such path elements are escaped by writing those characters, the first
+  letter of a device name, and any % of the element as %XX, their hex code: aux.c becomes
+  %61ux.c and "notes?" notes%3F. Decoding %XX gives the name back; the report lists every
+  name escaped. create warns about names that will be escaped.
+
+FREE SPACE:
+  Before anything is written, the size of the files to extract, taken from the index of a
+  v4 archive, is compared with the free space of the file system the output directory is
+  on. Files already in place count only for what an entry adds to them. If it falls short,
+  the command exits with status 5 and says by how much; --no-space-check extracts anyway.
+  Archives read from standard input or a URL, and older formats, are not checked, nor are
+  platforms that do not report free space.` + urlHelp,
+		Example: `  btxz extract data.btxz -o ./restored_data
+  btxz extract release.btxz --identity key.txt -o ./release
+  btxz extract nightly.btxz --keyfile /mnt/secure/backup.key -o ./restored
+  btxz extract backup.btxz --files config/app.yaml -o ./restored
+  btxz extract backup.btxz -o out --files 'etc/**' --files 'home/*/.ssh/*'
+  btxz extract nightly.btxz -o ./projects --overwrite newer
+  btxz extract nightly.btxz -o ./projects --overwrite newer --dry-run
+  btxz extract backup.btxz -o /mnt/nas/restore --verify
+  btxz extract home-2024-05-02.btxz -o ./home --incremental
+  btxz extract diff.btxz --base full.btxz -o ./data
+  curl -s https://example.com/backup.btxz | btxz extract - -o ./restore --password-file pass.txt
+  btxz extract https://example.com/backup.btxz -o ./restore --header "Authorization: Bearer $TOKEN"`,
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("ARCHIVE EXTRACTION")
+			startTime := time.Now()
+			archivePath := args[0]
+			if threads < 1 {
+				handleUsageError("--threads must be at least 1.")
+			}
+			if verify && dryRun {
+				handleUsageError("--verify checks written files, and --dry-run writes none; use one of them.")
+			}
+			if incremental && len(files) > 0 {
+				handleUsageError("--incremental applies a whole backup; it cannot be combined with --files.")
+			}
+			if baseArchive != "" && (len(files) > 0 || archivePath == core.StdinPath || remote.IsURL(archivePath)) {
+				handleUsageError("--base restores a whole archive file; it cannot be combined with --files, - or a URL.")
+			}
+			stream := fetch.openStream(archivePath, source, keys, signature.verifyKey != "" || signature.require)
+			// Prompts read standard input, or the terminal if it carries the archive.
+			interactive := term.IsTerminal(int(os.Stdin.Fd())) || stdinData && term.IsTerminal(int(os.Stderr.Fd()))
+			switch overwrite {
+			case "":
+				overwrite = core.OverwriteNever
+				if incremental {
+					// A later backup replaces what the earlier ones restored.
+					overwrite = core.OverwriteAlways
+				} else if interactive {
+					overwrite = core.OverwritePrompt
+				}
+			case core.OverwritePrompt:
+				if !interactive && !dryRun {
+					handleUsageError("--overwrite prompt needs a terminal; use never, always or newer.")
+				}
+			case core.OverwriteNever, core.OverwriteAlways, core.OverwriteNewer:
+			default:
+				handleUsageError("Invalid --overwrite. Use: never, always, newer, or prompt.")
+			}
+			switch caseCollisions {
+			case core.CaseCollisionRename, core.CaseCollisionSkip, core.CaseCollisionError:
+			default:
+				handleUsageError("Invalid --case-collisions. Use: rename, skip, or error.")
+			}
+
+			signatureStatus, err := signature.verify(archivePath)
+			exitIfFailed(err)
+			password = source.resolve(password)
+			password = keys.lookup(archivePath, password, keyfile)
+			if stream != nil {
+				password = unlockStream(stream, password, keyfile, identity, keyShares, "Enter decryption password")
+			} else {
+				password = unlockSecret(archivePath, password, keyfile, identity, keyShares, "Enter decryption password")
+			}
+			var base *core.DiffBase
+			if baseArchive != "" {
+				base = loadDiffBase(baseArchive, password, keyfile)
+			}
+
+			if !cmd.Flags().Changed("cleanup-on-error") {
+				// A folder this run creates holds nothing but what it wrote.
+				_, err := os.Stat(outputDir)
+				cleanupOnError = errors.Is(err, fs.ErrNotExist)
+			}
+
+			pterm.DefaultSection.Println("Processing")
+			ctx, stop := interruptContext()
+			progress := newByteProgress("Extracting", fmt.Sprintf("Decrypting '%s'...", archiveLabel(archivePath)))
+			opts := core.ExtractOptions{Names: files, NoTimes: noTimes, PreserveOwner: preserveOwner, NormalizeNames: normalize, Threads: threads, Progress: progress.extract, Overwrite: overwrite, ConfirmOverwrite: overwritePrompt(progress), DryRun: dryRun, Verify: verify, ApplyDeletions: incremental, Base: base, CleanupOnError: cleanupOnError, CaseCollisions: caseCollisions, PortableNames: portable, NoSpaceCheck: noSpaceCheck}
+			var extracted core.ExtractStats
+			if stream != nil {
+				extracted, err = stream.Extract(ctx, outputDir, password, opts)
+			} else {
+				extracted, err = core.ExtractArchiveContext(ctx, archivePath, outputDir, password, opts)
+			}
+			progress.stop()
+			stop()
+			if dryRun {
+				exitIfInterrupted(err, "Nothing was written.")
+			}
+			if errors.Is(err, context.Canceled) {
+				reportInterruptedExtract(extracted)
+			}
+
+			if err != nil {
+				reportCleanup(extracted, cleanupOnError && !dryRun)
+				exitIfStreamEnded(err)
+				if errors.Is(err, core.ErrAuthentication) {
+					exitWithError(exitAuth, "Access Denied: Incorrect Password or Corrupted Archive.")
+				}
+				var space *core.SpaceError
+				if errors.As(err, &space) {
+					exitWithError(exitIO, "Not enough free space in %s: the files need %s, but only %s are available (%s short). Nothing was written; free up space, extract elsewhere, or pass --no-space-check.", space.Dir, formatSize(space.Need), formatSize(int64(space.Free)), formatSize(space.Short()))
+				}
+				var collision *core.CollisionError
+				if errors.As(err, &collision) {
+					handleCmdError("%v. Pass --case-collisions rename or skip to extract them anyway.", err)
+				}
+				handleFailure(err, "Critical Error: %v", err)
+			}
+
+			if jsonOutput.enabled {
+				jsonOutput.write(extractDocument(archivePath, outputDir, signatureStatus, dryRun, overwrite, extracted))
+			}
+			duration := time.Since(startTime)
+			if dryRun {
+				reportDryRun(archivePath, outputDir, signatureStatus, overwrite, extracted, duration)
+				keys.offer(archivePath, password)
+				return
+			}
+			pterm.DefaultSection.Println("Mission Report")
+
+			keptExisting := overwrite == core.OverwriteNever && len(extracted.Kept) > 0
+			if extracted.BaseMissing > 0 {
+				pterm.Warning.Printf("This is a differential archive: %d unchanged files are in its base archive; pass it with --base to restore them.\n", extracted.BaseMissing)
+			}
+			if len(extracted.Corrupted) > 0 {
+				pterm.Error.Println("Checksum Mismatch: some files differ from the archived content.")
+			} else if len(extracted.VerifyFailed) > 0 {
+				pterm.Error.Println("Verification Failed: some files on disk differ from what was written.")
+			} else if len(extracted.Skipped) > 0 || len(extracted.OwnerSkipped) > 0 || keptExisting || extracted.BaseMissing > 0 || len(extracted.Renamed) > 0 || len(extracted.Collided) > 0 || len(extracted.Escaped) > 0 {
+				pterm.Warning.Println("Operation Completed with Warnings.")
+			} else {
+				pterm.Success.Println("All files extracted successfully.")
+			}
+			if len(extracted.Skipped) > 0 {
+				pterm.DefaultBox.WithTitle("Skipped Files (Safe Mode)").WithBoxStyle(pterm.NewStyle(pterm.FgYellow)).Println(
+					strings.Join(extracted.Skipped, "\n"),
+				)
+			}
+			if len(extracted.OwnerSkipped) > 0 {
+				pterm.DefaultBox.WithTitle("Ownership Not Restored").WithBoxStyle(pterm.NewStyle(pterm.FgYellow)).Println(
+					strings.Join(extracted.OwnerSkipped, "\n"),
+				)
+			}
+			if len(extracted.Renamed) > 0 {
+				renamed := make([]string, len(extracted.Renamed))
+				for i, entry := range extracted.Renamed {
+					renamed[i] = fmt.Sprintf("%s -> %s", entry.Name, entry.As)
+				}
+				pterm.DefaultBox.WithTitle("Renamed (Name Collision)").WithBoxStyle(pterm.NewStyle(pterm.FgYellow)).Println(
+					strings.Join(renamed, "\n"),
+				)
+			}
+			if len(extracted.Escaped) > 0 {
+				escaped := make([]string, len(extracted.Escaped))
+				for i, entry := range extracted.Escaped {
+					escaped[i] = fmt.Sprintf("%s -> %s", entry.Name, entry.As)
+				}
+				pterm.DefaultBox.WithTitle("Renamed for Windows").WithBoxStyle(pterm.NewStyle(pterm.FgYellow)).Println(
+					strings.Join(escaped, "\n"),
+				)
+			}
+			if len(extracted.Collided) > 0 {
+				pterm.DefaultBox.WithTitle("Skipped (Name Collision)").WithBoxStyle(pterm.NewStyle(pterm.FgYellow)).Println(
+					strings.Join(extracted.Collided, "\n"),
+				)
+			}
+			if keptExisting {
+				pterm.DefaultBox.WithTitle("Existing Files Kept (--overwrite never)").WithBoxStyle(pterm.NewStyle(pterm.FgYellow)).Println(
+					strings.Join(extracted.Kept, "\n"),
+				)
+			}
+			status := "RESTORED"
+			if len(extracted.Corrupted) > 0 {
+				pterm.DefaultBox.WithTitle("Checksum Mismatch").WithBoxStyle(pterm.NewStyle(pterm.FgRed)).Println(
+					strings.Join(extracted.Corrupted, "\n"),
+				)
+				status = "CORRUPTED"
+			}
+			if len(extracted.VerifyFailed) > 0 {
+				pterm.DefaultBox.WithTitle("Verification Failed").WithBoxStyle(pterm.NewStyle(pterm.FgRed)).Println(
+					strings.Join(extracted.VerifyFailed, "\n"),
+				)
+				if status == "RESTORED" {
+					status = "VERIFY FAILED"
+				}
+			}
+
+			data := [][]string{
+				{"Source", archiveLabel(archivePath)},
+				{"Destination", outputDir},
+			}
+			if signatureStatus != "" {
+				data = append(data, []string{"Signature", signatureStatus})
+			}
+			if verify {
+				data = append(data, []string{"Verified", fmt.Sprintf("%d files read back, %d differ", extracted.Verified, len(extracted.VerifyFailed))})
+			}
+			if incremental {
+				data = append(data, []string{"Deleted", fmt.Sprintf("%d entries recorded as deleted", len(extracted.Deleted))})
+			}
+			if base != nil {
+				data = append(data, []string{"From Base", fmt.Sprintf("%d files (%s)", len(extracted.FromBase), baseArchive)})
+			}
+			if len(extracted.Overwritten) > 0 || len(extracted.Kept) > 0 {
+				data = append(data,
+					[]string{"Overwritten", fmt.Sprintf("%d files", len(extracted.Overwritten))},
+					[]string{"Kept", fmt.Sprintf("%d existing files (--overwrite %s)", len(extracted.Kept), overwrite)},
+				)
+			}
+			renderSummary(data, duration, status)
+			if len(extracted.Corrupted) > 0 {
+				os.Exit(exitIntegrity)
+			}
+			if len(extracted.VerifyFailed) > 0 {
+				os.Exit(exitVerify)
+			}
+			keys.offer(archivePath, password)
+			if len(extracted.Skipped) > 0 || keptExisting || extracted.BaseMissing > 0 || len(extracted.Collided) > 0 {
+				os.Exit(exitPartial)
+			}
+		},
+	}
+	extractCmd.Flags().StringVarP(&outputDir, "output-dir", "o", ".", "Directory to extract files to")
+	addUnlockFlags(extractCmd, &password, &keyfile, &identity, &keyShares)
+	source.addFlags(extractCmd)
+	keys.addFlags(extractCmd)
+	signature.addFlags(extractCmd)
+	fetch.addFlags(extractCmd)
+	extractCmd.Flags().StringArrayVar(&files, "files", nil, "Extract only this entry, or the entries matching this pattern, e.g. 'etc/**' (repeatable)")
+	extractCmd.Flags().BoolVar(&noTimes, "no-times", false, "Do not restore modification times; extracted files get the current time")
+	// Like GNU tar, owners are restored by default only for the superuser.
+	extractCmd.Flags().StringVar(&normalize, "normalize-names", "none", "Convert file names to a Unicode normal form: nfc, nfd, none")
+	extractCmd.Flags().BoolVar(&preserveOwner, "preserve-owner", os.Geteuid() == 0, "Restore the archived owner and group of every file (default on when run as root)")
+	extractCmd.Flags().IntVar(&threads, "threads", runtime.GOMAXPROCS(0), "Write this many small files in parallel; 1 writes one at a time")
+	extractCmd.Flags().BoolVar(&incremental, "incremental", false, "Apply an incremental backup: overwrite older files and remove the entries it records as deleted")
+	extractCmd.Flags().StringVar(&baseArchive, "base", "", "Base archive of a differential archive, to take its unchanged files from")
+	extractCmd.Flags().BoolVar(&cleanupOnError, "cleanup-on-error", false, "Remove every file and folder this run created if it fails (default on when the output directory does not exist yet)")
+	extractCmd.Flags().StringVar(&caseCollisions, "case-collisions", core.CaseCollisionRename, "Names that differ only by case or Unicode form, where the file system ignores it: rename, skip, or error")
+	extractCmd.Flags().BoolVar(&noSpaceCheck, "no-space-check", false, "Extract even if the output directory's file system looks too small for the files")
+	extractCmd.Flags().BoolVar(&portable, "portable", false, "Escape names Windows cannot hold, as it does on Windows: device names such as aux.c, trailing dots and spaces, <>:\"|?*")
+	extractCmd.Flags().StringVar(&overwrite, "overwrite", "", "Existing files: never, always, newer, or prompt (default: prompt on a terminal, never otherwise)")
+	extractCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be created, overwritten, skipped or rejected, without writing anything")
+	extractCmd.Flags().BoolVar(&verify, "verify", false, "Read every extracted file back and check it against what was written")
+	extractCmd.ValidArgsFunction = completeArchive(false)
+	return extractCmd
+}
+
+// reportDryRun prints the action extract --dry-run decided on for every entry,
+// and exits with exitIntegrity if a file does not match its checksum.
+func reportDryRun(archivePath, outputDir, signatureStatus, overwrite string, planned core.ExtractStats, duration time.Duration) {
+	pterm.DefaultSection.Println("Dry Run")
+	counts := make(map[string]int)
+	tableData := pterm.TableData{{"Action", "Name"}}
+	for _, entry := range planned.Planned {
+		counts[entry.Action]++
+		tableData = append(tableData, []string{entry.Action, entry.Name})
+	}
+	if len(planned.Planned) > 0 {
+		pterm.DefaultTable.WithHasHeader().WithBoxed().WithData(tableData).Render()
+	}
+
+	status := "NOTHING WRITTEN"
+	if len(planned.Corrupted) > 0 {
+		pterm.Error.Println("Checksum Mismatch: some files differ from the archived content.")
+		pterm.DefaultBox.WithTitle("Checksum Mismatch").WithBoxStyle(pterm.NewStyle(pterm.FgRed)).Println(
+			strings.Join(planned.Corrupted, "\n"),
+		)
+		status = "CORRUPTED"
+	} else {
+		pterm.Success.Println("Archive read and authenticated; nothing was written.")
+	}
+	data := [][]string{
+		{"Source", archiveLabel(archivePath)},
+		{"Destination", outputDir},
+	}
+	if signatureStatus != "" {
+		data = append(data, []string{"Signature", signatureStatus})
+	}
+	data = append(data,
+		[]string{"Create", fmt.Sprintf("%d entries", counts[core.PlanCreate])},
+		[]string{"Overwrite", fmt.Sprintf("%d files", counts[core.PlanOverwrite])},
+	)
+	if counts[core.PlanAsk] > 0 {
+		data = append(data, []string{"Ask", fmt.Sprintf("%d files (--overwrite prompt)", counts[core.PlanAsk])})
+	}
+	if len(planned.Deleted) > 0 {
+		data = append(data, []string{"Delete", fmt.Sprintf("%d entries recorded as deleted (--incremental)", len(planned.Deleted))})
+	}
+	if len(planned.FromBase) > 0 {
+		data = append(data, []string{"From Base", fmt.Sprintf("%d files", len(planned.FromBase))})
+	}
+	if len(planned.Renamed) > 0 || len(planned.Collided) > 0 {
+		data = append(data, []string{"Name Collisions", fmt.Sprintf("%d renamed, %d skipped (--case-collisions)", len(planned.Renamed), len(planned.Collided))})
+	}
+	data = append(data,
+		[]string{"Skip", fmt.Sprintf("%d existing files (--overwrite %s)", counts[core.PlanSkip]-len(planned.Collided), overwrite)},
+		[]string{"Reject", fmt.Sprintf("%d unsafe paths", counts[core.PlanReject])},
+	)
+	renderSummary(data, duration, status)
+	if len(planned.Corrupted) > 0 {
+		os.Exit(exitIntegrity)
+	}
+}
+
+// reportCleanup tells, after a failed extraction, whether --cleanup-on-error
+// removed what it had written, and lists the paths it could not remove.
+func reportCleanup(stats core.ExtractStats, requested bool) {
+	switch {
+	case !requested:
+		if len(stats.Extracted) > 0 {
+			pterm.Info.Printf("Cleanup: not requested; the %d entries written so far were kept. Pass --cleanup-on-error to remove them on failure.\n", len(stats.Extracted))
+		}
+	case len(stats.CleanupFailed) == 0:
+		pterm.Info.Println("Cleanup: every file and folder this run created was removed.")
+	default:
+		pterm.Warning.Printf("Cleanup: %d paths this run created could not be removed:\n", len(stats.CleanupFailed))
+		for _, path := range stats.CleanupFailed {
+			fmt.Fprintln(os.Stderr, "  "+path)
+		}
+	}
+}
+
+// reportInterruptedExtract lists the entries an interrupted extraction wrote in
+// full, and the file it removed as incomplete, and exits like
+// exitIfInterrupted.
+func reportInterruptedExtract(stats core.ExtractStats) {
+	if len(stats.Extracted) > 0 {
+		pterm.DefaultSection.Println("Written Before the Interrupt")
+		for _, name := range stats.Extracted {
+			fmt.Fprintln(os.Stderr, "  "+name)
+		}
+	}
+	cleanup := fmt.Sprintf("%d entries were written in full.", len(stats.Extracted))
+	if stats.Truncated != "" {
+		cleanup += fmt.Sprintf(" %s was being written and was removed as incomplete.", stats.Truncated)
+	}
+	exitIfInterrupted(context.Canceled, cleanup)
+}
+
+// exitIfInterrupted reports a run stopped by interruptContext, with what was
+// cleaned up, and exits with the status of a process killed by SIGINT.
+func exitIfInterrupted(err error, cleanup string) {
+	if errors.Is(err, context.Canceled) {
+		pterm.Warning.Println(strings.TrimSpace("Interrupted. " + cleanup))
+		jsonOutput.fail(exitInterrupted, "interrupted")
+		os.Exit(exitInterrupted)
+	}
+}
+
+// extractDocument returns the document of extract --json. Files kept by
+// --overwrite never, unsafe paths and name collisions make it a warning;
+// checksum mismatches an error.
+func extractDocument(archivePath, outputDir, signatureStatus string, dryRun bool, overwrite string, extracted core.ExtractStats) output.Extract {
+	doc := output.Extract{
+		Archive:          archivePath,
+		Destination:      outputDir,
+		DryRun:           dryRun,
+		Signature:        signatureStatus,
+		Extracted:        len(extracted.Extracted),
+		Overwritten:      len(extracted.Overwritten),
+		Skipped:          make([]output.Skipped, 0, len(extracted.Skipped)+len(extracted.Kept)+len(extracted.Collided)),
+		Corrupted:        append([]string{}, extracted.Corrupted...),
+		OwnerNotRestored: append([]string{}, extracted.OwnerSkipped...),
+		VerifyFailed:     extracted.VerifyFailed,
+		Deleted:          extracted.Deleted,
+		FromBase:         extracted.FromBase,
+		BaseMissing:      extracted.BaseMissing,
+	}
+	for _, name := range extracted.Skipped {
+		doc.Skipped = append(doc.Skipped, output.Skipped{Name: name, Reason: output.ReasonUnsafePath})
+	}
+	for _, name := range extracted.Kept {
+		doc.Skipped = append(doc.Skipped, output.Skipped{Name: name, Reason: output.ReasonKeptExisting})
+	}
+	for _, name := range extracted.Collided {
+		doc.Skipped = append(doc.Skipped, output.Skipped{Name: name, Reason: output.ReasonNameCollision})
+	}
+	for _, entry := range extracted.Renamed {
+		doc.Renamed = append(doc.Renamed, output.Renamed{Name: entry.Name, As: entry.As})
+	}
+	for _, entry := range extracted.Escaped {
+		doc.Escaped = append(doc.Escaped, output.Renamed{Name: entry.Name, As: entry.As})
+	}
+	for _, entry := range extracted.Planned {
+		doc.Planned = append(doc.Planned, output.Planned{Name: entry.Name, Action: entry.Action})
+	}
+	status := output.StatusOK
+	if len(extracted.Skipped) > 0 || len(extracted.OwnerSkipped) > 0 || !dryRun && overwrite == core.OverwriteNever && len(extracted.Kept) > 0 || extracted.BaseMissing > 0 || len(extracted.Renamed) > 0 || len(extracted.Collided) > 0 || len(extracted.Escaped) > 0 {
+		status = output.StatusWarning
+	}
+	doc.Result = jsonOutput.result(status)
+	if len(extracted.Corrupted) > 0 {
+		doc.Result = jsonOutput.result(output.StatusError)
+		doc.Error = &output.Error{Message: fmt.Sprintf("%d files do not match their checksum", len(extracted.Corrupted)), ExitCode: exitIntegrity}
+	} else if len(extracted.VerifyFailed) > 0 {
+		doc.Result = jsonOutput.result(output.StatusError)
+		doc.Error = &output.Error{Message: fmt.Sprintf("%d files differ from what was written", len(extracted.VerifyFailed)), ExitCode: exitVerify}
+	}
+	return doc
+}
+
+// overwritePrompt returns the core.ExtractOptions.ConfirmOverwrite function of
+// --overwrite prompt. The answers all and none apply to every later file.
+func overwritePrompt(progress *byteProgress) func(string, os.FileInfo) bool {
+	var decided, replace bool
+	return func(name string, existing os.FileInfo) bool {
+		if decided {
+			return replace
+		}
+		progress.pause()
+		question := fmt.Sprintf("'%s' already exists (%s, modified %s). Overwrite it?", name, formatSize(existing.Size()), existing.ModTime().Format("2006-01-02 15:04"))
+		switch choose(question, []string{"yes", "no", "all", "none"}) {
+		case "yes":
+			return true
+		case "all":
+			decided, replace = true, true
+			return true
+		case "none":
+			decided = true
+		}
+		return false
+	}
+}
@@ -0,0 +1,215 @@
+// File: info.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements the info command and the description of an
+// archive's settings it shares with create.
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"btxz/core"
+	"btxz/output"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// NewInfoCmd configures the 'info' command.
+func NewInfoCmd() *cobra.Command {
+	infoCmd := &cobra.Command{
+		Use:   "info <archive.btxz>",
+		Short: "Show what the header of an archive tells, without a password",
+		Long: `Reads only the plaintext header of an archive and shows its format version,
+cipher, Argon2 parameters, compression and size, and whether it has an index and
+a signature. No password is needed and nothing is decrypted, so it shows what a
+key derivation will cost before it starts. A warning is shown if the Argon2
+memory is more than the RAM available on this machine.`,
+		Example: `  btxz info backup.btxz
+  btxz info backup.btxz --json`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("ARCHIVE INFO")
+			archivePath := args[0]
+			if archivePath == core.StdinPath {
+				handleUsageError("info needs an archive file; standard input (-) cannot be read without consuming it.")
+			}
+			header, err := core.ReadHeaderInfo(archivePath)
+			if err != nil {
+				handleFailure(err, "Could not read the archive header: %v", err)
+			}
+
+			kdfMemory := int64(header.Argon2Memory) * 1024
+			available := core.AvailableMemory()
+			tooLarge := header.Encrypted && available != 0 && uint64(kdfMemory) > available
+			slots := make([]string, 0, len(header.KeySlots))
+			for _, slot := range header.KeySlots {
+				slots = append(slots, slotLabel(slot))
+			}
+			if jsonOutput.enabled {
+				status := output.StatusOK
+				if tooLarge {
+					status = output.StatusWarning
+				}
+				doc := output.Header{
+					Result:    jsonOutput.result(status),
+					Archive:   archivePath,
+					Version:   header.Version,
+					Size:      header.Size,
+					Volumes:   header.Volumes,
+					Encrypted: header.Encrypted,
+					Cipher:    header.Cipher,
+					Codec:     header.Codec,
+					Profile:   header.Profile,
+					DictSize:  header.DictSize,
+					ChunkSize: header.ChunkSize,
+					Index:     header.Index,
+					Signed:    header.Signed,
+					KeySlots:  slots,
+				}
+				if header.Encrypted {
+					doc.KDF = &output.KDF{Time: header.Argon2Time, MemoryKiB: header.Argon2Memory, Threads: header.Argon2Threads}
+				}
+				jsonOutput.write(doc)
+			}
+
+			yesNo := func(b bool) string {
+				if b {
+					return "Yes"
+				}
+				return "No"
+			}
+			size := formatSize(header.Size)
+			if header.Volumes > 1 {
+				size = fmt.Sprintf("%s in %d volumes", size, header.Volumes)
+			}
+			data := [][]string{
+				{"Archive", archiveLabel(archivePath)},
+				{"Format", fmt.Sprintf("v%d", header.Version)},
+				{"Size", size},
+			}
+			if header.Encrypted {
+				data = append(data,
+					[]string{"Cipher", header.Cipher},
+					[]string{"Argon2id", fmt.Sprintf("memory %s, time %d, threads %d", formatSize(kdfMemory), header.Argon2Time, header.Argon2Threads)},
+				)
+				if len(slots) > 0 {
+					data = append(data, []string{"Unlocks With", strings.Join(slots, "\n")})
+				}
+			} else {
+				data = append(data, []string{"Cipher", "None (not encrypted)"})
+			}
+			compression := header.Codec
+			if header.Profile != "" {
+				compression = fmt.Sprintf("%s, profile %s", header.Codec, header.Profile)
+			}
+			data = append(data, []string{"Compression", compression})
+			if header.Version == 4 {
+				data = append(data,
+					[]string{"Dictionary", dictionaryLabel(core.ArchiveInfo{Level: header.Profile, DictSize: header.DictSize})},
+					[]string{"Chunk Size", formatSize(header.ChunkSize)},
+					[]string{"Index", yesNo(header.Index)},
+				)
+			}
+			data = append(data, []string{"Signed", yesNo(header.Signed)})
+			pterm.DefaultSection.Println("Header")
+			renderTable(data)
+			if tooLarge {
+				pterm.Warning.Printf("Deriving the key needs about %s of RAM, but only %s is available; opening the archive may fail or swap heavily.\n", formatSize(kdfMemory), formatSize(int64(available)))
+			}
+		},
+	}
+	infoCmd.ValidArgsFunction = completeArchive(false)
+	return infoCmd
+}
+
+// slotLabel names the secrets that open a key slot, e.g. "password + keyfile".
+func slotLabel(slot core.SecretKinds) string {
+	var parts []string
+	if slot.Password {
+		parts = append(parts, "password")
+	}
+	if slot.Keyfile {
+		parts = append(parts, "keyfile")
+	}
+	if slot.Identity {
+		parts = append(parts, "identity")
+	}
+	if slot.Shares {
+		parts = append(parts, "key shares")
+	}
+	if slot.FIDO2 {
+		parts = append(parts, "security key")
+	}
+	return strings.Join(parts, " + ")
+}
+
+// infoDocument converts the metadata of an archive for a JSON document.
+func infoDocument(info core.ArchiveInfo) output.Info {
+	doc := output.Info{Version: info.Version, Creator: info.Creator, Profile: info.Profile, Comment: info.Comment, Backup: info.Backup, Deleted: info.Deleted, Base: info.Base, BaseFiles: info.BaseFiles}
+	if !info.Created.IsZero() {
+		doc.Created = info.Created.UTC().Format(time.RFC3339)
+	}
+	return doc
+}
+
+// archiveInfoRows returns report rows for the creation metadata of an archive.
+// Archives that predate the metadata show "unknown".
+func archiveInfoRows(info core.ArchiveInfo) [][]string {
+	unknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	created := "unknown"
+	if !info.Created.IsZero() {
+		created = info.Created.Local().Format("2006-01-02 15:04:05 MST")
+	}
+	rows := [][]string{
+		{"Created", created},
+		{"Created By", unknown(info.Creator)},
+		{"Profile", profileLabel(info)},
+		{"Dictionary", dictionaryLabel(info)},
+	}
+	switch info.Backup {
+	case core.BackupFull:
+		rows = append(rows, []string{"Backup", "Full"})
+	case core.BackupIncremental:
+		rows = append(rows, []string{"Backup", fmt.Sprintf("Incremental (%d deleted)", len(info.Deleted))})
+	}
+	if info.Base != "" {
+		rows = append(rows, []string{"Base", fmt.Sprintf("Differential (%d files from base %s)", info.BaseFiles, info.Base)})
+	}
+	return rows
+}
+
+// dictionaryLabel describes the dictionary size of an archive together with
+// the RAM its extraction needs.
+func dictionaryLabel(info core.ArchiveInfo) string {
+	switch {
+	case info.Level == "":
+		return "unknown"
+	case info.DictSize == 0:
+		return "none"
+	}
+	_, extract := core.DictMemory(info.DictSize)
+	return fmt.Sprintf("%s (extraction needs about %s of RAM)", formatSize(info.DictSize), formatSize(extract))
+}
+
+// profileLabel describes the profile and compression level of an archive,
+// e.g. "default (level 6)" or "level 4".
+func profileLabel(info core.ArchiveInfo) string {
+	switch {
+	case info.Level == "":
+		if info.Profile == "" {
+			return "unknown"
+		}
+		return info.Profile
+	case info.Profile == "" || info.Profile == info.Level:
+		return "level " + info.Level
+	default:
+		return fmt.Sprintf("%s (level %s)", info.Profile, info.Level)
+	}
+}
@@ -0,0 +1,90 @@
+// File: internal/config/config.go
+
+// Package config keeps the settings btxz remembers between runs, in
+// btxz/config.json under the configuration directory of the user:
+// ~/.config on Linux, ~/Library/Application Support on macOS and %AppData%
+// on Windows. BTXZ_CONFIG names another file. A missing file holds the
+// defaults, and the file is only written when a setting is changed.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// pathEnv names the environment variable that overrides the path of the file.
+const pathEnv = "BTXZ_CONFIG"
+
+// Config holds the settings. A zero field takes its default.
+type Config struct {
+	UpdateChannel string `json:"update_channel,omitempty"`  // The release channel 'btxz update' follows (default stable)
+	NoUpdateCheck bool   `json:"no_update_check,omitempty"` // Do not check for a new version in the background
+}
+
+// Path returns the path of the settings file.
+func Path() (string, error) {
+	if path := os.Getenv(pathEnv); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find the configuration directory: %w", err)
+	}
+	return filepath.Join(dir, "btxz", "config.json"), nil
+}
+
+// Load reads the settings, or returns the defaults if there is no file.
+func Load() (Config, error) {
+	var config Config
+	path, err := Path()
+	if err != nil {
+		return config, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("could not read the settings: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("invalid settings file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Save writes the settings, replacing the file in one step so that an
+// interrupted write leaves the previous settings.
+func Save(config Config) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("could not save the settings: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.json")
+	if err != nil {
+		return fmt.Errorf("could not save the settings: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save the settings: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// File: internal/fido2/fido2.go
+
+// Package fido2 talks to FIDO2 security keys, such as a YubiKey, to obtain
+// hmac-secret responses: a 32-byte secret the key derives from a credential it
+// holds and a salt, which never leaves the key in any other form. The device
+// is accessed through the command line tools of libfido2 (fido2-token and
+// fido2-assert), so btxz needs neither cgo nor USB permissions of its own;
+// Authenticator hides them so the rest of the program can use any other
+// implementation.
+package fido2
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// RelyingParty is the relying party id of the credentials btxz uses.
+const RelyingParty = "btxz"
+
+// SaltSize is the size of an hmac-secret salt and of the response.
+const SaltSize = 32
+
+// ErrNoDevice is returned by Open if no security key is connected.
+var ErrNoDevice = errors.New("no FIDO2 security key found: plug it in (and touch it if it blinks), then try again")
+
+// Authenticator is a FIDO2 security key.
+type Authenticator interface {
+	// Credential returns the id of the first resident credential the key
+	// holds for rpID.
+	Credential(rpID string) ([]byte, error)
+	// HMACSecret returns the hmac-secret response of the credential for salt.
+	// The key may wait for the user to touch it.
+	HMACSecret(rpID string, credentialID, salt []byte) ([]byte, error)
+}
+
+// Open returns the first security key connected to this machine.
+func Open() (Authenticator, error) {
+	for _, tool := range []string{"fido2-token", "fido2-assert"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return nil, fmt.Errorf("%s was not found: install libfido2 (package libfido2, fido2-tools or libfido2-utils) to use a security key", tool)
+		}
+	}
+	out, err := run(nil, "fido2-token", "-L")
+	if err != nil {
+		return nil, err
+	}
+	// Each line reads "<device>: vendor=..., product=... (<name>)".
+	for _, line := range strings.Split(string(out), "\n") {
+		if device, _, ok := strings.Cut(line, ": "); ok && device != "" {
+			return &toolAuthenticator{device: device}, nil
+		}
+	}
+	return nil, ErrNoDevice
+}
+
+// toolAuthenticator is a security key driven through the libfido2 tools.
+type toolAuthenticator struct {
+	device string // Device path, e.g. /dev/hidraw3
+}
+
+func (a *toolAuthenticator) Credential(rpID string) ([]byte, error) {
+	out, err := run(nil, "fido2-token", "-L", "-k", rpID, a.device)
+	if err != nil {
+		return nil, err
+	}
+	// Each line reads "<index>: <credential id> <user name> <user id> <type> <protection>".
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		id, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected output of fido2-token: %q", line)
+		}
+		return id, nil
+	}
+	return nil, fmt.Errorf("the security key holds no credential for %q; create one with: fido2-cred -M -h -r -i cred.in %s (see the btxz usage guide)", rpID, a.device)
+}
+
+func (a *toolAuthenticator) HMACSecret(rpID string, credentialID, salt []byte) ([]byte, error) {
+	if len(salt) != SaltSize {
+		return nil, fmt.Errorf("invalid hmac-secret salt: %d bytes, %d required", len(salt), SaltSize)
+	}
+	// The client data hash only matters for the signature, which is not used.
+	clientData := make([]byte, 32)
+	if _, err := rand.Read(clientData); err != nil {
+		return nil, err
+	}
+	input := fmt.Sprintf("%s\n%s\n%s\n%s\n",
+		base64.StdEncoding.EncodeToString(clientData), rpID,
+		base64.StdEncoding.EncodeToString(credentialID),
+		base64.StdEncoding.EncodeToString(salt))
+	out, err := run(strings.NewReader(input), "fido2-assert", "-G", "-h", a.device)
+	if err != nil {
+		return nil, err
+	}
+	// The hmac-secret response is the last line of the assertion.
+	lines := strings.Fields(string(out))
+	if len(lines) == 0 {
+		return nil, errors.New("fido2-assert returned no assertion")
+	}
+	secret, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil || len(secret) != SaltSize {
+		return nil, errors.New("the security key returned no hmac-secret; the credential must be created with the hmac-secret extension (fido2-cred -h)")
+	}
+	return secret, nil
+}
+
+// run executes one of the libfido2 tools and returns its standard output. The
+// tools ask for a PIN on the terminal themselves.
+func run(stdin *strings.Reader, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%s failed: %s", name, message)
+		}
+		return nil, fmt.Errorf("%s failed: %w", name, err)
+	}
+	return out, nil
+}
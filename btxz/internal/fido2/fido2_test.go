@@ -0,0 +1,68 @@
+package fido2
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeTools puts stand-ins for the libfido2 tools on PATH, each a shell script
+// running its given body.
+func fakeTools(t *testing.T, scripts map[string]string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake tools are shell scripts")
+	}
+	dir := t.TempDir()
+	for name, body := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestOpenWithoutTools(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	_, err := Open()
+	if err == nil || !strings.Contains(err.Error(), "install libfido2") {
+		t.Errorf("err = %v, want a hint to install libfido2", err)
+	}
+}
+
+func TestOpenWithoutDevice(t *testing.T) {
+	fakeTools(t, map[string]string{"fido2-token": "exit 0", "fido2-assert": "exit 0"})
+	if _, err := Open(); !errors.Is(err, ErrNoDevice) {
+		t.Errorf("err = %v, want ErrNoDevice", err)
+	}
+}
+
+func TestToolAuthenticator(t *testing.T) {
+	// "credential" and 32 bytes of "s", in base64.
+	fakeTools(t, map[string]string{
+		"fido2-token": `case "$*" in
+"-L") echo "/dev/hidraw3: vendor=0x1050, product=0x0407 (Yubico YubiKey)" ;;
+"-L -k btxz /dev/hidraw3") echo "00: Y3JlZGVudGlhbA== btxz dXNlcg== es256 uvopt" ;;
+*) echo "unexpected arguments: $*" >&2; exit 1 ;;
+esac`,
+		"fido2-assert": `while read -r line; do :; done; echo c2lnbmF0dXJl; echo c3Nzc3Nzc3Nzc3Nzc3Nzc3Nzc3Nzc3Nzc3Nzc3Nzc3M=`,
+	})
+	key, err := Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential, err := key.Credential(RelyingParty)
+	if err != nil || string(credential) != "credential" {
+		t.Fatalf("Credential = %q, %v", credential, err)
+	}
+	secret, err := key.HMACSecret(RelyingParty, credential, make([]byte, SaltSize))
+	if err != nil || string(secret) != strings.Repeat("s", SaltSize) {
+		t.Errorf("HMACSecret = %q, %v", secret, err)
+	}
+	if _, err := key.HMACSecret(RelyingParty, credential, make([]byte, 16)); err == nil {
+		t.Error("a short salt was accepted")
+	}
+}
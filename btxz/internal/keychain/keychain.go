@@ -0,0 +1,37 @@
+// File: internal/keychain/keychain.go
+
+// Package keychain keeps archive passwords in the credential store of the
+// operating system: the macOS Keychain, the Windows Credential Manager, or the
+// Secret Service (GNOME Keyring, KWallet) through libsecret elsewhere. Every
+// entry belongs to the service "btxz" and is named by an archive identifier,
+// so it follows the archive when it is renamed or moved.
+package keychain
+
+import "errors"
+
+// Service is the service name of every entry btxz stores.
+const Service = "btxz"
+
+// ErrNotFound is returned by Lookup and Delete if there is no entry.
+var ErrNotFound = errors.New("no password stored for this archive")
+
+// Name is the name of the credential store on this platform.
+func Name() string {
+	return storeName
+}
+
+// Store saves the password of the archive with the given id, replacing an
+// existing entry.
+func Store(id, password string) error {
+	return store(id, password)
+}
+
+// Lookup returns the stored password of the archive with the given id.
+func Lookup(id string) (string, error) {
+	return lookup(id)
+}
+
+// Delete removes the stored password of the archive with the given id.
+func Delete(id string) error {
+	return remove(id)
+}
@@ -0,0 +1,66 @@
+// File: internal/keychain/keychain_darwin.go
+
+package keychain
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const storeName = "macOS Keychain"
+
+// store runs security(1) in interactive mode, so the password is passed on
+// standard input instead of the command line, where other users could see it.
+func store(id, password string) error {
+	command := fmt.Sprintf("add-generic-password -U -s %s -a %s -l %s -w %s\n",
+		quote(Service), quote(id), quote("btxz archive "+id), quote(password))
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(command)
+	if out, err := cmd.CombinedOutput(); err != nil || len(strings.TrimSpace(string(out))) > 0 {
+		return fmt.Errorf("could not store the password in the keychain: %s", failure(out, err))
+	}
+	return nil
+}
+
+func lookup(id string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", Service, "-a", id, "-w").Output()
+	if err != nil {
+		if notFound(err) {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("could not read the keychain: %w", err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func remove(id string) error {
+	out, err := exec.Command("security", "delete-generic-password", "-s", Service, "-a", id).CombinedOutput()
+	if err != nil {
+		if notFound(err) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("could not remove the password from the keychain: %s", failure(out, err))
+	}
+	return nil
+}
+
+// notFound reports the exit status of security(1) for a missing item.
+func notFound(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == 44
+}
+
+// quote quotes an argument for the command parser of security -i.
+func quote(arg string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
+
+// failure describes a failed run of security(1).
+func failure(out []byte, err error) string {
+	if message := strings.TrimSpace(string(out)); message != "" {
+		return message
+	}
+	return err.Error()
+}
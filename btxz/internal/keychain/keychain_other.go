@@ -0,0 +1,73 @@
+// File: internal/keychain/keychain_other.go
+
+//go:build !darwin && !windows
+
+package keychain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const storeName = "Secret Service keyring"
+
+// store runs secret-tool(1) of libsecret, which reads the password from
+// standard input.
+func store(id, password string) error {
+	if err := available(); err != nil {
+		return err
+	}
+	cmd := exec.Command("secret-tool", "store", "--label=btxz archive "+id, "service", Service, "archive", id)
+	cmd.Stdin = strings.NewReader(password)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("could not store the password in the keyring: %s", failure(out, err))
+	}
+	return nil
+}
+
+func lookup(id string) (string, error) {
+	if err := available(); err != nil {
+		return "", err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", Service, "archive", id)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	// secret-tool exits with 1 and prints nothing for a missing entry.
+	if len(out) == 0 && stderr.Len() == 0 {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read the keyring: %s", failure(stderr.Bytes(), err))
+	}
+	return string(out), nil
+}
+
+func remove(id string) error {
+	if _, err := lookup(id); err != nil {
+		return err
+	}
+	if out, err := exec.Command("secret-tool", "clear", "service", Service, "archive", id).CombinedOutput(); err != nil {
+		return fmt.Errorf("could not remove the password from the keyring: %s", failure(out, err))
+	}
+	return nil
+}
+
+// available checks that secret-tool is installed.
+func available() error {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return errors.New("secret-tool was not found: install libsecret (package libsecret-tools or libsecret) and a keyring such as GNOME Keyring")
+	}
+	return nil
+}
+
+// failure describes a failed run of secret-tool(1).
+func failure(out []byte, err error) string {
+	if message := strings.TrimSpace(string(out)); message != "" {
+		return message
+	}
+	return err.Error()
+}
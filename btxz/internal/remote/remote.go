@@ -0,0 +1,184 @@
+// File: internal/remote/remote.go
+
+// Package remote reads archives from HTTP and HTTPS URLs. The response body is
+// read front to back like standard input, so nothing is stored on disk. If the
+// server supports range requests, the archive can also be read like a file,
+// which lets a listing fetch the header and the index instead of the whole
+// object. Proxies are taken from HTTPS_PROXY, HTTP_PROXY and NO_PROXY, as by
+// other HTTP tools.
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ErrNetwork is the class of errors met fetching an archive: a connection
+// that fails or closes early, or a response other than the archive. errors.Is
+// reports it; the error keeps its own message.
+var ErrNetwork = errors.New("the archive could not be fetched")
+
+// networkError is an error of the class ErrNetwork.
+type networkError struct {
+	err error
+}
+
+// The error is not unwrapped: a connection closed early must not pass for the
+// end of the archive.
+func (e *networkError) Error() string { return e.err.Error() }
+
+func (e *networkError) Is(target error) bool { return target == ErrNetwork }
+
+// IsURL reports whether an archive argument is an http:// or https:// URL.
+func IsURL(arg string) bool {
+	lower := strings.ToLower(arg)
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://")
+}
+
+// ParseHeaders checks headers of the form "Name: value", as given to curl -H.
+func ParseHeaders(headers []string) (http.Header, error) {
+	parsed := make(http.Header)
+	for _, header := range headers {
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q: use \"Name: value\"", header)
+		}
+		parsed.Add(name, strings.TrimSpace(value))
+	}
+	return parsed, nil
+}
+
+// File is an archive at a URL. It reads the response body of a GET request
+// front to back. If the server supports range requests, Seek moves to any
+// offset, and the next Read fetches the archive from there; otherwise Seek
+// fails, so readers fall back to reading the stream.
+type File struct {
+	ctx     context.Context
+	client  *http.Client
+	url     string
+	header  http.Header
+	size    int64 // Size of the archive, -1 if unknown
+	ranges  bool  // The server answers range requests
+	body    io.ReadCloser
+	bodyPos int64 // Offset the body reads from next
+	pos     int64 // Offset of the next Read
+}
+
+// Open sends a GET request for url with header and returns the archive it
+// responds with. Requests stop once ctx is done.
+func Open(ctx context.Context, url string, header http.Header) (*File, error) {
+	// The default transport honors the proxy variables of the environment.
+	f := &File{ctx: ctx, client: &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}, url: url, header: header, size: -1}
+	resp, err := f.get(-1)
+	if err != nil {
+		return nil, err
+	}
+	f.body = resp.Body
+	if resp.ContentLength >= 0 {
+		f.size = resp.ContentLength
+	}
+	f.ranges = f.size > 0 && resp.Header.Get("Accept-Ranges") == "bytes" && resp.Header.Get("Content-Encoding") == ""
+	return f, nil
+}
+
+// Size returns the size of the archive, or -1 if the server did not tell.
+func (f *File) Size() int64 {
+	return f.size
+}
+
+// get sends the request, for the archive from offset on with a range
+// request, or for all of it with a negative offset.
+func (f *File) get(offset int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	for name, values := range f.header {
+		req.Header[name] = values
+	}
+	want := http.StatusOK
+	if offset >= 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+		want = http.StatusPartialContent
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		return nil, &networkError{err}
+	}
+	if resp.StatusCode != want {
+		resp.Body.Close()
+		return nil, &networkError{fmt.Errorf("%s: the server responded %s", f.url, resp.Status)}
+	}
+	return resp, nil
+}
+
+// Read reads from the current offset, fetching the archive from there if
+// Seek moved away from the body being read.
+func (f *File) Read(p []byte) (int, error) {
+	if f.size >= 0 && f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if f.body == nil || f.bodyPos != f.pos {
+		if f.body != nil {
+			f.body.Close()
+			f.body = nil
+		}
+		resp, err := f.get(f.pos)
+		if err != nil {
+			return 0, err
+		}
+		f.body, f.bodyPos = resp.Body, f.pos
+	}
+	n, err := f.body.Read(p)
+	f.pos += int64(n)
+	f.bodyPos = f.pos
+	switch {
+	case err == io.EOF && f.size >= 0 && f.pos < f.size, errors.Is(err, io.ErrUnexpectedEOF):
+		// Not the end of the archive: the connection closed early.
+		return n, &networkError{fmt.Errorf("the connection closed after %d bytes of the archive", f.pos)}
+	case err != nil && err != io.EOF && !errors.Is(err, context.Canceled):
+		return n, &networkError{err}
+	}
+	return n, err
+}
+
+// Seek moves the offset of the next Read. It fails unless the server
+// supports range requests.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if !f.ranges {
+		return 0, errors.New("the server does not support range requests")
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+// Close closes the response being read.
+func (f *File) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
@@ -0,0 +1,55 @@
+// File: internal/secmem/secmem.go
+
+// Package secmem clears secret material, such as derived keys and decrypted
+// payloads, from memory as soon as it is no longer needed, so it does not
+// linger on the heap until the garbage collector reuses it, or end up in a
+// core dump. Go strings cannot be cleared; secrets that must be wiped have to
+// be kept in byte slices.
+package secmem
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+)
+
+// Wipe overwrites every given buffer with zeros.
+func Wipe(buffers ...[]byte) {
+	for _, buf := range buffers {
+		clear(buf)
+		// Keep the compiler from dropping the stores to a dead buffer.
+		runtime.KeepAlive(buf)
+	}
+}
+
+// Reader reads from a buffer of secret data and wipes it once it has been read
+// to the end or the reader is closed, whichever comes first.
+type Reader struct {
+	buf    []byte
+	reader *bytes.Reader
+}
+
+// NewReader returns a Reader over buf. The caller must not use buf afterwards.
+func NewReader(buf []byte) *Reader {
+	return &Reader{buf: buf, reader: bytes.NewReader(buf)}
+}
+
+// Read reads from the buffer and wipes it at EOF.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.buf == nil {
+		return 0, io.EOF
+	}
+	n, err := r.reader.Read(p)
+	if err == io.EOF {
+		r.Close()
+	}
+	return n, err
+}
+
+// Close wipes the buffer. Later reads report EOF.
+func (r *Reader) Close() error {
+	Wipe(r.buf)
+	r.buf = nil
+	r.reader.Reset(nil)
+	return nil
+}
@@ -0,0 +1,60 @@
+package secmem
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// zeroed reports whether buf holds only zeros.
+func zeroed(buf []byte) bool {
+	return len(bytes.Trim(buf, "\x00")) == 0
+}
+
+func TestWipe(t *testing.T) {
+	a, b := []byte("derived key"), []byte("payload")
+	Wipe(a, nil, b)
+	if !zeroed(a) || !zeroed(b) {
+		t.Errorf("buffers not wiped: %q, %q", a, b)
+	}
+}
+
+func TestReaderWipesAtEOF(t *testing.T) {
+	secret := []byte("the decrypted payload")
+	r := NewReader(secret)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "the decrypted payload" {
+		t.Errorf("read %q", data)
+	}
+	if !zeroed(secret) {
+		t.Errorf("buffer not wiped after EOF: %q", secret)
+	}
+	if r.buf != nil {
+		t.Error("the reader still holds the buffer")
+	}
+}
+
+func TestReaderWipesOnClose(t *testing.T) {
+	secret := []byte("the decrypted payload")
+	r := NewReader(secret)
+	head := make([]byte, 3)
+	if _, err := io.ReadFull(r, head); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !zeroed(secret) {
+		t.Errorf("buffer not wiped on close: %q", secret)
+	}
+	if n, err := r.Read(head); n != 0 || err != io.EOF {
+		t.Errorf("read after close = %d, %v; want 0, EOF", n, err)
+	}
+	// Closing again is harmless.
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+// File: json.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements --json, which reports the result of a command as a
+// JSON document instead of tables.
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"btxz/output"
+
+	"atomicgo.dev/cursor"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// jsonOutput is set up by --json: standard output then carries the single JSON
+// document a command writes as it ends (see the output package), and nothing
+// else.
+var jsonOutput jsonReport
+
+// jsonReport writes the document of --json.
+type jsonReport struct {
+	enabled bool
+	stdout  *os.File
+	command string
+	started time.Time
+	written bool
+}
+
+// begin reserves standard output for the document of cmd. Banners, spinners,
+// progress bars and tables are not shown; warnings, errors and prompts go to
+// standard error.
+func (j *jsonReport) begin(cmd *cobra.Command) {
+	j.stdout = dataOutput()
+	j.command = strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+	j.started = time.Now()
+	pterm.Info.Writer = io.Discard
+	pterm.Success.Writer = io.Discard
+	pterm.DefaultSection.Writer = io.Discard
+	pterm.DefaultTable.Writer = io.Discard
+	pterm.DefaultBox.Writer = io.Discard
+	pterm.DefaultSpinner.Writer = io.Discard
+	pterm.DefaultProgressbar.Writer = io.Discard
+}
+
+// result returns the start of a document with the given status.
+func (j *jsonReport) result(status string) output.Result {
+	return output.Result{Schema: output.Schema, Command: j.command, Status: status, DurationMS: time.Since(j.started).Milliseconds()}
+}
+
+// write writes doc, a document of the output package, to standard output.
+func (j *jsonReport) write(doc any) {
+	j.written = true
+	encoder := json.NewEncoder(j.stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		pterm.Error.Printf("Failed to write the JSON result: %v\n", err)
+		os.Exit(exitIO)
+	}
+}
+
+// fail writes an error document for message and the exit status code,
+// unless the command wrote its document already.
+func (j *jsonReport) fail(code int, message string) {
+	if !j.enabled || j.written {
+		return
+	}
+	result := j.result(output.StatusError)
+	result.Error = &output.Error{Message: message, ExitCode: code}
+	j.write(result)
+}
+
+// finish writes a plain result for a command that has no document of its own.
+func (j *jsonReport) finish() {
+	if j.enabled && !j.written {
+		j.write(j.result(output.StatusOK))
+	}
+}
+
+// dataOutput reserves standard output for the data a command writes, and
+// returns it. Everything else, messages and prompts included, goes to standard
+// error from then on.
+func dataOutput() *os.File {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	pterm.SetDefaultOutput(os.Stderr)
+	for _, printer := range []*pterm.PrefixPrinter{&pterm.Info, &pterm.Success, &pterm.Warning, &pterm.Error} {
+		printer.Writer = os.Stderr
+	}
+	pterm.DefaultBox.Writer = os.Stderr
+	cursor.SetTarget(os.Stderr)
+	return stdout
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
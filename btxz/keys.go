@@ -0,0 +1,251 @@
+// File: keys.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements the keygen and keychain commands, the --keychain
+// option, and the checking of archive signatures.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"btxz/core"
+	"btxz/internal/keychain"
+	"btxz/output"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// NewKeygenCmd configures the 'keygen' command.
+func NewKeygenCmd() *cobra.Command {
+	var outputFile string
+	var sign bool
+	keygenCmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate an identity for recipient encryption or a signing key",
+		Long: `Creates a new X25519 key pair for public-key encryption. The identity (private key) is
+written to the output file with owner-only permissions; the matching public key is printed.
+
+Share the public key freely: anyone can encrypt an archive to it with
+'btxz create --recipient btxz1...'. Only the identity file opens those archives
+(--identity). If it is lost, so are the archives encrypted to it.
+
+With --sign, an Ed25519 signing key pair is created instead. The signing key is written
+to the output file, the public key to the same path with '.pub' appended. Sign archives
+with 'btxz create --sign-key' and check them with 'btxz test --verify-key'.`,
+		Example: `  btxz keygen -o key.txt
+  btxz create ./release -o release.btxz --recipient btxz1...
+  btxz extract release.btxz --identity key.txt
+  btxz keygen --sign -o signing.key
+  btxz create ./backup -o backup.btxz --sign-key signing.key
+  btxz test backup.btxz --verify-key signing.key.pub`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("KEY GENERATION")
+			if outputFile == "" {
+				handleUsageError("Output file path must be specified with -o or --output.")
+			}
+			created := time.Now().Format(time.RFC3339)
+
+			if sign {
+				signingKey, verifyKey, err := core.GenerateSigningKey()
+				if err != nil {
+					handleFailure(err, "Key generation failed: %v", err)
+				}
+				publicFile := outputFile + ".pub"
+				if _, err := os.Lstat(publicFile); err == nil {
+					handleCmdError("Could not write key file: %s already exists.", publicFile)
+				}
+				writeKeyFile(outputFile, fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", created, verifyKey, signingKey), 0600)
+				writeKeyFile(publicFile, fmt.Sprintf("# created: %s\n%s\n", created, verifyKey), 0644)
+
+				pterm.DefaultSection.Println("Mission Report")
+				pterm.Success.Println("Signing key generated.")
+				pterm.DefaultBox.WithTitle("Public Key (Verification)").Println(verifyKey)
+				data := [][]string{
+					{"Signing Key", outputFile},
+					{"Public Key", publicFile},
+					{"Algorithm", "Ed25519"},
+				}
+				renderTable(data)
+				pterm.Warning.Println("Keep the signing key secret; anyone who has it can sign archives in your name.")
+				if jsonOutput.enabled {
+					jsonOutput.write(output.Keygen{Result: jsonOutput.result(output.StatusOK), KeyFile: outputFile, PublicKeyFile: publicFile, PublicKey: verifyKey, Algorithm: "Ed25519"})
+				}
+				return
+			}
+
+			identity, recipient, err := core.GenerateIdentity()
+			if err != nil {
+				handleFailure(err, "Key generation failed: %v", err)
+			}
+			writeKeyFile(outputFile, fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", created, recipient, identity), 0600)
+
+			pterm.DefaultSection.Println("Mission Report")
+			pterm.Success.Println("Identity generated.")
+			pterm.DefaultBox.WithTitle("Public Key (Recipient)").Println(recipient)
+			data := [][]string{
+				{"Identity File", outputFile},
+				{"Algorithm", "X25519 + HKDF-SHA256"},
+			}
+			renderTable(data)
+			pterm.Warning.Println("Keep the identity file secret and backed up.")
+			if jsonOutput.enabled {
+				jsonOutput.write(output.Keygen{Result: jsonOutput.result(output.StatusOK), KeyFile: outputFile, PublicKey: recipient, Algorithm: "X25519 + HKDF-SHA256"})
+			}
+		},
+	}
+	keygenCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Path for the new identity file (required, must not exist)")
+	keygenCmd.Flags().BoolVar(&sign, "sign", false, "Create an Ed25519 signing key pair instead of an identity")
+	return keygenCmd
+}
+
+// NewKeychainCmd configures the 'keychain' command.
+func NewKeychainCmd() *cobra.Command {
+	keychainCmd := &cobra.Command{
+		Use:   "keychain",
+		Short: "Manage archive passwords stored in the OS keychain",
+		Long: `Archive passwords are only stored in the credential store of the operating system
+(macOS Keychain, Windows Credential Manager, or the Secret Service via libsecret) when
+--use-keychain is given and the offer after a successful create, extract, list or test
+is accepted. Entries are tied to the archive itself, not its path, so they survive
+renaming it.`,
+	}
+	forgetCmd := &cobra.Command{
+		Use:     "forget <archive.btxz>",
+		Short:   "Remove the stored password of an archive",
+		Example: `  btxz keychain forget backup.btxz`,
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("KEYCHAIN")
+			id, err := core.ArchiveID(args[0])
+			if err != nil {
+				handleFailure(err, "Could not identify the archive: %v", err)
+			}
+			if err := keychain.Delete(id); err != nil {
+				if errors.Is(err, keychain.ErrNotFound) {
+					pterm.Info.Printf("No password is stored for %s.\n", filepath.Base(args[0]))
+					return
+				}
+				handleFailure(err, "%v", err)
+			}
+			pterm.Success.Printf("Password of %s removed from the %s.\n", filepath.Base(args[0]), keychain.Name())
+		},
+	}
+	forgetCmd.ValidArgsFunction = completeArchive(false)
+	keychainCmd.AddCommand(forgetCmd)
+	return keychainCmd
+}
+
+// writeKeyFile writes a new key file, refusing to overwrite an existing one.
+func writeKeyFile(path, content string, perm os.FileMode) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		handleFailure(err, "Could not write key file: %v", err)
+	}
+	if _, err := file.WriteString(content); err != nil {
+		file.Close()
+		handleFailure(err, "Could not write key file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		handleFailure(err, "Could not write key file: %v", err)
+	}
+}
+
+// signatureCheck holds the flags that check the signature of an archive before
+// it is decrypted.
+type signatureCheck struct {
+	verifyKey string // --verify-key
+	require   bool   // --require-signature
+}
+
+// addFlags registers the flags of a signature check on cmd.
+func (check *signatureCheck) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&check.verifyKey, "verify-key", "", "Verify the archive's signature with this public key file (from btxz keygen --sign) before decrypting")
+	cmd.Flags().BoolVar(&check.require, "require-signature", false, "Fail if the archive is not signed (requires --verify-key)")
+}
+
+// verify checks the signature of an archive and fails on a mismatch. It
+// returns the signature status for the report, or "" if no key was given.
+// Unsigned archives only draw a warning unless a signature is required.
+func (check *signatureCheck) verify(archivePath string) (string, error) {
+	if check.verifyKey == "" {
+		if check.require {
+			return "", newCommandError(exitUsage, "--require-signature needs the signer's public key; pass it with --verify-key.")
+		}
+		return "", nil
+	}
+	err := core.VerifySignature(archivePath, check.verifyKey)
+	switch {
+	case errors.Is(err, core.ErrNotSigned):
+		if check.require {
+			return "", newCommandError(exitIntegrity, "Signature Missing: The archive is not signed, but --require-signature was given.")
+		}
+		pterm.Warning.Println("The archive is not signed; its origin cannot be verified.")
+		return "NOT SIGNED", nil
+	case err != nil:
+		return "", failureError(err, "Signature Invalid: %v", err)
+	}
+	pterm.Success.Println("Signature verified (Ed25519).")
+	return "VALID (Ed25519)", nil
+}
+
+// keychainOption holds --use-keychain, which keeps the password of an archive
+// in the credential store of the operating system.
+type keychainOption struct {
+	enabled bool // --use-keychain
+	used    bool // The password was read from the keychain
+}
+
+// addFlags registers --use-keychain on cmd.
+func (keys *keychainOption) addFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&keys.enabled, "use-keychain", false, "Use the password stored in the OS keychain, and offer to store it after success")
+}
+
+// lookup returns the password stored for an archive if the keychain is
+// enabled and no password or keyfile was given; otherwise password. Like the
+// prompt, it comes after BTXZ_PASSWORD.
+func (keys *keychainOption) lookup(archivePath, password, keyfile string) string {
+	if !keys.enabled || password != "" || keyfile != "" || os.Getenv(passwordEnv) != "" {
+		return password
+	}
+	id, err := core.ArchiveID(archivePath)
+	if err != nil {
+		return password
+	}
+	stored, err := keychain.Lookup(id)
+	if err != nil {
+		if !errors.Is(err, keychain.ErrNotFound) {
+			pterm.Warning.Printf("Keychain: %v\n", err)
+		}
+		return password
+	}
+	pterm.Info.Printf("Using the password stored in the %s.\n", keychain.Name())
+	keys.used = true
+	return stored
+}
+
+// offer asks whether to store the password that created or opened an archive.
+// Only plain passwords are stored, and only if they did not come from the
+// keychain already.
+func (keys *keychainOption) offer(archivePath, secret string) {
+	if !keys.enabled || keys.used || !core.PlainPassword(secret) {
+		return
+	}
+	id, err := core.ArchiveID(archivePath)
+	if err != nil {
+		pterm.Warning.Printf("Keychain: could not identify the archive: %v\n", err)
+		return
+	}
+	if !confirm(fmt.Sprintf("Store the password in the %s?", keychain.Name())) {
+		return
+	}
+	if err := keychain.Store(id, secret); err != nil {
+		pterm.Warning.Printf("Keychain: %v\n", err)
+		return
+	}
+	pterm.Success.Printf("Password stored in the %s. Remove it with 'btxz keychain forget %s'.\n", keychain.Name(), archivePath)
+}
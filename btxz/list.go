@@ -0,0 +1,291 @@
+// File: list.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements the list and cat commands.
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"btxz/core"
+	"btxz/output"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// NewListCmd configures the 'list' command.
+func NewListCmd() *cobra.Command {
+	var (
+		password      string
+		source        passwordSource
+		keys          keychainOption
+		keyfile       string
+		identity      string
+		keyShares     []string
+		normalize     string
+		hashes        bool
+		verbose       bool
+		fetch         remoteOption
+	)
+	// listArchive lists one archive, with the flags of the command, and
+	// returns its exit status.
+	listArchive := func(archivePath string) (int, error) {
+		stream := fetch.openStream(archivePath, source, keys, false)
+
+		secret := source.resolve(password)
+		secret = keys.lookup(archivePath, secret, keyfile)
+		var err error
+		if stream != nil {
+			secret, err = streamSecret(stream, secret, keyfile, identity, keyShares, "Enter decryption password")
+		} else {
+			secret, err = archiveSecret(archivePath, secret, keyfile, identity, keyShares, "Enter decryption password")
+		}
+		if err != nil {
+			return failed(err)
+		}
+
+		ctx, stop := interruptContext()
+		spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start("Decrypting metadata...")
+		var contents []core.ArchiveEntry
+		var info core.ArchiveInfo
+		if stream != nil {
+			contents, info, err = stream.List(ctx, secret)
+		} else {
+			contents, info, err = core.ListArchiveContext(ctx, archivePath, secret)
+		}
+		spinner.Stop()
+		stop()
+		exitIfInterrupted(err, "")
+
+		if err != nil {
+			if failure := streamEndedError(err); failure != nil {
+				return failed(failure)
+			}
+			if errors.Is(err, core.ErrAuthentication) {
+				return failed(newCommandError(exitAuth, "Access Denied: Incorrect Password."))
+			}
+			return failed(failureError(err, "Failed to list archive contents: %v", err))
+		}
+
+		if jsonOutput.enabled {
+			jsonOutput.write(listDocument(archivePath, info, contents))
+			keys.offer(archivePath, secret)
+			return 0, nil
+		}
+		pterm.Success.Printf("Index retrieved for %s.\n", archiveLabel(archivePath))
+		renderTable(archiveInfoRows(info))
+		if info.Comment != "" {
+			pterm.DefaultBox.WithTitle("Comment").Println(info.Comment)
+		}
+		tableData := pterm.TableData{{"Mode", "Size (bytes)", "Name"}}
+		if verbose {
+			tableData[0] = []string{"Mode", "Size (bytes)", "Modified", "Type", "Name"}
+		}
+		if hashes || verbose {
+			tableData[0] = append(tableData[0], "SHA-256")
+		}
+		notNormal := 0
+		var fileNames []string
+		for _, item := range contents {
+			if item.Typeflag != tar.TypeDir {
+				fileNames = append(fileNames, item.Name)
+			}
+			name := item.Name
+			if item.Dedup {
+				name = fmt.Sprintf("%s (dedup of %s)", item.Name, item.Link)
+			} else if item.Hardlink {
+				name = fmt.Sprintf("%s (link to %s)", item.Name, item.Link)
+			} else if item.Link != "" {
+				name = fmt.Sprintf("%s -> %s", item.Name, item.Link)
+			}
+			if ok, _ := core.IsNormalName(item.Name, normalize); !ok {
+				name = "⚠ " + name
+				notNormal++
+			}
+			row := []string{item.Mode, fmt.Sprintf("%d", item.Size), name}
+			if verbose {
+				modified := "unknown"
+				if !item.ModTime.IsZero() {
+					modified = item.ModTime.Local().Format("2006-01-02 15:04:05")
+				}
+				row = []string{item.Mode, fmt.Sprintf("%d", item.Size), modified, entryType(item), name}
+			}
+			if hashes || verbose {
+				row = append(row, item.SHA256)
+			}
+			tableData = append(tableData, row)
+		}
+		pterm.DefaultTable.WithHasHeader().WithBoxed().WithData(tableData).Render()
+		if notNormal > 0 {
+			pterm.Warning.Printf("%d name(s) marked ⚠ are not in %s form; extract with --normalize-names %s to convert them.\n", notNormal, strings.ToUpper(normalize), strings.ToLower(normalize))
+		}
+		if pairs := core.CaseCollisions(fileNames); len(pairs) > 0 {
+			collisions := make([]string, len(pairs))
+			for i, pair := range pairs {
+				collisions[i] = pair[0] + " / " + pair[1]
+			}
+			pterm.Warning.Printf("%d pair(s) of names differ only by case or Unicode form and name the same file on macOS and Windows; see extract --case-collisions:\n%s\n", len(pairs), strings.Join(collisions, "\n"))
+		}
+		keys.offer(archivePath, secret)
+		return 0, nil
+	}
+	listCmd := &cobra.Command{
+		Use:     "list <archive.btxz>...",
+		Short:   "List the contents of one or more archives",
+		Long:    `Shows a list of files and folders inside a .btxz archive without extracting them. Automatically handles all versions.
+
+With -v, every entry also shows its modification time, its type (file, dir, symlink, hardlink
+or dedup) and the SHA-256 digest recorded for it.
+
+With --json, standard output carries a single JSON document instead of the tables, and
+messages and prompts go to standard error:
+  {"schema": 1, "command": "list", "status": "ok", "duration_ms": 12, "version": 4,
+   "created": "2025-01-02T03:04:05Z", ..., "archive": "backup.btxz",
+   "entries": [{"name": "a.txt", "type": "file", "size": 2, "mode": 420,
+                "mode_string": "-rw-r--r--", "mtime": "2025-01-02T03:04:05Z", "sha256": "..."}],
+   "totals": {"entries": 1, "files": 1, "dirs": 0, "size": 2}}
+Times are RFC 3339 in UTC; link targets are in "link". Fields may be added, never renamed.` + batchHelp + urlHelp,
+		Example: `  btxz list my_archive.btxz -p "s3cr3t!"
+  btxz list my_archive.btxz -v
+  btxz list my_archive.btxz --json | jq -r '.entries[] | select(.size > 1048576) | .name'
+  btxz list backups/*.btxz --password-file pass.txt
+  btxz list https://example.com/backup.btxz`,
+		Args:    cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("ARCHIVE CONTENTS")
+			if _, err := core.IsNormalName("", normalize); err != nil {
+				handleUsageError("%v", err)
+			}
+			archives := archiveArgs(args)
+			if len(archives) > 1 {
+				runBatch(archives, &password, &source, &keys, keyfile, identity, keyShares, listArchive)
+				return
+			}
+			exitWith(listArchive(archives[0]))
+		},
+	}
+	addUnlockFlags(listCmd, &password, &keyfile, &identity, &keyShares)
+	source.addFlags(listCmd)
+	keys.addFlags(listCmd)
+	listCmd.Flags().StringVar(&normalize, "normalize-names", "none", "Mark names that are not in this Unicode normal form: nfc, nfd")
+	listCmd.Flags().BoolVar(&hashes, "hashes", false, "Show the stored SHA-256 digest of every file")
+	fetch.addFlags(listCmd)
+	listCmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Also show the modification time, type and SHA-256 digest of every entry")
+	listCmd.ValidArgsFunction = completeArchive(false)
+	return listCmd
+}
+
+// NewCatCmd configures the 'cat' command.
+func NewCatCmd() *cobra.Command {
+	var (
+		password      string
+		source        passwordSource
+		keys          keychainOption
+		keyfile       string
+		identity      string
+		keyShares     []string
+	)
+	catCmd := &cobra.Command{
+		Use:     "cat <archive.btxz> <entry...>",
+		Short:   "Write archive members to standard output",
+		Long:    `Writes the content of one or more files in an archive to standard output without extracting them, for piping into another program. Several members are written one after another in archive order. All other output goes to standard error.`,
+		Example: `  btxz cat backup.btxz config/settings.json -p "s3cr3t!" | jq .`,
+		Args:    cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if jsonOutput.enabled {
+				handleUsageError("--json cannot be used with cat: standard output carries the entry content.")
+			}
+			// Standard output carries the data, so there is no banner.
+			stdout := dataOutput()
+			archivePath := args[0]
+
+			password = source.resolve(password)
+			password = keys.lookup(archivePath, password, keyfile)
+			password = unlockSecret(archivePath, password, keyfile, identity, keyShares, "Enter decryption password")
+
+			out := bufio.NewWriter(stdout)
+			err := core.CatEntries(archivePath, password, args[1:], out)
+			if flushErr := out.Flush(); err == nil {
+				err = flushErr
+			}
+			if err != nil {
+				if errors.Is(err, core.ErrAuthentication) {
+					exitWithError(exitAuth, "Access Denied: Incorrect Password.")
+				}
+				handleFailure(err, "%v", err)
+			}
+			keys.offer(archivePath, password)
+		},
+	}
+	addUnlockFlags(catCmd, &password, &keyfile, &identity, &keyShares)
+	source.addFlags(catCmd)
+	keys.addFlags(catCmd)
+	catCmd.ValidArgsFunction = completeArchive(false)
+	return catCmd
+}
+
+// listDocument returns the document of list --json.
+func listDocument(archivePath string, info core.ArchiveInfo, contents []core.ArchiveEntry) output.List {
+	doc := output.List{
+		Result:  jsonOutput.result(output.StatusOK),
+		Info:    infoDocument(info),
+		Archive: archivePath,
+		Entries: make([]output.Entry, 0, len(contents)),
+	}
+	for _, item := range contents {
+		entry := output.Entry{
+			Name:       item.Name,
+			Type:       entryType(item),
+			Size:       item.Size,
+			Mode:       uint32(item.FileMode.Perm()),
+			ModeString: item.Mode,
+			Link:       item.Link,
+			SHA256:     item.SHA256,
+		}
+		if !item.ModTime.IsZero() {
+			entry.ModTime = item.ModTime.UTC().Format(time.RFC3339)
+		}
+		doc.Entries = append(doc.Entries, entry)
+		switch entry.Type {
+		case "file":
+			doc.Totals.Files++
+			doc.Totals.Size += item.Size
+		case "dir":
+			doc.Totals.Dirs++
+		}
+	}
+	doc.Totals.Entries = len(doc.Entries)
+	return doc
+}
+
+// entryType names the kind of an archive entry for the verbose listing.
+func entryType(item core.ArchiveEntry) string {
+	switch {
+	case item.Dedup:
+		return "dedup"
+	case item.Hardlink:
+		return "hardlink"
+	}
+	switch item.Typeflag {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char device"
+	case tar.TypeBlock:
+		return "block device"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	return fmt.Sprintf("type %q", item.Typeflag)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// runMainEnv makes the test binary run btxz itself, so that the tests can
+// start it with their own arguments, descriptors and environment.
+const runMainEnv = "BTXZ_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// result is the outcome of a run of btxz.
+type result struct {
+	stdout, stderr string
+	code           int
+}
+
+// runBTXZ runs btxz with args, after setup, if not nil, has adjusted the
+// command. The run has a home and settings of its own and no password in the
+// environment, and does not check for updates.
+func runBTXZ(t *testing.T, setup func(cmd *exec.Cmd), args ...string) result {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	home := t.TempDir()
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, passwordEnv+"=") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env, runMainEnv+"=1", "BTXZ_NO_UPDATE=1", "HOME="+home, "BTXZ_CONFIG="+filepath.Join(home, "config.json"))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if setup != nil {
+		setup(cmd)
+	}
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		t.Fatalf("btxz %s: %v", strings.Join(args, " "), err)
+	}
+	return result{stdout: stdout.String(), stderr: stderr.String(), code: cmd.ProcessState.ExitCode()}
+}
+
+// fastKDF are the cheapest Argon2 flags of create, for tests.
+var fastKDF = []string{"--level", "low", "--kdf-memory", "8M", "--kdf-time", "1", "--kdf-threads", "1"}
+
+// testTree writes a folder holding the file hello.txt and returns its path.
+func testTree(t *testing.T) string {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.Mkdir(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "hello.txt"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+// createArchive creates an archive of src with password and returns its path.
+func createArchive(t *testing.T, src, password string) string {
+	t.Helper()
+	archive := filepath.Join(t.TempDir(), "test.btxz")
+	args := append([]string{"create", src, "-o", archive, "-p", password}, fastKDF...)
+	if r := runBTXZ(t, nil, args...); r.code != 0 {
+		t.Fatalf("create exited with %d: %s", r.code, r.stderr)
+	}
+	return archive
+}
+
+// passwordPipe returns a setup that passes data to btxz as descriptor 3.
+func passwordPipe(t *testing.T, data string) func(cmd *exec.Cmd) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("extra descriptors are not inherited on Windows")
+	}
+	return func(cmd *exec.Cmd) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { r.Close() })
+		go func() {
+			w.WriteString(data)
+			w.Close()
+		}()
+		cmd.ExtraFiles = []*os.File{r}
+	}
+}
+
+func TestPasswordFD(t *testing.T) {
+	archive := createArchive(t, testTree(t), "correct horse")
+	for _, test := range []struct {
+		name string
+		data string
+		code int
+	}{
+		{"up to the newline", "correct horse\nignored", 0},
+		{"up to EOF", "correct horse", 0},
+		{"with a CRLF line end", "correct horse\r\n", 0},
+		{"wrong password", "wrong horse\n", exitAuth},
+		{"empty", "", exitError},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			out := t.TempDir()
+			r := runBTXZ(t, passwordPipe(t, test.data), "extract", archive, "-o", out, "--password-fd", "3")
+			if r.code != test.code {
+				t.Fatalf("exit code %d, want %d: %s", r.code, test.code, r.stderr)
+			}
+			if test.code != 0 {
+				return
+			}
+			if data, err := os.ReadFile(filepath.Join(out, "hello.txt")); err != nil || string(data) != "hello" {
+				t.Errorf("hello.txt: read %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestPasswordFDCreate(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "test.btxz")
+	args := append([]string{"create", testTree(t), "-o", archive, "--password-fd", "3"}, fastKDF...)
+	if r := runBTXZ(t, passwordPipe(t, "from a pipe\n"), args...); r.code != 0 {
+		t.Fatalf("create exited with %d: %s", r.code, r.stderr)
+	}
+	if r := runBTXZ(t, nil, "test", archive, "-p", "from a pipe"); r.code != 0 {
+		t.Errorf("test exited with %d: %s", r.code, r.stderr)
+	}
+}
+
+func TestPasswordFDConflicts(t *testing.T) {
+	archive := createArchive(t, testTree(t), "secret")
+	r := runBTXZ(t, passwordPipe(t, "secret\n"), "extract", archive, "-o", t.TempDir(), "--password-fd", "3", "-p", "secret")
+	if r.code != exitUsage {
+		t.Errorf("exit code %d, want %d: %s", r.code, exitUsage, r.stderr)
+	}
+}
@@ -0,0 +1,208 @@
+// File: modify.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements the add, remove and convert commands, which rewrite
+// an existing archive.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"btxz/core"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// NewAddCmd configures the 'add' command.
+func NewAddCmd() *cobra.Command {
+	var (
+		password  string
+		keyfile   string
+		identity  string
+		keyShares []string
+		replace   bool
+	)
+	addCmd := &cobra.Command{
+		Use:   "add <archive.btxz> [file/folder...]",
+		Short: "Add files to an existing archive",
+		Long: `Appends files and folders to an existing V4 archive, keeping its password, salt and profile.
+
+The archive is rewritten through a temporary file and swapped in atomically. Data that is
+not affected is copied as-is, without being decompressed or recompressed. Entries that are
+already present are refused unless --replace is given.`,
+		Example: `  btxz add backup.btxz ./notes.txt -p "pass"
+  btxz add backup.btxz ./config --replace`,
+		Args: cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("ARCHIVE UPDATE")
+			startTime := time.Now()
+			archivePath := args[0]
+
+			password = unlockSecret(archivePath, password, keyfile, identity, keyShares, "Enter archive password")
+
+			pterm.DefaultSection.Println("Processing")
+			spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start(fmt.Sprintf("Adding %d inputs to '%s'...", len(args)-1, filepath.Base(archivePath)))
+			err := core.AppendToArchive(archivePath, args[1:], password, replace)
+			spinner.Stop()
+
+			if err != nil {
+				if errors.Is(err, core.ErrAuthentication) {
+					exitWithError(exitAuth, "Access Denied: Incorrect Password or Corrupted Archive.")
+				}
+				handleFailure(err, "Failed to update archive: %v", err)
+			}
+
+			duration := time.Since(startTime)
+			pterm.DefaultSection.Println("Mission Report")
+			pterm.Success.Println("Files added successfully.")
+
+			data := [][]string{
+				{"Archive", filepath.Base(archivePath)},
+				{"Inputs Added", fmt.Sprintf("%d", len(args)-1)},
+			}
+			renderSummary(data, duration, "UPDATED")
+		},
+	}
+	addUnlockFlags(addCmd, &password, &keyfile, &identity, &keyShares)
+	addCmd.Flags().BoolVar(&replace, "replace", false, "Overwrite entries that already exist in the archive")
+	addCmd.ValidArgsFunction = completeArchive(true)
+	return addCmd
+}
+
+// NewRemoveCmd configures the 'remove' command.
+func NewRemoveCmd() *cobra.Command {
+	var (
+		password      string
+		keyfile       string
+		identity      string
+		keyShares     []string
+		ignoreMissing bool
+	)
+	removeCmd := &cobra.Command{
+		Use:   "remove <archive.btxz> <entry/glob...>",
+		Short: "Delete entries from an archive",
+		Long: `Deletes entries from an existing V4 archive, keeping its password, salt and profile.
+
+Entries can be given by name or as glob patterns (quote them so the shell does not expand
+them). Naming a folder removes everything inside it. The archive is rewritten through a
+temporary file and swapped in atomically.`,
+		Example: `  btxz remove backup.btxz notes.txt -p "pass"
+  btxz remove backup.btxz "logs/*.log" cache --ignore-missing`,
+		Args: cobra.MinimumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("ARCHIVE UPDATE")
+			startTime := time.Now()
+			archivePath := args[0]
+
+			password = unlockSecret(archivePath, password, keyfile, identity, keyShares, "Enter archive password")
+
+			pterm.DefaultSection.Println("Processing")
+			spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start(fmt.Sprintf("Removing entries from '%s'...", filepath.Base(archivePath)))
+			removed, err := core.RemoveFromArchive(archivePath, password, args[1:], ignoreMissing)
+			spinner.Stop()
+
+			if err != nil {
+				if errors.Is(err, core.ErrAuthentication) {
+					exitWithError(exitAuth, "Access Denied: Incorrect Password or Corrupted Archive.")
+				}
+				handleFailure(err, "Failed to update archive: %v", err)
+			}
+
+			duration := time.Since(startTime)
+			pterm.DefaultSection.Println("Mission Report")
+			if len(removed) > 0 {
+				pterm.Success.Println("Entries removed successfully.")
+				pterm.DefaultBox.WithTitle("Removed Entries").Println(strings.Join(removed, "\n"))
+			} else {
+				pterm.Warning.Println("No matching entries. The archive was left unchanged.")
+			}
+
+			data := [][]string{
+				{"Archive", filepath.Base(archivePath)},
+				{"Entries Removed", fmt.Sprintf("%d", len(removed))},
+			}
+			renderSummary(data, duration, "UPDATED")
+		},
+	}
+	addUnlockFlags(removeCmd, &password, &keyfile, &identity, &keyShares)
+	removeCmd.Flags().BoolVar(&ignoreMissing, "ignore-missing", false, "Do not fail when a name or pattern matches nothing")
+	removeCmd.ValidArgsFunction = completeArchive(false)
+	return removeCmd
+}
+
+// NewConvertCmd configures the 'convert' command.
+func NewConvertCmd() *cobra.Command {
+	var (
+		outputFile string
+		password   string
+		level      string
+		codec      string
+	)
+	convertCmd := &cobra.Command{
+		Use:   "convert <archive.btxz>",
+		Short: "Upgrade a legacy archive to the current format",
+		Long: `Rewrites a V1, V2 or V3 archive as a V4 archive with a fresh salt and nonce, using the
+same password. Entries are streamed from the old archive into the new one in memory; no
+plaintext file is written to disk.
+
+The new archive is verified before it replaces the original. Use --output to keep the
+original and write the converted archive elsewhere.`,
+		Example: `  btxz convert old.btxz -p "pass"
+  btxz convert old.btxz -o upgraded.btxz --level max --codec zstd`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("ARCHIVE CONVERSION")
+			startTime := time.Now()
+			archivePath := args[0]
+			if outputFile == "" {
+				outputFile = archivePath
+			}
+
+			level = normalizeLevel(level)
+
+			if password == "" {
+				password = promptPassword("Enter archive password")
+			}
+			var kdf core.KDFParams
+			if level == "auto" {
+				level = autoLevel(&kdf)
+			}
+
+			pterm.DefaultSection.Println("Processing")
+			spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start(fmt.Sprintf("Converting '%s'...", filepath.Base(archivePath)))
+			err := core.ConvertArchive(archivePath, outputFile, password, core.CreateOptions{Level: level, Codec: codec, KDF: kdf})
+			spinner.Stop()
+
+			if err != nil {
+				if errors.Is(err, core.ErrAuthentication) {
+					exitWithError(exitAuth, "Access Denied: Incorrect Password or Corrupted Archive.")
+				}
+				handleFailure(err, "Failed to convert archive: %v", err)
+			}
+
+			duration := time.Since(startTime)
+			pterm.DefaultSection.Println("Mission Report")
+			pterm.Success.Println("Archive converted and verified.")
+
+			data := [][]string{
+				{"Source", filepath.Base(archivePath)},
+				{"Destination", outputFile},
+				{"Format", "V4"},
+				{"Profile", strings.ToUpper(level)},
+				{"Codec", strings.ToUpper(codec)},
+			}
+			renderSummary(data, duration, "CONVERTED")
+		},
+	}
+	convertCmd.Flags().StringVarP(&outputFile, "output", "o", "", "Write the converted archive here and keep the original (default: replace it)")
+	convertCmd.Flags().StringVarP(&password, "password", "p", "", "Password of the archive (uses BTXZ_PASSWORD or prompts if empty)")
+	convertCmd.Flags().StringVarP(&level, "level", "l", "default", "Profile of the new archive: low, default, max, auto, or a compression level 0-9")
+	convertCmd.Flags().StringVar(&codec, "codec", "xz", "Compression backend: xz, zstd, s2 (alias lz4), store, auto")
+	convertCmd.ValidArgsFunction = completeArchive(false)
+	convertCmd.RegisterFlagCompletionFunc("level", completeLevel)
+	return convertCmd
+}
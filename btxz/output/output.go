@@ -0,0 +1,238 @@
+// File: output.go
+// This package defines the JSON documents btxz writes to standard output when it
+// runs with --json. Every command writes exactly one document as it ends, and
+// nothing else goes to standard output. The documents are part of the
+// command-line interface: later releases may add fields, but existing fields keep
+// their names and meaning. Schema is raised if that ever has to change.
+
+package output
+
+// Schema is the version of the documents.
+const Schema = 1
+
+// The statuses of a Result.
+const (
+	StatusOK      = "ok"      // The command did everything it was asked to
+	StatusWarning = "warning" // The command completed, but skipped something; see the document
+	StatusError   = "error"   // The command failed; the process exits with a non-zero status
+)
+
+// The reasons of a Skipped entry.
+const (
+	ReasonUnsafePath    = "unsafe_path"    // The path leads outside the output directory
+	ReasonKeptExisting  = "kept_existing"  // The overwrite policy kept the file already there
+	ReasonNameCollision = "name_collision" // Its name collides with an earlier entry's, with --case-collisions skip
+)
+
+// Result is the part every document starts with. Commands without a document
+// of their own write a Result alone.
+type Result struct {
+	Schema     int    `json:"schema"`
+	Command    string `json:"command"` // e.g. "extract" or "keychain forget"
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      *Error `json:"error,omitempty"` // Set with StatusError
+}
+
+// Error describes why a command failed.
+type Error struct {
+	Message  string `json:"message"`
+	ExitCode int    `json:"exit_code"` // The exit status of the process, see the usage guide
+}
+
+// Info is the metadata of an archive. Archives that predate a field leave it out.
+type Info struct {
+	Version int      `json:"version"`           // Format version, 1 to 4
+	Created string   `json:"created,omitempty"` // RFC 3339, in UTC
+	Creator string   `json:"creator,omitempty"` // Program and version that created the archive
+	Profile string   `json:"profile,omitempty"`
+	Comment string   `json:"comment,omitempty"`
+	Backup  string   `json:"backup,omitempty"`  // "full" or "incremental", for archives of create --listed-incremental
+	Deleted []string `json:"deleted,omitempty"` // The entries an incremental backup records as deleted
+	// The fingerprint of the base archive of a differential archive, and the
+	// number of files it takes from it.
+	Base      string `json:"base,omitempty"`
+	BaseFiles int    `json:"base_files,omitempty"`
+}
+
+// Create is the document of create, also for --sync.
+type Create struct {
+	Result
+	Archive      string       `json:"archive"` // "-" for standard output
+	Inputs       []string     `json:"inputs"`
+	Encrypted    bool         `json:"encrypted"`
+	Codec        string       `json:"codec"`
+	Profile      string       `json:"profile"`
+	InputBytes   int64        `json:"input_bytes"`   // Content read from the inputs and standard input
+	ArchiveBytes int64        `json:"archive_bytes"` // Size of the archive, all volumes together
+	Excluded     int          `json:"excluded"`      // Entries left out by --exclude and --include
+	DedupFiles   int          `json:"dedup_files"`
+	DedupBytes   int64        `json:"dedup_bytes"`
+	Signed       bool         `json:"signed"`
+	KeyShares    []string     `json:"key_shares,omitempty"`   // Share files written by --split-key
+	NotPortable  []string     `json:"not_portable,omitempty"` // Entries whose names extract escaped on Windows
+	Sync         *Sync        `json:"sync,omitempty"`         // Set for --sync
+	Incremental  *Incremental `json:"incremental,omitempty"`  // Set for --listed-incremental
+	DiffBase     *DiffBase    `json:"diff_base,omitempty"`    // Set for --diff-base
+}
+
+// Sync counts the entries of an archive updated with create --sync.
+type Sync struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Removed   int `json:"removed"`
+}
+
+// Incremental describes an archive created with create --listed-incremental.
+type Incremental struct {
+	Backup    string `json:"backup"`    // "full" or "incremental"
+	Snapshot  string `json:"snapshot"`  // The snapshot file, updated for the next run
+	Unchanged int    `json:"unchanged"` // Files left out as they did not change
+	Deleted   int    `json:"deleted"`   // Entries recorded as deleted
+}
+
+// DiffBase describes the base of an archive created with create --diff-base.
+type DiffBase struct {
+	Archive     string `json:"archive"`
+	Fingerprint string `json:"fingerprint"`
+	FromBase    int    `json:"from_base"` // Files left out as the base holds them unchanged
+}
+
+// Extract is the document of extract. With --dry-run the counts tell what
+// would happen, and Planned holds the action for every entry.
+type Extract struct {
+	Result
+	Archive          string    `json:"archive"` // "-" for standard input
+	Destination      string    `json:"destination"`
+	DryRun           bool      `json:"dry_run"`
+	Signature        string    `json:"signature,omitempty"` // Outcome of --verify-key
+	Extracted        int       `json:"extracted"`
+	Overwritten      int       `json:"overwritten"`
+	Skipped          []Skipped `json:"skipped"`
+	Corrupted        []string  `json:"corrupted"`               // Files that do not match their checksum
+	OwnerNotRestored []string  `json:"owner_not_restored"`      // Files extracted with the current owner
+	VerifyFailed     []string  `json:"verify_failed,omitempty"` // With --verify, files on disk that differ from what was written
+	Deleted          []string  `json:"deleted,omitempty"`       // With --incremental, entries removed as the backup records them deleted
+	FromBase         []string  `json:"from_base,omitempty"`     // With --base, files taken from the base archive
+	BaseMissing      int       `json:"base_missing,omitempty"`  // Files of a differential archive not restored, as --base was not given
+	Renamed          []Renamed `json:"renamed,omitempty"`       // Entries written under another name, as theirs collides with an earlier one
+	Escaped          []Renamed `json:"escaped,omitempty"`       // Entries written under a name escaped for Windows
+	Planned          []Planned `json:"planned,omitempty"`
+}
+
+// Skipped is an entry that was not written, and why.
+type Skipped struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"` // ReasonUnsafePath, ReasonKeptExisting or ReasonNameCollision
+}
+
+// Renamed is an entry extracted under another name.
+type Renamed struct {
+	Name string `json:"name"`
+	As   string `json:"as"`
+}
+
+// Planned is the action extract --dry-run decided on for an entry: "create",
+// "overwrite", "ask", "skip" or "reject".
+type Planned struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// Test is the document of test. Info is left out if the archive could not be
+// verified.
+type Test struct {
+	Result
+	*Info
+	Archive   string `json:"archive"`
+	Valid     bool   `json:"valid"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Diff is the document of diff. Its status is warning if the archive and the
+// directory differ.
+type Diff struct {
+	Result
+	Archive   string   `json:"archive"`
+	Directory string   `json:"directory"`
+	Content   bool     `json:"content"` // Files were compared by content (--content), not size and time
+	Changes   []Change `json:"changes"`
+	Unchanged int      `json:"unchanged"` // Entries of the archive that match the directory
+}
+
+// Change is a path that differs between the archive and the directory. Change
+// is "missing" (only in the archive), "added" (only in the directory),
+// "modified" or "type" (a different kind of file).
+type Change struct {
+	Name   string `json:"name"`
+	Change string `json:"change"`
+}
+
+// Header is the document of info: what the header of an archive tells about
+// it, read without a password. Its status is warning if deriving the key needs
+// more memory than is available. Fields an archive does not record are left
+// out.
+type Header struct {
+	Result
+	Archive   string   `json:"archive"`
+	Version   int      `json:"version"` // Format version, 1 to 4
+	Size      int64    `json:"size"`    // Bytes of the archive, all volumes together
+	Volumes   int      `json:"volumes"`
+	Encrypted bool     `json:"encrypted"`
+	Cipher    string   `json:"cipher,omitempty"`
+	KDF       *KDF     `json:"kdf,omitempty"` // Left out if the archive is not encrypted
+	Codec     string   `json:"codec"`
+	Profile   string   `json:"profile,omitempty"`
+	DictSize  int64    `json:"dict_size,omitempty"`  // Dictionary (xz) or window (zstd) size in bytes
+	ChunkSize int64    `json:"chunk_size,omitempty"` // Plaintext bytes per encrypted chunk
+	Index     bool     `json:"index"`
+	Signed    bool     `json:"signed"`
+	KeySlots  []string `json:"key_slots,omitempty"` // The secrets of each key slot, e.g. "password + keyfile"
+}
+
+// KDF holds the Argon2id parameters of an archive.
+type KDF struct {
+	Time      uint32 `json:"time"`
+	MemoryKiB uint32 `json:"memory_kib"`
+	Threads   uint8  `json:"threads"`
+}
+
+// Keygen is the document of keygen.
+type Keygen struct {
+	Result
+	KeyFile       string `json:"key_file"`                  // The identity or signing key, kept secret
+	PublicKeyFile string `json:"public_key_file,omitempty"` // Written with --sign
+	PublicKey     string `json:"public_key"`
+	Algorithm     string `json:"algorithm"`
+}
+
+// List is the document of list.
+type List struct {
+	Result
+	Info
+	Archive string  `json:"archive"`
+	Entries []Entry `json:"entries"`
+	Totals  Totals  `json:"totals"`
+}
+
+// Entry is an entry of a List.
+type Entry struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"` // file, dir, symlink, hardlink, dedup, ...
+	Size       int64  `json:"size"`
+	Mode       uint32 `json:"mode"`        // Permission bits, e.g. 420 for 0644
+	ModeString string `json:"mode_string"` // As in ls -l, e.g. -rw-r--r--
+	ModTime    string `json:"mtime,omitempty"`
+	Link       string `json:"link,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+}
+
+// Totals sums up the entries of a List. Size is the content of the regular
+// files, what extraction writes.
+type Totals struct {
+	Entries int   `json:"entries"`
+	Files   int   `json:"files"`
+	Dirs    int   `json:"dirs"`
+	Size    int64 `json:"size"`
+}
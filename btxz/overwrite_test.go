@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateRefusesToReplace(t *testing.T) {
+	src := testTree(t)
+	for _, test := range []struct {
+		name     string
+		args     []string
+		existing string // Name of the file in the way
+	}{
+		{"archive", nil, "test.btxz"},
+		{"first volume", []string{"--volume-size", "64K"}, "test.btxz.001"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "test.btxz")
+			existing := filepath.Join(dir, test.existing)
+			if err := os.WriteFile(existing, []byte("mine"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			args := append(append([]string{"create", src, "-o", archive, "-p", "secret"}, fastKDF...), test.args...)
+
+			// Without a terminal to ask on, the answer is no.
+			r := runBTXZ(t, nil, args...)
+			if r.code != exitError {
+				t.Errorf("exit code %d, want %d: %s", r.code, exitError, r.stderr)
+			}
+			if !strings.Contains(r.stdout+r.stderr, "Pass --force") {
+				t.Errorf("the refusal does not name --force:\n%s", r.stderr)
+			}
+			if data, _ := os.ReadFile(existing); string(data) != "mine" {
+				t.Errorf("%s was replaced without --force", test.existing)
+			}
+
+			if r := runBTXZ(t, nil, append(args, "--force")...); r.code != 0 {
+				t.Fatalf("create --force exited with %d: %s", r.code, r.stderr)
+			}
+			if data, _ := os.ReadFile(existing); string(data) == "mine" {
+				t.Errorf("%s was not replaced with --force", test.existing)
+			}
+			if r := runBTXZ(t, nil, "test", archive, "-p", "secret"); r.code != 0 {
+				t.Errorf("the new archive fails the test with %d: %s", r.code, r.stderr)
+			}
+		})
+	}
+}
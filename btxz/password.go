@@ -0,0 +1,383 @@
+// File: password.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements the rekey command and the ways a password is given:
+// prompted for on the terminal, or read from a flag, a file, a descriptor or
+// the environment.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+	"unicode"
+	"btxz/core"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// NewRekeyCmd configures the 'rekey' command.
+func NewRekeyCmd() *cobra.Command {
+	var (
+		password    string
+		keyfile     string
+		identity    string
+		keyShares   []string
+		newPassword string
+		newKeyfile  string
+		allowWeak   bool
+	)
+	rekeyCmd := &cobra.Command{
+		Use:   "rekey <archive.btxz>",
+		Short: "Change the password of an archive without recompressing it",
+		Long: `Replaces the key slot opened by the current password, keyfile or identity with one for
+a new password (and/or --new-keyfile). The payload is encrypted with a random archive key
+that does not change, so nothing is decrypted or recompressed; the archive is only copied
+once. Other key slots (see create --add-password) keep working.
+
+The rekeyed archive is written to a temporary file and verified with the new secret before
+it atomically replaces the original; an interrupted rekey leaves the original untouched.
+Only V4 archives have key slots; convert older archives first.`,
+		Example: `  btxz rekey backup.btxz
+  btxz rekey nightly.btxz --keyfile old.key --new-keyfile new.key`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("ARCHIVE REKEY")
+			startTime := time.Now()
+			archivePath := args[0]
+
+			password = unlockSecret(archivePath, password, keyfile, identity, keyShares, "Enter current password")
+			if newPassword == "" && newKeyfile == "" {
+				newPassword = promptNewPassword("Set new password", allowWeak)
+				if newPassword == "" {
+					handleCmdError("Aborted: A new password is required.")
+				}
+			}
+			newPassword = withKeyfile(newPassword, newKeyfile)
+
+			pterm.DefaultSection.Println("Processing")
+			spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start(fmt.Sprintf("Rekeying '%s'...", filepath.Base(archivePath)))
+			err := core.RekeyArchive(archivePath, password, newPassword)
+			spinner.Stop()
+
+			if err != nil {
+				if errors.Is(err, core.ErrAuthentication) {
+					exitWithError(exitAuth, "Access Denied: Incorrect Password.")
+				}
+				handleFailure(err, "Failed to rekey archive: %v", err)
+			}
+
+			duration := time.Since(startTime)
+			pterm.DefaultSection.Println("Mission Report")
+			pterm.Success.Println("Archive rekeyed and verified.")
+
+			data := [][]string{
+				{"Archive", filepath.Base(archivePath)},
+				{"Payload", "Unchanged (not recompressed)"},
+			}
+			renderSummary(data, duration, "REKEYED")
+		},
+	}
+	addUnlockFlags(rekeyCmd, &password, &keyfile, &identity, &keyShares)
+	rekeyCmd.Flags().Lookup("password").Usage = "Current password (uses BTXZ_PASSWORD or prompts if empty)"
+	rekeyCmd.Flags().Lookup("keyfile").Usage = "Current keyfile, if the archive was created with one"
+	rekeyCmd.Flags().StringVar(&newPassword, "new-password", "", "New password (prompts if empty, unless --new-keyfile is given)")
+	rekeyCmd.Flags().StringVar(&newKeyfile, "new-keyfile", "", "New keyfile, alone or together with the new password")
+	rekeyCmd.Flags().BoolVar(&allowWeak, "allow-weak-password", false, "Accept a weak new password without asking for confirmation")
+	rekeyCmd.ValidArgsFunction = completeArchive(false)
+	return rekeyCmd
+}
+
+// maxPasswordAttempts is how often a new password may be mistyped before the
+// command aborts.
+const maxPasswordAttempts = 3
+
+// promptForPassword checks if a password string is empty and, if so, takes it
+// from BTXZ_PASSWORD or prompts the user for it. A weak password given on the
+// command line or in the environment only draws a warning; see
+// promptNewPassword for interactive entry.
+func promptForPassword(password *string, allowWeak bool) {
+	if *password == "" {
+		*password = os.Getenv(passwordEnv)
+		if *password == "" {
+			pterm.Info.Println("No password provided via flags.")
+			*password = promptNewPassword("Set encryption password", allowWeak)
+			return
+		}
+	}
+	if weakness := passwordWeakness(*password); weakness != "" {
+		pterm.Warning.Printf("Weak password: %s.\n", weakness)
+	}
+	if *password == "" {
+		handleCmdError("Aborted: A password is required to encrypt the archive.")
+	}
+}
+
+// promptNewPassword asks for a new password and then for it again, so a typo
+// cannot lock the user out of the archive. On a mismatch it starts over, up to
+// maxPasswordAttempts times. A weak password is only accepted after a warning
+// and a confirmation, unless allowWeak is set. An empty first entry is
+// returned as is.
+func promptNewPassword(prompt string, allowWeak bool) string {
+	mismatches := 0
+	for {
+		pass := readSecret(prompt)
+		if pass == "" {
+			return ""
+		}
+		repeated := readSecret("Confirm password")
+		if pass != repeated {
+			mismatches++
+			pterm.Warning.Printf("Passwords do not match (attempt %d of %d).\n", mismatches, maxPasswordAttempts)
+			if mismatches == maxPasswordAttempts {
+				handleCmdError("Aborted: The passwords did not match.")
+			}
+			continue
+		}
+		if weakness := passwordWeakness(pass); weakness != "" {
+			pterm.Warning.Printf("Weak password: %s.\n", weakness)
+			if !allowWeak {
+				if !confirm("Use this weak password anyway?") {
+					continue
+				}
+			}
+		}
+		return pass
+	}
+}
+
+// commonPasswords holds passwords found at the top of every leaked-password list.
+var commonPasswords = map[string]bool{
+	"123456": true, "123456789": true, "12345678": true, "password": true, "qwerty": true,
+	"qwerty123": true, "1q2w3e4r": true, "111111": true, "1234567890": true, "letmein": true,
+	"iloveyou": true, "admin": true, "welcome": true, "monkey": true, "dragon": true,
+	"football": true, "baseball": true, "sunshine": true, "princess": true, "master": true,
+	"shadow": true, "superman": true, "trustno1": true, "passw0rd": true, "abc123": true,
+	"zaq12wsx": true, "qwertyuiop": true, "asdfghjkl": true, "changeme": true, "secret": true,
+}
+
+// passwordWeakness returns why a password is weak, or "" if it looks strong
+// enough. It is a rough estimate: common passwords (also with digits or symbols
+// appended), short or repetitive passwords, simple sequences, and passwords
+// with less than about 50 bits of brute-force entropy are flagged.
+func passwordWeakness(password string) string {
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] || commonPasswords[strings.TrimRightFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) })] {
+		return "it is one of the most common passwords"
+	}
+	runes := []rune(password)
+	if len(runes) < 8 {
+		return "it is shorter than 8 characters"
+	}
+
+	distinct := make(map[rune]bool)
+	sequence := true
+	var lowerSet, upperSet, digitSet, symbolSet, otherSet bool
+	for i, r := range runes {
+		distinct[r] = true
+		if i > 1 && runes[i]-runes[i-1] != runes[1]-runes[0] {
+			sequence = false
+		}
+		switch {
+		case r >= 'a' && r <= 'z':
+			lowerSet = true
+		case r >= 'A' && r <= 'Z':
+			upperSet = true
+		case r >= '0' && r <= '9':
+			digitSet = true
+		case r < 128:
+			symbolSet = true
+		default:
+			otherSet = true
+		}
+	}
+	if len(distinct) < 4 {
+		return "it uses fewer than 4 different characters"
+	}
+	if sequence {
+		return "it is a simple sequence"
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lowerSet, 26}, {upperSet, 26}, {digitSet, 10}, {symbolSet, 33}, {otherSet, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	if bits := float64(len(runes)) * math.Log2(float64(pool)); bits < 50 {
+		return fmt.Sprintf("its estimated strength is only about %d bits; use a longer passphrase or more kinds of characters", int(bits))
+	}
+	return ""
+}
+
+// passwordSource holds the flags that supply a password without the command
+// line: --password-fd and --password-file. Together with -p, BTXZ_PASSWORD and
+// the prompt it forms the order in which a password is looked for: -p, then
+// --password-fd, then --password-file, then BTXZ_PASSWORD, then the prompt.
+type passwordSource struct {
+	fd       int    // --password-fd, -1 if not given
+	file     string // --password-file
+	insecure bool   // --insecure-permissions
+}
+
+// addFlags registers the flags of a password source on cmd.
+func (source *passwordSource) addFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVar(&source.fd, "password-fd", -1, "Read the password from this inherited file descriptor, up to a newline or EOF")
+	cmd.Flags().StringVar(&source.file, "password-file", "", "Read the password from this file (a single trailing newline is stripped)")
+	cmd.Flags().BoolVar(&source.insecure, "insecure-permissions", false, "Accept a --password-file that other users can read")
+}
+
+// resolve returns password, given by -p, or else the password read from
+// --password-fd or --password-file. It returns "" if none of them was given,
+// leaving BTXZ_PASSWORD and the prompt to promptPassword. At most one of the
+// three flags may be used.
+func (source *passwordSource) resolve(password string) string {
+	given := 0
+	for _, set := range []bool{password != "", source.fd >= 0, source.file != ""} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		handleUsageError("Only one of --password, --password-fd and --password-file can be used.")
+	}
+	switch {
+	case password != "":
+		return password
+	case source.fd >= 0:
+		return readPasswordFD(source.fd)
+	case source.file != "":
+		return readPasswordFile(source.file, source.insecure)
+	}
+	return ""
+}
+
+// readPasswordFD reads a password from an inherited file descriptor, up to the
+// first newline or EOF, similar to gpg --passphrase-fd. The descriptor is read
+// one byte at a time so nothing after the newline is consumed.
+func readPasswordFD(fd int) string {
+	file := os.NewFile(uintptr(fd), "password-fd")
+	if file == nil {
+		handleUsageError("Password descriptor error: %d is not a valid file descriptor.", fd)
+	}
+	var secret []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := file.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' {
+				break
+			}
+			secret = append(secret, buf[0])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			handleFailure(err, "Password descriptor error: %v", err)
+		}
+	}
+	password := strings.TrimSuffix(string(secret), "\r")
+	if password == "" {
+		handleCmdError("No password could be read from file descriptor %d.", fd)
+	}
+	return password
+}
+
+// readPasswordFile returns the password stored in passwordFile. A single
+// trailing newline is stripped and the rest is used verbatim. Files that other
+// users can read are refused unless insecure is set.
+func readPasswordFile(passwordFile string, insecure bool) string {
+	info, err := os.Stat(passwordFile)
+	if err != nil {
+		handleFailure(err, "Password file error: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		handleCmdError("Password file error: '%s' is not a regular file.", passwordFile)
+	}
+	// Windows does not report meaningful permission bits.
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o004 != 0 && !insecure {
+		handleCmdError("Password file '%s' is readable by every user; restrict it with 'chmod 600' or pass --insecure-permissions.", passwordFile)
+	}
+	data, err := os.ReadFile(passwordFile)
+	if err != nil {
+		handleFailure(err, "Password file error: %v", err)
+	}
+	secret := strings.TrimSuffix(string(data), "\n")
+	secret = strings.TrimSuffix(secret, "\r")
+	if secret == "" {
+		handleCmdError("Password file '%s' is empty.", passwordFile)
+	}
+	return secret
+}
+
+// passwordEnv names the environment variable that supplies the password when
+// -p is not given, in place of the interactive prompt.
+const passwordEnv = "BTXZ_PASSWORD"
+
+// promptPassword returns the password from BTXZ_PASSWORD or, if it is unset or
+// empty, asks for it.
+func promptPassword(prompt string) string {
+	password, err := enterPassword(prompt)
+	exitIfFailed(err)
+	return password
+}
+
+// enterPassword is promptPassword returning its error.
+func enterPassword(prompt string) (string, error) {
+	if password := os.Getenv(passwordEnv); password != "" {
+		return password, nil
+	}
+	return enterSecret(prompt)
+}
+
+// stdinData is set when standard input carries the data of a command, so
+// prompts read from the terminal instead.
+var stdinData bool
+
+// readSecret shows prompt and reads a secret without echoing it. Without a
+// terminal to ask on, as in a cron job, it fails at once instead of waiting
+// for input that never comes.
+func readSecret(prompt string) string {
+	secret, err := enterSecret(prompt)
+	exitIfFailed(err)
+	return secret
+}
+
+// enterSecret is readSecret returning its error.
+func enterSecret(prompt string) (string, error) {
+	if !stdinData {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return "", errNoTerminal
+		}
+		pass, _ := pterm.DefaultInteractiveTextInput.WithMask("*").Show(prompt)
+		return pass, nil
+	}
+	tty := openTerminal()
+	if tty == nil {
+		return "", errNoTerminal
+	}
+	defer tty.Close()
+	fmt.Fprintf(os.Stderr, "%s: ", prompt)
+	pass, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", failureError(err, "Password prompt error: %v", err)
+	}
+	return string(pass), nil
+}
+
+// errNoTerminal reports a password that was not given and cannot be asked
+// for, as no terminal is attached.
+var errNoTerminal = newCommandError(exitAuth, "No password was given, and there is no terminal to ask for one. Pass it with -p, --password-file, --password-fd or %s.", passwordEnv)
@@ -0,0 +1,101 @@
+// File: repair.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements the repair command.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"btxz/core"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// NewRepairCmd configures the 'repair' command.
+func NewRepairCmd() *cobra.Command {
+	var (
+		outputDir string
+		password  string
+		keyfile   string
+		identity  string
+		keyShares []string
+	)
+	repairCmd := &cobra.Command{
+		Use:   "repair <archive.btxz>",
+		Short: "Salvage files from a damaged or truncated archive",
+		Long: `Extracts everything that can still be read from a damaged V4 archive and reports which
+entries were recovered, which were cut short and which are lost.
+
+Every 4 MiB chunk of a V4 archive is authenticated on its own, so data in front of a damaged
+area is trusted and restored. If the index at the end of the archive is intact, the parts
+behind the damage are restored as well. V1 to V3 archives are sealed as a single unit; for
+them the command explains why they cannot be salvaged.`,
+		Example: `  btxz repair backup.btxz -o rescued/ -p "pass"`,
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("ARCHIVE REPAIR")
+			startTime := time.Now()
+			archivePath := args[0]
+
+			password = unlockSecret(archivePath, password, keyfile, identity, keyShares, "Enter decryption password")
+
+			pterm.DefaultSection.Println("Processing")
+			spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start(fmt.Sprintf("Salvaging '%s'...", filepath.Base(archivePath)))
+			report, err := core.RepairArchive(archivePath, outputDir, password, core.ExtractOptions{})
+			spinner.Stop()
+
+			if err != nil {
+				if errors.Is(err, core.ErrAuthentication) {
+					exitWithError(exitAuth, "Access Denied: Incorrect Password or Damaged Header.")
+				}
+				handleFailure(err, "Repair failed: %v", err)
+			}
+
+			duration := time.Since(startTime)
+			pterm.DefaultSection.Println("Mission Report")
+			if len(report.Problems) == 0 && len(report.Partial) == 0 {
+				pterm.Success.Println("No damage found. All files extracted.")
+			} else {
+				pterm.Warning.Println("Damage found. Everything readable was extracted.")
+			}
+			if len(report.Problems) > 0 {
+				pterm.DefaultBox.WithTitle("Damage").WithBoxStyle(pterm.NewStyle(pterm.FgRed)).Println(strings.Join(report.Problems, "\n"))
+			}
+			if len(report.Partial) > 0 {
+				pterm.DefaultBox.WithTitle("Partially Recovered").WithBoxStyle(pterm.NewStyle(pterm.FgYellow)).Println(strings.Join(report.Partial, "\n"))
+			}
+			if len(report.Lost) > 0 {
+				pterm.DefaultBox.WithTitle("Lost").WithBoxStyle(pterm.NewStyle(pterm.FgRed)).Println(strings.Join(report.Lost, "\n"))
+			}
+			if len(report.Skipped) > 0 {
+				pterm.DefaultBox.WithTitle("Skipped Files (Safe Mode)").WithBoxStyle(pterm.NewStyle(pterm.FgYellow)).Println(strings.Join(report.Skipped, "\n"))
+			}
+
+			status := "RECOVERED"
+			if len(report.Problems) > 0 || len(report.Partial) > 0 {
+				status = "PARTIAL"
+			}
+			data := [][]string{
+				{"Source", filepath.Base(archivePath)},
+				{"Destination", outputDir},
+				{"Recovered", fmt.Sprintf("%d", len(report.Recovered))},
+				{"Partial", fmt.Sprintf("%d", len(report.Partial))},
+				{"Lost", fmt.Sprintf("%d", len(report.Lost))},
+			}
+			renderSummary(data, duration, status)
+			if status == "PARTIAL" {
+				os.Exit(exitPartial)
+			}
+		},
+	}
+	repairCmd.Flags().StringVarP(&outputDir, "output-dir", "o", ".", "Directory to write the salvaged files to")
+	addUnlockFlags(repairCmd, &password, &keyfile, &identity, &keyShares)
+	repairCmd.ValidArgsFunction = completeArchive(false)
+	return repairCmd
+}
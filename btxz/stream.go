@@ -0,0 +1,144 @@
+// File: stream.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file reads archives that cannot be seeked: from standard input or a
+// URL, and stops them cleanly on Ctrl-C.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"btxz/core"
+	"btxz/internal/remote"
+
+	"atomicgo.dev/cursor"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// interruptContext returns a context canceled by Ctrl-C or SIGTERM. It is
+// started after the password prompts, so they can still be left with Ctrl-C.
+// A second interrupt, while the run is winding down, exits at once, and once
+// stopped, an interrupt kills the process as usual.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-signals:
+			// A progress bar hides the cursor until it stops.
+			cursor.Show()
+			fmt.Fprintln(os.Stderr, "\nInterrupted again; exiting without cleaning up.")
+			os.Exit(exitInterrupted)
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(done)
+			cancel()
+		})
+	}
+}
+
+// openStdinArchive reads the header of the archive on standard input, for an
+// archive path of -. Prompts then read from the terminal. Flags that need an
+// archive file are refused; verifyKey tells whether a signature check was asked for.
+func openStdinArchive(source passwordSource, keys keychainOption, verifyKey bool) *core.ArchiveStream {
+	stdinData = true
+	switch {
+	case source.fd == 0:
+		handleUsageError("--password-fd 0 cannot be used when the archive is read from standard input (-).")
+	case keys.enabled:
+		handleUsageError("--use-keychain cannot be used with an archive read from standard input (-); the keychain entry belongs to an archive file.")
+	case verifyKey:
+		handleUsageError("--verify-key and --require-signature cannot be used with an archive read from standard input (-); the signature follows the data it covers.")
+	}
+	stream, err := core.OpenArchiveStream(os.Stdin)
+	if err != nil {
+		exitIfStreamEnded(err)
+		handleFailure(err, "Failed to read the archive from standard input: %v", err)
+	}
+	return stream
+}
+
+// openStream opens the archive of a command that reads it front to back: from
+// standard input for an archive path of -, or from the response for an
+// http:// or https:// URL. It returns nil for an archive file. Flags that need
+// an archive file are refused; verifyKey tells whether a signature check was
+// asked for.
+func (fetch *remoteOption) openStream(archivePath string, source passwordSource, keys keychainOption, verifyKey bool) *core.ArchiveStream {
+	if !remote.IsURL(archivePath) {
+		if len(fetch.headers) > 0 {
+			handleUsageError("--header only applies to an archive given as an http:// or https:// URL.")
+		}
+		if archivePath == core.StdinPath {
+			return openStdinArchive(source, keys, verifyKey)
+		}
+		return nil
+	}
+	switch {
+	case keys.enabled:
+		handleUsageError("--use-keychain cannot be used with an archive URL; the keychain entry belongs to an archive file.")
+	case verifyKey:
+		handleUsageError("--verify-key and --require-signature cannot be used with an archive URL; download the archive to check its signature.")
+	}
+	header, err := remote.ParseHeaders(fetch.headers)
+	if err != nil {
+		handleUsageError("%v", err)
+	}
+	spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start(fmt.Sprintf("Connecting to %s...", archivePath))
+	file, err := remote.Open(context.Background(), archivePath, header)
+	var stream *core.ArchiveStream
+	if err == nil {
+		stream, err = core.OpenArchiveStream(file)
+	}
+	spinner.Stop()
+	if err != nil {
+		exitIfStreamEnded(err)
+		handleFailure(err, "Failed to read the archive from %s: %v", archivePath, err)
+	}
+	return stream
+}
+
+// remoteOption holds --header, sent with the requests for an archive URL.
+type remoteOption struct {
+	headers []string
+}
+
+// addFlags registers --header on cmd.
+func (fetch *remoteOption) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&fetch.headers, "header", nil, "HTTP header for an archive URL, e.g. 'Authorization: Bearer <token>' (repeatable)")
+}
+
+// exitIfStreamEnded reports an archive whose stream was cut short, or whose
+// URL could not be fetched, which must not be mistaken for a wrong password.
+func exitIfStreamEnded(err error) {
+	exitIfFailed(streamEndedError(err))
+}
+
+// streamEndedError returns the error exitIfStreamEnded reports for err, or nil.
+func streamEndedError(err error) error {
+	if errors.Is(err, remote.ErrNetwork) {
+		return newCommandError(exitIO, "Network Error: %v. The archive could not be fetched; this is not a password problem.", err)
+	}
+	if errors.Is(err, core.ErrStreamEnded) {
+		return newCommandError(exitIO, "Incomplete Archive: standard input ended before the archive did. The transfer was cut short; this is not a password problem.")
+	}
+	return nil
+}
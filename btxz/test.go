@@ -0,0 +1,124 @@
+// File: test.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file implements the test command.
+package main
+
+import (
+	"time"
+	"btxz/core"
+	"btxz/output"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// NewTestCmd configures the 'test' command.
+func NewTestCmd() *cobra.Command {
+	var password, keyfile, identity string
+	var keyShares []string
+	var source passwordSource
+	var signature signatureCheck
+	var keys keychainOption
+	var fetch remoteOption
+	// testArchive tests one archive, with the flags of the command, and
+	// returns its exit status.
+	testArchive := func(archivePath string) (int, error) {
+		startTime := time.Now()
+		stream := fetch.openStream(archivePath, source, keys, signature.verifyKey != "" || signature.require)
+
+		signatureStatus, err := signature.verify(archivePath)
+		if err != nil {
+			return failed(err)
+		}
+		secret := source.resolve(password)
+		secret = keys.lookup(archivePath, secret, keyfile)
+		if stream != nil {
+			secret, err = streamSecret(stream, secret, keyfile, identity, keyShares, "Enter decryption password")
+		} else {
+			secret, err = archiveSecret(archivePath, secret, keyfile, identity, keyShares, "Enter decryption password")
+		}
+		if err != nil {
+			return failed(err)
+		}
+
+		pterm.DefaultSection.Println("Analysis")
+		ctx, stop := interruptContext()
+		spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start("Verifying structure and checksums...")
+		var info core.ArchiveInfo
+		if stream != nil {
+			info, err = stream.Verify(ctx, secret)
+		} else {
+			info, err = core.VerifyArchiveContext(ctx, archivePath, secret)
+		}
+		spinner.Stop()
+		stop()
+		exitIfInterrupted(err, "The archive was not fully verified.")
+
+		if err != nil {
+			if failure := streamEndedError(err); failure != nil {
+				return failed(failure)
+			}
+			if failure := limitError(err); failure != nil {
+				return failed(failure)
+			}
+			pterm.Error.Println("INTEGRITY CHECK FAILED")
+			pterm.Error.Println(err.Error())
+			code := exitStatus(err)
+			if jsonOutput.enabled {
+				result := jsonOutput.result(output.StatusError)
+				result.Error = &output.Error{Message: err.Error(), ExitCode: code}
+				jsonOutput.write(output.Test{Result: result, Archive: archivePath, Signature: signatureStatus})
+			}
+			return code, nil
+		}
+
+		duration := time.Since(startTime)
+		pterm.DefaultSection.Println("Mission Report")
+		pterm.Success.Println("Verification Passed.")
+		
+		data := [][]string{
+			{"Target", archiveLabel(archivePath)},
+			{"Integrity", "VALID"},
+		}
+		if signatureStatus != "" {
+			data = append(data, []string{"Signature", signatureStatus})
+		}
+		data = append(data, archiveInfoRows(info)...)
+		renderSummary(data, duration, "VERIFIED")
+		if jsonOutput.enabled {
+			archiveInfo := infoDocument(info)
+			jsonOutput.write(output.Test{Result: jsonOutput.result(output.StatusOK), Info: &archiveInfo, Archive: archivePath, Valid: true, Signature: signatureStatus})
+		}
+		keys.offer(archivePath, secret)
+		return 0, nil
+	}
+	testCmd := &cobra.Command{
+		Use:   "test <archive.btxz>...",
+		Short: "Test integrity of one or more archives",
+		Long: `Verifies the integrity of a .btxz archive of any version by decrypting and decompressing the stream, and reading every entry, without writing to disk.
+
+Several archives are tested one after another, and a failure does not stop the others;
+see "Several archives" below.` + batchHelp + urlHelp,
+		Example: `  btxz test backup.btxz -p "s3cr3t!"
+  btxz test backups/*.btxz --password-file pass.txt
+  btxz test https://example.com/backup.btxz --password-file pass.txt`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			printCommandHeader("INTEGRITY VERIFICATION")
+			archives := archiveArgs(args)
+			if len(archives) > 1 {
+				runBatch(archives, &password, &source, &keys, keyfile, identity, keyShares, testArchive)
+				return
+			}
+			exitWith(testArchive(archives[0]))
+		},
+	}
+	addUnlockFlags(testCmd, &password, &keyfile, &identity, &keyShares)
+	source.addFlags(testCmd)
+	keys.addFlags(testCmd)
+	signature.addFlags(testCmd)
+	fetch.addFlags(testCmd)
+	testCmd.ValidArgsFunction = completeArchive(true)
+	return testCmd
+}
@@ -0,0 +1,213 @@
+// File: ui.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file holds the terminal helpers the commands share: sizes and labels,
+// progress bars, tables, and questions for the user.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+	"btxz/core"
+
+	"github.com/pterm/pterm"
+	"golang.org/x/term"
+)
+
+// archiveLabel names an archive path in reports.
+func archiveLabel(archivePath string) string {
+	if archivePath == core.StdinPath {
+		return "(standard input)"
+	}
+	return filepath.Base(archivePath)
+}
+
+// archiveSize returns the size of an archive on disk, adding up all volumes of a split archive.
+func archiveSize(archivePath string) int64 {
+	if info, err := os.Stat(archivePath); err == nil {
+		return info.Size()
+	}
+	var total int64
+	for n := 1; ; n++ {
+		info, err := os.Stat(fmt.Sprintf("%s.%03d", archivePath, n))
+		if err != nil {
+			return total
+		}
+		total += info.Size()
+	}
+}
+
+// formatSize formats a byte count with a binary unit, e.g. "32 MiB".
+func formatSize(n int64) string {
+	units := []string{"bytes", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	value := float64(n)
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 || value == float64(int64(value)) {
+		return fmt.Sprintf("%d %s", int64(value), units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
+
+// renderTable draws rows of labels and values as a boxed table.
+func renderTable(rows [][]string) {
+	pterm.DefaultTable.WithData(rows).WithBoxed().Render()
+}
+
+// renderSummary draws the report a command ends with: rows, followed by the
+// time the command took and its status.
+func renderSummary(rows [][]string, elapsed time.Duration, status string) {
+	renderTable(append(rows,
+		[]string{"Time Elapsed", elapsed.Round(time.Millisecond).String()},
+		[]string{"Status", status},
+	))
+}
+
+// byteProgress renders the progress reported by core. A spinner is shown until
+// the total size is known, then a progress bar with the bytes processed, the
+// throughput and the estimated time remaining. If the total stays unknown, the
+// spinner shows the bytes processed and the current entry instead.
+type byteProgress struct {
+	task    string
+	spinner *pterm.SpinnerPrinter
+	bar     *pterm.ProgressbarPrinter
+	start   time.Time
+	updated time.Time // Last time the display was updated
+	shown   int64     // Bytes already added to the bar
+	paused  bool      // The display was removed for a prompt
+}
+
+// newByteProgress shows a spinner with text until the first report.
+func newByteProgress(task, text string) *byteProgress {
+	spinner, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).Start(text)
+	return &byteProgress{task: task, spinner: spinner, start: time.Now()}
+}
+
+// update is a core.ProgressFunc.
+func (p *byteProgress) update(done, total int64) {
+	p.extract("", done, total)
+}
+
+// extract is a core.ExtractProgressFunc. The display is redrawn at most ten
+// times a second.
+func (p *byteProgress) extract(name string, done, total int64) {
+	now := time.Now()
+	if (total == 0 || done < total) && now.Sub(p.updated) < 100*time.Millisecond {
+		return
+	}
+	p.updated = now
+	if p.paused {
+		p.paused = false
+		if total == 0 {
+			p.spinner, _ = pterm.DefaultSpinner.WithRemoveWhenDone(true).Start(p.task + "...")
+		}
+	}
+	if total == 0 {
+		text := fmt.Sprintf("%s %s", p.task, formatSize(done))
+		if name != "" {
+			text += ": " + name
+		}
+		p.spinner.UpdateText(text)
+		return
+	}
+	if p.bar == nil {
+		p.spinner.Stop()
+		p.start = now
+		p.bar, _ = pterm.DefaultProgressbar.WithTotal(int(total)).WithShowCount(false).WithRemoveWhenDone(true).Start(p.task + "...")
+		return
+	}
+	done = min(done, total)
+	title := fmt.Sprintf("%s %s of %s", p.task, formatSize(done), formatSize(total))
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 && done > 0 {
+		rate := float64(done) / elapsed
+		remaining := time.Duration(float64(total-done) / rate * float64(time.Second)).Round(time.Second)
+		title += fmt.Sprintf(", %s/s, ETA %s", formatSize(int64(rate)), remaining)
+	}
+	p.bar.Title = title
+	p.bar.Add(int(done - p.shown))
+	p.shown = done
+}
+
+// stop removes the spinner, or the bar if it did not fill up.
+func (p *byteProgress) stop() {
+	p.spinner.Stop()
+	if p.bar != nil {
+		p.bar.Stop()
+	}
+}
+
+// pause removes the display for a prompt. The next report shows it again.
+func (p *byteProgress) pause() {
+	p.stop()
+	p.bar, p.shown = nil, 0
+	p.paused = true
+}
+
+// confirm asks a yes/no question whose default is no. Without a terminal,
+// the default is the answer.
+func confirm(question string) bool {
+	if !stdinData {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return false
+		}
+		answer, _ := pterm.DefaultInteractiveConfirm.WithDefaultValue(false).Show(question)
+		return answer
+	}
+	tty := openTerminal()
+	if tty == nil {
+		return false
+	}
+	defer tty.Close()
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", question)
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// choose asks the question and returns one of the options, the second if the
+// answer is empty or not one of them, or if there is no terminal.
+func choose(question string, options []string) string {
+	if !stdinData {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return options[1]
+		}
+		answer, _ := pterm.DefaultInteractiveSelect.WithOptions(options).WithDefaultOption(options[1]).Show(question)
+		return answer
+	}
+	tty := openTerminal()
+	if tty == nil {
+		return options[1]
+	}
+	defer tty.Close()
+	fmt.Fprintf(os.Stderr, "%s [%s]: ", question, strings.Join(options, "/"))
+	answer, _ := bufio.NewReader(tty).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	for _, option := range options {
+		if answer == option || len(answer) == 1 && strings.HasPrefix(option, answer) {
+			return option
+		}
+	}
+	return options[1]
+}
+
+// openTerminal opens the terminal of the process, for prompts while standard
+// input carries data. It returns nil if the process has no terminal.
+func openTerminal() *os.File {
+	name := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		name = "CONIN$"
+	}
+	tty, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return nil
+	}
+	return tty
+}
@@ -0,0 +1,203 @@
+// File: unlock.go
+
+// Package main implements the command-line interface for BTXZ.
+// This file turns the password, keyfile, identities, key shares and security
+// keys given on the command line into the secret that opens an archive.
+package main
+
+import (
+	"btxz/core"
+	"btxz/internal/fido2"
+
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+// addUnlockFlags registers the flags that give the secrets of an existing
+// archive: its password, keyfile, identity and key shares.
+func addUnlockFlags(cmd *cobra.Command, password, keyfile, identity *string, keyShares *[]string) {
+	cmd.Flags().StringVarP(password, "password", "p", "", "Password for decryption (uses BTXZ_PASSWORD or prompts if empty)")
+	cmd.Flags().StringVar(keyfile, "keyfile", "", "Keyfile for decryption, if the archive was created with one")
+	cmd.Flags().StringVar(identity, "identity", "", "Identity file, if the archive was encrypted to a recipient")
+	cmd.Flags().StringArrayVar(keyShares, "key-share", nil, "Key share file, if the key was split with --split-key (repeat for each share)")
+}
+
+// unencrypted reports whether archivePath is a readable, unencrypted archive.
+func unencrypted(archivePath string) bool {
+	slots, err := core.RequiredSecrets(archivePath)
+	return err == nil && len(slots) == 0
+}
+
+// withKeyfile combines a password with the keyfile given by --keyfile, if any.
+func withKeyfile(password, keyfile string) string {
+	secret, err := keyfileSecret(password, keyfile)
+	exitIfFailed(err)
+	return secret
+}
+
+// keyfileSecret is withKeyfile returning its error.
+func keyfileSecret(password, keyfile string) (string, error) {
+	if keyfile == "" {
+		return password, nil
+	}
+	secret, err := core.KeyfileSecret(password, keyfile)
+	if err != nil {
+		return "", failureError(err, "Keyfile error: %v", err)
+	}
+	return secret, nil
+}
+
+// unlockSecret returns the secret that opens an archive. It prompts for the
+// password only if none was given and every key slot the given flags can open
+// needs one, and stops early if the archive needs a keyfile or identity that
+// was not given. Key shares, if given, take the place of the password. For
+// unencrypted archives it warns and returns "".
+func unlockSecret(archivePath, password, keyfile, identity string, keyShares []string, prompt string) string {
+	secret, err := archiveSecret(archivePath, password, keyfile, identity, keyShares, prompt)
+	exitIfFailed(err)
+	return secret
+}
+
+// unlockStream is unlockSecret for an archive read from standard input.
+func unlockStream(stream *core.ArchiveStream, password, keyfile, identity string, keyShares []string, prompt string) string {
+	secret, err := streamSecret(stream, password, keyfile, identity, keyShares, prompt)
+	exitIfFailed(err)
+	return secret
+}
+
+// archiveSecret is unlockSecret returning its error.
+func archiveSecret(archivePath, password, keyfile, identity string, keyShares []string, prompt string) (string, error) {
+	slots, err := core.RequiredSecrets(archivePath)
+	return unlockSlots(slots, err, func() (*core.FIDO2Binding, error) { return core.ArchiveFIDO2(archivePath) }, password, keyfile, identity, keyShares, prompt)
+}
+
+// streamSecret is unlockStream returning its error.
+func streamSecret(stream *core.ArchiveStream, password, keyfile, identity string, keyShares []string, prompt string) (string, error) {
+	slots, err := stream.RequiredSecrets()
+	return unlockSlots(slots, err, func() (*core.FIDO2Binding, error) { return stream.FIDO2(), nil }, password, keyfile, identity, keyShares, prompt)
+}
+
+// unlockSlots implements archiveSecret for the key slots of an archive, or
+// the error met reading them; archiveFIDO2 returns its security key binding.
+func unlockSlots(slots []core.SecretKinds, err error, archiveFIDO2 func() (*core.FIDO2Binding, error), password, keyfile, identity string, keyShares []string, prompt string) (string, error) {
+	if err != nil {
+		// Unreadable archives are reported by the command itself.
+		slots = []core.SecretKinds{{Password: true}}
+	}
+	if len(slots) == 0 {
+		pterm.Warning.Println("This archive is not encrypted; no password is needed.")
+		return "", nil
+	}
+	var recipientSlot, keyfileSlot, sharesSlot bool
+	var matching []core.SecretKinds
+	for _, slot := range slots {
+		recipientSlot = recipientSlot || slot.Identity
+		keyfileSlot = keyfileSlot || slot.Keyfile
+		sharesSlot = sharesSlot || slot.Shares
+		if !slot.Identity && !slot.Shares && slot.Keyfile == (keyfile != "") {
+			matching = append(matching, slot)
+		}
+	}
+
+	if len(keyShares) > 0 {
+		if !sharesSlot {
+			return "", newCommandError(exitAuth, "Access Denied: The key of this archive is not split into shares.")
+		}
+		secret, err := core.ShareSecret(keyShares)
+		if err != nil {
+			return "", failureError(err, "Key share error: %v", err)
+		}
+		return secret, nil
+	}
+
+	if identity != "" {
+		if !recipientSlot {
+			return "", newCommandError(exitAuth, "Access Denied: This archive is protected by a password or keyfile, not a recipient key.")
+		}
+		secret, err := core.IdentitySecret(identity)
+		if err != nil {
+			return "", failureError(err, "Identity error: %v", err)
+		}
+		return secret, nil
+	}
+	if len(matching) == 0 {
+		switch {
+		case keyfile == "" && keyfileSlot:
+			return "", newCommandError(exitAuth, "Access Denied: This archive was created with a keyfile; pass it with --keyfile.")
+		case recipientSlot && !keyfileSlot:
+			return "", newCommandError(exitAuth, "Access Denied: This archive is encrypted to a recipient; pass its identity file with --identity.")
+		case sharesSlot && !keyfileSlot:
+			return "", newCommandError(exitAuth, "Access Denied: The key of this archive is split into shares; pass enough of them with --key-share.")
+		}
+		// A keyfile for an archive without one; let authentication fail.
+		matching = []core.SecretKinds{{Password: true}}
+	}
+	needPassword, needFIDO2, someFIDO2 := true, true, false
+	for _, slot := range matching {
+		needPassword = needPassword && slot.Password
+		needFIDO2 = needFIDO2 && slot.FIDO2
+		someFIDO2 = someFIDO2 || slot.FIDO2
+	}
+	if password == "" && needPassword {
+		if password, err = enterPassword(prompt); err != nil {
+			return "", err
+		}
+	}
+	secret, err := keyfileSecret(password, keyfile)
+	if err != nil {
+		return "", err
+	}
+	if someFIDO2 {
+		binding, err := archiveFIDO2()
+		if err != nil || binding == nil {
+			return "", newCommandError(exitAuth, "Access Denied: The archive needs a security key but holds no valid binding.")
+		}
+		key, err := fido2.Open()
+		switch {
+		case err == nil:
+			return securityKeySecret(key, binding, secret)
+		case needFIDO2:
+			return "", failureError(err, "Security key error: %v", err)
+		default:
+			// Other slots, such as a recovery password, open without the key.
+			pterm.Warning.Printf("Security key not available (%v); trying the key slots that do not need it.\n", err)
+		}
+	}
+	return secret, nil
+}
+
+// bindSecurityKey binds a new archive to the resident btxz credential of the
+// connected FIDO2 security key, and returns the binding together with secret
+// combined with the response of the key.
+func bindSecurityKey(secret string) (*core.FIDO2Binding, string) {
+	key, err := fido2.Open()
+	if err != nil {
+		handleFailure(err, "Security key error: %v", err)
+	}
+	credential, err := key.Credential(fido2.RelyingParty)
+	if err != nil {
+		handleFailure(err, "Security key error: %v", err)
+	}
+	binding, err := core.NewFIDO2Binding(credential)
+	if err != nil {
+		handleFailure(err, "Security key error: %v", err)
+	}
+	combined, err := securityKeySecret(key, binding, secret)
+	exitIfFailed(err)
+	return binding, combined
+}
+
+// securityKeySecret combines secret with the hmac-secret response of the
+// security key for binding.
+func securityKeySecret(key fido2.Authenticator, binding *core.FIDO2Binding, secret string) (string, error) {
+	pterm.Info.Println("Touch your security key if it blinks...")
+	response, err := key.HMACSecret(fido2.RelyingParty, binding.CredentialID, binding.Salt)
+	if err != nil {
+		return "", failureError(err, "Security key error: %v", err)
+	}
+	combined, err := core.FIDO2Secret(secret, response)
+	if err != nil {
+		return "", failureError(err, "Security key error: %v", err)
+	}
+	return combined, nil
+}
@@ -0,0 +1,209 @@
+package update
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fastRetries shortens the delays of the client for the duration of a test.
+func fastRetries(t *testing.T) {
+	t.Helper()
+	delay, stall := retryDelay, stallTimeout
+	retryDelay, stallTimeout = time.Millisecond, 200*time.Millisecond
+	t.Cleanup(func() { retryDelay, stallTimeout = delay, stall })
+}
+
+// release is the file the test servers serve.
+var release = bytes.Repeat([]byte("btxz release binary "), 4096)
+
+// serve starts a server whose handler is called with the number of the
+// request, from 1, and returns its URL and the request counter.
+func serve(t *testing.T, handler func(n int32, w http.ResponseWriter, r *http.Request)) (string, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(requests.Add(1), w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/btxz", &requests
+}
+
+// serveFrom writes release from the first byte the Range header of r asks
+// for, as a 206 response, or all of it.
+func serveFrom(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("ETag", `"v1"`)
+	var start int
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start); err != nil || r.Header.Get("If-Range") != `"v1"` {
+		serveAll(w)
+		return
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(release)-1, len(release)))
+	w.Header().Set("Content-Length", fmt.Sprint(len(release)-start))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(release[start:])
+}
+
+// serveAll writes all of release.
+func serveAll(w http.ResponseWriter) {
+	w.Header().Set("Content-Length", fmt.Sprint(len(release)))
+	w.Write(release)
+}
+
+// half writes the first half of release, announcing all of it, and flushes it.
+func half(w http.ResponseWriter) {
+	w.Header().Set("ETag", `"v1"`)
+	w.Header().Set("Content-Length", fmt.Sprint(len(release)))
+	w.Write(release[:len(release)/2])
+	w.(http.Flusher).Flush()
+}
+
+func TestDownloadRetriesServerErrors(t *testing.T) {
+	fastRetries(t)
+	url, requests := serve(t, func(n int32, w http.ResponseWriter, r *http.Request) {
+		if n < maxAttempts {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		serveFrom(w, r)
+	})
+	data, err := download(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, release) {
+		t.Errorf("downloaded %d bytes, want %d", len(data), len(release))
+	}
+	if n := requests.Load(); n != maxAttempts {
+		t.Errorf("%d requests, want %d", n, maxAttempts)
+	}
+}
+
+func TestDownloadGivesUp(t *testing.T) {
+	fastRetries(t)
+	url, requests := serve(t, func(n int32, w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	})
+	_, err := download(url)
+	var status *statusError
+	if !errors.As(err, &status) || status.code != http.StatusInternalServerError {
+		t.Errorf("err = %v, want a 500 status error", err)
+	}
+	if n := requests.Load(); n != maxAttempts {
+		t.Errorf("%d requests, want %d", n, maxAttempts)
+	}
+}
+
+func TestNotFoundIsNotRetried(t *testing.T) {
+	fastRetries(t)
+	for name, get := range map[string]func(url string) ([]byte, error){"download": download, "fetch": fetch} {
+		t.Run(name, func(t *testing.T) {
+			url, requests := serve(t, func(n int32, w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			})
+			if _, err := get(url); !errors.Is(err, errNotFound) {
+				t.Errorf("err = %v, want errNotFound", err)
+			}
+			if n := requests.Load(); n != 1 {
+				t.Errorf("%d requests, want 1", n)
+			}
+		})
+	}
+}
+
+func TestFetchRetriesServerErrors(t *testing.T) {
+	fastRetries(t)
+	url, requests := serve(t, func(n int32, w http.ResponseWriter, r *http.Request) {
+		if n == 1 {
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("manifest"))
+	})
+	data, err := fetch(url)
+	if err != nil || string(data) != "manifest" {
+		t.Errorf("fetch = %q, %v", data, err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+}
+
+func TestDownloadResumes(t *testing.T) {
+	fastRetries(t)
+	for _, test := range []struct {
+		name string
+		cut  func(w http.ResponseWriter, r *http.Request) // Ends the first response part way
+	}{
+		{"dropped connection", func(w http.ResponseWriter, r *http.Request) {
+			half(w)
+			panic(http.ErrAbortHandler)
+		}},
+		{"stall", func(w http.ResponseWriter, r *http.Request) {
+			half(w)
+			<-r.Context().Done()
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var ranges []string
+			url, requests := serve(t, func(n int32, w http.ResponseWriter, r *http.Request) {
+				ranges = append(ranges, r.Header.Get("Range"))
+				if n == 1 {
+					test.cut(w, r)
+					return
+				}
+				serveFrom(w, r)
+			})
+			data, err := download(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, release) {
+				t.Errorf("downloaded %d bytes, want %d", len(data), len(release))
+			}
+			if n := requests.Load(); n != 2 {
+				t.Fatalf("%d requests, want 2", n)
+			}
+			if want := fmt.Sprintf("bytes=%d-", len(release)/2); ranges[1] != want {
+				t.Errorf("the second request asked for %q, want %q", ranges[1], want)
+			}
+		})
+	}
+}
+
+func TestDownloadStartsOverWithoutRanges(t *testing.T) {
+	fastRetries(t)
+	url, _ := serve(t, func(n int32, w http.ResponseWriter, r *http.Request) {
+		if n == 1 {
+			half(w)
+			panic(http.ErrAbortHandler)
+		}
+		// A server that ignores Range sends the whole file again.
+		serveAll(w)
+	})
+	data, err := download(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, release) {
+		t.Errorf("downloaded %d bytes, want %d", len(data), len(release))
+	}
+}
+
+func TestDownloadStallIsReported(t *testing.T) {
+	fastRetries(t)
+	url, _ := serve(t, func(n int32, w http.ResponseWriter, r *http.Request) {
+		half(w)
+		<-r.Context().Done()
+	})
+	_, err := download(url)
+	if !errors.Is(err, errStalled) || !strings.Contains(err.Error(), stallTimeout.String()) {
+		t.Errorf("err = %v, want a stall after %s", err, stallTimeout)
+	}
+}
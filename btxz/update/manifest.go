@@ -0,0 +1,93 @@
+// File: manifest.go
+// This file verifies the signature of the release manifest. version.json is
+// signed with minisign at release time, and the signature is published next to
+// it as version.json.minisig. The manifest carries the SHA-256 of every
+// platform binary, so a valid signature vouches for the binaries too. A
+// manifest that is unsigned or does not match the embedded public key is
+// refused: there is no fallback to trusting it.
+
+package update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// signatureURL is where the minisign signature of the manifest is published.
+const signatureURL = versionURL + ".minisig"
+
+// manifestPublicKey is the minisign public key the release manifest must be
+// signed with. It is not part of the source: the maintainers supply it when a
+// release is built, as scripts/build.sh does from BTXZ_RELEASE_PUBLIC_KEY:
+//
+//	go build -ldflags "-X btxz/update.manifestPublicKey=RW..."
+//
+// A build without it refuses every manifest, so it cannot update itself.
+var manifestPublicKey = ""
+
+// ErrBadSignature is returned when the release manifest is unsigned, or its
+// signature does not match the public key built into btxz. The manifest may
+// have been tampered with, so nothing it names is downloaded.
+var ErrBadSignature = errors.New("security check failed: the release manifest is not signed by the BTXZ release key")
+
+// The algorithms of a minisign key or signature.
+const (
+	algorithmEd       = "Ed" // Ed25519 over the data itself
+	algorithmPrehash  = "ED" // Ed25519 over the BLAKE2b-512 hash of the data
+	keyIDSize         = 8
+	minisigPublicSize = 2 + keyIDSize + ed25519.PublicKeySize
+	minisigSize       = 2 + keyIDSize + ed25519.SignatureSize
+)
+
+// verifyManifest checks that sig, the text of a .minisig file, is a valid
+// signature of manifest by the embedded public key, trusted comment included.
+func verifyManifest(manifest, sig []byte) error {
+	if manifestPublicKey == "" {
+		return fmt.Errorf("%w (this build has no release key; only release builds can update themselves)", ErrBadSignature)
+	}
+	key, err := base64.StdEncoding.DecodeString(manifestPublicKey)
+	if err != nil || len(key) != minisigPublicSize || string(key[:2]) != algorithmEd {
+		return errors.New("invalid built-in release key")
+	}
+	keyID, public := key[2:2+keyIDSize], ed25519.PublicKey(key[2+keyIDSize:])
+
+	// untrusted comment, signature, trusted comment, global signature
+	lines := strings.Split(strings.ReplaceAll(string(sig), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "untrusted comment:") || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("%w (malformed signature file)", ErrBadSignature)
+	}
+	signature, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(signature) != minisigSize {
+		return fmt.Errorf("%w (malformed signature)", ErrBadSignature)
+	}
+	if !bytes.Equal(signature[2:2+keyIDSize], keyID) {
+		return fmt.Errorf("%w (signed by another key)", ErrBadSignature)
+	}
+	message := manifest
+	switch string(signature[:2]) {
+	case algorithmPrehash:
+		hash := blake2b.Sum512(manifest)
+		message = hash[:]
+	case algorithmEd:
+	default:
+		return fmt.Errorf("%w (unknown signature algorithm)", ErrBadSignature)
+	}
+	if !ed25519.Verify(public, message, signature[2+keyIDSize:]) {
+		return ErrBadSignature
+	}
+
+	// The global signature covers the signature and the trusted comment.
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	signed := append(append([]byte{}, signature[2+keyIDSize:]...), trustedComment...)
+	if err != nil || !ed25519.Verify(public, signed, global) {
+		return fmt.Errorf("%w (invalid trusted comment)", ErrBadSignature)
+	}
+	return nil
+}
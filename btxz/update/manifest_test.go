@@ -0,0 +1,73 @@
+package update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// testKey builds a release key into btxz for the duration of a test and
+// returns its secret half and key ID.
+func testKey(t *testing.T) (ed25519.PrivateKey, []byte) {
+	t.Helper()
+	public, secret, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := []byte("btxztest")
+	saved := manifestPublicKey
+	manifestPublicKey = base64.StdEncoding.EncodeToString(append(append([]byte(algorithmEd), keyID...), public...))
+	t.Cleanup(func() { manifestPublicKey = saved })
+	return secret, keyID
+}
+
+// sign returns the text of a .minisig file signing manifest, as
+// minisign -S writes it.
+func sign(secret ed25519.PrivateKey, keyID, manifest []byte, trustedComment string) []byte {
+	hash := blake2b.Sum512(manifest)
+	signature := ed25519.Sign(secret, hash[:])
+	global := ed25519.Sign(secret, append(append([]byte{}, signature...), trustedComment...))
+	return []byte(fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte(algorithmPrehash), keyID...), signature...)),
+		trustedComment, base64.StdEncoding.EncodeToString(global)))
+}
+
+func TestVerifyManifest(t *testing.T) {
+	secret, keyID := testKey(t)
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := []byte(`{"version": "2.0.0"}`)
+	good := sign(secret, keyID, manifest, "btxz v2.0.0")
+	if err := verifyManifest(manifest, good); err != nil {
+		t.Fatalf("a good signature fails: %v", err)
+	}
+	for _, test := range []struct {
+		name     string
+		manifest []byte
+		sig      []byte
+	}{
+		{"tampered manifest", []byte(`{"version": "6.6.6"}`), good},
+		{"another key", manifest, sign(other, keyID, manifest, "btxz v2.0.0")},
+		{"another key ID", manifest, sign(secret, []byte("otherkey"), manifest, "btxz v2.0.0")},
+		{"tampered comment", manifest, bytes.Replace(good, []byte("v2.0.0"), []byte("v9.9.9"), 1)},
+		{"malformed", manifest, []byte("not a signature")},
+	} {
+		if err := verifyManifest(test.manifest, test.sig); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: err = %v, want ErrBadSignature", test.name, err)
+		}
+	}
+
+	// A build without a release key trusts no manifest.
+	manifestPublicKey = ""
+	if err := verifyManifest(manifest, good); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("without a release key: err = %v, want ErrBadSignature", err)
+	}
+}
@@ -4,14 +4,18 @@
 package update
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"runtime"
+	"strings"
 	"sync"
+	"time"
 	"bytes"
 
 	"github.com/inconshreveable/go-update"
@@ -27,11 +31,28 @@ const updateArt = `
  ╚══════════════════════════════════╝
       ↓↓ INSTALLING PATCHES ↓↓`
 
-// ReleaseInfo defines the structure of the version.json file on GitHub.
+// The release channels. Stable gets the releases; beta also gets the
+// pre-releases, for machines that opt into them.
+const (
+	ChannelStable = "stable"
+	ChannelBeta   = "beta"
+)
+
+// Channel is the channel updates are looked for on, ChannelStable if empty.
+var Channel string
+
+// ReleaseInfo defines the structure of the version.json file on GitHub. Its
+// top-level fields are the release of the stable channel, which versions of
+// btxz without channels read; Channels holds those of the other channels.
 type ReleaseInfo struct {
 	Version   string                     `json:"version"`
 	Notes     string                     `json:"notes"`
 	Platforms map[string]PlatformDetails `json:"platforms"`
+	Channels  map[string]ReleaseInfo     `json:"channels,omitempty"`
+
+	// Channel is the channel of the release, set once it is picked from the
+	// manifest.
+	Channel string `json:"-"`
 }
 
 // PlatformDetails contains the download URL and security checksum for a specific architecture.
@@ -40,9 +61,46 @@ type PlatformDetails struct {
 	Checksum string `json:"sha256"` // SHA256 hash of the binary
 }
 
+// CheckChannel returns an error unless name is a release channel.
+func CheckChannel(name string) error {
+	switch name {
+	case ChannelStable, ChannelBeta:
+		return nil
+	}
+	return fmt.Errorf("unknown release channel %q: use stable or beta", name)
+}
+
+// channel returns the release of the named channel in the manifest, or nil if
+// the channel has none.
+func (r *ReleaseInfo) channel(name string) *ReleaseInfo {
+	if name == ChannelStable {
+		stable := *r
+		stable.Channels, stable.Channel = nil, name
+		return &stable
+	}
+	release, ok := r.Channels[name]
+	if !ok || release.Version == "" {
+		return nil
+	}
+	release.Channels, release.Channel = nil, name
+	return &release
+}
+
+// currentChannel returns Channel, or ChannelStable if it is empty.
+func currentChannel() string {
+	if Channel == "" {
+		return ChannelStable
+	}
+	return Channel
+}
+
+// maxManifestSize bounds the size of the manifest and of its signature.
+const maxManifestSize = 1 << 20
+
 // Cache for the latest release info.
 var (
 	latestRelease *ReleaseInfo
+	checkErr      error // Why the last check failed, if it did
 	checkOnce     sync.Once
 	mu            sync.RWMutex
 )
@@ -50,42 +108,113 @@ var (
 // CheckForUpdates fetches release information from GitHub.
 // It is designed to be run in a goroutine and will not block.
 // It handles network errors gracefully by simply doing nothing.
+// A manifest that fails signature verification is never used.
+// Only the release of Channel is compared with currentVersion.
 func CheckForUpdates(currentVersion string) {
 	checkOnce.Do(func() {
-		resp, err := http.Get(versionURL)
+		manifest, err := fetchRelease()
+		mu.Lock()
+		defer mu.Unlock()
+		latestRelease, checkErr = nil, err
 		if err != nil {
-			return // Fail silently on network errors
+			return
 		}
-		defer resp.Body.Close()
-
-		var release ReleaseInfo
-		if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-			return // Fail silently on JSON parsing errors
+		release := manifest.channel(currentChannel())
+		if release == nil {
+			return
 		}
 
-		// Simple string comparison works for "v1.0" > "v1.0"
-		if release.Version > currentVersion {
-			mu.Lock()
-			latestRelease = &release
-			mu.Unlock()
+		if isNewer(release.Version, currentVersion) {
+			latestRelease = release
 		}
 	})
 }
 
+// fetchRelease downloads the manifest and its signature, and returns the
+// release the manifest describes once the signature is verified.
+func fetchRelease() (*ReleaseInfo, error) {
+	manifest, err := fetch(versionURL)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := fetch(signatureURL)
+	if errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("%w (the manifest is unsigned)", ErrBadSignature)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyManifest(manifest, sig); err != nil {
+		return nil, err
+	}
+
+	var release ReleaseInfo
+	if err := json.Unmarshal(manifest, &release); err != nil {
+		return nil, fmt.Errorf("invalid release manifest: %w", err)
+	}
+	return &release, nil
+}
+
+// errNotFound is returned by fetch for a file the server does not have.
+var errNotFound = errors.New("not found")
+
+// fetch downloads a small file, retrying transient failures.
+func fetch(url string) ([]byte, error) {
+	var data []byte
+	err := retry(context.Background(), func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		defer cancel()
+		var err error
+		data, err = fetchOnce(ctx, url)
+		return err
+	})
+	return data, err
+}
+
+// fetchOnce makes one attempt of fetch.
+func fetchOnce(ctx context.Context, url string) ([]byte, error) {
+	resp, err := get(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(url, resp)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxManifestSize {
+		return nil, fmt.Errorf("%s: too large", url)
+	}
+	return data, nil
+}
+
 // DisplayUpdateNotification prints a prominent warning if a new version is available.
 func DisplayUpdateNotification() {
 	mu.RLock()
-	release := latestRelease
+	release, err := latestRelease, checkErr
 	mu.RUnlock()
 
+	if errors.Is(err, ErrBadSignature) || errors.Is(err, errProxyAuth) {
+		// Not an outage: someone may be serving a forged manifest, or the
+		// proxy needs credentials the user can fix.
+		pterm.Println()
+		pterm.Warning.Printf("Update check: %v. No update is offered.\n", err)
+	}
 	if release != nil {
 		pterm.Println() // Add some space
-		message := fmt.Sprintf("A new version (%s) is available!\n\nNotes: %s\n\n%s",
+		message := fmt.Sprintf("A new version (%s) is available on the %s channel!\n\nNotes: %s\n\n%s",
 			pterm.LightGreen(release.Version),
+			release.Channel,
 			release.Notes,
 			pterm.LightYellow("Run 'btxz update' to get the latest features and security fixes."),
 		)
-		pterm.DefaultBox.WithTitle(pterm.LightYellow("UPDATE AVAILABLE")).WithTitleTopCenter().WithBoxStyle(pterm.NewStyle(pterm.FgYellow)).Println(pterm.FgYellow.Sprint(updateArt) + "\n\n" + message)
+		if release.Channel != ChannelStable {
+			message += "\n" + pterm.LightYellow("This is a pre-release build; 'btxz update --channel stable' returns to the releases.")
+		}
+		pterm.DefaultBox.WithTitle(pterm.LightYellow("UPDATE AVAILABLE (" + strings.ToUpper(release.Channel) + ")")).WithTitleTopCenter().WithBoxStyle(pterm.NewStyle(pterm.FgYellow)).Println(pterm.FgYellow.Sprint(updateArt) + "\n\n" + message)
 	}
 }
 
@@ -96,11 +225,14 @@ func PerformUpdate(currentVersion string) error {
 	CheckForUpdates(currentVersion)
 
 	mu.RLock()
-	release := latestRelease
+	release, err := latestRelease, checkErr
 	mu.RUnlock()
 
+	if err != nil {
+		return fmt.Errorf("could not check for updates: %w", err)
+	}
 	if release == nil {
-		pterm.Info.Println("Your system is up to date.")
+		pterm.Info.Printf("Your system is up to date on the %s channel.\n", currentChannel())
 		return nil
 	}
 
@@ -109,95 +241,246 @@ func PerformUpdate(currentVersion string) error {
 	if !ok {
 		return fmt.Errorf("no update available for your platform: %s", platformKey)
 	}
+	if platformInfo.Checksum == "" {
+		return fmt.Errorf("security check failed: the manifest has no checksum for %s", platformKey)
+	}
 
 	pterm.DefaultSection.Println("Update Found")
 	pterm.Info.Printf("Current: %s\n", currentVersion)
 	pterm.Info.Printf("Latest:  %s\n", pterm.Green(release.Version))
+	pterm.Info.Printf("Channel: %s\n", release.Channel)
 	pterm.Info.Printf("Notes:   %s\n", release.Notes)
 
 	// --- DOWNLOAD PHASE ---
 	pterm.DefaultSection.Println("Downloading")
 	
-	req, err := http.NewRequest("GET", platformInfo.URL, nil)
+	data, err := download(platformInfo.URL)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to download: %w", err)
 	}
-	resp, err := http.DefaultClient.Do(req)
+
+	// --- VERIFICATION PHASE ---
+	pterm.DefaultSection.Println("Security Checks")
+
+	// The signed manifest vouches for the binary through its checksum.
+	spinner, _ := pterm.DefaultSpinner.Start("Verifying SHA256 checksum...")
+	hash := sha256.Sum256(data)
+	calculatedHash := hex.EncodeToString(hash[:])
+	if calculatedHash != platformInfo.Checksum {
+		spinner.Fail("Checksum Mismatch!")
+		return fmt.Errorf("security check failed: expected %s, got %s", platformInfo.Checksum, calculatedHash)
+	}
+	spinner.Success("Checksum Verified")
+
+	// --- INSTALLATION PHASE ---
+	pterm.DefaultSection.Println("Installation")
+	pterm.Info.Println("Replacing binary...")
+
+	reader := bytes.NewReader(data)
+	err = update.Apply(reader, update.Options{})
 	if err != nil {
-		return fmt.Errorf("failed to download: %w", err)
+		if rerr := update.RollbackError(err); rerr != nil {
+			return fmt.Errorf("failed to apply update and rollback failed: %v", rerr)
+		}
+		return fmt.Errorf("failed to apply update: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.ContentLength > 0 {
-		bar, _ := pterm.DefaultProgressbar.WithTotal(int(resp.ContentLength)).WithTitle("Downloading update...").Start()
-		// Wrap body to update the progress bar
-		proxyReader := &progressReader{
-			Reader: resp.Body,
-			Bar:    bar,
+	// --- SUMMARY ---
+	pterm.DefaultSection.Println("Mission Report")
+	reportData := [][]string{
+		{"Previous Version", currentVersion},
+		{"New Version", pterm.Green(release.Version)},
+		{"Channel", release.Channel},
+		{"Platform", platformKey},
+		{"Status", "UPDATED"},
+	}
+	pterm.DefaultTable.WithData(reportData).WithBoxed().Render()
+	pterm.Success.Println("BTXZ has been updated successfully. Please restart your terminal.")
+
+	return nil
+}
+
+// download fetches the binary at rawURL with a progress display. An attempt
+// that fails part way is resumed from the bytes already received, if the
+// server answers range requests for the same file, else started over.
+func download(rawURL string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), downloadTimeout)
+	defer cancel()
+	var data []byte
+	var progress *progressReader
+	var validator string // ETag or Last-Modified of the file, for If-Range
+	err := retry(ctx, func() error {
+		attemptCtx, cancelAttempt := context.WithCancelCause(ctx)
+		defer cancelAttempt(nil)
+		header := http.Header{}
+		if len(data) > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", len(data)))
+			if validator != "" {
+				header.Set("If-Range", validator)
+			}
 		}
-		// Read into memory
-		data, err := io.ReadAll(proxyReader)
+		resp, err := get(attemptCtx, rawURL, header)
 		if err != nil {
-			return fmt.Errorf("download interrupted: %w", err)
+			return err
 		}
-		bar.Stop() // Ensure bar finishes
-		
-		// --- VERIFICATION PHASE ---
-		pterm.DefaultSection.Println("Security Checks")
-		
-		if platformInfo.Checksum != "" {
-			spinner, _ := pterm.DefaultSpinner.Start("Verifying SHA256 checksum...")
-			hash := sha256.Sum256(data)
-			calculatedHash := hex.EncodeToString(hash[:])
-			if calculatedHash != platformInfo.Checksum {
-				spinner.Fail("Checksum Mismatch!")
-				return fmt.Errorf("security check failed: expected %s, got %s", platformInfo.Checksum, calculatedHash)
+		defer resp.Body.Close()
+
+		size := resp.ContentLength
+		switch resp.StatusCode {
+		case http.StatusOK:
+			// The whole file, the first time or if the server cannot resume.
+			data = data[:0]
+		case http.StatusPartialContent:
+			start, total, ok := contentRange(resp)
+			if !ok || start != int64(len(data)) {
+				data = nil
+				return fmt.Errorf("%s: unusable partial response (Content-Range %q)", rawURL, resp.Header.Get("Content-Range"))
 			}
-			spinner.Success("Checksum Verified")
-		} else {
-			pterm.Warning.Println("Skipping checksum checks (not provided in manifest).")
+			size = total
+		default:
+			return newStatusError(rawURL, resp)
+		}
+		if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			validator = etag
+		} else if validator == "" {
+			validator = resp.Header.Get("Last-Modified")
 		}
 
-		// --- INSTALLATION PHASE ---
-		pterm.DefaultSection.Println("Installation")
-		pterm.Info.Println("Replacing binary...")
-		
-		reader := bytes.NewReader(data)
-		err = update.Apply(reader, update.Options{})
+		body := newStallReader(resp.Body, cancelAttempt)
+		defer body.stop()
+		if progress == nil {
+			progress = newProgress(size)
+		}
+		progress.resume(body, int64(len(data)))
+		buf := bytes.NewBuffer(data)
+		_, err = buf.ReadFrom(progress)
+		data = buf.Bytes()
 		if err != nil {
-			if rerr := update.RollbackError(err); rerr != nil {
-				return fmt.Errorf("failed to apply update and rollback failed: %v", rerr)
+			if cause := context.Cause(attemptCtx); cause != nil && cause != context.Canceled {
+				err = cause
 			}
-			return fmt.Errorf("failed to apply update: %w", err)
-		}
-		
-		// --- SUMMARY ---
-		pterm.DefaultSection.Println("Mission Report")
-		reportData := [][]string{
-			{"Previous Version", currentVersion},
-			{"New Version", pterm.Green(release.Version)},
-			{"Platform", platformKey},
-			{"Status", "UPDATED"},
+			return fmt.Errorf("download interrupted: %w", err)
 		}
-		pterm.DefaultTable.WithData(reportData).WithBoxed().Render()
-		pterm.Success.Println("BTXZ has been updated successfully. Please restart your terminal.")
-
 		return nil
+	})
+	if progress != nil {
+		progress.stop()
 	}
-	
-	return fmt.Errorf("server returned unknown content length")
+	return data, err
 }
 
-// progressReader wraps an io.Reader to update a pterm.Progressbar
+const (
+	// progressInterval is how often the speed and the time left are
+	// refreshed, so the title does not flicker.
+	progressInterval = 250 * time.Millisecond
+	// rateSmoothing is the weight of the newest sample in the moving average
+	// of the speed.
+	rateSmoothing = 0.3
+)
+
+// progressReader wraps an io.Reader to update a pterm.Progressbar with the
+// download speed and the time left, or a spinner with the bytes downloaded
+// and the speed when the size is unknown.
 type progressReader struct {
 	io.Reader
-	Bar *pterm.ProgressbarPrinter
+	Bar     *pterm.ProgressbarPrinter
+	Spinner *pterm.SpinnerPrinter
+	Total   int64 // Size of the download, 0 or less if unknown
+
+	read      int64
+	rate      float64   // Moving average of the speed, in bytes per second
+	sampled   time.Time // Time of the last sample of the speed
+	sampledAt int64     // Bytes read at the last sample
+}
+
+// newProgress starts the display of a download of total bytes, 0 or less if
+// the size is unknown.
+func newProgress(total int64) *progressReader {
+	pr := &progressReader{Total: total}
+	if total > 0 {
+		pr.Bar, _ = pterm.DefaultProgressbar.WithTotal(int(total)).WithTitle("Downloading update...").Start()
+	} else {
+		pr.Spinner, _ = pterm.DefaultSpinner.Start("Downloading update...")
+	}
+	return pr
+}
+
+// resume continues the display with body, the rest of the download from the
+// byte at offset from.
+func (pr *progressReader) resume(body io.Reader, from int64) {
+	pr.Reader = body
+	pr.read, pr.sampledAt, pr.sampled = from, from, time.Time{}
+	if pr.Bar != nil {
+		pr.Bar.Current = int(from)
+	}
 }
 
 func (pr *progressReader) Read(p []byte) (n int, err error) {
+	if pr.sampled.IsZero() {
+		pr.sampled = time.Now()
+	}
 	n, err = pr.Reader.Read(p)
-	if n > 0 && pr.Bar != nil {
-		pr.Bar.Add(n)
+	if n > 0 {
+		pr.read += int64(n)
+		if pr.Bar != nil {
+			pr.Bar.Add(n)
+		}
+		pr.sample(time.Now())
 	}
 	return
 }
+
+// sample updates the moving average of the speed and the display, at most
+// once per progressInterval.
+func (pr *progressReader) sample(now time.Time) {
+	elapsed := now.Sub(pr.sampled)
+	if elapsed < progressInterval {
+		return
+	}
+	current := float64(pr.read-pr.sampledAt) / elapsed.Seconds()
+	if pr.rate == 0 {
+		pr.rate = current
+	} else {
+		pr.rate = rateSmoothing*current + (1-rateSmoothing)*pr.rate
+	}
+	pr.sampled, pr.sampledAt = now, pr.read
+	title := "Downloading update... " + pr.status()
+	if pr.Bar != nil {
+		pr.Bar.UpdateTitle(title)
+	}
+	if pr.Spinner != nil {
+		pr.Spinner.UpdateText(title)
+	}
+}
+
+// status describes the progress, e.g. "12.4 MB/s, ~8s left", or
+// "3.1 MB, 12.4 MB/s" when the size is unknown.
+func (pr *progressReader) status() string {
+	speed := formatBytes(pr.rate) + "/s"
+	if pr.Total <= 0 {
+		return formatBytes(float64(pr.read)) + ", " + speed
+	}
+	if pr.rate <= 0 || pr.read >= pr.Total {
+		return speed
+	}
+	left := time.Duration(float64(pr.Total-pr.read) / pr.rate * float64(time.Second))
+	return fmt.Sprintf("%s, ~%s left", speed, left.Round(time.Second))
+}
+
+// stop ends the display.
+func (pr *progressReader) stop() {
+	if pr.Bar != nil {
+		pr.Bar.Stop() // Ensure bar finishes
+	}
+	if pr.Spinner != nil {
+		pr.Spinner.Success("Downloaded " + formatBytes(float64(pr.read)))
+	}
+}
+
+// formatBytes formats a byte count or rate in decimal units, e.g. "12.4 MB".
+func formatBytes(n float64) string {
+	if n < 1e6 {
+		return fmt.Sprintf("%.0f kB", n/1e3)
+	}
+	return fmt.Sprintf("%.1f MB", n/1e6)
+}
@@ -0,0 +1,108 @@
+// File: version.go
+// This file compares release versions as semantic versions: "v1.10.0" is
+// newer than "v1.9.0", and "2.0.0-rc1" older than "2.0.0". Development builds,
+// whose version "0.0.0‑dev" is spelled with a non-breaking hyphen, are older
+// than every release. A version that cannot be parsed is never taken to be
+// newer, so a malformed manifest offers no update.
+
+package update
+
+import (
+	"cmp"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed version: MAJOR.MINOR.PATCH and its pre-release
+// identifiers. Build metadata is dropped, as it does not order versions.
+type semver struct {
+	core       [3]uint64
+	prerelease []string
+}
+
+// hyphens are the characters taken for the hyphen before a pre-release.
+var hyphens = strings.NewReplacer("‐", "-", "‑", "-", "‒", "-", "–", "-", "−", "-")
+
+// parseVersion parses s, with or without a leading "v". A missing minor or
+// patch number counts as 0, so "1.2" is "1.2.0".
+func parseVersion(s string) (semver, bool) {
+	var v semver
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(hyphens.Replace(s)), "v"), "V")
+	s, _, _ = strings.Cut(s, "+")
+	s, prerelease, hasPrerelease := strings.Cut(s, "-")
+	numbers := strings.Split(s, ".")
+	if len(numbers) > len(v.core) {
+		return v, false
+	}
+	for i, number := range numbers {
+		n, err := strconv.ParseUint(number, 10, 64)
+		if err != nil || len(number) > 1 && number[0] == '0' {
+			return v, false
+		}
+		v.core[i] = n
+	}
+	if hasPrerelease {
+		v.prerelease = strings.Split(prerelease, ".")
+		for _, identifier := range v.prerelease {
+			if identifier == "" {
+				return v, false
+			}
+		}
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1 as v is older than, the same as or newer than w,
+// by the precedence rules of Semantic Versioning 2.0.0.
+func (v semver) compare(w semver) int {
+	for i := range v.core {
+		if v.core[i] != w.core[i] {
+			return cmp.Compare(v.core[i], w.core[i])
+		}
+	}
+	// A pre-release is older than the release.
+	switch {
+	case len(v.prerelease) == 0 && len(w.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(w.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(w.prerelease); i++ {
+		if c := compareIdentifier(v.prerelease[i], w.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(v.prerelease), len(w.prerelease))
+}
+
+// compareIdentifier compares pre-release identifiers: numeric ones by value
+// and before the others, which compare as text.
+func compareIdentifier(a, b string) int {
+	m, errA := strconv.ParseUint(a, 10, 64)
+	n, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		return cmp.Compare(m, n)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// isNewer reports whether the version latest is newer than current, and false
+// if either cannot be parsed.
+func isNewer(latest, current string) bool {
+	l, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	return l.compare(c) > 0
+}
@@ -0,0 +1,83 @@
+package update
+
+import "testing"
+
+func TestIsNewer(t *testing.T) {
+	for _, test := range []struct {
+		latest, current string
+		want            bool
+	}{
+		// Numbers compare by value, not as text.
+		{"1.10", "1.9", true},
+		{"1.9", "1.10", false},
+		{"v1.10.0", "v1.9.0", true},
+		{"2.0.0", "1.99.99", true},
+		{"1.2.10", "1.2.9", true},
+		// A release is newer than its pre-releases.
+		{"2.0.0", "2.0.0-rc1", true},
+		{"2.0.0-rc1", "2.0.0", false},
+		{"2.0.0-rc.2", "2.0.0-rc.1", true},
+		{"2.0.0-rc.10", "2.0.0-rc.9", true},
+		{"2.0.0-beta", "2.0.0-alpha", true},
+		{"2.0.0-alpha.1", "2.0.0-alpha", true},
+		{"2.0.0-alpha.beta", "2.0.0-alpha.1", true},
+		{"2.0.0-rc1", "1.9.0", true},
+		// Development builds are older than every release.
+		{"0.0.1", "0.0.0‑dev", true},
+		{"1.0.0", "0.0.0‑dev", true},
+		{"0.0.0‑dev", "0.0.0-dev", false},
+		// Equal versions, however they are spelled.
+		{"1.2.3", "1.2.3", false},
+		{"v1.2.3", "1.2.3", false},
+		{"1.2.3", "v1.2.3", false},
+		{"V1.2.3", "1.2.3", false},
+		{"1.2", "1.2.0", false},
+		{"1", "1.0.0", false},
+		{" 1.2.3\n", "1.2.3", false},
+		// Build metadata does not order versions.
+		{"1.2.3+build.5", "1.2.3+build.4", false},
+		{"1.2.3+build", "1.2.3", false},
+		{"1.2.4+build", "1.2.3", true},
+		{"2.0.0-rc1+exp.sha", "2.0.0-rc1", false},
+		// A version that cannot be parsed is never newer, nor older.
+		{"", "1.0.0", false},
+		{"latest", "1.0.0", false},
+		{"1.2.3.4", "1.0.0", false},
+		{"1.02.3", "1.0.0", false},
+		{"1.x.3", "1.0.0", false},
+		{"1.2.3-", "1.0.0", false},
+		{"1.2.3-rc..1", "1.0.0", false},
+		{"-1.2.3", "1.0.0", false},
+		{"2.0.0", "", false},
+		{"2.0.0", "unknown", false},
+	} {
+		if got := isNewer(test.latest, test.current); got != test.want {
+			t.Errorf("isNewer(%q, %q) = %v, want %v", test.latest, test.current, got, test.want)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for _, test := range []struct {
+		in         string
+		core       [3]uint64
+		prerelease []string
+	}{
+		{"1.2.3", [3]uint64{1, 2, 3}, nil},
+		{"v1.10", [3]uint64{1, 10, 0}, nil},
+		{"0.0.0‑dev", [3]uint64{0, 0, 0}, []string{"dev"}},
+		{"2.0.0-rc.1+build.7", [3]uint64{2, 0, 0}, []string{"rc", "1"}},
+		{"3.1.4+20250102", [3]uint64{3, 1, 4}, nil},
+	} {
+		v, ok := parseVersion(test.in)
+		if !ok || v.core != test.core || len(v.prerelease) != len(test.prerelease) {
+			t.Errorf("parseVersion(%q) = %v, %v, want %v %v", test.in, v, ok, test.core, test.prerelease)
+			continue
+		}
+		for i := range v.prerelease {
+			if v.prerelease[i] != test.prerelease[i] {
+				t.Errorf("parseVersion(%q) has pre-release %v, want %v", test.in, v.prerelease, test.prerelease)
+			}
+		}
+	}
+}
@@ -18,6 +18,15 @@ echo "▶ Building BTXZ v${VERSION} with suffix '${SUFFIX}'"
 rm -rf "${ARTIFACTS}"
 mkdir -p "${ARTIFACTS}"
 
+# The public key the release manifest is signed with is supplied by the
+# maintainers, never kept in the source. Without it btxz refuses every update.
+LDFLAGS=""
+if [[ -n "${BTXZ_RELEASE_PUBLIC_KEY:-}" ]]; then
+  LDFLAGS="-X btxz/update.manifestPublicKey=${BTXZ_RELEASE_PUBLIC_KEY}"
+else
+  echo "⚠ BTXZ_RELEASE_PUBLIC_KEY is not set: 'btxz update' will refuse every manifest"
+fi
+
 # Move into the btxz/ dir where go.mod lives
 cd "$(dirname "$0")/../btxz"
 
@@ -36,7 +45,7 @@ for platform in "${PLATFORMS[@]}"; do
 
   echo "  • $GOOS/$GOARCH → $(basename "$BIN")"
   env GOOS="$GOOS" GOARCH="$GOARCH" \
-    go build -v -o "$BIN" .
+    go build -v -ldflags "$LDFLAGS" -o "$BIN" .
 done
 
 echo "✅ Done — artifacts in ${ARTIFACTS}/"
@@ -20,13 +20,16 @@ def get_platform_key(filename):
     return None
 
 def main():
-    if len(sys.argv) < 3:
-        print("Usage: update_manifest.py <version_file> <assets_json_string> <artifacts_dir>")
+    if len(sys.argv) < 4:
+        print("Usage: update_manifest.py <version_file> <assets_json_string> <artifacts_dir> [channel]")
         sys.exit(1)
 
     version_file = sys.argv[1]
     assets_json_str = sys.argv[2]
     artifacts_dir = sys.argv[3]
+    # The stable release lives at the top level, where older clients read it;
+    # the other channels (e.g. beta) under "channels".
+    channel = sys.argv[4] if len(sys.argv) > 4 else "stable"
 
     try:
         assets = json.loads(assets_json_str)
@@ -37,10 +40,14 @@ def main():
     with open(version_file, 'r') as f:
         manifest = json.load(f)
 
-    if 'platforms' not in manifest:
-        manifest['platforms'] = {}
+    release = manifest
+    if channel != "stable":
+        release = manifest.setdefault('channels', {}).setdefault(channel, {})
 
-    print(f"Updating manifest for version {manifest.get('version', 'unknown')}...")
+    if 'platforms' not in release:
+        release['platforms'] = {}
+
+    print(f"Updating manifest for version {release.get('version', 'unknown')} on the {channel} channel...")
 
     count = 0
     for asset in assets:
@@ -66,7 +73,7 @@ def main():
             print(f"Processed {filename} -> Key: {key}")
             count += 1
 
-        manifest['platforms'][key] = {
+        release['platforms'][key] = {
             "url": download_url,
             "sha256": checksum
         }